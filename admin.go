@@ -0,0 +1,464 @@
+package boilme
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/robfig/cron/v3"
+)
+
+// AdminJob describes a scheduled job, as listed by the admin server
+type AdminJob struct {
+	ID   int       `json:"id"`
+	Name string    `json:"name"`
+	Next time.Time `json:"next"`
+	Prev time.Time `json:"prev,omitempty"`
+}
+
+// AdminLogLevel is the body of the admin server's log-level endpoint
+type AdminLogLevel struct {
+	Level string `json:"level"`
+}
+
+// AdminCacheFlush is the body of the admin server's cache flush endpoint. Keys
+// matching Pattern are removed. To empty the whole cache, Pattern must be empty and
+// All set: that also removes rate limit counters, cached responses and, with
+// TLS_ACME_CACHE=cache, ACME certificates, which are kept in the cache.
+type AdminCacheFlush struct {
+	Pattern string `json:"pattern,omitempty"`
+	All     bool   `json:"all,omitempty"`
+}
+
+// AdminRoutes returns the handler of the admin server. It is served on ADMIN_SOCKET
+// and ADMIN_ADDR by ListenAndServe, behind adminAuth, and is exposed so it can be
+// mounted elsewhere, or tested.
+func (b *Boilme) AdminRoutes() http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(middleware.Recoverer)
+	mux.Use(b.adminAuth)
+
+	mux.Get("/maintenance", b.adminGetMaintenance)
+	mux.Put("/maintenance", b.adminSetMaintenance)
+	mux.Post("/cache/flush", b.adminFlushCache)
+	mux.Get("/jobs", b.adminListJobs)
+	mux.Post("/jobs/{id}/run", b.adminRunJob)
+	mux.Get("/log-level", b.adminGetLogLevel)
+	mux.Put("/log-level", b.adminSetLogLevel)
+	mux.Get("/mail/outbox", b.adminListOutbox)
+	mux.Post("/mail/outbox/{id}/retry", b.adminRetryOutbox)
+	mux.Get("/config", b.adminConfig)
+
+	return mux
+}
+
+// adminAuth is middleware that requires the ADMIN_TOKEN bearer token, when one is
+// set. Without a token, the admin server relies on the permissions of its socket,
+// or on client certificates.
+func (b *Boilme) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := b.Config.Admin.Token
+		if token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				b.Logger.Warn("admin request refused", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				b.adminError(w, http.StatusUnauthorized, errors.New("a valid admin token is required"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (b *Boilme) adminError(w http.ResponseWriter, status int, err error) {
+	_ = b.WriteJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func (b *Boilme) adminGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	state, err := b.Maintenance(r.Context())
+	if err != nil {
+		b.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	_ = b.WriteJSON(w, http.StatusOK, state)
+}
+
+func (b *Boilme) adminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var state MaintenanceState
+	if err := b.ReadJSON(w, r, &state); err != nil {
+		b.adminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := b.SetMaintenance(r.Context(), state); err != nil {
+		b.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b.Logger.Info("admin: maintenance state changed", "enabled", state.Enabled,
+		"start", state.Start, "until", state.Until)
+	_ = b.WriteJSON(w, http.StatusOK, state)
+}
+
+func (b *Boilme) adminFlushCache(w http.ResponseWriter, r *http.Request) {
+	if b.Cache == nil {
+		b.adminError(w, http.StatusConflict, errors.New("no cache is configured"))
+		return
+	}
+
+	var req AdminCacheFlush
+	if r.ContentLength != 0 {
+		if err := b.ReadJSON(w, r, &req); err != nil {
+			b.adminError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if (req.Pattern == "") != req.All {
+		b.adminError(w, http.StatusBadRequest, errors.New("either a pattern, or all to empty the whole cache, is required"))
+		return
+	}
+
+	var err error
+	if req.All {
+		err = b.Cache.Empty()
+	} else {
+		err = b.Cache.EmptyByMatch(req.Pattern)
+	}
+	if err != nil {
+		b.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b.Logger.Info("admin: cache flushed", "pattern", req.Pattern, "all", req.All)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Boilme) adminListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := []AdminJob{}
+	for _, e := range b.Scheduler.Entries() {
+		jobs = append(jobs, AdminJob{
+			ID:   int(e.ID),
+			Name: jobName(e.Job),
+			Next: e.Next,
+			Prev: e.Prev,
+		})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+
+	_ = b.WriteJSON(w, http.StatusOK, jobs)
+}
+
+func (b *Boilme) adminRunJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		b.adminError(w, http.StatusBadRequest, fmt.Errorf("invalid job id %q", chi.URLParam(r, "id")))
+		return
+	}
+
+	entry := b.Scheduler.Entry(cron.EntryID(id))
+	if !entry.Valid() {
+		b.adminError(w, http.StatusNotFound, fmt.Errorf("no job with id %d", id))
+		return
+	}
+
+	// run it as the scheduler would, with the same wrappers, without waiting for it
+	b.Logger.Info("admin: running job", "id", id, "job", jobName(entry.Job))
+	go entry.WrappedJob.Run()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (b *Boilme) adminGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	_ = b.WriteJSON(w, http.StatusOK, AdminLogLevel{Level: b.LogLevel()})
+}
+
+func (b *Boilme) adminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req AdminLogLevel
+	if err := b.ReadJSON(w, r, &req); err != nil {
+		b.adminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := b.SetLogLevel(req.Level); err != nil {
+		b.adminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	b.Logger.Info("admin: log level changed", "level", b.LogLevel())
+	_ = b.WriteJSON(w, http.StatusOK, AdminLogLevel{Level: b.LogLevel()})
+}
+
+// adminListOutbox lists the messages in the mail outbox, filtered by the status
+// query parameter, up to limit (50 by default)
+func (b *Boilme) adminListOutbox(w http.ResponseWriter, r *http.Request) {
+	if b.Mail.Outbox == nil {
+		b.adminError(w, http.StatusNotFound, errors.New("there is no mail outbox"))
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			b.adminError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = n
+	}
+
+	messages, err := b.Mail.Outbox.List(r.Context(), mailer.Status(r.URL.Query().Get("status")), limit)
+	if err != nil {
+		b.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if messages == nil {
+		messages = []*mailer.OutboxMessage{}
+	}
+	_ = b.WriteJSON(w, http.StatusOK, messages)
+}
+
+func (b *Boilme) adminRetryOutbox(w http.ResponseWriter, r *http.Request) {
+	if b.Mail.Outbox == nil {
+		b.adminError(w, http.StatusNotFound, errors.New("there is no mail outbox"))
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err := b.Mail.Outbox.Retry(r.Context(), id)
+	switch {
+	case errors.Is(err, mailer.ErrNotFound):
+		b.adminError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, mailer.ErrNotFailed):
+		b.adminError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		b.adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	b.Logger.Info("admin: retrying mail", "id", id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (b *Boilme) adminConfig(w http.ResponseWriter, r *http.Request) {
+	_ = b.WriteJSON(w, http.StatusOK, b.Config.Settings())
+}
+
+// listenAdmin starts the admin server on ADMIN_SOCKET, and on ADMIN_ADDR if set.
+// The socket is only accessible to the user running the application. If either
+// cannot be listened on, neither is.
+func (b *Boilme) listenAdmin() (err error) {
+	if b.Config.RPCPort != "" {
+		b.Logger.Warn("RPC_PORT is no longer used; the admin server listens on ADMIN_SOCKET or ADMIN_ADDR")
+	}
+
+	cfg := b.Config.Admin
+	if !cfg.Enabled {
+		return nil
+	}
+
+	handler := b.AdminRoutes()
+	newServer := func() *http.Server {
+		return &http.Server{
+			Handler:      handler,
+			ErrorLog:     b.ErrorLog,
+			IdleTimeout:  30 * time.Second,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+	}
+
+	var listeners []net.Listener
+	var servers []*http.Server
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+		}
+	}()
+
+	if cfg.Socket != "" {
+		l, err := listenAdminSocket(b.AdminSocketPath())
+		if err != nil {
+			return fmt.Errorf("admin socket: %w", err)
+		}
+		listeners = append(listeners, l)
+		servers = append(servers, newServer())
+	}
+
+	if cfg.Addr != "" {
+		srv := newServer()
+		if cfg.TLSCert != "" {
+			tlsConfig, err := b.adminTLSConfig()
+			if err != nil {
+				return err
+			}
+			srv.TLSConfig = tlsConfig
+		} else if !isLoopbackAddr(cfg.Addr) {
+			return fmt.Errorf("admin server: %s is not a loopback address, so ADMIN_TLS_CERT and ADMIN_TLS_KEY are required", cfg.Addr)
+		}
+
+		l, err := net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return fmt.Errorf("admin server: %w", err)
+		}
+		if srv.TLSConfig != nil {
+			l = tls.NewListener(l, srv.TLSConfig)
+		}
+		listeners = append(listeners, l)
+		servers = append(servers, srv)
+	}
+
+	b.shutdownMu.Lock()
+	b.adminServers = servers
+	b.shutdownMu.Unlock()
+
+	for i, srv := range servers {
+		l := listeners[i]
+		b.Logger.Info("starting admin server", "addr", l.Addr().String())
+		go func() {
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				b.Logger.Error("admin server failed", "addr", l.Addr().String(), "error", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// isLoopbackAddr reports whether the tcp address addr can only be reached from this
+// machine. An address without a host listens on every interface, so it cannot.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// AdminSocketPath returns the path of the admin server's unix socket. A relative
+// ADMIN_SOCKET is relative to the root path.
+func (b *Boilme) AdminSocketPath() string {
+	path := b.Config.Admin.Socket
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(b.RootPath, path)
+	}
+	return path
+}
+
+// listenAdminSocket listens on a unix socket at path, that only our user can use.
+// A socket left behind by a previous run is replaced, but one that another process
+// is still listening on is not.
+//
+// The socket is created in a new directory that only we can enter, made private
+// before it is moved to path, so no other user can connect to it in the meantime.
+func listenAdminSocket(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists, and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use; is the application already running?", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// MkdirTemp creates the directory with mode 0700
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the listener would remove the socket at tmp, which is about to be moved
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, 0600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	return &socketListener{Listener: l, path: path}, nil
+}
+
+// socketListener removes the socket it listens on when it is closed
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	_ = os.Remove(l.path)
+	return err
+}
+
+// adminTLSConfig returns the TLS configuration of the admin server on ADMIN_ADDR.
+// With ADMIN_TLS_CLIENT_CA, clients must present a certificate signed by it.
+func (b *Boilme) adminTLSConfig() (*tls.Config, error) {
+	cfg := b.Config.Admin
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("admin server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("admin client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("admin client ca: no certificates found in %s", cfg.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,262 @@
+package boilme
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+)
+
+func newAdminTestApp(t *testing.T) *Boilme {
+	t.Helper()
+	b := &Boilme{RootPath: t.TempDir()}
+	b.setLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	return b
+}
+
+func TestBoilme_adminAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing token", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"not a bearer token", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"token without scheme", "s3cret", "s3cret", http.StatusUnauthorized},
+		{"prefix of the token", "s3cret", "Bearer s3c", http.StatusUnauthorized},
+		{"valid token", "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newAdminTestApp(t)
+			b.Config.Admin.Token = tt.token
+
+			h := b.adminAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/config", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("status is %d, want %d", rr.Code, tt.want)
+			}
+			if rr.Code == http.StatusUnauthorized && !strings.Contains(rr.Body.String(), "admin token") {
+				t.Errorf("unexpected body: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestListenAdminSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tmp", "admin.sock")
+
+	l, err := listenAdminSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket has mode %o", perm)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the socket to be left, got %v", entries)
+	}
+
+	// a second instance must not take the socket of one that is running
+	if _, err := listenAdminSocket(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected the live socket to be refused, got %v", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket was not removed on close: %v", err)
+	}
+}
+
+func TestListenAdminSocket_replacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// leave a socket behind, as a process that crashed would
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	l, err := listenAdminSocket(path)
+	if err != nil {
+		t.Fatalf("stale socket was not replaced: %v", err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
+
+func TestListenAdminSocket_refusesOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	if err := os.WriteFile(path, []byte("important"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listenAdminSocket(path); err == nil {
+		t.Error("expected an error")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "important" {
+		t.Error("the file was replaced")
+	}
+}
+
+func TestBoilme_listenAdmin(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr string
+	}{
+		{"socket only", "", ""},
+		{"loopback address", "127.0.0.1:0", ""},
+		{"address in use", busy.Addr().String(), "address already in use"},
+		{"public address without tls", "0.0.0.0:0", "ADMIN_TLS_CERT and ADMIN_TLS_KEY are required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newAdminTestApp(t)
+			b.Config.Admin = AdminConfig{Enabled: true, Socket: "tmp/admin.sock", Addr: tt.addr, Token: "s3cret"}
+
+			err := b.listenAdmin()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				// the socket opened before the failure is closed again
+				if _, err := os.Lstat(b.AdminSocketPath()); !os.IsNotExist(err) {
+					t.Errorf("the socket was left behind: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", b.AdminSocketPath())
+				},
+			}}
+			resp, err := client.Get("http://admin/log-level")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("unauthenticated request got %d", resp.StatusCode)
+			}
+
+			if err := b.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(b.AdminSocketPath()); !os.IsNotExist(err) {
+				t.Errorf("the socket was not removed on shutdown: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:9443", true},
+		{"127.1.2.3:9443", true},
+		{"[::1]:9443", true},
+		{"localhost:9443", true},
+		{":9443", false},
+		{"0.0.0.0:9443", false},
+		{"10.0.0.5:9443", false},
+		{"admin.example.com:9443", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLoopbackAddr(tt.addr); got != tt.want {
+			t.Errorf("isLoopbackAddr(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestBoilme_adminFlushCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     int
+		wantKeys []string
+	}{
+		{"no body", "", http.StatusBadRequest, []string{"user:1", "page:1"}},
+		{"empty pattern", `{"pattern":""}`, http.StatusBadRequest, []string{"user:1", "page:1"}},
+		{"pattern and all", `{"pattern":"user:*","all":true}`, http.StatusBadRequest, []string{"user:1", "page:1"}},
+		{"pattern", `{"pattern":"user:"}`, http.StatusNoContent, []string{"page:1"}},
+		{"all", `{"all":true}`, http.StatusNoContent, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newAdminTestApp(t)
+			b.Cache = &cache.MemoryCache{}
+			for _, k := range []string{"user:1", "page:1"} {
+				if err := b.Cache.Set(k, "v", 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/cache/flush", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			b.AdminRoutes().ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status is %d, want %d: %s", rr.Code, tt.want, rr.Body.String())
+			}
+
+			var kept []string
+			for _, k := range []string{"user:1", "page:1"} {
+				if found, _ := b.Cache.Has(k); found {
+					kept = append(kept, k)
+				}
+			}
+			if strings.Join(kept, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("kept %v, want %v", kept, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -1,19 +1,24 @@
 package boilme
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net"
-	"net/rpc"
-	"os"
+	"log/slog"
+	"net/http"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bxtal-lsn/go-boilme/filesystems/miniofilesystem"
 	"github.com/bxtal-lsn/go-boilme/filesystems/s3filesystem"
 	"github.com/bxtal-lsn/go-boilme/filesystems/sftpfilesystem"
 	"github.com/bxtal-lsn/go-boilme/filesystems/webdavfilesystem"
+	"github.com/bxtal-lsn/go-boilme/jobs"
 
 	"github.com/CloudyKit/jet/v6"
 	"github.com/alexedwards/scs/v2"
@@ -21,29 +26,26 @@ import (
 	"github.com/bxtal-lsn/go-boilme/mailer"
 	"github.com/bxtal-lsn/go-boilme/render"
 	"github.com/bxtal-lsn/go-boilme/session"
+	"github.com/bxtal-lsn/go-boilme/urlsigner"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/go-chi/chi/v5"
 	"github.com/gomodule/redigo/redis"
-	"github.com/joho/godotenv"
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const version = "1.0.0"
 
-var (
-	myRedisCache    *cache.RedisCache
-	myBadgerCache   *cache.BadgerCache
-	redisPool       *redis.Pool
-	badgerConn      *badger.DB
-	maintenanceMode bool
-)
-
 // Boilme is the overall type for the Boilme package. Members that are exported in this type
 // are available to any application that uses it.
 type Boilme struct {
 	AppName       string
 	Debug         bool
 	Version       string
+	Logger        *slog.Logger
+	Tracer        trace.Tracer
 	ErrorLog      *log.Logger
 	InfoLog       *log.Logger
 	RootPath      string
@@ -52,10 +54,11 @@ type Boilme struct {
 	Session       *scs.SessionManager
 	DB            Database
 	JetViews      *jet.Set
-	config        config
+	Config        Config
 	EncryptionKey string
 	Cache         cache.Cache
 	Scheduler     *cron.Cron
+	Jobs          *jobs.Jobs
 	Mail          mailer.Mail
 	Server        Server
 	FileSystems   map[string]interface{}
@@ -63,6 +66,33 @@ type Boilme struct {
 	SFTP          sftpfilesystem.SFTP
 	WebDAV        webdavfilesystem.WebDAV
 	Minio         miniofilesystem.Minio
+
+	// running servers, and shutdown state
+	server         *http.Server
+	redirectServer *http.Server
+	adminServers   []*http.Server
+	shutdownMu     sync.Mutex
+	shutdownHooks  []func(ctx context.Context) error
+	shutdownOnce   sync.Once
+	shutdownErr    error
+	shuttingDown   atomic.Bool
+
+	// connections opened for the cache and sessions, closed on shutdown
+	redisPool  *redis.Pool
+	badgerConn *badger.DB
+
+	// logging
+	logLevel *slog.LevelVar
+	logFile  io.Closer
+
+	health      healthChecks
+	metrics     *metrics
+	maintenance *maintenance
+
+	// tracing
+	tracerProvider      trace.TracerProvider
+	ownedTracerProvider *sdktrace.TracerProvider
+	propagator          propagation.TextMapPropagator
 }
 
 type Server struct {
@@ -72,21 +102,6 @@ type Server struct {
 	URL        string
 }
 
-type config struct {
-	port        string
-	renderer    string
-	cookie      cookieConfig
-	sessionType string
-	database    databaseConfig
-	redis       redisConfig
-	uploads     uploadConfig
-}
-
-type uploadConfig struct {
-	allowedMimeTypes []string
-	maxUploadSize    int64
-}
-
 // New reads the .env file, creates our application config, populates the Boilme type with settings
 // based on .env values, and creates necessary folders and files if they don't exist
 func (b *Boilme) New(rootPath string) error {
@@ -105,150 +120,341 @@ func (b *Boilme) New(rootPath string) error {
 		return err
 	}
 
-	// read .env
-	err = godotenv.Load(rootPath + "/.env")
+	// read .env, config files and the environment
+	cfg, err := LoadConfig(rootPath)
 	if err != nil {
 		return err
 	}
 
+	return b.setup(WithRootPath(rootPath), WithConfig(cfg))
+}
+
+// setup populates b from opts, creating from the configuration anything that
+// opts do not supply. It is shared by New and NewWithOptions.
+func (b *Boilme) setup(opts ...Option) (err error) {
+	o := options{rootPath: "."}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return err
+		}
+	}
+
+	// on failure, close whatever was opened so far, so nothing leaks
+	defer func() {
+		if err != nil {
+			b.closeOpened(o)
+		}
+	}()
+
+	if o.config != nil {
+		b.Config = *o.config
+	} else {
+		b.Config = DefaultConfig()
+	}
+	if err := b.Config.Validate(); err != nil {
+		return err
+	}
+
+	b.RootPath = o.rootPath
+	b.AppName = b.Config.AppName
+	b.Debug = b.Config.Debug
+	b.Version = version
+	b.EncryptionKey = b.Config.Key
+
 	// create loggers
-	infoLog, errorLog := b.startLoggers()
+	if o.logger != nil {
+		b.setLogger(o.logger)
+	} else if err := b.startLoggers(); err != nil {
+		return err
+	}
+
+	// set up tracing, which records nothing unless wanted
+	if err := b.setupTracing(o.tracerProvider); err != nil {
+		return err
+	}
+
+	// create metrics, if wanted
+	if b.Config.Metrics.Enabled || o.metricsRegistry != nil {
+		m, err := newMetrics(o.metricsRegistry)
+		if err != nil {
+			return err
+		}
+		b.metrics = m
+	}
 
 	// connect to database
-	if os.Getenv("DATABASE_TYPE") != "" {
-		db, err := b.OpenDB(os.Getenv("DATABASE_TYPE"), b.BuildDSN())
+	if o.db != nil {
+		b.DB = Database{
+			DataType: b.Config.Database.Type,
+			Dialect:  NormalizeDialect(b.Config.Database.Type),
+			Pool:     o.db,
+		}
+	} else if b.Config.Database.Type != "" {
+		db, err := b.OpenDB(b.Config.Database.Type, b.BuildDSN())
 		if err != nil {
-			errorLog.Println(err)
-			os.Exit(1)
+			return err
 		}
 		b.DB = Database{
-			DataType: os.Getenv("DATABASE_TYPE"),
+			DataType: b.Config.Database.Type,
+			Dialect:  NormalizeDialect(b.Config.Database.Type),
 			Pool:     db,
 		}
 	}
 
-	scheduler := cron.New()
+	var cronOptions []cron.Option
+	if b.metrics != nil {
+		if b.DB.Pool != nil {
+			if err := b.metrics.instrumentDB(b.DB); err != nil {
+				return err
+			}
+		}
+		cronOptions = append(cronOptions, cron.WithChain(b.metrics.jobWrapper()))
+	}
+	scheduler := cron.New(cronOptions...)
 	b.Scheduler = scheduler
 
-	if os.Getenv("CACHE") == "redis" || os.Getenv("SESSION_TYPE") == "redis" {
-		myRedisCache = b.createClientRedisCache()
-		b.Cache = myRedisCache
-		redisPool = myRedisCache.Conn
+	// create the cache
+	if err := b.createCache(o.cache); err != nil {
+		return err
+	}
+	if b.metrics != nil {
+		b.metrics.instrumentCache(b.Cache)
+	}
+	if b.tracing() && b.Cache != nil {
+		b.traceCache()
+	}
+
+	// create the background job queue
+	if err := b.createJobs(o.jobQueue); err != nil {
+		return err
+	}
+	if b.metrics != nil {
+		b.metrics.instrumentJobs(b.Jobs)
 	}
 
-	if os.Getenv("CACHE") == "badger" {
-		myBadgerCache = b.createClientBadgerCache()
-		b.Cache = myBadgerCache
-		badgerConn = myBadgerCache.Conn
+	// read the maintenance state from the store shared by every instance
+	store := o.maintenance
+	if store == nil {
+		var err error
+		if store, err = b.createMaintenanceStore(); err != nil {
+			return err
+		}
+	}
+	b.maintenance = &maintenance{store: store, refresh: b.Config.Maintenance.Refresh, logger: b.Logger}
 
-		_, err = b.Scheduler.AddFunc("@daily", func() {
-			_ = myBadgerCache.Conn.RunValueLogGC(0.7)
-		})
+	if o.mailer != nil {
+		b.Mail = *o.mailer
+		if b.Mail.Jobs == nil {
+			b.Mail.Jobs = make(chan mailer.Message, 20)
+		}
+		if b.Mail.Results == nil {
+			b.Mail.Results = make(chan mailer.Result, 20)
+		}
+	} else {
+		b.Mail = b.createMailer()
+	}
+	if b.Mail.Signer == nil {
+		b.Mail.Signer = &urlsigner.Signer{Secret: []byte(b.EncryptionKey)}
+	}
+	if b.Mail.CaptureDir == "" {
+		b.Mail.CaptureDir = filepath.Join(b.RootPath, "tmp", "mail")
+	}
+	if b.Mail.Logger == nil {
+		b.Mail.Logger = b.Logger
+	}
+	if b.Mail.Suppressions == nil {
+		b.Mail.Suppressions = b.createSuppressions(o.suppressions)
+	}
+	if o.mailTransport != nil {
+		b.Mail.Transport = o.mailTransport
+	}
+	// a transport kept for the life of the application reuses its connections
+	if b.Mail.Transport == nil {
+		t, err := b.Mail.NewTransport()
 		if err != nil {
 			return err
 		}
+		b.Mail.Transport = t
 	}
-
-	b.InfoLog = infoLog
-	b.ErrorLog = errorLog
-	b.Debug, _ = strconv.ParseBool(os.Getenv("DEBUG"))
-	b.Version = version
-	b.RootPath = rootPath
-	b.Mail = b.createMailer()
-	b.Routes = b.routes().(*chi.Mux)
-
-	// file uploads
-	exploded := strings.Split(os.Getenv("ALLOWED_FILETYPES"), ",")
-	var mimeTypes []string
-	for _, m := range exploded {
-		mimeTypes = append(mimeTypes, m)
+	// in debug mode, mail templates are parsed for every message, so edits show up at once
+	if b.Mail.TemplateCache == nil && !b.Debug {
+		b.Mail.TemplateCache = mailer.NewTemplateCache()
 	}
-
-	var maxUploadSize int64
-	if max, err := strconv.Atoi(os.Getenv("MAX_UPLOAD_SIZE")); err != nil {
-		maxUploadSize = 10 << 20
-	} else {
-		maxUploadSize = int64(max)
-	}
-
-	b.config = config{
-		port:     os.Getenv("PORT"),
-		renderer: os.Getenv("RENDERER"),
-		cookie: cookieConfig{
-			name:     os.Getenv("COOKIE_NAME"),
-			lifetime: os.Getenv("COOKIE_LIFETIME"),
-			persist:  os.Getenv("COOKIE_PERSISTS"),
-			secure:   os.Getenv("COOKIE_SECURE"),
-			domain:   os.Getenv("COOKIE_DOMAIN"),
-		},
-		sessionType: os.Getenv("SESSION_TYPE"),
-		database: databaseConfig{
-			database: os.Getenv("DATABASE_TYPE"),
-			dsn:      b.BuildDSN(),
-		},
-		redis: redisConfig{
-			host:     os.Getenv("REDIS_HOST"),
-			password: os.Getenv("REDIS_PASSWORD"),
-			prefix:   os.Getenv("REDIS_PREFIX"),
-		},
-		uploads: uploadConfig{
-			maxUploadSize:    maxUploadSize,
-			allowedMimeTypes: mimeTypes,
-		},
+	if b.metrics != nil {
+		if err := b.metrics.instrumentMail(&b.Mail); err != nil {
+			return err
+		}
 	}
-
-	secure := true
-	if strings.ToLower(os.Getenv("SECURE")) == "false" {
-		secure = false
+	if b.tracing() {
+		b.Mail.Tracer = b.Tracer
+	}
+	if err := b.createOutbox(o.outboxStore); err != nil {
+		return err
 	}
+	b.Routes = b.routes().(*chi.Mux)
 
 	b.Server = Server{
-		ServerName: os.Getenv("SERVER_NAME"),
-		Port:       os.Getenv("PORT"),
-		Secure:     secure,
-		URL:        os.Getenv("APP_URL"),
+		ServerName: b.Config.ServerName,
+		Port:       b.Config.Port,
+		Secure:     b.Config.Secure,
+		URL:        b.Config.AppURL,
 	}
 
 	// create session
 
 	sess := session.Session{
-		CookieLifetime: b.config.cookie.lifetime,
-		CookiePersist:  b.config.cookie.persist,
-		CookieName:     b.config.cookie.name,
-		SessionType:    b.config.sessionType,
-		CookieDomain:   b.config.cookie.domain,
+		CookieLifetime: strconv.Itoa(b.Config.Session.CookieLifetime),
+		CookiePersist:  strconv.FormatBool(b.Config.Session.CookiePersist),
+		CookieName:     b.Config.Session.CookieName,
+		SessionType:    b.Config.Session.Type,
+		CookieDomain:   b.Config.Session.CookieDomain,
+		CookieSecure:   strconv.FormatBool(b.Config.Session.CookieSecure),
 	}
 
-	switch b.config.sessionType {
-	case "redis":
-		sess.RedisPool = myRedisCache.Conn
-	case "mysql", "postgres", "mariadb", "postgresql":
-		sess.DBPool = b.DB.Pool
+	if o.sessionStore == nil {
+		switch b.Config.Session.Type {
+		case "redis":
+			if b.redisPool == nil {
+				return errors.New("SESSION_TYPE is redis, but no redis connection is available")
+			}
+			sess.RedisPool = b.redisPool
+		case "mysql", "postgres", "mariadb", "postgresql":
+			if b.DB.Pool == nil {
+				return fmt.Errorf("SESSION_TYPE is %s, but no database connection is available", b.Config.Session.Type)
+			}
+			sess.DBPool = b.DB.Pool
+			sess.SessionType = b.DB.Dialect
+		}
 	}
 
 	b.Session = sess.InitSession()
-	b.EncryptionKey = os.Getenv("KEY")
+	if o.sessionStore != nil {
+		b.Session.Store = o.sessionStore
+	}
 
 	if b.Debug {
 		views := jet.NewSet(
-			jet.NewOSFileSystemLoader(fmt.Sprintf("%s/views", rootPath)),
+			jet.NewOSFileSystemLoader(fmt.Sprintf("%s/views", b.RootPath)),
 			jet.InDevelopmentMode(),
 		)
 		b.JetViews = views
 	} else {
 		views := jet.NewSet(
-			jet.NewOSFileSystemLoader(fmt.Sprintf("%s/views", rootPath)),
+			jet.NewOSFileSystemLoader(fmt.Sprintf("%s/views", b.RootPath)),
 		)
 		b.JetViews = views
 	}
 
 	b.createRenderer()
 	b.FileSystems = b.createFileSystems()
+	for name, fs := range o.fileSystems {
+		b.FileSystems[name] = fs
+	}
+
+	b.registerHealthChecks()
+
 	go b.Mail.ListenForMail()
 
 	return nil
 }
 
+// closeOpened closes the connections and files that setup opened itself, leaving
+// alone those passed in through o, which belong to the caller until setup succeeds
+func (b *Boilme) closeOpened(o options) {
+	if b.DB.Pool != nil && o.db == nil {
+		_ = b.DB.Pool.Close()
+	}
+
+	var given interface{}
+	if o.cache != nil {
+		given = cache.Unwrap(o.cache)
+	}
+	if b.redisPool != nil {
+		if rc, ok := given.(*cache.RedisCache); !ok || rc.Conn != b.redisPool {
+			_ = b.redisPool.Close()
+		}
+	}
+	if b.badgerConn != nil {
+		if bc, ok := given.(*cache.BadgerCache); !ok || bc.Conn != b.badgerConn {
+			_ = b.badgerConn.Close()
+		}
+	}
+
+	if b.ownedTracerProvider != nil {
+		_ = b.ownedTracerProvider.Shutdown(context.Background())
+	}
+	if b.logFile != nil {
+		_ = b.logFile.Close()
+	}
+}
+
+// redisCache returns the redis cache behind b.Cache, or nil if it does not use redis
+func (b *Boilme) redisCache() *cache.RedisCache {
+	if b.Cache == nil {
+		return nil
+	}
+	switch c := cache.Unwrap(b.Cache).(type) {
+	case *cache.RedisCache:
+		return c
+	case *cache.TieredCache:
+		return c.Remote
+	}
+	return nil
+}
+
+// createCache sets b.Cache to c, if given, or otherwise to the cache selected by
+// CACHE. A redis pool is also created when sessions or jobs are stored in redis.
+func (b *Boilme) createCache(c cache.Cache) error {
+	if c != nil {
+		b.Cache = c
+		switch x := cache.Unwrap(c).(type) {
+		case *cache.RedisCache:
+			b.redisPool = x.Conn
+		case *cache.TieredCache:
+			b.redisPool = x.Remote.Conn
+		case *cache.BadgerCache:
+			b.badgerConn = x.Conn
+		}
+	}
+
+	if b.Cache == nil && b.Config.Cache == "memory" {
+		b.Cache = b.createClientMemoryCache()
+	}
+
+	if b.Cache == nil && b.Config.Cache == "badger" {
+		badgerCache, err := b.createClientBadgerCache()
+		if err != nil {
+			return err
+		}
+		b.Cache = badgerCache
+		b.badgerConn = badgerCache.Conn
+	}
+
+	if (b.Cache == nil && (b.Config.Cache == "redis" || b.Config.Cache == "tiered")) || (b.redisPool == nil && (b.Config.Session.Type == "redis" || b.Config.Jobs.Backend == "redis")) {
+		redisCache := b.createClientRedisCache()
+		b.redisPool = redisCache.Conn
+		switch {
+		case b.Cache != nil:
+		case b.Config.Cache == "tiered":
+			b.Cache = cache.NewTieredCache(redisCache, b.createClientMemoryCache())
+		default:
+			b.Cache = redisCache
+		}
+	}
+
+	if b.badgerConn != nil {
+		badgerConn := b.badgerConn
+		_, err := b.Scheduler.AddFunc("@daily", func() {
+			_ = badgerConn.RunValueLogGC(0.7)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Init creates necessary folders for our Boilme application
 func (b *Boilme) Init(p initPaths) error {
 	root := p.rootPath
@@ -270,61 +476,168 @@ func (b *Boilme) checkDotEnv(path string) error {
 	return nil
 }
 
-func (b *Boilme) startLoggers() (*log.Logger, *log.Logger) {
-	var infoLog *log.Logger
-	var errorLog *log.Logger
+// createJobs sets b.Jobs to run jobs from queue, if given, or otherwise from the
+// queue selected by JOBS_BACKEND. Workers are started by ListenAndServe.
+func (b *Boilme) createJobs(queue jobs.Queue) error {
+	if queue == nil {
+		switch b.Config.Jobs.Backend {
+		case "redis":
+			if b.redisPool == nil {
+				return errors.New("JOBS_BACKEND is redis, but no redis connection is available")
+			}
+			queue = &jobs.RedisQueue{Conn: b.redisPool, Prefix: b.Config.Redis.Prefix}
+		case "database":
+			if b.DB.Pool == nil {
+				return errors.New("JOBS_BACKEND is database, but no database connection is available")
+			}
+			queue = &jobs.SQLQueue{DB: b.DB.Pool, Dialect: b.DB.Dialect}
+		default:
+			queue = jobs.NewMemoryQueue()
+		}
+	}
+
+	b.Jobs = jobs.New(queue)
+	b.Jobs.Workers = b.Config.Jobs.Workers
+	b.Jobs.PollInterval = b.Config.Jobs.PollInterval
+	b.Jobs.Lease = b.Config.Jobs.Lease
+	b.Jobs.Logger = b.Logger
+	return nil
+}
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+// createOutbox sends the mail received on b.Mail.Jobs through an outbox kept in
+// store, if given, or otherwise in the store selected by JOBS_BACKEND. Sent and
+// failed messages are pruned daily, once they are older than MAIL_OUTBOX_RETENTION.
+func (b *Boilme) createOutbox(store mailer.OutboxStore) error {
+	if store == nil {
+		switch b.Config.Jobs.Backend {
+		case "redis":
+			store = &mailer.RedisOutboxStore{Conn: b.redisPool, Prefix: b.Config.Redis.Prefix}
+		case "database":
+			store = &mailer.SQLOutboxStore{DB: b.DB.Pool, Dialect: b.DB.Dialect}
+		default:
+			store = mailer.NewMemoryOutboxStore()
+		}
+	}
 
-	return infoLog, errorLog
+	outbox := mailer.NewOutbox(&b.Mail, b.Jobs, store)
+	outbox.Workers = b.Config.Mail.Workers
+	outbox.MaxAttempts = b.Config.Mail.MaxAttempts
+	outbox.Backoff = b.Config.Mail.RetryBackoff
+	outbox.Logger = b.Logger
+	outbox.Register()
+	b.Mail.Outbox = outbox
+
+	retention := b.Config.Mail.OutboxRetention
+	_, err := b.Scheduler.AddFunc("@daily", func() {
+		n, err := outbox.Prune(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			b.Logger.Error("could not prune the mail outbox", "error", err)
+			return
+		}
+		b.Logger.Debug("pruned the mail outbox", "messages", n)
+	})
+	return err
+}
+
+// createSuppressions returns list, if given, or otherwise a suppression list in
+// the store selected by JOBS_BACKEND when MAIL_SUPPRESSIONS is set
+func (b *Boilme) createSuppressions(list mailer.SuppressionList) mailer.SuppressionList {
+	if list != nil || !b.Config.Mail.Suppressions {
+		return list
+	}
+	switch b.Config.Jobs.Backend {
+	case "redis":
+		return &mailer.RedisSuppressionList{Conn: b.redisPool, Prefix: b.Config.Redis.Prefix}
+	case "database":
+		return &mailer.SQLSuppressionList{DB: b.DB.Pool, Dialect: b.DB.Dialect}
+	default:
+		return mailer.NewMemorySuppressionList()
+	}
 }
 
 func (b *Boilme) createRenderer() {
 	myRenderer := render.Render{
-		Renderer: b.config.renderer,
+		Renderer: b.Config.Renderer,
 		RootPath: b.RootPath,
-		Port:     b.config.port,
+		Port:     b.Config.Port,
 		JetViews: b.JetViews,
 		Session:  b.Session,
+		Logger:   b.Logger,
+	}
+	if b.tracing() {
+		myRenderer.Tracer = b.Tracer
 	}
 	b.Render = &myRenderer
 }
 
 func (b *Boilme) createMailer() mailer.Mail {
-	port, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
 	m := mailer.Mail{
-		Domain:      os.Getenv("MAIL_DOMAIN"),
+		Domain:      b.Config.Mail.Domain,
 		Templates:   b.RootPath + "/mail",
-		Host:        os.Getenv("SMTP_HOST"),
-		Port:        port,
-		Username:    os.Getenv("SMTP_USERNAME"),
-		Password:    os.Getenv("SMTP_PASSWORD"),
-		Encryption:  os.Getenv("SMTP_ENCRYPTION"),
-		FromName:    os.Getenv("FROM_NAME"),
-		FromAddress: os.Getenv("FROM_ADDRESS"),
+		Host:        b.Config.Mail.Host,
+		Port:        b.Config.Mail.Port,
+		Username:    b.Config.Mail.Username,
+		Password:    b.Config.Mail.Password,
+		Encryption:  b.Config.Mail.Encryption,
+		FromName:    b.Config.Mail.FromName,
+		FromAddress: b.Config.Mail.FromAddress,
 		Jobs:        make(chan mailer.Message, 20),
 		Results:     make(chan mailer.Result, 20),
-		API:         os.Getenv("MAILER_API"),
-		APIKey:      os.Getenv("MAILER_KEY"),
-		APIUrl:      os.Getenv("MAILER_URL"),
+		API:         b.Config.Mail.API,
+		APIKey:      b.Config.Mail.APIKey,
+		APIUrl:      b.Config.Mail.APIURL,
+		WebhookKey:  b.Config.Mail.WebhookKey,
+		WebhookAuth: b.Config.Mail.WebhookAuth,
+
+		DKIMDomain:    b.Config.Mail.DKIMDomain,
+		DKIMSelector:  b.Config.Mail.DKIMSelector,
+		DKIMKeyFile:   b.Config.Mail.DKIMKeyFile,
+		SMIMECertFile: b.Config.Mail.SMIMECertFile,
+		SMIMEKeyFile:  b.Config.Mail.SMIMEKeyFile,
 	}
 	return m
 }
 
 func (b *Boilme) createClientRedisCache() *cache.RedisCache {
 	cacheClient := cache.RedisCache{
-		Conn:   b.createRedisPool(),
-		Prefix: b.config.redis.prefix,
+		Conn:       b.createRedisPool(),
+		Prefix:     b.Config.Redis.Prefix,
+		Serializer: b.cacheSerializer(),
 	}
 	return &cacheClient
 }
 
-func (b *Boilme) createClientBadgerCache() *cache.BadgerCache {
+func (b *Boilme) createClientBadgerCache() (*cache.BadgerCache, error) {
+	conn, err := b.createBadgerConn()
+	if err != nil {
+		return nil, err
+	}
+
 	cacheClient := cache.BadgerCache{
-		Conn: b.createBadgerConn(),
+		Conn:       conn,
+		Serializer: b.cacheSerializer(),
 	}
-	return &cacheClient
+	return &cacheClient, nil
+}
+
+// createClientMemoryCache returns a memory cache bounded by CACHE_MAX_ENTRIES and
+// CACHE_MAX_BYTES
+func (b *Boilme) createClientMemoryCache() *cache.MemoryCache {
+	return &cache.MemoryCache{
+		MaxEntries: b.Config.CacheMaxEntries,
+		MaxBytes:   b.Config.CacheMaxBytes,
+		Serializer: b.cacheSerializer(),
+	}
+}
+
+// cacheSerializer returns the serializer of CACHE_SERIALIZER, which the config
+// has been checked to name
+func (b *Boilme) cacheSerializer() cache.Serializer {
+	s, err := cache.SerializerFor(b.Config.CacheSerializer)
+	if err != nil {
+		return cache.Gob
+	}
+	return s
 }
 
 func (b *Boilme) createRedisPool() *redis.Pool {
@@ -334,8 +647,8 @@ func (b *Boilme) createRedisPool() *redis.Pool {
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
 			return redis.Dial("tcp",
-				b.config.redis.host,
-				redis.DialPassword(b.config.redis.password))
+				b.Config.Redis.Host,
+				redis.DialPassword(b.Config.Redis.Password))
 		},
 
 		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
@@ -345,41 +658,57 @@ func (b *Boilme) createRedisPool() *redis.Pool {
 	}
 }
 
-func (b *Boilme) createBadgerConn() *badger.DB {
-	db, err := badger.Open(badger.DefaultOptions(b.RootPath + "/tmp/badger"))
-	if err != nil {
-		return nil
-	}
-	return db
+func (b *Boilme) createBadgerConn() (*badger.DB, error) {
+	return badger.Open(badger.DefaultOptions(b.RootPath + "/tmp/badger"))
 }
 
 // BuildDSN builds the datasource name for our database, and returns it as a string
 func (b *Boilme) BuildDSN() string {
 	var dsn string
 
-	switch os.Getenv("DATABASE_TYPE") {
-	case "postgres", "postgresql":
+	switch NormalizeDialect(b.Config.Database.Type) {
+	case DialectPostgres:
 		dsn = fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s timezone=UTC connect_timeout=5",
-			os.Getenv("DATABASE_HOST"),
-			os.Getenv("DATABASE_PORT"),
-			os.Getenv("DATABASE_USER"),
-			os.Getenv("DATABASE_NAME"),
-			os.Getenv("DATABASE_SSL_MODE"))
+			b.Config.Database.Host,
+			b.Config.Database.Port,
+			b.Config.Database.User,
+			b.Config.Database.Name,
+			b.Config.Database.SSLMode)
 
 		// we check to see if a database password has been supplied, since including "password=" with nothing
 		// after it sometimes causes postgres to fail to allow a connection.
-		if os.Getenv("DATABASE_PASS") != "" {
-			dsn = fmt.Sprintf("%s password=%s", dsn, os.Getenv("DATABASE_PASS"))
+		if b.Config.Database.Pass != "" {
+			dsn = fmt.Sprintf("%s password=%s", dsn, b.Config.Database.Pass)
+		}
+
+	case DialectMySQL:
+		port := b.Config.Database.Port
+		if port == "" {
+			port = "3306"
+		}
+		dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?collation=utf8_unicode_ci&timeout=5s&parseTime=true&readTimeout=5s",
+			b.Config.Database.User,
+			b.Config.Database.Pass,
+			b.Config.Database.Host,
+			port,
+			b.Config.Database.Name)
+
+		// the mysql driver rejects an empty tls value, and knows nothing of the postgres style "disable"
+		switch b.Config.Database.SSLMode {
+		case "":
+		case "disable":
+			dsn += "&tls=false"
+		default:
+			dsn += "&tls=" + b.Config.Database.SSLMode
 		}
 
-	case "mysql", "mariadb":
-		dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?collation=utf8_unicode_ci&timeout=5s&parseTime=true&tls=%s&readTimeout=5s",
-			os.Getenv("DATABASE_USER"),
-			os.Getenv("DATABASE_PASS"),
-			os.Getenv("DATABASE_HOST"),
-			os.Getenv("DATABASE_PORT"),
-			os.Getenv("DATABASE_NAME"),
-			os.Getenv("DATABASE_SSL_MODE"))
+	case DialectSQLite:
+		// DATABASE_NAME is the database file, relative to the application root
+		path := b.Config.Database.Name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(b.RootPath, path)
+		}
+		dsn = fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", path)
 
 	default:
 
@@ -391,52 +720,47 @@ func (b *Boilme) BuildDSN() string {
 func (b *Boilme) createFileSystems() map[string]interface{} {
 	fileSystems := make(map[string]interface{})
 
-	if os.Getenv("S3_KEY") != "" {
+	if b.Config.S3.Key != "" {
 		s3 := s3filesystem.S3{
-			Key:      os.Getenv("S3_KEY"),
-			Secret:   os.Getenv("S3_SECRET"),
-			Region:   os.Getenv("S3_REGION"),
-			Endpoint: os.Getenv("S3_ENDPOINT"),
-			Bucket:   os.Getenv("S3_BUCKET"),
+			Key:      b.Config.S3.Key,
+			Secret:   b.Config.S3.Secret,
+			Region:   b.Config.S3.Region,
+			Endpoint: b.Config.S3.Endpoint,
+			Bucket:   b.Config.S3.Bucket,
 		}
 		fileSystems["S3"] = s3
 		b.S3 = s3
 	}
 
-	if os.Getenv("MINIO_SECRET") != "" {
-		useSSL := false
-		if strings.ToLower(os.Getenv("MINIO_USESSL")) == "true" {
-			useSSL = true
-		}
-
+	if b.Config.Minio.Secret != "" {
 		minio := miniofilesystem.Minio{
-			Endpoint: os.Getenv("MINIO_ENDPOINT"),
-			Key:      os.Getenv("MINIO_KEY"),
-			Secret:   os.Getenv("MINIO_SECRET"),
-			UseSSL:   useSSL,
-			Region:   os.Getenv("MINIO_REGION"),
-			Bucket:   os.Getenv("MINIO_BUCKET"),
+			Endpoint: b.Config.Minio.Endpoint,
+			Key:      b.Config.Minio.Key,
+			Secret:   b.Config.Minio.Secret,
+			UseSSL:   b.Config.Minio.UseSSL,
+			Region:   b.Config.Minio.Region,
+			Bucket:   b.Config.Minio.Bucket,
 		}
 		fileSystems["MINIO"] = minio
 		b.Minio = minio
 	}
 
-	if os.Getenv("SFTP_HOST") != "" {
+	if b.Config.SFTP.Host != "" {
 		sftp := sftpfilesystem.SFTP{
-			Host: os.Getenv("SFTP_HOST"),
-			User: os.Getenv("SFTP_USER"),
-			Pass: os.Getenv("SFTP_PASS"),
-			Port: os.Getenv("SFTP_PORT"),
+			Host: b.Config.SFTP.Host,
+			User: b.Config.SFTP.User,
+			Pass: b.Config.SFTP.Pass,
+			Port: b.Config.SFTP.Port,
 		}
 		fileSystems["SFTP"] = sftp
 		b.SFTP = sftp
 	}
 
-	if os.Getenv("WEBDAV_HOST") != "" {
+	if b.Config.WebDAV.Host != "" {
 		webDav := webdavfilesystem.WebDAV{
-			Host: os.Getenv("WEBDAV_HOST"),
-			User: os.Getenv("WEBDAV_USER"),
-			Pass: os.Getenv("WEBDAV_PASS"),
+			Host: b.Config.WebDAV.Host,
+			User: b.Config.WebDAV.User,
+			Pass: b.Config.WebDAV.Pass,
 		}
 		fileSystems["WEBDAV"] = webDav
 		b.WebDAV = webDav
@@ -445,40 +769,3 @@ func (b *Boilme) createFileSystems() map[string]interface{} {
 	return fileSystems
 }
 
-type RPCServer struct{}
-
-func (r *RPCServer) MaintenanceMode(inMaintenanceMode bool, resp *string) error {
-	if inMaintenanceMode {
-		maintenanceMode = true
-		*resp = "Server in maintenance mode"
-	} else {
-		maintenanceMode = false
-		*resp = "Server live!"
-	}
-	return nil
-}
-
-func (b *Boilme) listenRPC() {
-	// if nothing specified for rpc port, don't start
-	if os.Getenv("RPC_PORT") != "" {
-		b.InfoLog.Println("Starting RPC server on port", os.Getenv("RPC_PORT"))
-		err := rpc.Register(new(RPCServer))
-		if err != nil {
-			b.ErrorLog.Println(err)
-			return
-		}
-		listen, err := net.Listen("tcp", "127.0.0.1:"+os.Getenv("RPC_PORT"))
-		if err != nil {
-			b.ErrorLog.Println(err)
-			return
-		}
-		for {
-			rpcConn, err := listen.Accept()
-			if err != nil {
-				continue
-			}
-			go rpc.ServeConn(rpcConn)
-		}
-
-	}
-}
@@ -17,7 +17,7 @@ func TestBadgerCache_Has(t *testing.T) {
 		t.Error("foo found in cache, and it shouldn't be there")
 	}
 
-	_ = testBadgerCache.Set("foo", "bar")
+	_ = testBadgerCache.Set("foo", "bar", 0)
 	inCache, err = testBadgerCache.Has("foo")
 	if err != nil {
 		t.Error(err)
@@ -34,7 +34,7 @@ func TestBadgerCache_Has(t *testing.T) {
 }
 
 func TestBadgerCache_Get(t *testing.T) {
-	err := testBadgerCache.Set("foo", "bar")
+	err := testBadgerCache.Set("foo", "bar", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -50,7 +50,7 @@ func TestBadgerCache_Get(t *testing.T) {
 }
 
 func TestBadgerCache_Forget(t *testing.T) {
-	err := testBadgerCache.Set("foo", "foo")
+	err := testBadgerCache.Set("foo", "foo", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -72,7 +72,7 @@ func TestBadgerCache_Forget(t *testing.T) {
 }
 
 func TestBadgerCache_Empty(t *testing.T) {
-	err := testBadgerCache.Set("alpha", "beta")
+	err := testBadgerCache.Set("alpha", "beta", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -93,17 +93,17 @@ func TestBadgerCache_Empty(t *testing.T) {
 }
 
 func TestBadgerCache_EmptyByMatch(t *testing.T) {
-	err := testBadgerCache.Set("alpha", "beta")
+	err := testBadgerCache.Set("alpha", "beta", 0)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = testBadgerCache.Set("alpha2", "beta2")
+	err = testBadgerCache.Set("alpha2", "beta2", 0)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = testBadgerCache.Set("beta", "beta")
+	err = testBadgerCache.Set("beta", "beta", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -1,25 +1,73 @@
 package cache
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
 )
 
+// BadgerCache stores values in a badger database, with Serializer; nil means Gob
 type BadgerCache struct {
-	Conn *badger.DB
-	Prefix string
+	Conn       *badger.DB
+	Prefix     string
+	Serializer Serializer
+	Observe    Observer
+}
+
+var (
+	_ ByteCache = (*BadgerCache)(nil)
+	_ TagCache  = (*BadgerCache)(nil)
+	_ Locker    = (*BadgerCache)(nil)
+	_ Counter   = (*BadgerCache)(nil)
+)
+
+// updateAttempts is how many times BadgerCache runs a transaction that conflicts
+// with another before it gives up
+const updateAttempts = 10
+
+// counting lets one counter be changed at a time: badger makes transactions that
+// change the same key at once fail, rather than wait for each other. A database is
+// only opened by one process, so this is enough to keep increments apart.
+var counting sync.Mutex
+
+// tagIndex returns the prefix of the index keys of tag: a key tagged with it is
+// indexed under tagIndex(tag)+key, with an empty value that expires with the key
+func tagIndex(tag string) []byte {
+	return []byte("#tag:" + tag + "\x00")
+}
+
+func (b *BadgerCache) Codec() Serializer {
+	return serializerOr(b.Serializer)
 }
 
 func (b *BadgerCache) Has(str string) (bool, error) {
-	_, err := b.Get(str)
+	_, err := b.get(str)
 	if err != nil {
+		b.Observe.lookup("has", false, nil)
 		return false, nil
 	}
+	b.Observe.lookup("has", true, nil)
 	return true, nil
 }
 
 func (b *BadgerCache) Get(str string) (interface{}, error) {
+	item, err := b.get(str)
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+		b.Observe.lookup("get", false, nil)
+		err = fmt.Errorf("%w: %s: %w", ErrMiss, str, err)
+	default:
+		b.Observe.lookup("get", err == nil, err)
+	}
+	return item, err
+}
+
+func (b *BadgerCache) get(str string) (interface{}, error) {
 	var fromCache []byte
 
 	err := b.Conn.View(func(txn *badger.Txn) error {
@@ -28,57 +76,265 @@ func (b *BadgerCache) Get(str string) (interface{}, error) {
 			return err
 		}
 
-		err = item.Value(func(val []byte) error {
-			fromCache = append([]byte{}, val...)
-			return nil
-		})
-		if err != nil {
-			return err
+		fromCache, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var item interface{}
+	if err := b.Codec().Unmarshal(fromCache, &item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// GetBytes reads every key in one transaction
+func (b *BadgerCache) GetBytes(keys ...string) ([][]byte, error) {
+	data := make([][]byte, len(keys))
+
+	err := b.Conn.View(func(txn *badger.Txn) error {
+		for i, k := range keys {
+			item, err := txn.Get([]byte(k))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if data[i], err = item.ValueCopy(nil); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
+	for i := range keys {
+		b.Observe.lookup("get", err == nil && data[i] != nil, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return data, nil
+}
 
-	decoded, err := decode(string(fromCache))
+func (b *BadgerCache) Set(str string, value interface{}, ttl time.Duration) error {
+	encoded, err := b.Codec().Marshal(value)
 	if err != nil {
-		return nil, err
+		b.Observe.write("set", err)
+		return err
 	}
+	return b.SetBytes(map[string][]byte{str: encoded}, ttl)
+}
 
-	item := decoded[str]
+// SetBytes writes every key in one transaction
+func (b *BadgerCache) SetBytes(items map[string][]byte, ttl time.Duration) (err error) {
+	defer func() { b.Observe.write("set", err) }()
 
-	return item, nil
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		for k, data := range items {
+			e := badger.NewEntry([]byte(k), data)
+			if ttl > 0 {
+				e = e.WithTTL(ttl)
+			}
+			if err := txn.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetTagged sets key and its index keys in one transaction
+func (b *BadgerCache) SetTagged(str string, value interface{}, ttl time.Duration, tags ...string) (err error) {
+	defer func() { b.Observe.write("set", err) }()
+
+	encoded, err := b.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return b.Conn.Update(func(txn *badger.Txn) error {
+		entries := []*badger.Entry{badger.NewEntry([]byte(str), encoded)}
+		for _, tag := range tags {
+			entries = append(entries, badger.NewEntry(append(tagIndex(tag), str...), nil))
+		}
+		for _, e := range entries {
+			if ttl > 0 {
+				e = e.WithTTL(ttl)
+			}
+			if err := txn.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (b *BadgerCache) Set(str string, value interface{}, expires ...int) error {
-	entry := Entry{}
+func (b *BadgerCache) FlushTags(tags ...string) (err error) {
+	defer func() { b.Observe.write("flush_tags", err) }()
 
-	entry[str] = value
-	encoded, err := encode(entry)
+	var keys [][]byte
+	err = b.Conn.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for _, tag := range tags {
+			prefix := tagIndex(tag)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				index := it.Item().KeyCopy(nil)
+				keys = append(keys, index, index[len(prefix):])
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	if len(expires) > 0 {
-		err = b.Conn.Update(func(txn *badger.Txn) error {
-			e := badger.NewEntry([]byte(str), encoded).WithTTL(time.Second * time.Duration(expires[0]))
-			err = txn.SetEntry(e)
+	return b.deleteKeys(keys)
+}
+
+// deleteKeys deletes keys in as many transactions as it takes
+func (b *BadgerCache) deleteKeys(keys [][]byte) error {
+	wb := b.Conn.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
 			return err
-		})
-	} else {
-		err = b.Conn.Update(func(txn *badger.Txn) error {
-			e := badger.NewEntry([]byte(str), encoded)
-			err = txn.SetEntry(e)
+		}
+	}
+	return wb.Flush()
+}
+
+// update runs fn in a read-write transaction, again when it conflicts with another
+func (b *BadgerCache) update(fn func(txn *badger.Txn) error) (err error) {
+	for i := 0; i < updateAttempts; i++ {
+		if err = b.Conn.Update(fn); !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// lockKey returns the key of the lock name, which Empty leaves alone
+func lockKey(name string) []byte {
+	return []byte("#lock:" + name)
+}
+
+// Lock holds the lock in the database, which a single process can open: locks of
+// a BadgerCache keep the goroutines of one process apart.
+func (b *BadgerCache) Lock(name string, ttl time.Duration) (token string, err error) {
+	defer func() { b.Observe.lookup("lock", err == nil, ignore(err, ErrLocked)) }()
+
+	token = newToken()
+	err = b.Conn.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(lockKey(name))
+		if err == nil {
+			return ErrLocked
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
 			return err
-		})
+		}
+		return txn.SetEntry(badger.NewEntry(lockKey(name), []byte(token)).WithTTL(ttl))
+	})
+	if errors.Is(err, badger.ErrConflict) {
+		// another owner took it meanwhile
+		err = ErrLocked
 	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
 
+// checkLock returns ErrNotHeld unless token holds the lock name in txn
+func checkLock(txn *badger.Txn, name, token string) error {
+	item, err := txn.Get(lockKey(name))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotHeld
+	}
+	if err != nil {
+		return err
+	}
+	held, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	if string(held) != token {
+		return ErrNotHeld
+	}
 	return nil
 }
 
-func (b *BadgerCache) Forget(str string) error {
-	err := b.Conn.Update(func(txn *badger.Txn) error {
+func (b *BadgerCache) Renew(name, token string, ttl time.Duration) (err error) {
+	defer func() { b.Observe.write("renew", ignore(err, ErrNotHeld)) }()
+
+	return b.update(func(txn *badger.Txn) error {
+		if err := checkLock(txn, name, token); err != nil {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry(lockKey(name), []byte(token)).WithTTL(ttl))
+	})
+}
+
+func (b *BadgerCache) Unlock(name, token string) (err error) {
+	defer func() { b.Observe.write("unlock", ignore(err, ErrNotHeld)) }()
+
+	return b.update(func(txn *badger.Txn) error {
+		if err := checkLock(txn, name, token); err != nil {
+			return err
+		}
+		return txn.Delete(lockKey(name))
+	})
+}
+
+func (b *BadgerCache) Increment(key string, by int64, ttl time.Duration) (n int64, err error) {
+	defer func() { b.Observe.write("increment", err) }()
+
+	counting.Lock()
+	defer counting.Unlock()
+	err = b.update(func(txn *badger.Txn) error {
+		e := badger.NewEntry([]byte(key), nil)
+		n = 0
+
+		item, err := txn.Get([]byte(key))
+		switch {
+		case err == nil:
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return fmt.Errorf("cache: %s is not a counter", key)
+			}
+			e.ExpiresAt = item.ExpiresAt()
+		case !errors.Is(err, badger.ErrKeyNotFound):
+			return err
+		}
+
+		n += by
+		e.Value = []byte(strconv.FormatInt(n, 10))
+		if e.ExpiresAt == 0 && ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		return txn.SetEntry(e)
+	})
+	return n, err
+}
+
+func (b *BadgerCache) Decrement(key string, by int64, ttl time.Duration) (int64, error) {
+	return b.Increment(key, -by, ttl)
+}
+
+func (b *BadgerCache) Forget(str string) (err error) {
+	defer func() { b.Observe.write("forget", err) }()
+
+	err = b.Conn.Update(func(txn *badger.Txn) error {
 		err := txn.Delete([]byte(str))
 		return err
 	})
@@ -86,60 +342,39 @@ func (b *BadgerCache) Forget(str string) error {
 	return err
 }
 
-func (b *BadgerCache) EmptyByMatch(str string) error {
+func (b *BadgerCache) EmptyByMatch(str string) (err error) {
+	defer func() { b.Observe.write("empty_by_match", err) }()
+
 	return b.emptyByMatch(str)
 }
 
-func (b *BadgerCache) Empty() error {
+func (b *BadgerCache) Empty() (err error) {
+	defer func() { b.Observe.write("empty", err) }()
+
 	return b.emptyByMatch("")
 }
 
 func (b *BadgerCache) emptyByMatch(str string) error {
-	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := b.Conn.Update(func(txn *badger.Txn) error {
-			for _, key := range keysForDelete {
-				if err := txn.Delete(key); err != nil {
-					return err
-				}
-			}
-			return nil
-		}); err != nil {
-			return err
-		}
-		return nil
-	}
-
-	collectSize := 100000
-
-	err := b.Conn.View(func(txn *badger.Txn) error{
+	var keys [][]byte
+	err := b.Conn.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.AllVersions = false
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-
 		for it.Seek([]byte(str)); it.ValidForPrefix([]byte(str)); it.Next() {
 			key := it.Item().KeyCopy(nil)
-			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysForDelete); err != nil {
-					return err
-				}
-			}
-		}
-
-		if keysCollected > 0 {
-			if err := deleteKeys(keysForDelete); err != nil {
-				return err
+			if bytes.HasPrefix(key, lockKey("")) {
+				continue
 			}
+			keys = append(keys, key)
 		}
-
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
-}
\ No newline at end of file
+	return b.deleteKeys(keys)
+}
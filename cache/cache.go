@@ -3,28 +3,120 @@ package cache
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
 
+// Cache is implemented by every cache. Set keeps a value for ttl, or until it is
+// forgotten when ttl is 0. Get returns an error wrapping ErrMiss for keys that are
+// not in the cache.
 type Cache interface {
 	Has(string) (bool, error)
 	Get(string) (interface{}, error)
-	Set(string, interface{}, ...int) error
+	Set(string, interface{}, time.Duration) error
 	Forget(string) error
 	EmptyByMatch(string) error
 	Empty() error
 }
 
+// ErrMiss is wrapped by the errors returned for keys that are not in a cache
+var ErrMiss = errors.New("cache: miss")
+
+// ByteCache is implemented by caches that store values as the bytes their
+// Serializer makes of them. The typed helpers, such as Get[T], decode those bytes
+// into the type asked for, and get and set many keys at once through it.
+type ByteCache interface {
+	Cache
+
+	// Codec returns the serializer values are stored with
+	Codec() Serializer
+
+	// GetBytes returns the stored bytes of each key, in order, and nil for the keys
+	// that are not in the cache
+	GetBytes(keys ...string) ([][]byte, error)
+
+	// SetBytes stores each value under its key, for ttl
+	SetBytes(items map[string][]byte, ttl time.Duration) error
+}
+
+// Observer is told the outcome of every cache operation, typically to record metrics.
+// op is the method, in lower case; result is hit or miss for lookups, and for locks
+// (a miss being a lock held by another owner), ok for other operations, or error.
+type Observer func(op, result string)
+
+// lookup reports the outcome of Has or Get to o, if it is set
+func (o Observer) lookup(op string, found bool, err error) {
+	switch {
+	case o == nil:
+	case err != nil:
+		o(op, "error")
+	case found:
+		o(op, "hit")
+	default:
+		o(op, "miss")
+	}
+}
+
+// write reports the outcome of any other operation to o, if it is set
+func (o Observer) write(op string, err error) {
+	switch {
+	case o == nil:
+	case err != nil:
+		o(op, "error")
+	default:
+		o(op, "ok")
+	}
+}
+
+// RedisCache stores values in redis, under Prefix, with Serializer; nil means Gob
 type RedisCache struct {
-	Conn   *redis.Pool
-	Prefix string
+	Conn       *redis.Pool
+	Prefix     string
+	Serializer Serializer
+	Observe    Observer
 }
 
+var (
+	_ ByteCache = (*RedisCache)(nil)
+	_ TagCache  = (*RedisCache)(nil)
+	_ Locker    = (*RedisCache)(nil)
+	_ Counter   = (*RedisCache)(nil)
+)
+
+// The scripts that check the token of a lock before they change it, and that set
+// the expiry of a new counter along with its value
+var (
+	unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	renewScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	incrementScript = redis.NewScript(1, `
+local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return n`)
+)
+
+// delBatch is the number of keys each DEL sent by RedisCache deletes at most
+const delBatch = 500
+
+// Entry is how values were once stored: gob encoded, keyed by their key. Values
+// stored that way can still be read with Gob.
 type Entry map[string]interface{}
 
-func (b *RedisCache) Has(str string) (bool, error) {
+func (b *RedisCache) Has(str string) (found bool, err error) {
+	defer func() { b.Observe.lookup("has", found, err) }()
+
 	key := fmt.Sprintf("%s:%s", b.Prefix, str)
 	conn := b.Conn.Get()
 	defer conn.Close()
@@ -37,6 +129,7 @@ func (b *RedisCache) Has(str string) (bool, error) {
 	return ok, nil
 }
 
+// encode and decode write and read an Entry
 func encode(item Entry) ([]byte, error) {
 	b := bytes.Buffer{}
 	e := gob.NewEncoder(&b)
@@ -59,59 +152,321 @@ func decode(str string) (Entry, error) {
 	return item, nil
 }
 
+func (b *RedisCache) key(str string) string {
+	return fmt.Sprintf("%s:%s", b.Prefix, str)
+}
+
+func (b *RedisCache) Codec() Serializer {
+	return serializerOr(b.Serializer)
+}
+
 func (b *RedisCache) Get(str string) (interface{}, error) {
-	key := fmt.Sprintf("%s:%s", b.Prefix, str)
+	data, err := b.GetBytes(str)
+	if err != nil {
+		return nil, err
+	}
+	if data[0] == nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrMiss, str, redis.ErrNil)
+	}
+
+	var item interface{}
+	if err := b.Codec().Unmarshal(data[0], &item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// GetBytes gets every key in one round trip
+func (b *RedisCache) GetBytes(keys ...string) ([][]byte, error) {
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		args[i] = b.key(k)
+	}
+
 	conn := b.Conn.Get()
 	defer conn.Close()
 
-	cacheEntry, err := redis.Bytes(conn.Do("GET", key))
+	data, err := redis.ByteSlices(conn.Do("MGET", args...))
+	for i := range keys {
+		b.Observe.lookup("get", err == nil && data[i] != nil, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return data, nil
+}
+
+// getWithTTL gets every key, and the time it has left, in one round trip. The time
+// is 0 for keys that do not expire.
+func (b *RedisCache) getWithTTL(keys ...string) ([][]byte, []time.Duration, error) {
+	conn := b.Conn.Get()
+	defer conn.Close()
 
-	decoded, err := decode(string(cacheEntry))
+	data := make([][]byte, len(keys))
+	ttls := make([]time.Duration, len(keys))
+	err := func() error {
+		for _, k := range keys {
+			if err := conn.Send("GET", b.key(k)); err != nil {
+				return err
+			}
+			if err := conn.Send("PTTL", b.key(k)); err != nil {
+				return err
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for i := range keys {
+			v, err := redis.Bytes(conn.Receive())
+			if err != nil && !errors.Is(err, redis.ErrNil) {
+				return err
+			}
+			data[i] = v
+			ms, err := redis.Int64(conn.Receive())
+			if err != nil {
+				return err
+			}
+			if ms > 0 {
+				ttls[i] = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return nil
+	}()
+	for i := range keys {
+		b.Observe.lookup("get", err == nil && data[i] != nil, err)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return data, ttls, nil
+}
 
-	item := decoded[key]
-
-	return item, nil
+func (b *RedisCache) Set(str string, value interface{}, ttl time.Duration) error {
+	encoded, err := b.Codec().Marshal(value)
+	if err != nil {
+		b.Observe.write("set", err)
+		return err
+	}
+	return b.SetBytes(map[string][]byte{str: encoded}, ttl)
 }
 
-func (b *RedisCache) Set(str string, value interface{}, expires ...int) error {
-	key := fmt.Sprintf("%s:%s", b.Prefix, str)
+// SetBytes sets every key in one transaction
+func (b *RedisCache) SetBytes(items map[string][]byte, ttl time.Duration) (err error) {
+	defer func() { b.Observe.write("set", err) }()
+
 	conn := b.Conn.Get()
 	defer conn.Close()
 
-	entry := Entry{}
-	entry[key] = value
-	encoded, err := encode(entry)
+	args := func(key string, data []byte) []interface{} {
+		if ttl > 0 {
+			return []interface{}{b.key(key), data, "PX", max(ttl.Milliseconds(), 1)}
+		}
+		return []interface{}{b.key(key), data}
+	}
+
+	if len(items) == 1 {
+		for k, data := range items {
+			_, err = conn.Do("SET", args(k, data)...)
+		}
+		return err
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	for k, data := range items {
+		if err := conn.Send("SET", args(k, data)...); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// tagKey returns the key of the set of keys tagged with tag. It is not under
+// Prefix+":", so that EmptyByMatch leaves it alone.
+func (b *RedisCache) tagKey(tag string) string {
+	return fmt.Sprintf("%s#tag:%s", b.Prefix, tag)
+}
+
+// SetTagged sets key and adds it to the sets of its tags in one transaction
+func (b *RedisCache) SetTagged(str string, value interface{}, ttl time.Duration, tags ...string) (err error) {
+	defer func() { b.Observe.write("set", err) }()
+
+	encoded, err := b.Codec().Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	if len(expires) > 0 {
-		_, err := conn.Do("SETEX", key, expires[0], string(encoded))
-		if err != nil {
+	conn := b.Conn.Get()
+	defer conn.Close()
+
+	args := []interface{}{b.key(str), encoded}
+	if ttl > 0 {
+		args = append(args, "PX", max(ttl.Milliseconds(), 1))
+	}
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := conn.Send("SET", args...); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := conn.Send("SADD", b.tagKey(tag), str); err != nil {
 			return err
 		}
-	} else {
-		_, err := conn.Do("SET", key, string(encoded))
-		if err != nil {
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (b *RedisCache) FlushTags(tags ...string) error {
+	_, err := b.flushTags(tags...)
+	return err
+}
+
+// flushTags deletes the keys tagged with any of tags, and returns them. The sets
+// of the tags are read and deleted in one transaction, so a key tagged meanwhile
+// is either deleted or left in a new set.
+func (b *RedisCache) flushTags(tags ...string) (keys []string, err error) {
+	defer func() { b.Observe.write("flush_tags", err) }()
+
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	tagKeys := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		tagKeys[i] = b.tagKey(tag)
+	}
+
+	conn := b.Conn.Get()
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return nil, err
+	}
+	if err := conn.Send("SUNION", tagKeys...); err != nil {
+		return nil, err
+	}
+	if err := conn.Send("DEL", tagKeys...); err != nil {
+		return nil, err
+	}
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	if keys, err = redis.Strings(replies[0], nil); err != nil {
+		return nil, err
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, k := range keys {
+		prefixed[i] = b.key(k)
+	}
+	return keys, b.del(conn, prefixed)
+}
+
+// del deletes keys, which are prefixed already, with as few DEL commands as it
+// takes, sent together
+func (b *RedisCache) del(conn redis.Conn, keys []string) error {
+	sent := 0
+	for start := 0; start < len(keys); start += delBatch {
+		batch := keys[start:min(start+delBatch, len(keys))]
+		if err := conn.Send("DEL", redis.Args{}.AddFlat(batch)...); err != nil {
 			return err
 		}
+		sent++
+	}
+	if sent == 0 {
+		return nil
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for i := 0; i < sent; i++ {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockKey returns the key of the lock name. Like the sets of tags, it is not under
+// Prefix+":", so that emptying the cache does not release locks.
+func (b *RedisCache) lockKey(name string) string {
+	return fmt.Sprintf("%s#lock:%s", b.Prefix, name)
+}
+
+func (b *RedisCache) Lock(name string, ttl time.Duration) (token string, err error) {
+	defer func() { b.Observe.lookup("lock", err == nil, ignore(err, ErrLocked)) }()
+
+	conn := b.Conn.Get()
+	defer conn.Close()
+
+	token = newToken()
+	_, err = redis.String(conn.Do("SET", b.lockKey(name), token, "NX", "PX", max(ttl.Milliseconds(), 1)))
+	if errors.Is(err, redis.ErrNil) {
+		return "", ErrLocked
+	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (b *RedisCache) Renew(name, token string, ttl time.Duration) (err error) {
+	defer func() { b.Observe.write("renew", ignore(err, ErrNotHeld)) }()
+
+	conn := b.Conn.Get()
+	defer conn.Close()
+
+	renewed, err := redis.Int(renewScript.Do(conn, b.lockKey(name), token, max(ttl.Milliseconds(), 1)))
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return ErrNotHeld
 	}
+	return nil
+}
 
+func (b *RedisCache) Unlock(name, token string) (err error) {
+	defer func() { b.Observe.write("unlock", ignore(err, ErrNotHeld)) }()
+
+	conn := b.Conn.Get()
+	defer conn.Close()
+
+	deleted, err := redis.Int(unlockScript.Do(conn, b.lockKey(name), token))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotHeld
+	}
 	return nil
 }
 
-func (b *RedisCache) Forget(str string) error {
+func (b *RedisCache) Increment(key string, by int64, ttl time.Duration) (n int64, err error) {
+	defer func() { b.Observe.write("increment", err) }()
+
+	conn := b.Conn.Get()
+	defer conn.Close()
+
+	return redis.Int64(incrementScript.Do(conn, b.key(key), by, ttl.Milliseconds()))
+}
+
+func (b *RedisCache) Decrement(key string, by int64, ttl time.Duration) (int64, error) {
+	return b.Increment(key, -by, ttl)
+}
+
+func (b *RedisCache) Forget(str string) (err error) {
+	defer func() { b.Observe.write("forget", err) }()
+
 	key := fmt.Sprintf("%s:%s", b.Prefix, str)
 	conn := b.Conn.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", key)
+	_, err = conn.Do("DEL", key)
 	if err != nil {
 		return err
 	}
@@ -119,7 +474,9 @@ func (b *RedisCache) Forget(str string) error {
 	return nil
 }
 
-func (b *RedisCache) EmptyByMatch(str string) error {
+func (b *RedisCache) EmptyByMatch(str string) (err error) {
+	defer func() { b.Observe.write("empty_by_match", err) }()
+
 	key := fmt.Sprintf("%s:%s", b.Prefix, str)
 	conn := b.Conn.Get()
 	defer conn.Close()
@@ -129,17 +486,12 @@ func (b *RedisCache) EmptyByMatch(str string) error {
 		return err
 	}
 
-	for _, x := range keys {
-		_, err := conn.Do("DEL", x)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return b.del(conn, keys)
 }
 
-func (b *RedisCache) Empty() error {
+func (b *RedisCache) Empty() (err error) {
+	defer func() { b.Observe.write("empty", err) }()
+
 	key := fmt.Sprintf("%s:", b.Prefix)
 	conn := b.Conn.Get()
 	defer conn.Close()
@@ -149,14 +501,13 @@ func (b *RedisCache) Empty() error {
 		return err
 	}
 
-	for _, x := range keys {
-		_, err := conn.Do("DEL", x)
-		if err != nil {
-			return err
-		}
+	// and the sets of tags
+	tagKeys, err := b.getKeys(b.tagKey(""))
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return b.del(conn, append(keys, tagKeys...))
 }
 
 func (b *RedisCache) getKeys(pattern string) ([]string, error) {
@@ -167,7 +518,7 @@ func (b *RedisCache) getKeys(pattern string) ([]string, error) {
 	keys := []string{}
 
 	for {
-		arr, err := redis.Values(conn.Do("SCAN", iter, "MATCH", fmt.Sprintf("%s*", pattern)))
+		arr, err := redis.Values(conn.Do("SCAN", iter, "MATCH", fmt.Sprintf("%s*", pattern), "COUNT", 1000))
 		if err != nil {
 			return keys, err
 		}
@@ -17,7 +17,7 @@ func TestRedisCache_Has( t *testing.T) {
 		t.Error("foo found in cache, and it shouldn't be there")
 	}
 
-	err = testRedisCache.Set("foo", "bar")
+	err = testRedisCache.Set("foo", "bar", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -33,7 +33,7 @@ func TestRedisCache_Has( t *testing.T) {
 }
 
 func TestRedisCache_Get(t *testing.T) {
-	err := testRedisCache.Set("foo", "bar")
+	err := testRedisCache.Set("foo", "bar", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -49,7 +49,7 @@ func TestRedisCache_Get(t *testing.T) {
 }
 
 func TestRedisCache_Forget(t *testing.T) {
-	err := testRedisCache.Set("alpha", "beta")
+	err := testRedisCache.Set("alpha", "beta", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -70,7 +70,7 @@ func TestRedisCache_Forget(t *testing.T) {
 }
 
 func TestRedisCache_Empty(t *testing.T) {
-	err := testRedisCache.Set("alpha", "beta")
+	err := testRedisCache.Set("alpha", "beta", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -92,17 +92,17 @@ func TestRedisCache_Empty(t *testing.T) {
 }
 
 func TestRedisCache_EmptyByMatch(t *testing.T) {
-	err := testRedisCache.Set("alpha", "foo")
+	err := testRedisCache.Set("alpha", "foo", 0)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = testRedisCache.Set("alpha2", "foo")
+	err = testRedisCache.Set("alpha2", "foo", 0)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = testRedisCache.Set("beta", "foo")
+	err = testRedisCache.Set("beta", "foo", 0)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Locker is implemented by caches that hold locks, shared by every process that
+// uses the same store: with redis, every replica of an application. A lock is held
+// for ttl, unless it is renewed or released first, so that a holder that dies does
+// not keep it for ever.
+type Locker interface {
+	// Lock acquires the lock name for ttl, and returns the token that renews and
+	// releases it, or ErrLocked when another owner holds it
+	Lock(name string, ttl time.Duration) (token string, err error)
+
+	// Renew holds the lock name for ttl from now, or returns ErrNotHeld when token
+	// no longer holds it
+	Renew(name, token string, ttl time.Duration) error
+
+	// Unlock releases the lock name, or returns ErrNotHeld when token no longer
+	// holds it
+	Unlock(name, token string) error
+}
+
+// Counter is implemented by caches with atomic counters. A counter is kept as text,
+// so it is read with Increment(key, 0, 0) rather than Get.
+type Counter interface {
+	// Increment adds by to the counter key, which starts at 0, and returns its new
+	// value. A counter that does not expire yet expires after ttl, unless ttl is 0:
+	// counting again does not push the expiry back.
+	Increment(key string, by int64, ttl time.Duration) (int64, error)
+
+	// Decrement subtracts by from the counter key, as Increment adds to it
+	Decrement(key string, by int64, ttl time.Duration) (int64, error)
+}
+
+var (
+	// ErrLocked is returned by Lock when another owner holds the lock
+	ErrLocked = errors.New("cache: locked by another owner")
+
+	// ErrNotHeld is returned by Renew and Unlock when the token no longer holds
+	// the lock, because it expired or was taken over
+	ErrNotHeld = errors.New("cache: lock not held")
+)
+
+// ignore returns err, or nil when err is target, for an Observer to which target
+// is an outcome rather than a failure
+func ignore(err, target error) error {
+	if errors.Is(err, target) {
+		return nil
+	}
+	return err
+}
+
+// newToken returns a random token, for a lock or a process
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
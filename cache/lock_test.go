@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// lockCaches returns a cache of every kind that holds locks and counts, and a
+// function that lets time pass for it
+func lockCaches(t *testing.T) map[string]struct {
+	cache interface {
+		Cache
+		Locker
+		Counter
+	}
+	wait func(time.Duration)
+} {
+	sleep := time.Sleep
+	fastForward := func(d time.Duration) { testRedisServer.FastForward(d) }
+	redisCache := &RedisCache{Conn: testRedisCache.Conn, Prefix: "locks"}
+
+	type entry = struct {
+		cache interface {
+			Cache
+			Locker
+			Counter
+		}
+		wait func(time.Duration)
+	}
+	return map[string]entry{
+		"redis":  {redisCache, fastForward},
+		"badger": {&BadgerCache{Conn: testBadgerCache.Conn}, sleep},
+		"memory": {&MemoryCache{}, sleep},
+		"tiered": {newTestTieredCache(t, "locks-tiered", nil), fastForward},
+		"traced": {NewTracedCache(redisCache, noop.NewTracerProvider().Tracer(""), "redis"), fastForward},
+	}
+}
+
+func TestLocker(t *testing.T) {
+	for name, tc := range lockCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			l := tc.cache
+			lock := name + ":job"
+
+			token, err := l.Lock(lock, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Lock(lock, time.Second); !errors.Is(err, ErrLocked) {
+				t.Errorf("locked twice: %v", err)
+			}
+			if err := l.Unlock(lock, "another token"); !errors.Is(err, ErrNotHeld) {
+				t.Errorf("unlocked with another token: %v", err)
+			}
+			if err := l.Renew(lock, token, 3*time.Second); err != nil {
+				t.Errorf("could not renew: %v", err)
+			}
+
+			// renewed, the lock outlives its first ttl
+			tc.wait(1500 * time.Millisecond)
+			if _, err := l.Lock(lock, time.Second); !errors.Is(err, ErrLocked) {
+				t.Errorf("the renewed lock expired: %v", err)
+			}
+
+			if err := l.Unlock(lock, token); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Unlock(lock, token); !errors.Is(err, ErrNotHeld) {
+				t.Errorf("unlocked twice: %v", err)
+			}
+
+			// an expired lock can be taken over, and its old token does nothing
+			if _, err := l.Lock(lock, 100*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			tc.wait(1100 * time.Millisecond)
+			next, err := l.Lock(lock, time.Second)
+			if err != nil {
+				t.Fatalf("the expired lock was kept: %v", err)
+			}
+			if err := l.Renew(lock, token, time.Second); !errors.Is(err, ErrNotHeld) {
+				t.Errorf("renewed with an old token: %v", err)
+			}
+			_ = l.Unlock(lock, next)
+		})
+	}
+}
+
+func TestCounter(t *testing.T) {
+	for name, tc := range lockCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			c := tc.cache
+			key := name + ":hits"
+			_ = c.Forget(key)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.Increment(key, 2, time.Hour); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if n, err := c.Decrement(key, 1, 0); err != nil || n != 99 {
+				t.Errorf("got %d, %v, want 99", n, err)
+			}
+			if n, err := c.Increment(key, 0, 0); err != nil || n != 99 {
+				t.Errorf("got %d, %v, want 99", n, err)
+			}
+		})
+	}
+}
+
+func TestCounter_ttl(t *testing.T) {
+	for name, tc := range lockCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			c := tc.cache
+			key := name + ":window"
+			_ = c.Forget(key)
+
+			// badger keeps expiry times to the second
+			if _, err := c.Increment(key, 1, 2*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			// counting again does not push the expiry back
+			tc.wait(time.Second)
+			if _, err := c.Increment(key, 1, 2*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			tc.wait(1500 * time.Millisecond)
+			if n, err := c.Increment(key, 1, 2*time.Second); err != nil || n != 1 {
+				t.Errorf("got %d, %v, want a new counter", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxEntries bounds a MemoryCache whose MaxEntries is 0
+const defaultMaxEntries = 10000
+
+// MemoryCache keeps values in the memory of the process, for tests and
+// applications that run on a single node. It holds up to MaxEntries values (10000
+// when 0), and, when MaxBytes is set, up to MaxBytes of keys and values, dropping
+// the least recently used to make room. Values are stored as bytes made by
+// Serializer, nil meaning Gob, so that changing a value after setting it does not
+// change what is cached. The zero value is ready to use.
+type MemoryCache struct {
+	MaxEntries int
+	MaxBytes   int64
+	Serializer Serializer
+	Observe    Observer
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+	locks map[string]memoryLock
+	size  int64
+}
+
+// memoryLock is a lock held in a MemoryCache
+type memoryLock struct {
+	token   string
+	expires time.Time
+}
+
+// memoryEntry is a value of a MemoryCache, in its list of recently used values
+type memoryEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+	tags    []string
+}
+
+var (
+	_ ByteCache = (*MemoryCache)(nil)
+	_ TagCache  = (*MemoryCache)(nil)
+	_ Locker    = (*MemoryCache)(nil)
+	_ Counter   = (*MemoryCache)(nil)
+)
+
+// NewMemoryCache returns a MemoryCache holding up to maxEntries values
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{MaxEntries: maxEntries}
+}
+
+func (c *MemoryCache) Codec() Serializer {
+	return serializerOr(c.Serializer)
+}
+
+// Len returns the number of values in c, including those that have expired but
+// have not been dropped yet
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// lookup returns the value of key, and marks it as the most recently used; c.mu
+// is held
+func (c *MemoryCache) lookup(key string, now time.Time) ([]byte, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && !now.Before(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.data, true
+}
+
+// remove drops e; c.mu is held
+func (c *MemoryCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*memoryEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.key) + len(entry.data))
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// set stores data under key, tagged with tags as well as the tags it had, and
+// drops the least recently used values while c is too big; c.mu is held
+func (c *MemoryCache) set(key string, data []byte, expires time.Time, tags ...string) {
+	if c.items == nil {
+		c.items = make(map[string]*list.Element)
+		c.tags = make(map[string]map[string]struct{})
+		c.lru = list.New()
+	}
+	tags = slices.Clone(tags)
+	if e, ok := c.items[key]; ok {
+		for _, tag := range e.Value.(*memoryEntry).tags {
+			if _, tagged := c.tags[tag][key]; tagged && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		c.remove(e)
+	}
+
+	entry := &memoryEntry{key: key, data: append([]byte(nil), data...), expires: expires, tags: tags}
+	c.items[key] = c.lru.PushFront(entry)
+	c.size += int64(len(key) + len(data))
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	for len(c.items) > maxEntries || (c.MaxBytes > 0 && c.size > c.MaxBytes && len(c.items) > 1) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MemoryCache) Has(str string) (bool, error) {
+	c.mu.Lock()
+	_, found := c.lookup(str, time.Now())
+	c.mu.Unlock()
+
+	c.Observe.lookup("has", found, nil)
+	return found, nil
+}
+
+func (c *MemoryCache) Get(str string) (interface{}, error) {
+	data, _ := c.GetBytes(str)
+	if data[0] == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMiss, str)
+	}
+
+	var item interface{}
+	if err := c.Codec().Unmarshal(data[0], &item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (c *MemoryCache) GetBytes(keys ...string) ([][]byte, error) {
+	data := make([][]byte, len(keys))
+	now := time.Now()
+
+	c.mu.Lock()
+	for i, k := range keys {
+		data[i], _ = c.lookup(k, now)
+	}
+	c.mu.Unlock()
+
+	for i := range keys {
+		c.Observe.lookup("get", data[i] != nil, nil)
+	}
+	return data, nil
+}
+
+func (c *MemoryCache) Set(str string, value interface{}, ttl time.Duration) error {
+	encoded, err := c.Codec().Marshal(value)
+	if err != nil {
+		c.Observe.write("set", err)
+		return err
+	}
+	return c.SetBytes(map[string][]byte{str: encoded}, ttl)
+}
+
+func (c *MemoryCache) SetBytes(items map[string][]byte, ttl time.Duration) error {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	for k, data := range items {
+		c.set(k, data, expires)
+	}
+	c.mu.Unlock()
+
+	c.Observe.write("set", nil)
+	return nil
+}
+
+func (c *MemoryCache) SetTagged(str string, value interface{}, ttl time.Duration, tags ...string) error {
+	encoded, err := c.Codec().Marshal(value)
+	if err != nil {
+		c.Observe.write("set", err)
+		return err
+	}
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	c.set(str, encoded, expires, tags...)
+	c.mu.Unlock()
+
+	c.Observe.write("set", nil)
+	return nil
+}
+
+func (c *MemoryCache) FlushTags(tags ...string) error {
+	c.mu.Lock()
+	for _, tag := range tags {
+		for k := range c.tags[tag] {
+			c.remove(c.items[k])
+		}
+	}
+	c.mu.Unlock()
+
+	c.Observe.write("flush_tags", nil)
+	return nil
+}
+
+// Lock holds the lock in c, so locks of a MemoryCache keep the goroutines of one
+// process apart. Locks are not values: they are neither counted by Len nor dropped
+// to make room.
+func (c *MemoryCache) Lock(name string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, ok := c.locks[name]; ok && now.Before(l.expires) {
+		c.Observe.lookup("lock", false, nil)
+		return "", ErrLocked
+	}
+	if c.locks == nil {
+		c.locks = make(map[string]memoryLock)
+	}
+	token := newToken()
+	c.locks[name] = memoryLock{token: token, expires: now.Add(ttl)}
+	c.Observe.lookup("lock", true, nil)
+	return token, nil
+}
+
+// heldLock reports whether token holds the lock name; c.mu is held
+func (c *MemoryCache) heldLock(name, token string, now time.Time) bool {
+	l, ok := c.locks[name]
+	return ok && l.token == token && now.Before(l.expires)
+}
+
+func (c *MemoryCache) Renew(name, token string, ttl time.Duration) error {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Observe.write("renew", nil)
+	if !c.heldLock(name, token, now) {
+		return ErrNotHeld
+	}
+	c.locks[name] = memoryLock{token: token, expires: now.Add(ttl)}
+	return nil
+}
+
+func (c *MemoryCache) Unlock(name, token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Observe.write("unlock", nil)
+	if !c.heldLock(name, token, time.Now()) {
+		return ErrNotHeld
+	}
+	delete(c.locks, name)
+	return nil
+}
+
+func (c *MemoryCache) Increment(key string, by int64, ttl time.Duration) (n int64, err error) {
+	defer func() { c.Observe.write("increment", err) }()
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if data, ok := c.lookup(key, now); ok {
+		if n, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+			return 0, fmt.Errorf("cache: %s is not a counter", key)
+		}
+		expires = c.items[key].Value.(*memoryEntry).expires
+	}
+	if expires.IsZero() && ttl > 0 {
+		expires = now.Add(ttl)
+	}
+
+	n += by
+	c.set(key, []byte(strconv.FormatInt(n, 10)), expires)
+	return n, nil
+}
+
+func (c *MemoryCache) Decrement(key string, by int64, ttl time.Duration) (int64, error) {
+	return c.Increment(key, -by, ttl)
+}
+
+// fill stores each of data under its key, for its ttl, if ok still returns true
+// once c is locked. Keys whose data is nil are skipped.
+func (c *MemoryCache) fill(keys []string, data [][]byte, ttls []time.Duration, ok func() bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok() {
+		return
+	}
+	for i, k := range keys {
+		if data[i] == nil {
+			continue
+		}
+		var expires time.Time
+		if ttls[i] > 0 {
+			expires = now.Add(ttls[i])
+		}
+		c.set(k, data[i], expires)
+	}
+}
+
+func (c *MemoryCache) Forget(str string) error {
+	c.mu.Lock()
+	if e, ok := c.items[str]; ok {
+		c.remove(e)
+	}
+	c.mu.Unlock()
+
+	c.Observe.write("forget", nil)
+	return nil
+}
+
+// EmptyByMatch drops the values whose key starts with a match of pattern, in
+// which, as with redis, * matches any text and ? any character
+func (c *MemoryCache) EmptyByMatch(pattern string) error {
+	match := globPrefix(pattern)
+
+	c.mu.Lock()
+	for k, e := range c.items {
+		if match.MatchString(k) {
+			c.remove(e)
+		}
+	}
+	c.mu.Unlock()
+
+	c.Observe.write("empty_by_match", nil)
+	return nil
+}
+
+func (c *MemoryCache) Empty() error {
+	c.mu.Lock()
+	c.items, c.tags, c.lru, c.size = nil, nil, nil, 0
+	c.mu.Unlock()
+
+	c.Observe.write("empty", nil)
+	return nil
+}
+
+// globPrefix returns a regexp matching the keys that start with a match of the
+// glob pattern
+func globPrefix(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache_lru(t *testing.T) {
+	c := NewMemoryCache(2)
+	_ = c.Set("a", 1, 0)
+	_ = c.Set("b", 2, 0)
+
+	// a is now the most recently used, so b makes room for c
+	if _, err := c.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Set("c", 3, 0)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if found, _ := c.Has(key); found != want {
+			t.Errorf("%s found: %v, want %v", key, found, want)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("holds %d values, want 2", c.Len())
+	}
+}
+
+func TestMemoryCache_maxBytes(t *testing.T) {
+	c := &MemoryCache{MaxBytes: 30}
+	_ = c.SetBytes(map[string][]byte{"a": make([]byte, 10)}, 0)
+	_ = c.SetBytes(map[string][]byte{"b": make([]byte, 10)}, 0)
+	_ = c.SetBytes(map[string][]byte{"c": make([]byte, 10)}, 0)
+
+	if found, _ := c.Has("a"); found {
+		t.Error("a was kept past MaxBytes")
+	}
+	if c.Len() != 2 {
+		t.Errorf("holds %d values, want 2", c.Len())
+	}
+
+	// a value bigger than MaxBytes is still kept, alone
+	_ = c.SetBytes(map[string][]byte{"big": make([]byte, 100)}, 0)
+	if found, _ := c.Has("big"); !found || c.Len() != 1 {
+		t.Errorf("big found: %v, with %d values", found, c.Len())
+	}
+}
+
+func TestMemoryCache_ttl(t *testing.T) {
+	var c MemoryCache
+	_ = c.Set("short", "value", 20*time.Millisecond)
+	_ = c.Set("forever", "value", 0)
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Get("short"); !errors.Is(err, ErrMiss) {
+		t.Errorf("got %v for an expired key, want ErrMiss", err)
+	}
+	if found, _ := c.Has("forever"); !found {
+		t.Error("a value without ttl expired")
+	}
+}
+
+func TestMemoryCache_EmptyByMatch(t *testing.T) {
+	var c MemoryCache
+	for _, k := range []string{"user:1", "user:2", "users", "post:1", "a.b", "axb"} {
+		_ = c.Set(k, k, 0)
+	}
+
+	tests := []struct {
+		pattern string
+		dropped []string
+	}{
+		{"user:", []string{"user:1", "user:2"}},
+		{"a.b", []string{"a.b"}},
+		{"p?st", []string{"post:1"}},
+		{"*", []string{"users", "axb"}},
+	}
+	for _, tt := range tests {
+		if err := c.EmptyByMatch(tt.pattern); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range tt.dropped {
+			if found, _ := c.Has(k); found {
+				t.Errorf("%s was kept by %q", k, tt.pattern)
+			}
+		}
+	}
+	if found, _ := c.Has("axb"); found {
+		t.Error("axb was kept")
+	}
+}
+
+func TestMemoryCache_copies(t *testing.T) {
+	var c MemoryCache
+	data := []byte("value")
+	_ = c.SetBytes(map[string][]byte{"key": data}, 0)
+	data[0] = 'X'
+
+	if got, _ := c.GetBytes("key"); string(got[0]) != "value" {
+		t.Errorf("got %q, changed after it was set", got[0])
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Serializer turns values into the bytes a cache stores, and back. Unmarshal is
+// given a pointer, either to the type asked for with Get[T], or to an empty
+// interface for the untyped Get.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The serializers caches can store values with. Gob keeps the type of every value,
+// so the untyped Get returns what was set, but custom types must be registered
+// with gob.Register unless they are read with Get[T]. JSON and msgpack need no
+// registration, but the untyped Get returns maps, slices and numbers for them.
+var (
+	Gob     Serializer = gobSerializer{}
+	JSON    Serializer = jsonSerializer{}
+	Msgpack Serializer = msgpackSerializer{}
+)
+
+// SerializerFor returns the serializer named gob, json or msgpack; an empty name
+// means gob
+func SerializerFor(name string) (Serializer, error) {
+	switch name {
+	case "", "gob":
+		return Gob, nil
+	case "json":
+		return JSON, nil
+	case "msgpack":
+		return Msgpack, nil
+	}
+	return nil, fmt.Errorf("cache: unknown serializer %s; only gob, json and msgpack accepted", name)
+}
+
+// serializerOr returns s, or Gob when s is nil
+func serializerOr(s Serializer) Serializer {
+	if s == nil {
+		return Gob
+	}
+	return s
+}
+
+// gobSerializer encodes every value as an interface, so that its type travels
+// with it
+type gobSerializer struct{}
+
+func (gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	register(v)
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, v interface{}) error {
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		// values were once stored in an Entry, keyed by their key
+		entry, legacyErr := decode(string(data))
+		if legacyErr != nil || len(entry) != 1 {
+			return err
+		}
+		for _, value = range entry {
+		}
+	}
+	return assign(v, value)
+}
+
+// prepare registers the type v points to, so that it can be decoded before a value
+// of it has been encoded by this process
+func (gobSerializer) prepare(v interface{}) {
+	if t := reflect.TypeOf(v).Elem(); t.Kind() != reflect.Interface {
+		register(reflect.Zero(t).Interface())
+	}
+}
+
+// register registers the type of v with gob, as a value of an interface has to be.
+// Gob panics on the types it cannot encode, which are left for Encode to report.
+func register(v interface{}) {
+	if v == nil {
+		return
+	}
+	defer func() { _ = recover() }()
+	gob.Register(v)
+}
+
+// assign sets what ptr points to to value
+func assign(ptr interface{}, value interface{}) error {
+	target := reflect.ValueOf(ptr)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return errors.New("cache: values can only be decoded into a pointer")
+	}
+	target = target.Elem()
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	if !reflect.TypeOf(value).AssignableTo(target.Type()) {
+		return fmt.Errorf("cache: value is a %T, not a %s", value, target.Type())
+	}
+	target.Set(reflect.ValueOf(value))
+	return nil
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -13,6 +13,7 @@ import (
 
 var testRedisCache RedisCache
 var testBadgerCache BadgerCache
+var testRedisServer *miniredis.Miniredis
 
 func TestMain(m *testing.M) {
 	s, err := miniredis.Run()
@@ -20,6 +21,7 @@ func TestMain(m *testing.M) {
 		panic(err)
 	}
 	defer s.Close()
+	testRedisServer = s
 
 	pool := redis.Pool {
 		MaxIdle: 50,
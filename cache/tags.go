@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// TagCache is implemented by caches that can tag keys, so that keys that do not
+// share a prefix, such as everything about a user, can be forgotten together. A key
+// stays tagged until it is forgotten or expires, even when it is set again without
+// the tag.
+type TagCache interface {
+	Cache
+
+	// SetTagged sets value under key, for ttl, and tags key with every tag
+	SetTagged(key string, value interface{}, ttl time.Duration, tags ...string) error
+
+	// FlushTags forgets every key tagged with any of tags
+	FlushTags(tags ...string) error
+}
+
+// ErrTagsUnsupported is returned by SetTagged and FlushTags for caches that are
+// not a TagCache
+var ErrTagsUnsupported = errors.New("cache: the cache does not support tags")
+
+// SetTagged sets value under key in c, for ttl, and tags key with every tag
+func SetTagged(c Cache, key string, value interface{}, ttl time.Duration, tags ...string) error {
+	tc, ok := c.(TagCache)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+	return tc.SetTagged(key, value, ttl, tags...)
+}
+
+// FlushTags forgets every key of c tagged with any of tags
+func FlushTags(c Cache, tags ...string) error {
+	tc, ok := c.(TagCache)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+	return tc.FlushTags(tags...)
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tagCaches returns a cache of every kind that supports tags. The badger cache is
+// shared with other tests, so keys are prefixed with the name of the cache.
+func tagCaches(t *testing.T) map[string]Cache {
+	redisCache := &RedisCache{Conn: testRedisCache.Conn, Prefix: "tags"}
+	return map[string]Cache{
+		"redis":  redisCache,
+		"badger": &testBadgerCache,
+		"memory": &MemoryCache{},
+		"tiered": newTestTieredCache(t, "tags-tiered", nil),
+		"traced": NewTracedCache(redisCache, noop.NewTracerProvider().Tracer(""), "redis"),
+	}
+}
+
+func TestFlushTags(t *testing.T) {
+	for name, c := range tagCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			key := func(k string) string { return name + ":" + k }
+			items := []struct {
+				key  string
+				tags []string
+			}{
+				{"order:1", []string{"user:1"}},
+				{"basket:1", []string{"user:1", "product:2"}},
+				{"product:3", []string{"product:3"}},
+				{"untagged", nil},
+			}
+			for _, item := range items {
+				if err := SetTagged(c, key(item.key), item.key, time.Minute, item.tags...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// a key stays tagged when it is set again
+			if err := c.Set(key("order:1"), "again", 0); err != nil {
+				t.Fatal(err)
+			}
+
+			steps := []struct {
+				tags []string
+				kept map[string]bool
+			}{
+				{[]string{"user:1"}, map[string]bool{"order:1": false, "basket:1": false, "product:3": true, "untagged": true}},
+				{[]string{"product:2", "product:3"}, map[string]bool{"product:3": false, "untagged": true}},
+				{[]string{"unknown"}, map[string]bool{"untagged": true}},
+			}
+			for _, step := range steps {
+				if err := FlushTags(c, step.tags...); err != nil {
+					t.Fatal(err)
+				}
+				for k, want := range step.kept {
+					if found, _ := c.Has(key(k)); found != want {
+						t.Errorf("after flushing %v, %s found: %v, want %v", step.tags, k, found, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFlushTags_unsupported(t *testing.T) {
+	traced := NewTracedCache(plainCache{}, noop.NewTracerProvider().Tracer(""), "custom")
+	for _, c := range []Cache{plainCache{}, traced} {
+		if err := SetTagged(c, "key", "value", 0, "tag"); err != ErrTagsUnsupported {
+			t.Errorf("SetTagged returned %v, want ErrTagsUnsupported", err)
+		}
+		if err := FlushTags(c, "tag"); err != ErrTagsUnsupported {
+			t.Errorf("FlushTags returned %v, want ErrTagsUnsupported", err)
+		}
+	}
+}
+
+// plainCache is a Cache without tags
+type plainCache struct{ Cache }
+
+func TestTieredCache_FlushTags(t *testing.T) {
+	a := newTestTieredCache(t, "tiered-tags", nil)
+	b := newTestTieredCache(t, "tiered-tags", nil)
+
+	if err := a.SetTagged("user:1", "Ann", 0, "team:1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := Get[string](b, "user:1"); v != "Ann" {
+		t.Fatalf("got %q", v)
+	}
+
+	if err := a.FlushTags("team:1"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the local copy to be dropped", func() bool {
+		data, _ := b.Local.GetBytes("user:1")
+		return data[0] == nil
+	})
+}
+
+func TestRedisCache_bulkDelete(t *testing.T) {
+	c := &RedisCache{Conn: testRedisCache.Conn, Prefix: "bulk"}
+	items := map[string]int{}
+	for i := 0; i < 2*delBatch+10; i++ {
+		items[fmt.Sprintf("many:%d", i)] = i
+	}
+	if err := SetMany(c, items, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTagged(c, "tagged", 1, 0, "tag"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.EmptyByMatch("many:"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := c.getKeys("bulk:many:"); len(keys) != 0 {
+		t.Errorf("%d keys were left", len(keys))
+	}
+
+	// Empty drops the sets of tags too
+	if err := c.Empty(); err != nil {
+		t.Fatal(err)
+	}
+	conn := c.Conn.Get()
+	defer conn.Close()
+	if n, _ := redis.Int(conn.Do("EXISTS", c.tagKey("tag"))); n != 0 {
+		t.Error("the set of a tag was kept")
+	}
+}
+
+func TestBadgerCache_bulkDelete(t *testing.T) {
+	items := map[string]int{}
+	for i := 0; i < 20000; i++ {
+		items[fmt.Sprintf("bulk:%d", i)] = i
+	}
+	if err := SetMany(&testBadgerCache, items, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testBadgerCache.EmptyByMatch("bulk:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"bulk:0", "bulk:19999"} {
+		if found, _ := testBadgerCache.Has(k); found {
+			t.Errorf("%s was kept", k)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// defaultLocalTTL is how long a TieredCache keeps a value read from redis, when
+// its LocalTTL is 0
+const defaultLocalTTL = time.Minute
+
+// TieredCache reads through Local, a MemoryCache in front of Remote, so that the
+// values read most often do not cost a round trip to redis. Every Set, Forget,
+// EmptyByMatch, Empty and FlushTags is published on the channel Remote.Prefix+":invalidate",
+// and the TieredCaches of every replica drop their local copies of the keys
+// concerned. A value read from redis is kept locally for LocalTTL (a minute when
+// 0) at most, and never longer than it has left in redis. While a TieredCache is
+// not subscribed, for example when redis restarts, it reads from redis only.
+type TieredCache struct {
+	Local    *MemoryCache
+	Remote   *RedisCache
+	LocalTTL time.Duration
+
+	origin     string
+	gen        atomic.Uint64
+	subscribed atomic.Bool
+
+	mu     sync.Mutex
+	psc    *redis.PubSubConn
+	closed bool
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+var (
+	_ ByteCache = (*TieredCache)(nil)
+	_ TagCache  = (*TieredCache)(nil)
+	_ Locker    = (*TieredCache)(nil)
+	_ Counter   = (*TieredCache)(nil)
+)
+
+// invalidation is published by a TieredCache when it changes redis
+type invalidation struct {
+	Origin   string   `json:"origin"`
+	Keys     []string `json:"keys,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	All      bool     `json:"all,omitempty"`
+}
+
+// NewTieredCache returns a TieredCache keeping values of remote in local, or in a
+// MemoryCache of the default size when local is nil, and subscribes to the
+// invalidations of the other replicas. Close stops listening to them.
+func NewTieredCache(remote *RedisCache, local *MemoryCache) *TieredCache {
+	if local == nil {
+		local = NewMemoryCache(0)
+	}
+	c := &TieredCache{
+		Local:   local,
+		Remote:  remote,
+		origin:  newToken(),
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go c.listen()
+	return c
+}
+
+// Ready returns a channel closed once c has subscribed to invalidations for the
+// first time, and reads values through Local
+func (c *TieredCache) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Close stops listening to invalidations, and empties Local. Remote is left open.
+func (c *TieredCache) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	if c.psc != nil {
+		_ = c.psc.Unsubscribe()
+	}
+	c.mu.Unlock()
+
+	<-c.stopped
+	return nil
+}
+
+func (c *TieredCache) channel() string {
+	return c.Remote.Prefix + ":invalidate"
+}
+
+// listen subscribes to invalidations until c is closed, subscribing again, after
+// a growing delay, when the connection is lost
+func (c *TieredCache) listen() {
+	defer close(c.stopped)
+
+	delay := 100 * time.Millisecond
+	for {
+		if subscribed := c.subscribe(); subscribed {
+			delay = 100 * time.Millisecond
+		}
+		c.unsubscribed()
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 10*time.Second)
+	}
+}
+
+// subscribe applies the invalidations published on the channel of c until its
+// connection is lost or c is closed, and reports whether it had subscribed
+func (c *TieredCache) subscribe() (subscribed bool) {
+	psc := &redis.PubSubConn{Conn: c.Remote.Conn.Get()}
+	defer func() {
+		// Close may be unsubscribing
+		c.mu.Lock()
+		c.psc = nil
+		c.mu.Unlock()
+		_ = psc.Close()
+	}()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.psc = psc
+	err := psc.Subscribe(c.channel())
+	c.mu.Unlock()
+	if err != nil {
+		return false
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Subscription:
+			switch {
+			case v.Kind == "subscribe":
+				// invalidations published while c was not subscribed are lost
+				c.gen.Add(1)
+				_ = c.Local.Empty()
+				c.subscribed.Store(true)
+				c.readyOnce.Do(func() { close(c.ready) })
+				subscribed = true
+			case v.Count == 0:
+				return subscribed
+			}
+		case redis.Message:
+			var inv invalidation
+			if err := json.Unmarshal(v.Data, &inv); err == nil && inv.Origin != c.origin {
+				c.apply(inv)
+			}
+		case error:
+			return subscribed
+		}
+	}
+}
+
+// unsubscribed stops reading through Local, until c subscribes again
+func (c *TieredCache) unsubscribed() {
+	c.subscribed.Store(false)
+	c.gen.Add(1)
+	_ = c.Local.Empty()
+}
+
+// apply drops the local copies of the keys inv concerns. Reads of redis that
+// began before are not kept locally, as they may have read what inv replaced.
+func (c *TieredCache) apply(inv invalidation) {
+	c.gen.Add(1)
+	if inv.All {
+		_ = c.Local.Empty()
+		return
+	}
+	for _, k := range inv.Keys {
+		_ = c.Local.Forget(k)
+	}
+	for _, p := range inv.Patterns {
+		_ = c.Local.EmptyByMatch(p)
+	}
+}
+
+// invalidate applies inv locally, and publishes it to the other replicas
+func (c *TieredCache) invalidate(inv invalidation) error {
+	inv.Origin = c.origin
+	c.apply(inv)
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	conn := c.Remote.Conn.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PUBLISH", c.channel(), data); err != nil {
+		return fmt.Errorf("cache: invalidating other replicas: %w", err)
+	}
+	return nil
+}
+
+func (c *TieredCache) Codec() Serializer {
+	return c.Remote.Codec()
+}
+
+func (c *TieredCache) Has(str string) (bool, error) {
+	if c.subscribed.Load() {
+		if found, _ := c.Local.Has(str); found {
+			return true, nil
+		}
+	}
+	return c.Remote.Has(str)
+}
+
+func (c *TieredCache) Get(str string) (interface{}, error) {
+	data, err := c.GetBytes(str)
+	if err != nil {
+		return nil, err
+	}
+	if data[0] == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMiss, str)
+	}
+
+	var item interface{}
+	if err := c.Codec().Unmarshal(data[0], &item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (c *TieredCache) GetBytes(keys ...string) ([][]byte, error) {
+	if !c.subscribed.Load() {
+		return c.Remote.GetBytes(keys...)
+	}
+
+	data, _ := c.Local.GetBytes(keys...)
+	var missing []string
+	var at []int
+	for i, k := range keys {
+		if data[i] == nil {
+			missing = append(missing, k)
+			at = append(at, i)
+		}
+	}
+	if len(missing) == 0 {
+		return data, nil
+	}
+
+	gen := c.gen.Load()
+	remote, ttls, err := c.Remote.getWithTTL(missing...)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range at {
+		data[i] = remote[j]
+	}
+
+	localTTL := c.LocalTTL
+	if localTTL <= 0 {
+		localTTL = defaultLocalTTL
+	}
+	for j, ttl := range ttls {
+		if ttl == 0 || ttl > localTTL {
+			ttls[j] = localTTL
+		}
+	}
+	c.Local.fill(missing, remote, ttls, func() bool {
+		return c.subscribed.Load() && c.gen.Load() == gen
+	})
+	return data, nil
+}
+
+func (c *TieredCache) Set(str string, value interface{}, ttl time.Duration) error {
+	encoded, err := c.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.SetBytes(map[string][]byte{str: encoded}, ttl)
+}
+
+func (c *TieredCache) SetBytes(items map[string][]byte, ttl time.Duration) error {
+	if err := c.Remote.SetBytes(items, ttl); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	return c.invalidate(invalidation{Keys: keys})
+}
+
+func (c *TieredCache) Forget(str string) error {
+	if err := c.Remote.Forget(str); err != nil {
+		return err
+	}
+	return c.invalidate(invalidation{Keys: []string{str}})
+}
+
+func (c *TieredCache) EmptyByMatch(str string) error {
+	if err := c.Remote.EmptyByMatch(str); err != nil {
+		return err
+	}
+	return c.invalidate(invalidation{Patterns: []string{str}})
+}
+
+func (c *TieredCache) Empty() error {
+	if err := c.Remote.Empty(); err != nil {
+		return err
+	}
+	return c.invalidate(invalidation{All: true})
+}
+
+func (c *TieredCache) SetTagged(str string, value interface{}, ttl time.Duration, tags ...string) error {
+	if err := c.Remote.SetTagged(str, value, ttl, tags...); err != nil {
+		return err
+	}
+	return c.invalidate(invalidation{Keys: []string{str}})
+}
+
+// FlushTags publishes the keys that were tagged, so the other replicas need not
+// know the tags of their local copies
+func (c *TieredCache) FlushTags(tags ...string) error {
+	keys, err := c.Remote.flushTags(tags...)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(invalidation{Keys: keys})
+}
+
+// Locks and counters are kept in redis only
+
+func (c *TieredCache) Lock(name string, ttl time.Duration) (string, error) {
+	return c.Remote.Lock(name, ttl)
+}
+
+func (c *TieredCache) Renew(name, token string, ttl time.Duration) error {
+	return c.Remote.Renew(name, token, ttl)
+}
+
+func (c *TieredCache) Unlock(name, token string) error {
+	return c.Remote.Unlock(name, token)
+}
+
+func (c *TieredCache) Increment(key string, by int64, ttl time.Duration) (int64, error) {
+	return c.Remote.Increment(key, by, ttl)
+}
+
+func (c *TieredCache) Decrement(key string, by int64, ttl time.Duration) (int64, error) {
+	return c.Remote.Decrement(key, by, ttl)
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTieredCache returns a TieredCache over the test redis, under prefix,
+// once it is subscribed. remoteGets counts the lookups that reach redis.
+func newTestTieredCache(t *testing.T, prefix string, remoteGets *atomic.Int32) *TieredCache {
+	t.Helper()
+	remote := &RedisCache{Conn: testRedisCache.Conn, Prefix: prefix}
+	if remoteGets != nil {
+		remote.Observe = func(op, result string) {
+			if op == "get" {
+				remoteGets.Add(1)
+			}
+		}
+	}
+	c := NewTieredCache(remote, nil)
+	t.Cleanup(func() { _ = c.Close() })
+
+	select {
+	case <-c.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the subscription")
+	}
+	return c
+}
+
+// waitFor polls cond until it is true, or fails the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTieredCache_readThrough(t *testing.T) {
+	var gets atomic.Int32
+	c := newTestTieredCache(t, "tiered-read", &gets)
+	if err := c.Set("key", "value", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if v, err := c.Get("key"); err != nil || v != "value" {
+			t.Fatalf("got %v, %v", v, err)
+		}
+	}
+	if n := gets.Load(); n != 1 {
+		t.Errorf("redis was read %d times, want once", n)
+	}
+
+	if _, err := Get[string](c, "missing"); !errors.Is(err, ErrMiss) {
+		t.Errorf("got %v for a missing key, want ErrMiss", err)
+	}
+}
+
+func TestTieredCache_invalidation(t *testing.T) {
+	a := newTestTieredCache(t, "tiered-invalidate", nil)
+	b := newTestTieredCache(t, "tiered-invalidate", nil)
+
+	tests := []struct {
+		name   string
+		change func() error
+		want   string
+	}{
+		{"set", func() error { return a.Set("user:1", "new", 0) }, "new"},
+		{"forget", func() error { return a.Forget("user:1") }, ""},
+		{"empty by match", func() error { return a.EmptyByMatch("user:") }, ""},
+		{"empty", func() error { return a.Empty() }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := b.Set("user:1", "old", 0); err != nil {
+				t.Fatal(err)
+			}
+			// b keeps a local copy, which a changes
+			if v, _ := Get[string](b, "user:1"); v != "old" {
+				t.Fatalf("got %q", v)
+			}
+			if data, _ := b.Local.GetBytes("user:1"); data[0] == nil {
+				t.Fatal("no local copy was kept")
+			}
+
+			if err := tt.change(); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, "the local copy to be dropped", func() bool {
+				data, _ := b.Local.GetBytes("user:1")
+				return data[0] == nil
+			})
+			if v, _ := Get[string](b, "user:1"); v != tt.want {
+				t.Errorf("got %q, want %q", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestTieredCache_localTTL(t *testing.T) {
+	c := newTestTieredCache(t, "tiered-ttl", nil)
+	c.LocalTTL = 20 * time.Millisecond
+	if err := c.Set("key", "value", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if data, _ := c.Local.GetBytes("key"); data[0] != nil {
+		t.Error("the local copy outlived LocalTTL")
+	}
+}
+
+func TestTieredCache_Close(t *testing.T) {
+	c := newTestTieredCache(t, "tiered-close", nil)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// once closed, c cannot be told of changes, so it reads from redis only
+	_ = c.Set("key", "value", 0)
+	_, _ = c.Get("key")
+	if c.Local.Len() != 0 {
+		t.Error("a closed cache kept a local copy")
+	}
+}
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedCache wraps a Cache, and records an OpenTelemetry span for every call. The
+// Cache interface carries no context, so spans have no parent unless the cache is
+// bound to a request's context with WithContext.
+type TracedCache struct {
+	cache   Cache
+	tracer  trace.Tracer
+	backend string
+	ctx     context.Context
+}
+
+var (
+	_ ByteCache = (*TracedCache)(nil)
+	_ TagCache  = (*TracedCache)(nil)
+	_ Locker    = (*TracedCache)(nil)
+	_ Counter   = (*TracedCache)(nil)
+)
+
+// NewTracedCache returns c, traced with tracer. backend names the cache in spans,
+// for example redis or badger.
+func NewTracedCache(c Cache, tracer trace.Tracer, backend string) *TracedCache {
+	return &TracedCache{
+		cache:   c,
+		tracer:  tracer,
+		backend: backend,
+		ctx:     context.Background(),
+	}
+}
+
+// WithContext returns a copy of t whose spans are children of the span in ctx
+func (t *TracedCache) WithContext(ctx context.Context) *TracedCache {
+	t2 := *t
+	t2.ctx = ctx
+	return &t2
+}
+
+// Unwrap returns the cache being traced
+func (t *TracedCache) Unwrap() Cache {
+	return t.cache
+}
+
+func (t *TracedCache) start(op, key string) trace.Span {
+	_, span := t.tracer.Start(t.ctx, "cache."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("cache.backend", t.backend),
+			attribute.String("cache.key", key),
+		),
+	)
+	return span
+}
+
+// startMany starts the span of op on keys: op itself for one key, or op_many with
+// the number of keys
+func (t *TracedCache) startMany(op string, keys []string) trace.Span {
+	if len(keys) == 1 {
+		return t.start(op, keys[0])
+	}
+	_, span := t.tracer.Start(t.ctx, "cache."+op+"_many",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("cache.backend", t.backend),
+			attribute.Int("cache.keys", len(keys)),
+		),
+	)
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *TracedCache) Has(key string) (bool, error) {
+	span := t.start("has", key)
+	found, err := t.cache.Has(key)
+	span.SetAttributes(attribute.Bool("cache.hit", found))
+	end(span, err)
+	return found, err
+}
+
+func (t *TracedCache) Get(key string) (interface{}, error) {
+	span := t.start("get", key)
+	v, err := t.cache.Get(key)
+	end(span, err)
+	return v, err
+}
+
+func (t *TracedCache) Set(key string, value interface{}, ttl time.Duration) error {
+	span := t.start("set", key)
+	err := t.cache.Set(key, value, ttl)
+	end(span, err)
+	return err
+}
+
+// errNotByteCache is returned by the ByteCache methods of a TracedCache whose cache
+// is not a ByteCache. The typed helpers check for one first, so they never see it.
+var errNotByteCache = errors.New("cache: the traced cache does not store bytes")
+
+func (t *TracedCache) Codec() Serializer {
+	if bc, ok := t.cache.(ByteCache); ok {
+		return bc.Codec()
+	}
+	return nil
+}
+
+func (t *TracedCache) GetBytes(keys ...string) ([][]byte, error) {
+	bc, ok := t.cache.(ByteCache)
+	if !ok {
+		return nil, errNotByteCache
+	}
+	span := t.startMany("get", keys)
+	data, err := bc.GetBytes(keys...)
+	end(span, err)
+	return data, err
+}
+
+func (t *TracedCache) SetBytes(items map[string][]byte, ttl time.Duration) error {
+	bc, ok := t.cache.(ByteCache)
+	if !ok {
+		return errNotByteCache
+	}
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	span := t.startMany("set", keys)
+	err := bc.SetBytes(items, ttl)
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) Forget(key string) error {
+	span := t.start("forget", key)
+	err := t.cache.Forget(key)
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) EmptyByMatch(pattern string) error {
+	span := t.start("empty_by_match", pattern)
+	err := t.cache.EmptyByMatch(pattern)
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) Empty() error {
+	span := t.start("empty", "")
+	err := t.cache.Empty()
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) SetTagged(key string, value interface{}, ttl time.Duration, tags ...string) error {
+	tc, ok := t.cache.(TagCache)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+	span := t.start("set", key)
+	span.SetAttributes(attribute.StringSlice("cache.tags", tags))
+	err := tc.SetTagged(key, value, ttl, tags...)
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) FlushTags(tags ...string) error {
+	tc, ok := t.cache.(TagCache)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+	span := t.start("flush_tags", "")
+	span.SetAttributes(attribute.StringSlice("cache.tags", tags))
+	err := tc.FlushTags(tags...)
+	end(span, err)
+	return err
+}
+
+// errNotLocker and errNotCounter are returned by the methods of a TracedCache whose
+// cache does not implement them
+var (
+	errNotLocker  = errors.New("cache: the traced cache does not hold locks")
+	errNotCounter = errors.New("cache: the traced cache does not count")
+)
+
+func (t *TracedCache) Lock(name string, ttl time.Duration) (string, error) {
+	l, ok := t.cache.(Locker)
+	if !ok {
+		return "", errNotLocker
+	}
+	span := t.start("lock", name)
+	token, err := l.Lock(name, ttl)
+	span.SetAttributes(attribute.Bool("cache.acquired", err == nil))
+	end(span, ignore(err, ErrLocked))
+	return token, err
+}
+
+func (t *TracedCache) Renew(name, token string, ttl time.Duration) error {
+	l, ok := t.cache.(Locker)
+	if !ok {
+		return errNotLocker
+	}
+	span := t.start("renew", name)
+	err := l.Renew(name, token, ttl)
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) Unlock(name, token string) error {
+	l, ok := t.cache.(Locker)
+	if !ok {
+		return errNotLocker
+	}
+	span := t.start("unlock", name)
+	err := l.Unlock(name, token)
+	end(span, err)
+	return err
+}
+
+func (t *TracedCache) Increment(key string, by int64, ttl time.Duration) (int64, error) {
+	c, ok := t.cache.(Counter)
+	if !ok {
+		return 0, errNotCounter
+	}
+	span := t.start("increment", key)
+	n, err := c.Increment(key, by, ttl)
+	end(span, err)
+	return n, err
+}
+
+func (t *TracedCache) Decrement(key string, by int64, ttl time.Duration) (int64, error) {
+	c, ok := t.cache.(Counter)
+	if !ok {
+		return 0, errNotCounter
+	}
+	span := t.start("decrement", key)
+	n, err := c.Decrement(key, by, ttl)
+	end(span, err)
+	return n, err
+}
+
+// Unwrap returns the cache underneath any wrappers around c, such as TracedCache
+func Unwrap(c Cache) Cache {
+	for {
+		u, ok := c.(interface{ Unwrap() Cache })
+		if !ok {
+			return c
+		}
+		c = u.Unwrap()
+	}
+}
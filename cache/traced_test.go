@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedCache(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "request")
+
+	c := NewTracedCache(&MemoryCache{}, tracer, "memory").WithContext(ctx)
+	_ = c.Set("a", 1, 0)
+	_, _ = c.Has("b")
+	_, _ = c.GetBytes("a", "b")
+	if _, err := c.Get("b"); err == nil {
+		t.Fatal("got a value that was never set")
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	want := []struct {
+		name  string
+		attr  attribute.KeyValue
+		error bool
+	}{
+		{"cache.set", attribute.String("cache.key", "a"), false},
+		{"cache.has", attribute.Bool("cache.hit", false), false},
+		{"cache.get_many", attribute.Int("cache.keys", 2), false},
+		{"cache.get", attribute.String("cache.key", "b"), true},
+	}
+	if len(spans) != len(want)+1 {
+		t.Fatalf("got %d spans, want %d", len(spans), len(want)+1)
+	}
+	for i, w := range want {
+		span := spans[i]
+		if span.Name != w.name {
+			t.Errorf("span %d is %s, want %s", i, span.Name, w.name)
+		}
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the span in the context", span.Name)
+		}
+		found := false
+		for _, kv := range span.Attributes {
+			found = found || kv == w.attr
+		}
+		if !found {
+			t.Errorf("%s has attributes %v, want %v", span.Name, span.Attributes, w.attr)
+		}
+		if got := span.Status.Code.String() == "Error"; got != w.error {
+			t.Errorf("%s recorded an error: %v, want %v", span.Name, got, w.error)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// byteCache returns c as a ByteCache, if the cache underneath any wrappers stores
+// bytes and the wrappers pass them on
+func byteCache(c Cache) (ByteCache, bool) {
+	if _, ok := Unwrap(c).(ByteCache); !ok {
+		return nil, false
+	}
+	bc, ok := c.(ByteCache)
+	return bc, ok
+}
+
+// prepare readies s to decode into v, a pointer: gob has to know a type before it
+// decodes a value of it as an interface
+func prepare(s Serializer, v interface{}) {
+	if p, ok := s.(interface{ prepare(interface{}) }); ok {
+		p.prepare(v)
+	}
+}
+
+// Get returns the value of key in c as a T, or an error wrapping ErrMiss when key
+// is not in c. Caches that do not store bytes must hold a T under key.
+func Get[T any](c Cache, key string) (T, error) {
+	var value T
+
+	bc, ok := byteCache(c)
+	if !ok {
+		v, err := c.Get(key)
+		if err != nil {
+			return value, err
+		}
+		return value, assign(&value, v)
+	}
+
+	prepare(bc.Codec(), &value)
+	data, err := bc.GetBytes(key)
+	if err != nil {
+		return value, err
+	}
+	if data[0] == nil {
+		return value, fmt.Errorf("%w: %s", ErrMiss, key)
+	}
+	return value, bc.Codec().Unmarshal(data[0], &value)
+}
+
+// GetMany returns the values of keys in c, as T, keyed by their key. Keys that are
+// not in c are left out. A cache that stores bytes is asked for all the keys at
+// once.
+func GetMany[T any](c Cache, keys ...string) (map[string]T, error) {
+	values := make(map[string]T, len(keys))
+
+	bc, ok := byteCache(c)
+	if !ok {
+		for _, k := range keys {
+			v, err := Get[T](c, k)
+			if errors.Is(err, ErrMiss) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[k] = v
+		}
+		return values, nil
+	}
+
+	data, err := bc.GetBytes(keys...)
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	prepare(bc.Codec(), &zero)
+	for i, k := range keys {
+		if data[i] == nil {
+			continue
+		}
+		var v T
+		if err := bc.Codec().Unmarshal(data[i], &v); err != nil {
+			return nil, fmt.Errorf("cache: %s: %w", k, err)
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+// SetMany sets every value of items under its key, for ttl. A cache that stores
+// bytes sets them all at once.
+func SetMany[T any](c Cache, items map[string]T, ttl time.Duration) error {
+	bc, ok := byteCache(c)
+	if !ok {
+		for k, v := range items {
+			if err := c.Set(k, v, ttl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	encoded := make(map[string][]byte, len(items))
+	for k, v := range items {
+		data, err := bc.Codec().Marshal(v)
+		if err != nil {
+			return fmt.Errorf("cache: %s: %w", k, err)
+		}
+		encoded[k] = data
+	}
+	return bc.SetBytes(encoded, ttl)
+}
+
+// remembering lets a single caller at a time compute a value for Remember
+var remembering singleflight.Group
+
+// Remember returns the value of key in c, as a T. When key is not in c, it calls
+// fn, and keeps what it returns for ttl. Callers that ask for the same key at the
+// same time wait for a single call of fn, so that an expired key does not send
+// every request to the database at once. When the cache fails, the value of fn is
+// returned anyway: an outage of the cache slows pages down, rather than breaking
+// them, and is seen by the Observer of the cache.
+func Remember[T any](c Cache, key string, ttl time.Duration, fn func() (T, error)) (T, error) {
+	if v, err := Get[T](c, key); err == nil {
+		return v, nil
+	}
+
+	v, err, _ := remembering.Do(rememberKey[T](c, key), func() (interface{}, error) {
+		// another caller may have just set it
+		if v, err := Get[T](c, key); err == nil {
+			return v, nil
+		}
+
+		v, err := fn()
+		if err != nil {
+			return v, err
+		}
+		_ = c.Set(key, v, ttl)
+		return v, nil
+	})
+	value, _ := v.(T)
+	return value, err
+}
+
+// rememberKey keeps the calls of Remember for the same key of different caches, or
+// of different types, apart
+func rememberKey[T any](c Cache, key string) string {
+	var id uintptr
+	if v := reflect.ValueOf(Unwrap(c)); v.Kind() == reflect.Pointer {
+		id = v.Pointer()
+	}
+	return fmt.Sprintf("%x %s %s", id, reflect.TypeOf((*T)(nil)).Elem(), key)
+}
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type typedUser struct {
+	Name  string
+	Roles []string
+}
+
+// typedCaches returns the redis, badger and memory caches with every serializer. The badger
+// caches share a database, so keys are prefixed with the name of the cache.
+func typedCaches() map[string]Cache {
+	caches := map[string]Cache{}
+	for _, name := range []string{"gob", "json", "msgpack"} {
+		s, _ := SerializerFor(name)
+		caches["redis-"+name] = &RedisCache{Conn: testRedisCache.Conn, Prefix: "typed-" + name, Serializer: s}
+		caches["badger-"+name] = &BadgerCache{Conn: testBadgerCache.Conn, Serializer: s}
+		caches["memory-"+name] = &MemoryCache{Serializer: s}
+	}
+	caches["traced"] = NewTracedCache(caches["redis-json"], noop.NewTracerProvider().Tracer(""), "redis")
+	return caches
+}
+
+func TestGet(t *testing.T) {
+	for name, c := range typedCaches() {
+		t.Run(name, func(t *testing.T) {
+			key := name + ":user"
+			want := typedUser{Name: "Ann", Roles: []string{"admin"}}
+			if err := c.Set(key, want, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Get[typedUser](c, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+
+			if _, err := Get[typedUser](c, name+":missing"); !errors.Is(err, ErrMiss) {
+				t.Errorf("got %v for a missing key, want ErrMiss", err)
+			}
+			if _, err := c.Get(name + ":missing"); !errors.Is(err, ErrMiss) {
+				t.Errorf("untyped Get returned %v for a missing key, want ErrMiss", err)
+			}
+
+			// a value of another type is an error, not a zero value
+			if err := c.Set(key, "not a user", 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Get[typedUser](c, key); err == nil {
+				t.Error("a string was read as a user")
+			}
+		})
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	for name, c := range typedCaches() {
+		t.Run(name, func(t *testing.T) {
+			items := map[string]int{name + ":a": 1, name + ":b": 2, name + ":c": 3}
+			if err := SetMany(c, items, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := GetMany[int](c, name+":a", name+":b", name+":c", name+":missing")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, items) {
+				t.Errorf("got %v, want %v", got, items)
+			}
+		})
+	}
+}
+
+func TestRedisCache_ttl(t *testing.T) {
+	c := &RedisCache{Conn: testRedisCache.Conn, Prefix: "ttl"}
+	if err := c.Set("short", "value", 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMany(c, map[string]string{"many1": "a", "many2": "b"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("forever", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := c.Conn.Get()
+	defer conn.Close()
+	tests := []struct {
+		key      string
+		min, max int64
+	}{
+		{"short", 1, 1500},
+		{"many1", 3_500_000, 3_600_000},
+		{"many2", 3_500_000, 3_600_000},
+		{"forever", -1, -1},
+	}
+	for _, tt := range tests {
+		ttl, err := redis.Int64(conn.Do("PTTL", "ttl:"+tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl < tt.min || ttl > tt.max {
+			t.Errorf("%s expires in %dms, want %d to %d", tt.key, ttl, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBadgerCache_ttl(t *testing.T) {
+	if err := testBadgerCache.Set("ttl:short", "value", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	err := testBadgerCache.Conn.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("ttl:short"))
+		if err != nil {
+			return err
+		}
+		if expires := time.Unix(int64(item.ExpiresAt()), 0); time.Until(expires) < 59*time.Minute || time.Until(expires) > time.Hour {
+			t.Errorf("expires at %v, want in an hour", expires)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemember(t *testing.T) {
+	for name, c := range typedCaches() {
+		t.Run(name, func(t *testing.T) {
+			key := name + ":remembered"
+			_ = c.Forget(key)
+
+			var calls atomic.Int32
+			fn := func() (typedUser, error) {
+				calls.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return typedUser{Name: "Ann"}, nil
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					u, err := Remember(c, key, time.Minute, fn)
+					if err != nil || u.Name != "Ann" {
+						t.Errorf("got %+v, %v", u, err)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := calls.Load(); n != 1 {
+				t.Errorf("fn was called %d times, want once", n)
+			}
+
+			if u, err := Get[typedUser](c, key); err != nil || u.Name != "Ann" {
+				t.Errorf("the value was not kept: %+v, %v", u, err)
+			}
+		})
+	}
+}
+
+func TestRemember_error(t *testing.T) {
+	c := &RedisCache{Conn: testRedisCache.Conn, Prefix: "remember-error"}
+	boom := errors.New("boom")
+
+	if _, err := Remember(c, "key", time.Minute, func() (int, error) { return 0, boom }); !errors.Is(err, boom) {
+		t.Errorf("got %v, want the error of fn", err)
+	}
+	if found, _ := c.Has("key"); found {
+		t.Error("the value of a failed call was kept")
+	}
+}
+
+func TestGob_legacyEntry(t *testing.T) {
+	c := &RedisCache{Conn: testRedisCache.Conn, Prefix: "legacy"}
+	data, err := encode(Entry{"legacy:old": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := c.Conn.Get()
+	defer conn.Close()
+	if _, err := conn.Do("SET", "legacy:old", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := c.Get("old"); err != nil || v != "value" {
+		t.Errorf("got %v, %v, want the value of the entry", v, err)
+	}
+}
+
+func TestSerializers(t *testing.T) {
+	for _, name := range []string{"gob", "json", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			s, err := SerializerFor(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := s.Marshal(typedUser{Name: "Ann"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var u typedUser
+			if err := s.Unmarshal(data, &u); err != nil || u.Name != "Ann" {
+				t.Errorf("got %+v, %v", u, err)
+			}
+		})
+	}
+
+	if _, err := SerializerFor("xml"); err == nil {
+		t.Error("xml was accepted")
+	}
+}
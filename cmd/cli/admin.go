@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// adminClient talks to the admin server of the running application
+type adminClient struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// newAdminClient connects to the admin socket, if the application is running on
+// this machine, and to ADMIN_ADDR otherwise
+func newAdminClient() (*adminClient, error) {
+	cfg := boil.Config.Admin
+	client := &adminClient{token: cfg.Token}
+
+	socket := boil.AdminSocketPath()
+	if _, err := os.Stat(socket); err == nil || cfg.Addr == "" {
+		client.base = "http://admin"
+		client.http = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+		return client, nil
+	}
+
+	transport := &http.Transport{}
+	client.base = "http://" + cfg.Addr
+	if cfg.TLSCert != "" {
+		tlsConfig, err := adminClientTLS(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+		client.base = "https://" + cfg.Addr
+	}
+	client.http = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+
+	return client, nil
+}
+
+// adminClientTLS trusts the admin server's own certificate, as well as the system
+// roots, so self-signed certificates work, and presents ADMIN_CLIENT_CERT if set
+func adminClientTLS(cfg boilme.AdminConfig) (*tls.Config, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if pem, err := os.ReadFile(cfg.TLSCert); err == nil {
+		roots.AppendCertsFromPEM(pem)
+	}
+
+	tlsConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("admin client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// do sends in, as json, to path, and decodes the response into out. Either may be nil.
+func (c *adminClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach the admin server; is the application running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var payload struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil || payload.Error == "" {
+			return fmt.Errorf("admin server: %s", resp.Status)
+		}
+		return errors.New(payload.Error)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// mustAdminClient returns a client for the admin server, or exits
+func mustAdminClient() *adminClient {
+	client, err := newAdminClient()
+	if err != nil {
+		exitGracefully(err)
+	}
+	return client
+}
+
+var flushAll bool
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache flush <pattern>|--all",
+	Short: "Remove the keys matching pattern from the cache of the running application, or empty it",
+	Long: `Remove the keys matching pattern from the cache of the running application.
+
+With --all, the whole cache is emptied. That also removes rate limit counters,
+cached responses and, with TLS_ACME_CACHE=cache, ACME certificates, which then
+have to be issued again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || args[0] != "flush" {
+			showHelp()
+			return
+		}
+
+		req := boilme.AdminCacheFlush{All: flushAll}
+		if len(args) > 1 {
+			req.Pattern = args[1]
+		}
+		if (req.Pattern == "") != req.All {
+			exitGracefully(errors.New("give a pattern, or --all to empty the whole cache"))
+		}
+
+		if err := mustAdminClient().do(http.MethodPost, "/cache/flush", req, nil); err != nil {
+			exitGracefully(err)
+		}
+		exitGracefully(nil, "Cache flushed")
+	},
+}
+
+func init() {
+	cacheCmd.Flags().BoolVar(&flushAll, "all", false, "empty the whole cache")
+}
+
+// jobsCmd represents the jobs command
+var jobsCmd = &cobra.Command{
+	Use:   "jobs [list|run <id>]",
+	Short: "List the scheduled jobs of the running application, or run one now",
+	Run: func(cmd *cobra.Command, args []string) {
+		client := mustAdminClient()
+
+		if len(args) > 0 && args[0] == "run" {
+			if len(args) < 2 {
+				exitGracefully(errors.New("jobs run requires a job id"))
+			}
+			if _, err := strconv.Atoi(args[1]); err != nil {
+				exitGracefully(fmt.Errorf("invalid job id %q", args[1]))
+			}
+			if err := client.do(http.MethodPost, "/jobs/"+args[1]+"/run", nil, nil); err != nil {
+				exitGracefully(err)
+			}
+			exitGracefully(nil, "Job "+args[1]+" started")
+		}
+
+		var jobs []boilme.AdminJob
+		if err := client.do(http.MethodGet, "/jobs", nil, &jobs); err != nil {
+			exitGracefully(err)
+		}
+		if len(jobs) == 0 {
+			exitGracefully(nil, "No scheduled jobs")
+		}
+
+		fmt.Printf("%-4s %-25s %-25s %s\n", "ID", "NEXT", "PREVIOUS", "JOB")
+		for _, job := range jobs {
+			prev := "-"
+			if !job.Prev.IsZero() {
+				prev = job.Prev.Format(time.RFC3339)
+			}
+			fmt.Printf("%-4d %-25s %-25s %s\n", job.ID, job.Next.Format(time.RFC3339), prev, job.Name)
+		}
+	},
+}
+
+// logLevelCmd represents the log-level command
+var logLevelCmd = &cobra.Command{
+	Use:   "log-level [debug|info|warn|error]",
+	Short: "Show or change the log level of the running application",
+	Run: func(cmd *cobra.Command, args []string) {
+		client := mustAdminClient()
+
+		var level boilme.AdminLogLevel
+		var err error
+		if len(args) > 0 {
+			err = client.do(http.MethodPut, "/log-level", boilme.AdminLogLevel{Level: args[0]}, &level)
+		} else {
+			err = client.do(http.MethodGet, "/log-level", nil, &level)
+		}
+		if err != nil {
+			exitGracefully(err)
+		}
+
+		color.Yellow("Log level: %s", level.Level)
+	},
+}
+
+// mailQueueCmd represents the mail:queue command
+var mailQueueCmd = &cobra.Command{
+	Use:   "mail:queue [queued|retrying|sent|failed|retry <id>]",
+	Short: "List the mail outbox of the running application, or retry a failed message",
+	Run: func(cmd *cobra.Command, args []string) {
+		client := mustAdminClient()
+
+		if len(args) > 0 && args[0] == "retry" {
+			if len(args) < 2 {
+				exitGracefully(errors.New("mail:queue retry requires a message id"))
+			}
+			if err := client.do(http.MethodPost, "/mail/outbox/"+url.PathEscape(args[1])+"/retry", nil, nil); err != nil {
+				exitGracefully(err)
+			}
+			exitGracefully(nil, "Message "+args[1]+" queued")
+		}
+
+		path := "/mail/outbox"
+		if len(args) > 0 {
+			path += "?status=" + url.QueryEscape(args[0])
+		}
+
+		var messages []mailer.OutboxMessage
+		if err := client.do(http.MethodGet, path, nil, &messages); err != nil {
+			exitGracefully(err)
+		}
+		if len(messages) == 0 {
+			exitGracefully(nil, "No messages")
+		}
+
+		fmt.Printf("%-32s %-8s %-8s %-25s %-30s %s\n", "ID", "STATUS", "ATTEMPTS", "UPDATED", "TO", "LAST ERROR")
+		for _, msg := range messages {
+			fmt.Printf("%-32s %-8s %-8d %-25s %-30s %s\n", msg.ID, msg.Status, len(msg.Attempts),
+				msg.UpdatedAt.Format(time.RFC3339), strings.Join(msg.Message.To, ","), msg.LastError())
+		}
+	},
+}
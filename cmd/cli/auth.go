@@ -8,7 +8,7 @@ func doAuth() error {
 	checkForDB()
 
 	// migrations
-	dbType := boil.DB.DataType
+	dbType := boil.DB.Dialect
 
 	tx, err := boil.PopConnect()
 	if err != nil {
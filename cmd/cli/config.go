@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bxtal-lsn/go-boilme"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config [check|dump]",
+	Short: "Print the resolved configuration, and check it for errors",
+	Run: func(cmd *cobra.Command, args []string) {
+		switch {
+		case len(args) == 0 || args[0] == "check":
+			doConfigCheck()
+		case args[0] == "dump":
+			doConfigDump()
+		default:
+			showHelp()
+		}
+	},
+}
+
+// doConfigDump prints the configuration the running application is using, with
+// secrets redacted
+func doConfigDump() {
+	var settings []boilme.ConfigSetting
+	if err := mustAdminClient().do(http.MethodGet, "/config", nil, &settings); err != nil {
+		exitGracefully(err)
+	}
+
+	color.Green("Configuration of the running application:\n")
+	for _, setting := range settings {
+		fmt.Printf("\t%-22s %s\n", setting.Name, setting.Value)
+	}
+}
+
+// doConfigCheck prints every configuration value, with secrets redacted, followed
+// by any validation errors. It exits with a non-zero status if there are errors, so
+// it can be used in CI or deploy scripts.
+func doConfigCheck() {
+	color.Green("Configuration for environment %q:\n", boil.Config.Environment)
+
+	for _, setting := range boil.Config.Settings() {
+		fmt.Printf("\t%-22s %s\n", setting.Name, setting.Value)
+	}
+	fmt.Println()
+
+	if len(configErr) > 0 {
+		color.Red(configErr.Error())
+		os.Exit(1)
+	}
+
+	color.Green("Configuration is valid")
+}
@@ -18,9 +18,19 @@ func showHelp() {
 	color.Yellow(`Available commands:
 
 	help                           - show the help commands
-	down                           - put the server into maintenance mode
+	down                           - put the server into maintenance mode; see boilme down --help for
+	                                 scheduled windows (--start, --until), --message, --allow and --secret
 	up                             - take the server out of maintenance mode
 	version                        - print application version
+	config check                   - print the resolved configuration (secrets redacted) and report any errors
+	config dump                    - print the configuration of the running application (secrets redacted)
+	cache flush <pattern>          - remove the keys matching pattern from the cache of the running application
+	cache flush --all              - empty the whole cache, including rate limits and ACME certificates kept there
+	jobs                           - list the scheduled jobs of the running application
+	jobs run <id>                  - run a scheduled job now
+	log-level [level]              - show or change the log level of the running application
+	mail:queue [status]            - list the mail outbox, optionally only queued, retrying, sent or failed mail
+	mail:queue retry <id>          - send a failed message again
 	migrate                        - runs all up migrations that have not been run previously
 	migrate down                   - reverses the most recent migration
 	migrate reset                  - runs all down migrations in reverse order, and then all up migrations
@@ -29,6 +39,7 @@ func showHelp() {
 	make handler <name>            - creates a stub handler in the handlers directory
 	make model <name>              - creates a new model in the data directory
 	make session                   - creates a table in the database as a session store
+	make jobs                      - creates tables in the database for background jobs and the mail outbox
 	make mail <name>               - creates two starter mail templates in the mail directory
 	
 	`)
@@ -6,35 +6,40 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/bxtal-lsn/go-boilme"
 )
 
 func getDSN() string {
-	dbType := boil.DB.DataType
-
-	if dbType == "pgx" {
-		dbType = "postgres"
-	}
-
-	if dbType == "postgres" {
+	switch boil.DB.Dialect {
+	case boilme.DialectPostgres:
 		var dsn string
-		if os.Getenv("DATABASE_PASS") != "" {
+		if boil.Config.Database.Pass != "" {
 			dsn = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
-				os.Getenv("DATABASE_USER"),
-				os.Getenv("DATABASE_PASS"),
-				os.Getenv("DATABASE_HOST"),
-				os.Getenv("DATABASE_PORT"),
-				os.Getenv("DATABASE_NAME"),
-				os.Getenv("DATABASE_SSL_MODE"))
+				boil.Config.Database.User,
+				boil.Config.Database.Pass,
+				boil.Config.Database.Host,
+				boil.Config.Database.Port,
+				boil.Config.Database.Name,
+				boil.Config.Database.SSLMode)
 		} else {
 			dsn = fmt.Sprintf("postgres://%s@%s:%s/%s?sslmode=%s",
-				os.Getenv("DATABASE_USER"),
-				os.Getenv("DATABASE_HOST"),
-				os.Getenv("DATABASE_PORT"),
-				os.Getenv("DATABASE_NAME"),
-				os.Getenv("DATABASE_SSL_MODE"))
+				boil.Config.Database.User,
+				boil.Config.Database.Host,
+				boil.Config.Database.Port,
+				boil.Config.Database.Name,
+				boil.Config.Database.SSLMode)
 		}
 		return dsn
+
+	case boilme.DialectSQLite:
+		path := boil.Config.Database.Name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(boil.RootPath, path)
+		}
+		return "sqlite3://" + path
 	}
+
 	return "mysql://" + boil.BuildDSN()
 }
 
@@ -6,7 +6,6 @@ import (
 
 	"github.com/bxtal-lsn/go-boilme"
 	"github.com/fatih/color"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
@@ -14,12 +13,16 @@ const version = "1.0.0"
 
 var boil boilme.Boilme
 
+// configErr holds any problems found when loading the application's configuration
+var configErr boilme.ConfigErrors
+
 // rootCmd represents the base command
 var rootCmd = &cobra.Command{
 	Use:   "boilme",
 	Short: "A powerful web application framework for Go",
 	Long: `Boilme is a powerful web application framework for Go.
 It provides tools for database migrations, authentication, and more.`,
+	PersistentPreRun: setup,
 }
 
 func main() {
@@ -42,6 +45,11 @@ func initCommands() {
 	rootCmd.AddCommand(newCmd)
 	rootCmd.AddCommand(migrateCmd)
 	rootCmd.AddCommand(makeCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(jobsCmd)
+	rootCmd.AddCommand(logLevelCmd)
+	rootCmd.AddCommand(mailQueueCmd)
 }
 
 func exitGracefully(err error, msg ...string) {
@@ -89,20 +97,35 @@ func validateInput() (string, string, string, string, error) {
 	return arg1, arg2, arg3, arg4, nil
 }
 
-func setup(arg1, arg2 string) {
-	if arg1 != "new" && arg1 != "version" && arg1 != "help" {
-		err := godotenv.Load()
-		if err != nil {
-			exitGracefully(err)
-		}
+// setup loads the configuration of the application in the current directory, for
+// every command that needs one
+func setup(cmd *cobra.Command, args []string) {
+	switch cmd.Name() {
+	case "new", "version", "help":
+		return
+	}
+
+	path, err := os.Getwd()
+	if err != nil {
+		exitGracefully(err)
+	}
 
-		path, err := os.Getwd()
-		if err != nil {
+	cfg, err := boilme.LoadConfig(path)
+	if err != nil {
+		var invalid boilme.ConfigErrors
+		if !errors.As(err, &invalid) {
 			exitGracefully(err)
 		}
 
-		boil.RootPath = path
-		boil.DB.DataType = os.Getenv("DATABASE_TYPE")
+		// config check reports these itself; everything else carries on with a warning
+		configErr = invalid
+		if cmd.Name() != "config" {
+			color.Yellow(invalid.Error())
+		}
 	}
-}
 
+	boil.RootPath = path
+	boil.Config = cfg
+	boil.DB.DataType = cfg.Database.Type
+	boil.DB.Dialect = boilme.NormalizeDialect(cfg.Database.Type)
+}
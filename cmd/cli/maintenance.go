@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme"
+	"github.com/spf13/cobra"
+)
+
+// flags for the down command
+var (
+	downUntil      string
+	downStart      string
+	downMessage    string
+	downRetryAfter time.Duration
+	downAllow      []string
+	downSecret     string
+)
+
+// upCmd represents the up command
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Take the server out of maintenance mode",
+	Run: func(cmd *cobra.Command, args []string) {
+		setMaintenance(boilme.MaintenanceState{})
+	},
+}
+
+// downCmd represents the down command
+var downCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Put the server into maintenance mode",
+	Long: `Put the server into maintenance mode, now or for a scheduled window.
+
+--start and --until take a duration from now, such as 30m, or a time such as
+2024-06-01T22:00:00Z. Without --until, the site stays down until "boilme up".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		now := time.Now()
+		state := boilme.MaintenanceState{
+			Enabled:    true,
+			Message:    downMessage,
+			RetryAfter: downRetryAfter,
+			AllowedIPs: downAllow,
+			Secret:     downSecret,
+		}
+
+		var err error
+		if state.Start, err = parseWhen(downStart, now); err != nil {
+			exitGracefully(fmt.Errorf("--start: %w", err))
+		}
+		from := now
+		if !state.Start.IsZero() {
+			from = state.Start
+		}
+		if state.Until, err = parseWhen(downUntil, from); err != nil {
+			exitGracefully(fmt.Errorf("--until: %w", err))
+		}
+
+		setMaintenance(state)
+	},
+}
+
+func init() {
+	downCmd.Flags().StringVar(&downUntil, "until", "", "end of the maintenance window (duration or RFC 3339 time)")
+	downCmd.Flags().StringVar(&downStart, "start", "", "start of the maintenance window (duration or RFC 3339 time); default now")
+	downCmd.Flags().StringVar(&downMessage, "message", "", "message shown to visitors")
+	downCmd.Flags().DurationVar(&downRetryAfter, "retry-after", 0, "Retry-After sent to clients when there is no --until")
+	downCmd.Flags().StringSliceVar(&downAllow, "allow", nil, "addresses or CIDR ranges that may still use the site")
+	downCmd.Flags().StringVar(&downSecret, "secret", "", "visiting /<secret> sets a cookie that bypasses maintenance mode")
+}
+
+// parseWhen parses a duration after from, or an RFC 3339 time. Empty means the zero time.
+func parseWhen(s string, from time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return from.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// setMaintenance saves state as the maintenance state of the running application
+func setMaintenance(state boilme.MaintenanceState) {
+	client, err := newAdminClient()
+	if err != nil {
+		exitGracefully(err)
+	}
+
+	var saved boilme.MaintenanceState
+	if err := client.do(http.MethodPut, "/maintenance", state, &saved); err != nil {
+		exitGracefully(err)
+	}
+
+	switch now := time.Now(); {
+	case !saved.Enabled:
+		exitGracefully(nil, "Server live!")
+	case saved.Active(now):
+		exitGracefully(nil, "Server in maintenance mode")
+	case !saved.Start.IsZero() && now.Before(saved.Start):
+		exitGracefully(nil, "Maintenance scheduled for "+saved.Start.Format(time.RFC1123))
+	default:
+		exitGracefully(nil, "Maintenance window has already ended")
+	}
+}
@@ -13,12 +13,12 @@ import (
 
 // makeCmd represents the make command
 var makeCmd = &cobra.Command{
-	Use:   "make [migration|model|handler|auth|mail|session]",
+	Use:   "make [migration|model|handler|auth|mail|session|jobs]",
 	Short: "Generate resources like migrations, models, handlers, etc.",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if args[0] == "" {
-			exitGracefully(errors.New("make requires a subcommand: (migration|model|handler|auth|mail|session)"))
+			exitGracefully(errors.New("make requires a subcommand: (migration|model|handler|auth|mail|session|jobs)"))
 			return
 		}
 
@@ -161,6 +161,12 @@ func doMake(arg2, arg3, arg4 string) error {
 		if err != nil {
 			exitGracefully(err)
 		}
+
+	case "jobs":
+		err := doJobsTable()
+		if err != nil {
+			exitGracefully(err)
+		}
 	}
 
 	return nil
@@ -1,19 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/bxtal-lsn/go-boilme"
 )
 
 func doSessionTable() error {
-	dbType := boil.DB.DataType
-
-	if dbType == "mariadb" {
-		dbType = "mysql"
-	}
+	dbType := boil.DB.Dialect
 
-	if dbType == "postgresql" {
-		dbType = "postgres"
+	if dbType == boilme.DialectSQLite {
+		exitGracefully(errors.New("sessions cannot be stored in sqlite; use SESSION_TYPE=cookie or redis"))
 	}
 
 	fileName := fmt.Sprintf("%d_create_sessions_table", time.Now().UnixMicro())
@@ -38,3 +37,30 @@ func doSessionTable() error {
 
 	return nil
 }
+
+func doJobsTable() error {
+	dbType := boil.DB.Dialect
+	checkForDB()
+
+	fileName := fmt.Sprintf("%d_create_jobs_table", time.Now().UnixMicro())
+
+	upFile := boil.RootPath + "/migrations/" + fileName + "." + dbType + ".up.sql"
+	downFile := boil.RootPath + "/migrations/" + fileName + "." + dbType + ".down.sql"
+
+	err := copyFilefromTemplate("templates/migrations/"+dbType+"_jobs.sql", upFile)
+	if err != nil {
+		exitGracefully(err)
+	}
+
+	err = copyDataToFile([]byte("drop table mail_suppressions;\ndrop table mail_outbox;\ndrop table jobs;"), downFile)
+	if err != nil {
+		exitGracefully(err)
+	}
+
+	err = doMigrate("up", "")
+	if err != nil {
+		exitGracefully(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+CREATE TABLE jobs (
+	id VARCHAR(32) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 0,
+	run_at BIGINT NOT NULL,
+	unique_key VARCHAR(255) UNIQUE,
+	last_error TEXT,
+	created_at BIGINT NOT NULL,
+	failed_at BIGINT
+);
+
+CREATE INDEX jobs_due_idx ON jobs (failed_at, run_at);
+
+CREATE TABLE mail_outbox (
+	id VARCHAR(32) PRIMARY KEY,
+	status VARCHAR(16) NOT NULL,
+	message TEXT NOT NULL,
+	attempts TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL,
+	sent_at BIGINT
+);
+
+CREATE INDEX mail_outbox_status_idx ON mail_outbox (status, updated_at);
+
+CREATE TABLE mail_suppressions (
+	address VARCHAR(255) PRIMARY KEY,
+	reason TEXT NOT NULL,
+	created_at BIGINT NOT NULL
+);
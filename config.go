@@ -0,0 +1,676 @@
+package boilme
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds every setting a Boilme application reads at startup. Each field
+// is described by struct tags:
+//
+//	env      the environment variable(s) the value is read from; the first is
+//	         the canonical name, any others are accepted as aliases
+//	default  the value used when nothing else sets the field
+//	required the field must be set whenever its section is in use
+//	secret   the value is redacted when the configuration is printed
+//
+// yaml and toml tags name the field in config files. See LoadConfig for the
+// order in which sources are applied.
+type Config struct {
+	AppName     string `env:"APP_NAME" yaml:"app_name" toml:"app_name"`
+	AppURL      string `env:"APP_URL" yaml:"app_url" toml:"app_url"`
+	Environment string `env:"APP_ENV" yaml:"environment" toml:"environment" default:"development"`
+	Debug       bool   `env:"DEBUG" yaml:"debug" toml:"debug"`
+	Port        string `env:"PORT" yaml:"port" toml:"port" default:"4000"`
+	// Deprecated: the CLI now talks to the admin server; see AdminConfig.
+	RPCPort         string        `env:"RPC_PORT" yaml:"rpc_port" toml:"rpc_port"`
+	ServerName      string        `env:"SERVER_NAME" yaml:"server_name" toml:"server_name" default:"localhost"`
+	Secure          bool          `env:"SECURE" yaml:"secure" toml:"secure" default:"true"`
+	Renderer        string        `env:"RENDERER" yaml:"renderer" toml:"renderer" default:"jet"`
+	Key             string        `env:"KEY" yaml:"key" toml:"key" secret:"true"`
+	Cache           string        `env:"CACHE" yaml:"cache" toml:"cache"`
+	CacheSerializer string        `env:"CACHE_SERIALIZER" yaml:"cache_serializer" toml:"cache_serializer" default:"gob"`
+	CacheMaxEntries int           `env:"CACHE_MAX_ENTRIES" yaml:"cache_max_entries" toml:"cache_max_entries" default:"10000"`
+	CacheMaxBytes   int64         `env:"CACHE_MAX_BYTES" yaml:"cache_max_bytes" toml:"cache_max_bytes"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout" toml:"shutdown_timeout" default:"30s"`
+	HealthTimeout   time.Duration `env:"HEALTH_CHECK_TIMEOUT" yaml:"health_check_timeout" toml:"health_check_timeout" default:"5s"`
+
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Redis    RedisConfig    `yaml:"redis" toml:"redis"`
+	Session  SessionConfig  `yaml:"session" toml:"session"`
+	Mail     MailConfig     `yaml:"mail" toml:"mail"`
+	Uploads  UploadConfig   `yaml:"uploads" toml:"uploads"`
+	Log      LogConfig      `yaml:"log" toml:"log"`
+	Metrics  MetricsConfig  `yaml:"metrics" toml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing" toml:"tracing"`
+
+	Maintenance MaintenanceConfig `yaml:"maintenance" toml:"maintenance"`
+	Admin       AdminConfig       `yaml:"admin" toml:"admin"`
+	Jobs        JobsConfig        `yaml:"jobs" toml:"jobs"`
+	TLS         TLSConfig         `yaml:"tls" toml:"tls"`
+	S3          S3Config          `yaml:"s3" toml:"s3"`
+	Minio       MinioConfig       `yaml:"minio" toml:"minio"`
+	SFTP        SFTPConfig        `yaml:"sftp" toml:"sftp"`
+	WebDAV      WebDAVConfig      `yaml:"webdav" toml:"webdav"`
+}
+
+// DatabaseConfig holds the settings for the sql database
+type DatabaseConfig struct {
+	Type    string `env:"DATABASE_TYPE" yaml:"type" toml:"type"`
+	Host    string `env:"DATABASE_HOST" yaml:"host" toml:"host"`
+	Port    string `env:"DATABASE_PORT" yaml:"port" toml:"port"`
+	User    string `env:"DATABASE_USER" yaml:"user" toml:"user"`
+	Pass    string `env:"DATABASE_PASS" yaml:"pass" toml:"pass" secret:"true"`
+	Name    string `env:"DATABASE_NAME" yaml:"name" toml:"name" required:"true"`
+	SSLMode string `env:"DATABASE_SSL_MODE" yaml:"ssl_mode" toml:"ssl_mode"`
+
+	MaxOpenConns    int           `env:"DATABASE_MAX_OPEN_CONNS" yaml:"max_open_conns" toml:"max_open_conns" default:"25"`
+	MaxIdleConns    int           `env:"DATABASE_MAX_IDLE_CONNS" yaml:"max_idle_conns" toml:"max_idle_conns" default:"25"`
+	ConnMaxLifetime time.Duration `env:"DATABASE_CONN_MAX_LIFETIME" yaml:"conn_max_lifetime" toml:"conn_max_lifetime" default:"5m"`
+	ConnMaxIdleTime time.Duration `env:"DATABASE_CONN_MAX_IDLE_TIME" yaml:"conn_max_idle_time" toml:"conn_max_idle_time" default:"5m"`
+	ConnectRetries  int           `env:"DATABASE_CONNECT_RETRIES" yaml:"connect_retries" toml:"connect_retries" default:"5"`
+	ConnectBackoff  time.Duration `env:"DATABASE_CONNECT_BACKOFF" yaml:"connect_backoff" toml:"connect_backoff" default:"1s"`
+}
+
+func (d DatabaseConfig) enabled() bool {
+	return d.Type != ""
+}
+
+// RedisConfig holds the settings for connecting to redis
+type RedisConfig struct {
+	Host     string `env:"REDIS_HOST" yaml:"host" toml:"host"`
+	Password string `env:"REDIS_PASSWORD" yaml:"password" toml:"password" secret:"true"`
+	Prefix   string `env:"REDIS_PREFIX" yaml:"prefix" toml:"prefix"`
+}
+
+// SessionConfig holds the session store and session cookie settings
+type SessionConfig struct {
+	Type           string `env:"SESSION_TYPE" yaml:"type" toml:"type" default:"cookie"`
+	CookieName     string `env:"COOKIE_NAME" yaml:"cookie_name" toml:"cookie_name"`
+	CookieLifetime int    `env:"COOKIE_LIFETIME" yaml:"cookie_lifetime" toml:"cookie_lifetime" default:"60"`
+	CookiePersist  bool   `env:"COOKIE_PERSIST,COOKIE_PERSISTS" yaml:"cookie_persist" toml:"cookie_persist"`
+	CookieSecure   bool   `env:"COOKIE_SECURE" yaml:"cookie_secure" toml:"cookie_secure"`
+	CookieDomain   string `env:"COOKIE_DOMAIN" yaml:"cookie_domain" toml:"cookie_domain"`
+}
+
+// MailConfig holds the settings for sending mail, over smtp or an api. API names
+// a transport registered with the mailer package: smtp, mailgun, sparkpost,
+// sendgrid, ses, postmark or http, or log or file, which write mail to tmp/mail
+// instead of sending it. Mail sent
+// through the Jobs channel goes through the outbox, which is kept in the JOBS_BACKEND
+// store: Workers messages are sent at once, each is tried up to MaxAttempts times,
+// waiting RetryBackoff before the first retry, and sent and failed messages are
+// kept for OutboxRetention. WebhookKey and WebhookAuth verify the webhooks of the
+// api; with Suppressions set, addresses that bounce for good or complain are kept
+// in the JOBS_BACKEND store and no longer sent mail. Mail sent over smtp is signed
+// with DKIM when DKIMSelector is set, and messages that ask for it are signed with
+// S/MIME by SMIMECertFile.
+type MailConfig struct {
+	Domain      string `env:"MAIL_DOMAIN" yaml:"domain" toml:"domain"`
+	Host        string `env:"SMTP_HOST" yaml:"host" toml:"host"`
+	Port        int    `env:"SMTP_PORT" yaml:"port" toml:"port"`
+	Username    string `env:"SMTP_USERNAME" yaml:"username" toml:"username"`
+	Password    string `env:"SMTP_PASSWORD" yaml:"password" toml:"password" secret:"true"`
+	Encryption  string `env:"SMTP_ENCRYPTION" yaml:"encryption" toml:"encryption"`
+	FromName    string `env:"FROM_NAME" yaml:"from_name" toml:"from_name"`
+	FromAddress string `env:"FROM_ADDRESS" yaml:"from_address" toml:"from_address"`
+	API         string `env:"MAILER_API" yaml:"api" toml:"api"`
+	APIKey      string `env:"MAILER_KEY" yaml:"api_key" toml:"api_key" secret:"true"`
+	APIURL      string `env:"MAILER_URL" yaml:"api_url" toml:"api_url"`
+
+	WebhookKey   string `env:"MAILER_WEBHOOK_KEY" yaml:"webhook_key" toml:"webhook_key" secret:"true"`
+	WebhookAuth  string `env:"MAILER_WEBHOOK_AUTH" yaml:"webhook_auth" toml:"webhook_auth" secret:"true"`
+	Suppressions bool   `env:"MAIL_SUPPRESSIONS" yaml:"suppressions" toml:"suppressions"`
+
+	DKIMDomain    string `env:"MAIL_DKIM_DOMAIN" yaml:"dkim_domain" toml:"dkim_domain"`
+	DKIMSelector  string `env:"MAIL_DKIM_SELECTOR" yaml:"dkim_selector" toml:"dkim_selector"`
+	DKIMKeyFile   string `env:"MAIL_DKIM_KEY_FILE" yaml:"dkim_key_file" toml:"dkim_key_file"`
+	SMIMECertFile string `env:"MAIL_SMIME_CERT_FILE" yaml:"smime_cert_file" toml:"smime_cert_file"`
+	SMIMEKeyFile  string `env:"MAIL_SMIME_KEY_FILE" yaml:"smime_key_file" toml:"smime_key_file"`
+
+	Workers         int           `env:"MAIL_WORKERS" yaml:"workers" toml:"workers" default:"5"`
+	MaxAttempts     int           `env:"MAIL_MAX_ATTEMPTS" yaml:"max_attempts" toml:"max_attempts" default:"5"`
+	RetryBackoff    time.Duration `env:"MAIL_RETRY_BACKOFF" yaml:"retry_backoff" toml:"retry_backoff" default:"30s"`
+	OutboxRetention time.Duration `env:"MAIL_OUTBOX_RETENTION" yaml:"outbox_retention" toml:"outbox_retention" default:"168h"`
+}
+
+// UploadConfig holds the settings for file uploads
+type UploadConfig struct {
+	AllowedMimeTypes []string `env:"ALLOWED_FILETYPES" yaml:"allowed_mime_types" toml:"allowed_mime_types"`
+	MaxUploadSize    int64    `env:"MAX_UPLOAD_SIZE" yaml:"max_upload_size" toml:"max_upload_size" default:"10485760"`
+}
+
+// LogConfig holds the settings for the application's logger. MaxSize is in
+// megabytes; when File is relative it is created in the logs folder.
+type LogConfig struct {
+	Level      string `env:"LOG_LEVEL" yaml:"level" toml:"level" default:"info"`
+	Format     string `env:"LOG_FORMAT" yaml:"format" toml:"format" default:"text"`
+	File       string `env:"LOG_FILE" yaml:"file" toml:"file"`
+	MaxSize    int    `env:"LOG_MAX_SIZE" yaml:"max_size" toml:"max_size" default:"100"`
+	MaxBackups int    `env:"LOG_MAX_BACKUPS" yaml:"max_backups" toml:"max_backups" default:"5"`
+}
+
+// MetricsConfig holds the settings for the prometheus metrics endpoint
+type MetricsConfig struct {
+	Enabled bool   `env:"METRICS_ENABLED" yaml:"enabled" toml:"enabled"`
+	Path    string `env:"METRICS_PATH" yaml:"path" toml:"path" default:"/metrics"`
+}
+
+// TracingConfig holds the settings for OpenTelemetry tracing. The exporter and
+// sampler are further configured with the standard OTEL_* environment variables.
+type TracingConfig struct {
+	Enabled     bool   `env:"TRACING_ENABLED" yaml:"enabled" toml:"enabled"`
+	Exporter    string `env:"TRACING_EXPORTER" yaml:"exporter" toml:"exporter" default:"otlp"`
+	ServiceName string `env:"TRACING_SERVICE_NAME" yaml:"service_name" toml:"service_name"`
+}
+
+// MaintenanceConfig holds the settings for maintenance mode. Store is cache, database
+// or file; by default the cache is used if there is one. AllowedIPs (addresses or
+// CIDR ranges) may always use the site, on top of those allowed by each window.
+type MaintenanceConfig struct {
+	Store      string        `env:"MAINTENANCE_STORE" yaml:"store" toml:"store"`
+	AllowedIPs []string      `env:"MAINTENANCE_ALLOWED_IPS" yaml:"allowed_ips" toml:"allowed_ips"`
+	RetryAfter time.Duration `env:"MAINTENANCE_RETRY_AFTER" yaml:"retry_after" toml:"retry_after" default:"5m"`
+	Refresh    time.Duration `env:"MAINTENANCE_REFRESH" yaml:"refresh" toml:"refresh" default:"5s"`
+}
+
+// JobsConfig holds the settings for the background job queue. Backend is memory,
+// redis or database; the database backend needs the table from "boilme make jobs".
+type JobsConfig struct {
+	Backend      string        `env:"JOBS_BACKEND" yaml:"backend" toml:"backend" default:"memory"`
+	Workers      int           `env:"JOBS_WORKERS" yaml:"workers" toml:"workers" default:"10"`
+	PollInterval time.Duration `env:"JOBS_POLL_INTERVAL" yaml:"poll_interval" toml:"poll_interval" default:"1s"`
+	Lease        time.Duration `env:"JOBS_LEASE" yaml:"lease" toml:"lease" default:"5m"`
+}
+
+// AdminConfig holds the settings for the admin server, which the CLI uses to
+// control a running application. It listens on the unix socket Socket, relative to
+// the root path, and on the tcp address Addr if set. Connections to Addr must be
+// authenticated, with Token or with client certificates signed by ClientCA, and
+// must use TLS unless Addr is a loopback address.
+// ClientCert and ClientKey are the certificate the CLI presents.
+type AdminConfig struct {
+	Enabled    bool   `env:"ADMIN_ENABLED" yaml:"enabled" toml:"enabled" default:"true"`
+	Socket     string `env:"ADMIN_SOCKET" yaml:"socket" toml:"socket" default:"tmp/admin.sock"`
+	Addr       string `env:"ADMIN_ADDR" yaml:"addr" toml:"addr"`
+	Token      string `env:"ADMIN_TOKEN" yaml:"token" toml:"token" secret:"true"`
+	TLSCert    string `env:"ADMIN_TLS_CERT" yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey     string `env:"ADMIN_TLS_KEY" yaml:"tls_key" toml:"tls_key"`
+	ClientCA   string `env:"ADMIN_TLS_CLIENT_CA" yaml:"tls_client_ca" toml:"tls_client_ca"`
+	ClientCert string `env:"ADMIN_CLIENT_CERT" yaml:"client_cert" toml:"client_cert"`
+	ClientKey  string `env:"ADMIN_CLIENT_KEY" yaml:"client_key" toml:"client_key" secret:"true"`
+}
+
+// TLSConfig holds the settings for serving https directly from ListenAndServe
+type TLSConfig struct {
+	CertFile      string   `env:"TLS_CERT" yaml:"cert" toml:"cert"`
+	KeyFile       string   `env:"TLS_KEY" yaml:"key" toml:"key"`
+	RedirectPort  string   `env:"TLS_REDIRECT_PORT" yaml:"redirect_port" toml:"redirect_port"`
+	ACME          bool     `env:"TLS_ACME" yaml:"acme" toml:"acme"`
+	ACMEDomains   []string `env:"TLS_ACME_DOMAINS" yaml:"acme_domains" toml:"acme_domains"`
+	ACMEEmail     string   `env:"TLS_ACME_EMAIL" yaml:"acme_email" toml:"acme_email"`
+	ACMEDirectory string   `env:"TLS_ACME_DIRECTORY" yaml:"acme_directory" toml:"acme_directory"`
+	ACMECache     string   `env:"TLS_ACME_CACHE" yaml:"acme_cache" toml:"acme_cache"`
+	ACMECARoot    string   `env:"TLS_ACME_CA_ROOT" yaml:"acme_ca_root" toml:"acme_ca_root"`
+}
+
+// redirectPort returns the port to listen on for plain http, if any. With ACME it
+// defaults to 80, since http-01 challenges are only ever sent there.
+func (t TLSConfig) redirectPort() string {
+	if t.RedirectPort == "" && t.ACME {
+		return "80"
+	}
+	return t.RedirectPort
+}
+
+// enabled reports whether we should terminate TLS ourselves
+func (t TLSConfig) enabled() bool {
+	return t.ACME || (t.CertFile != "" && t.KeyFile != "")
+}
+
+// S3Config holds the settings for the S3 file system
+type S3Config struct {
+	Key      string `env:"S3_KEY" yaml:"key" toml:"key"`
+	Secret   string `env:"S3_SECRET" yaml:"secret" toml:"secret" secret:"true" required:"true"`
+	Region   string `env:"S3_REGION" yaml:"region" toml:"region" required:"true"`
+	Endpoint string `env:"S3_ENDPOINT" yaml:"endpoint" toml:"endpoint"`
+	Bucket   string `env:"S3_BUCKET" yaml:"bucket" toml:"bucket" required:"true"`
+}
+
+func (s S3Config) enabled() bool {
+	return s.Key != ""
+}
+
+// MinioConfig holds the settings for the Minio file system
+type MinioConfig struct {
+	Endpoint string `env:"MINIO_ENDPOINT" yaml:"endpoint" toml:"endpoint" required:"true"`
+	Key      string `env:"MINIO_KEY" yaml:"key" toml:"key" required:"true"`
+	Secret   string `env:"MINIO_SECRET" yaml:"secret" toml:"secret" secret:"true"`
+	UseSSL   bool   `env:"MINIO_USESSL" yaml:"use_ssl" toml:"use_ssl"`
+	Region   string `env:"MINIO_REGION" yaml:"region" toml:"region"`
+	Bucket   string `env:"MINIO_BUCKET" yaml:"bucket" toml:"bucket" required:"true"`
+}
+
+func (m MinioConfig) enabled() bool {
+	return m.Secret != ""
+}
+
+// SFTPConfig holds the settings for the sftp file system
+type SFTPConfig struct {
+	Host string `env:"SFTP_HOST" yaml:"host" toml:"host"`
+	User string `env:"SFTP_USER" yaml:"user" toml:"user" required:"true"`
+	Pass string `env:"SFTP_PASS" yaml:"pass" toml:"pass" secret:"true"`
+	Port string `env:"SFTP_PORT" yaml:"port" toml:"port"`
+}
+
+func (s SFTPConfig) enabled() bool {
+	return s.Host != ""
+}
+
+// WebDAVConfig holds the settings for the WebDAV file system
+type WebDAVConfig struct {
+	Host string `env:"WEBDAV_HOST" yaml:"host" toml:"host"`
+	User string `env:"WEBDAV_USER" yaml:"user" toml:"user"`
+	Pass string `env:"WEBDAV_PASS" yaml:"pass" toml:"pass" secret:"true"`
+}
+
+func (w WebDAVConfig) enabled() bool {
+	return w.Host != ""
+}
+
+// ConfigErrors collects every problem found while loading or validating a Config,
+// so they can all be reported at once
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid configuration:\n\t" + strings.Join(msgs, "\n\t")
+}
+
+// ConfigSetting is one resolved configuration value, as printed by `boilme config check`
+type ConfigSetting struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+// redacted is shown in place of secret values
+const redacted = "********"
+
+// DefaultConfig returns a Config with every field set to its default value
+func DefaultConfig() Config {
+	var cfg Config
+	for _, f := range configFields(&cfg) {
+		if f.def != "" {
+			// defaults are part of the source, so this cannot fail at runtime
+			if err := setConfigValue(f.value, f.def); err != nil {
+				panic(fmt.Sprintf("bad default for %s: %s", f.names[0], err))
+			}
+		}
+	}
+	return cfg
+}
+
+// LoadConfig builds the configuration for the application in rootPath. Sources are
+// applied in this order, later ones overriding earlier ones:
+//
+//  1. the defaults in the Config struct tags
+//  2. config/boilme.yml (or .yaml, or .toml), if it exists
+//  3. config/boilme.<environment>.yml (or .yaml, or .toml), if it exists, where the
+//     environment comes from APP_ENV or the environment setting in the base file
+//  4. environment variables, including those read from .env (variables that are
+//     already set in the process are never overridden by .env); variables that are
+//     set but empty are ignored
+//
+// Every invalid value is collected, and returned together as ConfigErrors; the
+// partially resolved configuration is returned along with them.
+func LoadConfig(rootPath string) (Config, error) {
+	if _, err := os.Stat(rootPath + "/.env"); err == nil {
+		if err := godotenv.Load(rootPath + "/.env"); err != nil {
+			return Config{}, err
+		}
+	}
+
+	cfg := DefaultConfig()
+	var errs ConfigErrors
+
+	if err := cfg.loadFile(rootPath + "/config/boilme"); err != nil {
+		errs = append(errs, err)
+	}
+
+	env := os.Getenv("APP_ENV")
+	if env == "" {
+		env = cfg.Environment
+	}
+	if err := cfg.loadFile(rootPath + "/config/boilme." + env); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, cfg.loadEnv()...)
+
+	if err := cfg.Validate(); err != nil {
+		var invalid ConfigErrors
+		if errors.As(err, &invalid) {
+			errs = append(errs, invalid...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+
+	return cfg, nil
+}
+
+// loadFile decodes the first of base.yml, base.yaml or base.toml that exists over c
+func (c *Config) loadFile(base string) error {
+	for _, ext := range []string{".yml", ".yaml", ".toml"} {
+		path := base + ext
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if ext == ".toml" {
+			err = toml.Unmarshal(data, c)
+		} else {
+			err = yaml.Unmarshal(data, c)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// loadEnv sets every field whose environment variable (or an alias) is set. Empty
+// variables are skipped, as the generated .env lists many settings with no value,
+// which must not wipe out those from config files, nor the defaults.
+func (c *Config) loadEnv() ConfigErrors {
+	var errs ConfigErrors
+
+	for _, f := range configFields(c) {
+		for _, name := range f.names {
+			raw := os.Getenv(name)
+			if raw == "" {
+				continue
+			}
+			if err := setConfigValue(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			break
+		}
+	}
+
+	return errs
+}
+
+// Validate checks that required settings are present and that settings with a fixed
+// set of values hold one of them. It returns ConfigErrors listing every problem.
+func (c Config) Validate() error {
+	var errs ConfigErrors
+
+	for _, f := range configFields(&c) {
+		if f.required && f.sectionEnabled && f.value.IsZero() {
+			errs = append(errs, fmt.Errorf("%s is required", f.names[0]))
+		}
+	}
+
+	oneOf := func(name, value string, allowed ...string) {
+		for _, a := range allowed {
+			if strings.EqualFold(value, a) {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s must be one of %s; got %q", name, strings.Join(allowed, ", "), value))
+	}
+
+	oneOf("RENDERER", c.Renderer, "jet", "go")
+	oneOf("DATABASE_TYPE", c.Database.Type, "", "postgres", "postgresql", "pgx", "mysql", "mariadb", "sqlite", "sqlite3")
+	oneOf("SESSION_TYPE", c.Session.Type, "", "cookie", "redis", "mysql", "mariadb", "postgres", "postgresql")
+	oneOf("CACHE", c.Cache, "", "redis", "badger", "memory", "tiered")
+	oneOf("CACHE_SERIALIZER", c.CacheSerializer, "gob", "json", "msgpack")
+	oneOf("SMTP_ENCRYPTION", c.Mail.Encryption, "", "tls", "ssl", "none")
+	oneOf("MAILER_API", c.Mail.API, append([]string{""}, mailer.Transports()...)...)
+	oneOf("LOG_LEVEL", c.Log.Level, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.Log.Format, "text", "json")
+	oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "otlp", "stdout")
+	oneOf("MAINTENANCE_STORE", c.Maintenance.Store, "", "cache", "database", "file")
+	oneOf("JOBS_BACKEND", c.Jobs.Backend, "memory", "redis", "database")
+
+	if c.Key != "" && len(c.Key) != 32 {
+		errs = append(errs, fmt.Errorf("KEY must be exactly 32 characters long; got %d", len(c.Key)))
+	}
+
+	if (c.Cache == "redis" || c.Cache == "tiered" || c.Session.Type == "redis" || c.Jobs.Backend == "redis") && c.Redis.Host == "" {
+		errs = append(errs, errors.New("REDIS_HOST is required when CACHE is redis or tiered, or SESSION_TYPE or JOBS_BACKEND is redis"))
+	}
+
+	if c.Jobs.Backend == "database" && !c.Database.enabled() {
+		errs = append(errs, errors.New("DATABASE_TYPE is required when JOBS_BACKEND is database"))
+	}
+
+	if c.Mail.Workers < 1 || c.Mail.MaxAttempts < 1 {
+		errs = append(errs, errors.New("MAIL_WORKERS and MAIL_MAX_ATTEMPTS must be at least 1"))
+	}
+
+	dialect := NormalizeDialect(c.Database.Type)
+	if c.Database.enabled() && dialect != DialectSQLite {
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("DATABASE_HOST is required"))
+		}
+		if c.Database.User == "" {
+			errs = append(errs, errors.New("DATABASE_USER is required"))
+		}
+	}
+
+	switch c.Session.Type {
+	case "mysql", "mariadb", "postgres", "postgresql":
+		if c.Database.Type == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_TYPE is required when SESSION_TYPE is %s", c.Session.Type))
+		} else if NormalizeDialect(c.Session.Type) != dialect {
+			errs = append(errs, fmt.Errorf("SESSION_TYPE %s needs a %s database; DATABASE_TYPE is %s",
+				c.Session.Type, NormalizeDialect(c.Session.Type), c.Database.Type))
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT and TLS_KEY must be set together"))
+	}
+
+	if c.TLS.ACME && len(c.TLS.ACMEDomains) == 0 {
+		errs = append(errs, errors.New("TLS_ACME_DOMAINS is required when TLS_ACME is true"))
+	}
+
+	if (c.Admin.TLSCert == "") != (c.Admin.TLSKey == "") {
+		errs = append(errs, errors.New("ADMIN_TLS_CERT and ADMIN_TLS_KEY must be set together"))
+	}
+
+	if c.Admin.ClientCA != "" && c.Admin.TLSCert == "" {
+		errs = append(errs, errors.New("ADMIN_TLS_CLIENT_CA needs ADMIN_TLS_CERT and ADMIN_TLS_KEY"))
+	}
+
+	if c.Admin.Addr != "" && c.Admin.Token == "" && c.Admin.ClientCA == "" {
+		errs = append(errs, errors.New("ADMIN_ADDR needs ADMIN_TOKEN or ADMIN_TLS_CLIENT_CA, so the admin server is authenticated"))
+	}
+
+	if c.Admin.Addr != "" && c.Admin.TLSCert == "" && !isLoopbackAddr(c.Admin.Addr) {
+		errs = append(errs, fmt.Errorf("ADMIN_ADDR %s is not a loopback address, so it needs ADMIN_TLS_CERT and ADMIN_TLS_KEY", c.Admin.Addr))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Settings lists every configuration value by its environment variable name, with
+// secret values redacted
+func (c Config) Settings() []ConfigSetting {
+	var settings []ConfigSetting
+
+	for _, f := range configFields(&c) {
+		value := formatConfigValue(f.value)
+		if f.secret && value != "" {
+			value = redacted
+		}
+		settings = append(settings, ConfigSetting{
+			Name:   f.names[0],
+			Value:  value,
+			Secret: f.secret,
+		})
+	}
+
+	return settings
+}
+
+// configField describes one tagged field of a Config
+type configField struct {
+	names          []string
+	def            string
+	required       bool
+	secret         bool
+	sectionEnabled bool
+	value          reflect.Value
+}
+
+// configFields returns every field of c that has an env tag, descending into sections
+func configFields(c *Config) []configField {
+	return collectConfigFields(reflect.ValueOf(c).Elem(), true)
+}
+
+// enabler is implemented by sections that are only in use when some setting is made,
+// for example a file system that is only configured when its host is set
+type enabler interface {
+	enabled() bool
+}
+
+func collectConfigFields(v reflect.Value, enabled bool) []configField {
+	var fields []configField
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			sectionEnabled := enabled
+			if e, ok := fv.Interface().(enabler); ok {
+				sectionEnabled = enabled && e.enabled()
+			}
+			fields = append(fields, collectConfigFields(fv, sectionEnabled)...)
+			continue
+		}
+
+		env, ok := sf.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		fields = append(fields, configField{
+			names:          strings.Split(env, ","),
+			def:            sf.Tag.Get("default"),
+			required:       sf.Tag.Get("required") == "true",
+			secret:         sf.Tag.Get("secret") == "true",
+			sectionEnabled: enabled,
+			value:          fv,
+		})
+	}
+
+	return fields
+}
+
+// setConfigValue parses raw according to the kind of v, and stores it in v
+func setConfigValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		// a bare number is a count of seconds
+		if seconds, err := strconv.Atoi(raw); err == nil {
+			v.SetInt(int64(time.Duration(seconds) * time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		if raw == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		if raw == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+
+	return nil
+}
+
+// formatConfigValue is the inverse of setConfigValue
+func formatConfigValue(v reflect.Value) string {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	default:
+		return v.String()
+	}
+}
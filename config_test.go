@@ -0,0 +1,212 @@
+package boilme
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig_precedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		env         map[string]string
+		wantPort    string
+		wantWorkers int
+		wantName    string
+	}{
+		{
+			name:        "defaults",
+			wantPort:    "4000",
+			wantWorkers: 5,
+		},
+		{
+			name:        "yaml file over defaults",
+			files:       map[string]string{"config/boilme.yml": "app_name: filed\nport: \"5000\"\nmail:\n  workers: 2\n"},
+			wantPort:    "5000",
+			wantWorkers: 2,
+			wantName:    "filed",
+		},
+		{
+			name:        "toml file",
+			files:       map[string]string{"config/boilme.toml": "port = \"5001\"\n[mail]\nworkers = 3\n"},
+			wantPort:    "5001",
+			wantWorkers: 3,
+		},
+		{
+			name: "environment file over base file",
+			files: map[string]string{
+				"config/boilme.yml":            "app_name: filed\nport: \"5000\"\n",
+				"config/boilme.production.yml": "port: \"6000\"\n",
+			},
+			env:         map[string]string{"APP_ENV": "production"},
+			wantPort:    "6000",
+			wantWorkers: 5,
+			wantName:    "filed",
+		},
+		{
+			name:        "environment variables over files",
+			files:       map[string]string{"config/boilme.yml": "port: \"5000\"\nmail:\n  workers: 2\n"},
+			env:         map[string]string{"PORT": "7000", "MAIL_WORKERS": "9"},
+			wantPort:    "7000",
+			wantWorkers: 9,
+		},
+		{
+			name:        "empty environment variables are ignored",
+			files:       map[string]string{"config/boilme.yml": "port: \"5000\"\n"},
+			env:         map[string]string{"PORT": "", "MAIL_WORKERS": ""},
+			wantPort:    "5000",
+			wantWorkers: 5,
+		},
+		{
+			name: ".env over files",
+			files: map[string]string{
+				"config/boilme.yml": "port: \"5000\"\n",
+				".env":              "PORT=8000\nMAIL_WORKERS=\n",
+			},
+			wantPort:    "8000",
+			wantWorkers: 5,
+		},
+		{
+			name: "process environment over .env",
+			files: map[string]string{
+				".env": "PORT=8000\n",
+			},
+			env:         map[string]string{"PORT": "9000"},
+			wantPort:    "9000",
+			wantWorkers: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// start every case from a clean environment, restored afterwards
+			for _, name := range []string{"APP_ENV", "APP_NAME", "PORT", "MAIL_WORKERS"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			root := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg, err := LoadConfig(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Port != tt.wantPort {
+				t.Errorf("port is %q, want %q", cfg.Port, tt.wantPort)
+			}
+			if cfg.Mail.Workers != tt.wantWorkers {
+				t.Errorf("mail workers is %d, want %d", cfg.Mail.Workers, tt.wantWorkers)
+			}
+			if cfg.AppName != tt.wantName {
+				t.Errorf("app name is %q, want %q", cfg.AppName, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_invalidValues(t *testing.T) {
+	t.Setenv("MAIL_WORKERS", "many")
+	t.Setenv("CACHE", "memcached")
+
+	_, err := LoadConfig(t.TempDir())
+
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected both problems to be reported, got %v", errs)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"unknown renderer", func(c *Config) { c.Renderer = "pug" }, "RENDERER must be one of"},
+		{"short key", func(c *Config) { c.Key = "short" }, "KEY must be exactly 32 characters"},
+		{"redis cache without host", func(c *Config) { c.Cache = "redis" }, "REDIS_HOST is required"},
+		{"redis cache with host", func(c *Config) { c.Cache = "redis"; c.Redis.Host = "localhost:6379" }, ""},
+		{"memory cache", func(c *Config) { c.Cache = "memory" }, ""},
+		{"tiered cache without host", func(c *Config) { c.Cache = "tiered" }, "REDIS_HOST is required"},
+		{"cache values in msgpack", func(c *Config) { c.CacheSerializer = "msgpack" }, ""},
+		{"unknown cache serializer", func(c *Config) { c.CacheSerializer = "xml" }, "CACHE_SERIALIZER must be one of"},
+		{"database without host", func(c *Config) { c.Database.Type = "postgres"; c.Database.Name = "app" }, "DATABASE_HOST is required"},
+		{"database without name", func(c *Config) {
+			c.Database.Type = "postgres"
+			c.Database.Host = "localhost"
+			c.Database.User = "app"
+		}, "DATABASE_NAME is required"},
+		{"sqlite needs no host", func(c *Config) { c.Database.Type = "sqlite"; c.Database.Name = "app.db" }, ""},
+		{"session store of another database", func(c *Config) {
+			c.Database.Type = "sqlite"
+			c.Database.Name = "app.db"
+			c.Session.Type = "postgres"
+		}, "SESSION_TYPE postgres needs a postgres database"},
+		{"tls cert without key", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "TLS_CERT and TLS_KEY must be set together"},
+		{"acme without domains", func(c *Config) { c.TLS.ACME = true }, "TLS_ACME_DOMAINS is required"},
+		{"unknown mail api", func(c *Config) { c.Mail.API = "pigeon" }, "MAILER_API must be one of"},
+		{"mail sent with ses", func(c *Config) { c.Mail.API = "ses" }, ""},
+		{"mail captured to files", func(c *Config) { c.Mail.API = "file" }, ""},
+		{"no mail workers", func(c *Config) { c.Mail.Workers = 0 }, "MAIL_WORKERS and MAIL_MAX_ATTEMPTS must be at least 1"},
+		{"unauthenticated admin server", func(c *Config) { c.Admin.Addr = "127.0.0.1:4001" }, "ADMIN_ADDR needs ADMIN_TOKEN"},
+		{"loopback admin server without tls", func(c *Config) { c.Admin.Addr = "127.0.0.1:4001"; c.Admin.Token = "s3cret" }, ""},
+		{"public admin server without tls", func(c *Config) { c.Admin.Addr = "10.0.0.5:4001"; c.Admin.Token = "s3cret" }, "is not a loopback address"},
+		{"public admin server with tls", func(c *Config) {
+			c.Admin.Addr = "10.0.0.5:4001"
+			c.Admin.Token = "s3cret"
+			c.Admin.TLSCert = "admin.pem"
+			c.Admin.TLSKey = "admin.key"
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.change(&c)
+
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfig_Settings_redactsSecrets(t *testing.T) {
+	c := DefaultConfig()
+	c.Key = "abcdefghijklmnopqrstuvwxyz123456"
+
+	for _, s := range c.Settings() {
+		if s.Name == "KEY" && s.Value != redacted {
+			t.Errorf("KEY is shown as %q", s.Value)
+		}
+		if s.Name == "PORT" && s.Value != "4000" {
+			t.Errorf("PORT is shown as %q", s.Value)
+		}
+	}
+}
@@ -2,29 +2,114 @@ package boilme
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/XSAM/otelsql"
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
-// OpenDB opens a connection to a sql database. dbType must be one of postgres (or pgx).
-// TODO: add support for mysql/mariadb
-func (b *Boilme) OpenDB(dbType, dsn string) (*sql.DB, error) {
-	if dbType == "postgres" || dbType == "postgresql" {
-		dbType = "pgx"
+// The sql dialects Boilme supports. Database.Dialect always holds one of these
+// (or "" when there is no database), whichever DATABASE_TYPE alias was used.
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+	DialectSQLite   = "sqlite"
+)
+
+// maxConnectBackoff caps the wait between attempts to reach the database
+const maxConnectBackoff = 30 * time.Second
+
+// sqliteDriver is the database/sql driver used for sqlite. It is only registered
+// when Boilme is built with the sqlite tag, since the driver needs cgo.
+var sqliteDriver = ""
+
+// NormalizeDialect maps a DATABASE_TYPE value (postgres, postgresql, pgx, mysql,
+// mariadb, sqlite or sqlite3) to one of the Dialect constants. Unknown values are
+// returned lower cased and unchanged.
+func NormalizeDialect(dbType string) string {
+	switch dbType = strings.ToLower(dbType); dbType {
+	case "postgres", "postgresql", "pgx":
+		return DialectPostgres
+	case "mysql", "mariadb":
+		return DialectMySQL
+	case "sqlite", "sqlite3":
+		return DialectSQLite
+	default:
+		return dbType
+	}
+}
+
+// driverName returns the database/sql driver registered for dialect
+func driverName(dialect string) (string, error) {
+	switch dialect {
+	case DialectPostgres:
+		return "pgx", nil
+	case DialectMySQL:
+		return "mysql", nil
+	case DialectSQLite:
+		if sqliteDriver == "" {
+			return "", fmt.Errorf("sqlite support is not compiled in; build with -tags sqlite (requires cgo)")
+		}
+		return sqliteDriver, nil
+	default:
+		return "", fmt.Errorf("unsupported database type %q", dialect)
 	}
+}
 
-	db, err := sql.Open(dbType, dsn)
+// OpenDB opens a connection pool to a sql database. dbType may be any value accepted
+// by DATABASE_TYPE. The pool is sized from the DATABASE_MAX_* settings, and the
+// first connection is retried with exponential backoff, DATABASE_CONNECT_RETRIES
+// times, so the application can start before its database is ready.
+func (b *Boilme) OpenDB(dbType, dsn string) (*sql.DB, error) {
+	dialect := NormalizeDialect(dbType)
+	driver, err := driverName(dialect)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
+	var db *sql.DB
+	if b.tracing() {
+		// record a span for every query
+		db, err = otelsql.Open(driver, dsn,
+			otelsql.WithTracerProvider(b.tracerProvider),
+			otelsql.WithAttributes(dbAttributes(dialect, b.Config.Database.Name)...),
+		)
+	} else {
+		db, err = sql.Open(driver, dsn)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return db, nil
+	cfg := b.Config.Database
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	backoff := cfg.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= cfg.ConnectRetries {
+			break
+		}
+
+		if b.Logger != nil {
+			b.Logger.Warn("could not connect to database, retrying",
+				"attempt", attempt+1, "retry_in", backoff, "error", err)
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
 
+	_ = db.Close()
+	return nil, fmt.Errorf("could not connect to database after %d attempts: %w", cfg.ConnectRetries+1, err)
 }
@@ -0,0 +1,12 @@
+//go:build sqlite
+
+package boilme
+
+import (
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sqliteDriver = "sqlite3"
+}
@@ -0,0 +1,172 @@
+package boilme
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDialect(t *testing.T) {
+	tests := []struct {
+		dbType string
+		want   string
+	}{
+		{"postgres", DialectPostgres},
+		{"postgresql", DialectPostgres},
+		{"pgx", DialectPostgres},
+		{"Postgres", DialectPostgres},
+		{"mysql", DialectMySQL},
+		{"mariadb", DialectMySQL},
+		{"MariaDB", DialectMySQL},
+		{"sqlite", DialectSQLite},
+		{"sqlite3", DialectSQLite},
+		{"Oracle", "oracle"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeDialect(tt.dbType); got != tt.want {
+			t.Errorf("NormalizeDialect(%q) = %q, want %q", tt.dbType, got, tt.want)
+		}
+	}
+}
+
+func TestBoilme_BuildDSN(t *testing.T) {
+	root := t.TempDir()
+
+	tests := []struct {
+		name string
+		db   DatabaseConfig
+		want string
+	}{
+		{
+			"postgres",
+			DatabaseConfig{Type: "postgresql", Host: "db", Port: "5432", User: "boilme", Name: "app", SSLMode: "disable"},
+			"host=db port=5432 user=boilme dbname=app sslmode=disable timezone=UTC connect_timeout=5",
+		},
+		{
+			"postgres with a password",
+			DatabaseConfig{Type: "pgx", Host: "db", Port: "5432", User: "boilme", Pass: "secret", Name: "app", SSLMode: "require"},
+			"host=db port=5432 user=boilme dbname=app sslmode=require timezone=UTC connect_timeout=5 password=secret",
+		},
+		{
+			"mysql on the default port",
+			DatabaseConfig{Type: "mariadb", Host: "db", User: "boilme", Pass: "secret", Name: "app"},
+			"boilme:secret@tcp(db:3306)/app?collation=utf8_unicode_ci&timeout=5s&parseTime=true&readTimeout=5s",
+		},
+		{
+			"mysql with tls disabled",
+			DatabaseConfig{Type: "mysql", Host: "db", Port: "3307", User: "boilme", Name: "app", SSLMode: "disable"},
+			"boilme:@tcp(db:3307)/app?collation=utf8_unicode_ci&timeout=5s&parseTime=true&readTimeout=5s&tls=false",
+		},
+		{
+			"mysql with tls",
+			DatabaseConfig{Type: "mysql", Host: "db", Port: "3306", User: "boilme", Name: "app", SSLMode: "skip-verify"},
+			"boilme:@tcp(db:3306)/app?collation=utf8_unicode_ci&timeout=5s&parseTime=true&readTimeout=5s&tls=skip-verify",
+		},
+		{
+			"sqlite relative to the root",
+			DatabaseConfig{Type: "sqlite3", Name: "data/app.db"},
+			"file:" + filepath.Join(root, "data", "app.db") + "?_foreign_keys=on&_busy_timeout=5000",
+		},
+		{
+			"sqlite at an absolute path",
+			DatabaseConfig{Type: "sqlite", Name: "/var/lib/app.db"},
+			"file:/var/lib/app.db?_foreign_keys=on&_busy_timeout=5000",
+		},
+		{"unknown", DatabaseConfig{Type: "oracle", Host: "db"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Boilme{RootPath: root}
+			b.Config.Database = tt.db
+			if got := b.BuildDSN(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// closedPort returns the host and port of an address that nothing listens on
+func closedPort(t *testing.T) (string, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	_ = l.Close()
+	return host, port
+}
+
+func TestBoilme_OpenDB_unsupported(t *testing.T) {
+	b := &Boilme{}
+	if _, err := b.OpenDB("oracle", ""); err == nil || !strings.Contains(err.Error(), `unsupported database type "oracle"`) {
+		t.Errorf("got %v, want an unsupported database type", err)
+	}
+	if sqliteDriver == "" {
+		if _, err := b.OpenDB("sqlite3", b.BuildDSN()); err == nil || !strings.Contains(err.Error(), "-tags sqlite") {
+			t.Errorf("got %v without sqlite compiled in, want to be told to build with it", err)
+		}
+	}
+}
+
+func TestBoilme_OpenDB_retries(t *testing.T) {
+	host, port := closedPort(t)
+
+	var logs bytes.Buffer
+	b := &Boilme{Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	b.Config.Database = DatabaseConfig{
+		Type:           "postgres",
+		Host:           host,
+		Port:           port,
+		User:           "boilme",
+		Name:           "app",
+		SSLMode:        "disable",
+		ConnectRetries: 2,
+		ConnectBackoff: 20 * time.Millisecond,
+	}
+
+	start := time.Now()
+	db, err := b.OpenDB(b.Config.Database.Type, b.BuildDSN())
+	if err == nil {
+		_ = db.Close()
+		t.Fatal("connected to nothing")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("got %q, want it to count 3 attempts", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("got %q, want the connection error wrapped", err)
+	}
+
+	// the backoff doubles between attempts
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("gave up after %s, want at least 20ms and then 40ms of backoff", elapsed)
+	}
+	if got := strings.Count(logs.String(), "retrying"); got != 2 {
+		t.Errorf("logged %d retries, want 2:\n%s", got, logs.String())
+	}
+	if !strings.Contains(logs.String(), "retry_in=20ms") || !strings.Contains(logs.String(), "retry_in=40ms") {
+		t.Errorf("got %s, want retries in 20ms and 40ms", logs.String())
+	}
+}
+
+func TestBoilme_OpenDB_noRetries(t *testing.T) {
+	host, port := closedPort(t)
+
+	// with no retries, the backoff is never waited for
+	b := &Boilme{}
+	b.Config.Database = DatabaseConfig{Type: "mysql", Host: host, Port: port, User: "boilme", Name: "app", ConnectBackoff: time.Hour}
+	if _, err := b.OpenDB(b.Config.Database.Type, b.BuildDSN()); err == nil || !strings.Contains(err.Error(), "after 1 attempts") {
+		t.Errorf("got %v, want a single attempt", err)
+	}
+}
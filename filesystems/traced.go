@@ -0,0 +1,89 @@
+package filesystems
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedFS wraps an FS, and records an OpenTelemetry span for every operation.
+// The FS interface carries no context, so spans have no parent unless the file
+// system is bound to a request's context with WithContext.
+type TracedFS struct {
+	fs     FS
+	tracer trace.Tracer
+	name   string
+	ctx    context.Context
+}
+
+var _ FS = (*TracedFS)(nil)
+
+// NewTracedFS returns fs, traced with tracer. name identifies the file system in
+// spans, for example S3 or SFTP.
+func NewTracedFS(fs FS, tracer trace.Tracer, name string) *TracedFS {
+	return &TracedFS{
+		fs:     fs,
+		tracer: tracer,
+		name:   name,
+		ctx:    context.Background(),
+	}
+}
+
+// WithContext returns a copy of t whose spans are children of the span in ctx
+func (t *TracedFS) WithContext(ctx context.Context) *TracedFS {
+	t2 := *t
+	t2.ctx = ctx
+	return &t2
+}
+
+func (t *TracedFS) start(op string, attrs ...attribute.KeyValue) trace.Span {
+	attrs = append(attrs, attribute.String("fs.name", t.name))
+	_, span := t.tracer.Start(t.ctx, "fs."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *TracedFS) Put(fileName, folder string) error {
+	span := t.start("put", attribute.String("fs.file", fileName), attribute.String("fs.folder", folder))
+	err := t.fs.Put(fileName, folder)
+	end(span, err)
+	return err
+}
+
+func (t *TracedFS) Get(destination string, items ...string) error {
+	span := t.start("get", attribute.String("fs.destination", destination), attribute.String("fs.items", strings.Join(items, ",")))
+	err := t.fs.Get(destination, items...)
+	end(span, err)
+	return err
+}
+
+func (t *TracedFS) List(prefix string) ([]Listing, error) {
+	span := t.start("list", attribute.String("fs.prefix", prefix))
+	listing, err := t.fs.List(prefix)
+	span.SetAttributes(attribute.Int("fs.count", len(listing)))
+	end(span, err)
+	return listing, err
+}
+
+func (t *TracedFS) Delete(itemsToDelete []string) bool {
+	span := t.start("delete", attribute.String("fs.items", strings.Join(itemsToDelete, ",")))
+	ok := t.fs.Delete(itemsToDelete)
+	if !ok {
+		span.SetStatus(codes.Error, "delete failed")
+	}
+	span.End()
+	return ok
+}
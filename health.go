@@ -0,0 +1,286 @@
+package boilme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/filesystems"
+	"github.com/bxtal-lsn/go-boilme/filesystems/sftpfilesystem"
+	"github.com/bxtal-lsn/go-boilme/filesystems/webdavfilesystem"
+	"github.com/gomodule/redigo/redis"
+)
+
+// The paths answered by the health endpoints, ahead of sessions, csrf checks and
+// maintenance mode
+const (
+	LivenessPath  = "/livez"
+	HealthPath    = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// HealthCheck reports whether one component of the application is working. It
+// should give up when ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// ComponentHealth is the result of one HealthCheck
+type ComponentHealth struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the body returned by the health endpoints
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Reason     string                     `json:"reason,omitempty"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+const (
+	healthOK   = "ok"
+	healthFail = "fail"
+)
+
+// healthChecks holds the registered checks, by name
+type healthChecks struct {
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// AddHealthCheck registers check under name, to be run by /healthz and /readyz. A
+// check registered under an existing name replaces it; the built in checks are
+// named database, cache, mail and filesystem:<name>.
+func (b *Boilme) AddHealthCheck(name string, check HealthCheck) {
+	b.health.mu.Lock()
+	defer b.health.mu.Unlock()
+
+	if b.health.checks == nil {
+		b.health.checks = make(map[string]HealthCheck)
+	}
+	b.health.checks[name] = check
+}
+
+// registerHealthChecks adds a check for every subsystem the application has set up
+func (b *Boilme) registerHealthChecks() {
+	if b.DB.Pool != nil {
+		b.AddHealthCheck("database", func(ctx context.Context) error {
+			return b.DB.Pool.PingContext(ctx)
+		})
+	}
+
+	if b.Cache != nil {
+		b.AddHealthCheck("cache", b.pingCache)
+	}
+
+	if b.Mail.Host != "" || (b.Mail.API != "" && b.Mail.API != "smtp") {
+		b.AddHealthCheck("mail", b.Mail.Ping)
+	}
+
+	for name, v := range b.FileSystems {
+		fs, ok := asFS(v)
+		if !ok {
+			continue
+		}
+		probe := fsHealthProbe(fs)
+		b.AddHealthCheck("filesystem:"+name, func(ctx context.Context) error {
+			_, err := fs.List(probe)
+			return err
+		})
+	}
+}
+
+// fsHealthProbe returns the path to list to check that fs can be reached and
+// authenticated to, without walking everything in it. Object stores list a prefix
+// that does not exist without complaint, but sftp and webdav servers fail to read a
+// missing directory, so the directory they start in is listed instead.
+func fsHealthProbe(fs filesystems.FS) string {
+	switch fs.(type) {
+	case *sftpfilesystem.SFTP:
+		return "."
+	case *webdavfilesystem.WebDAV:
+		return "/"
+	default:
+		return ".boilme-health"
+	}
+}
+
+// pingCache checks the redis pool or badger database behind the cache
+func (b *Boilme) pingCache(ctx context.Context) error {
+	switch c := cache.Unwrap(b.Cache).(type) {
+	case *cache.RedisCache:
+		return pingRedis(ctx, c.Conn)
+	case *cache.TieredCache:
+		return pingRedis(ctx, c.Remote.Conn)
+	case *cache.BadgerCache:
+		if c.Conn.IsClosed() {
+			return errors.New("badger database is closed")
+		}
+		return nil
+	default:
+		_, err := b.Cache.Has("boilme:health")
+		return err
+	}
+}
+
+// pingRedis sends a PING over a connection of pool
+func pingRedis(ctx context.Context, pool *redis.Pool) error {
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PING")
+	return err
+}
+
+// asFS returns v as a filesystems.FS. The file systems created from the
+// configuration are stored by value, but implement FS on their pointer type.
+func asFS(v interface{}) (filesystems.FS, bool) {
+	if v == nil {
+		return nil, false
+	}
+	if fs, ok := v.(filesystems.FS); ok {
+		return fs, true
+	}
+
+	p := reflect.New(reflect.TypeOf(v))
+	p.Elem().Set(reflect.ValueOf(v))
+	fs, ok := p.Interface().(filesystems.FS)
+	return fs, ok
+}
+
+// CheckHealth runs every registered check concurrently, each bounded by
+// HEALTH_CHECK_TIMEOUT, and reports the result of each
+func (b *Boilme) CheckHealth(ctx context.Context) HealthReport {
+	b.health.mu.RLock()
+	checks := make(map[string]HealthCheck, len(b.health.checks))
+	for name, check := range b.health.checks {
+		checks[name] = check
+	}
+	b.health.mu.RUnlock()
+
+	report := HealthReport{
+		Status:     healthOK,
+		Components: make(map[string]ComponentHealth, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+
+			result := runHealthCheck(ctx, b.Config.HealthTimeout, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = result
+			if result.Status != healthOK {
+				report.Status = healthFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// runHealthCheck runs check, and gives up on it after timeout even if it ignores ctx
+func runHealthCheck(ctx context.Context, timeout time.Duration, check HealthCheck) ComponentHealth {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("health check panicked: %v", r)
+			}
+		}()
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := ComponentHealth{
+		Status:    healthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = healthFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// HealthEndpoints is middleware that answers the liveness, health and readiness
+// endpoints itself, and passes every other request on. It is installed by routes,
+// ahead of sessions and maintenance mode, so orchestrators can always reach it.
+//
+// /livez only reports that the process is serving requests. /healthz runs every
+// health check. /readyz does the same, but also fails while in maintenance mode or
+// once shutdown has started, so load balancers stop sending traffic.
+func (b *Boilme) HealthEndpoints(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case LivenessPath:
+			writeHealth(w, HealthReport{Status: healthOK})
+		case HealthPath:
+			writeHealth(w, b.CheckHealth(r.Context()))
+		case ReadinessPath:
+			var report HealthReport
+			switch {
+			case b.ShuttingDown():
+				report = HealthReport{Status: healthFail, Reason: "shutting down"}
+			case b.InMaintenance(r.Context()):
+				report = HealthReport{Status: healthFail, Reason: "maintenance mode"}
+			default:
+				report = b.CheckHealth(r.Context())
+			}
+			writeHealth(w, report)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func writeHealth(w http.ResponseWriter, report HealthReport) {
+	status := http.StatusOK
+	if report.Status != healthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	out, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,265 @@
+package boilme
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/filesystems/miniofilesystem"
+	"github.com/bxtal-lsn/go-boilme/filesystems/s3filesystem"
+	"github.com/bxtal-lsn/go-boilme/filesystems/sftpfilesystem"
+	"github.com/bxtal-lsn/go-boilme/filesystems/webdavfilesystem"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
+)
+
+// newObjectStore starts a fake s3 server with a single, empty bucket, which answers
+// both versions of ListObjects
+func newObjectStore(t *testing.T, bucket string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if strings.Trim(r.URL.Path, "/") != bucket {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>`)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>%s</Prefix><KeyCount>0</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>`,
+			bucket, r.URL.Query().Get("prefix"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newWebDAVServer starts a webdav server holding nothing, behind basic auth
+func newWebDAVServer(t *testing.T, user, pass string) *httptest.Server {
+	t.Helper()
+	h := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newSFTPServer starts an ssh server, accepting only user and pass, which serves the
+// sftp subsystem from the working directory
+func newSFTPServer(t *testing.T, user, pass string) (host, port string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(p) == pass {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for ch := range chans {
+		if ch.ChannelType() != "session" {
+			_ = ch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// the payload is the length prefixed name of the subsystem
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+			}
+		}()
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		_ = server.Serve()
+		_ = server.Close()
+	}
+}
+
+// the file systems are stored by value, as setup does, to check that asFS finds them
+func TestBoilme_CheckHealth_filesystems(t *testing.T) {
+	// a bucket name that is not a valid host name makes the aws sdk use path style
+	// requests, which the fake object store expects
+	s3srv := newObjectStore(t, "Health_Bucket")
+	miniosrv := newObjectStore(t, "health-bucket")
+	davsrv := newWebDAVServer(t, "dav", "secret")
+	sftpHost, sftpPort := newSFTPServer(t, "ftp", "secret")
+
+	tests := []struct {
+		name string
+		fs   interface{}
+		want string
+	}{
+		{"s3", s3filesystem.S3{Key: "key", Secret: "secret", Region: "us-east-1", Endpoint: s3srv.URL, Bucket: "Health_Bucket"}, healthOK},
+		{"s3 missing bucket", s3filesystem.S3{Key: "key", Secret: "secret", Region: "us-east-1", Endpoint: s3srv.URL, Bucket: "Other_Bucket"}, healthFail},
+		{"minio", miniofilesystem.Minio{Endpoint: strings.TrimPrefix(miniosrv.URL, "http://"), Key: "key", Secret: "secret", Region: "us-east-1", Bucket: "health-bucket"}, healthOK},
+		{"minio missing bucket", miniofilesystem.Minio{Endpoint: strings.TrimPrefix(miniosrv.URL, "http://"), Key: "key", Secret: "secret", Region: "us-east-1", Bucket: "other-bucket"}, healthFail},
+		{"webdav", webdavfilesystem.WebDAV{Host: davsrv.URL, User: "dav", Pass: "secret"}, healthOK},
+		{"webdav wrong password", webdavfilesystem.WebDAV{Host: davsrv.URL, User: "dav", Pass: "wrong"}, healthFail},
+		{"sftp", sftpfilesystem.SFTP{Host: sftpHost, Port: sftpPort, User: "ftp", Pass: "secret"}, healthOK},
+		{"sftp wrong password", sftpfilesystem.SFTP{Host: sftpHost, Port: sftpPort, User: "ftp", Pass: "wrong"}, healthFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Boilme{FileSystems: map[string]interface{}{"files": tt.fs}}
+			b.Config.HealthTimeout = 5 * time.Second
+			b.registerHealthChecks()
+
+			report := b.CheckHealth(context.Background())
+			got, ok := report.Components["filesystem:files"]
+			if !ok {
+				t.Fatalf("no check was registered: %+v", report)
+			}
+			if got.Status != tt.want {
+				t.Errorf("status is %s, want %s (error %q)", got.Status, tt.want, got.Error)
+			}
+			if report.Status != tt.want {
+				t.Errorf("overall status is %s, want %s", report.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoilme_HealthEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		check      HealthCheck
+		shutdown   bool
+		inWindow   bool
+		wantStatus int
+		wantReason string
+		wantChecks bool
+	}{
+		{name: "liveness", path: LivenessPath, check: failingCheck, wantStatus: http.StatusOK},
+		{name: "health", path: HealthPath, check: passingCheck, wantStatus: http.StatusOK, wantChecks: true},
+		{name: "failing health", path: HealthPath, check: failingCheck, wantStatus: http.StatusServiceUnavailable, wantChecks: true},
+		{name: "health check that ignores its context", path: HealthPath, check: hangingCheck, wantStatus: http.StatusServiceUnavailable, wantChecks: true},
+		{name: "health check that panics", path: HealthPath, check: panickingCheck, wantStatus: http.StatusServiceUnavailable, wantChecks: true},
+		{name: "ready", path: ReadinessPath, check: passingCheck, wantStatus: http.StatusOK, wantChecks: true},
+		{name: "not ready while shutting down", path: ReadinessPath, check: passingCheck, shutdown: true, wantStatus: http.StatusServiceUnavailable, wantReason: "shutting down"},
+		{name: "not ready in maintenance", path: ReadinessPath, check: passingCheck, inWindow: true, wantStatus: http.StatusServiceUnavailable, wantReason: "maintenance mode"},
+		{name: "healthy in maintenance", path: HealthPath, check: passingCheck, inWindow: true, wantStatus: http.StatusOK, wantChecks: true},
+		{name: "other paths are passed on", path: "/users", check: failingCheck, wantStatus: http.StatusTeapot},
+		{name: "other methods are passed on", method: http.MethodPost, path: HealthPath, check: failingCheck, wantStatus: http.StatusTeapot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := FileMaintenanceStore{Path: filepath.Join(t.TempDir(), "maintenance.json")}
+			if err := store.Save(context.Background(), MaintenanceState{Enabled: tt.inWindow}); err != nil {
+				t.Fatal(err)
+			}
+
+			b := &Boilme{maintenance: &maintenance{store: store}}
+			b.Config.HealthTimeout = 50 * time.Millisecond
+			b.AddHealthCheck("component", tt.check)
+			if tt.shutdown {
+				b.shuttingDown.Store(true)
+			}
+
+			h := b.HealthEndpoints(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(method, tt.path, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status is %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if rr.Code == http.StatusTeapot {
+				return
+			}
+
+			var report HealthReport
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Reason != tt.wantReason {
+				t.Errorf("reason is %q, want %q", report.Reason, tt.wantReason)
+			}
+			if _, ran := report.Components["component"]; ran != tt.wantChecks {
+				t.Errorf("checks ran: %v, want %v", ran, tt.wantChecks)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control is %q", got)
+			}
+		})
+	}
+}
+
+func passingCheck(ctx context.Context) error { return nil }
+
+func failingCheck(ctx context.Context) error { return errors.New("down") }
+
+func hangingCheck(ctx context.Context) error {
+	time.Sleep(time.Second)
+	return nil
+}
+
+func panickingCheck(ctx context.Context) error { panic("oops") }
@@ -0,0 +1,464 @@
+// Package jobs runs background jobs from a persistent queue. Jobs are handled by
+// functions registered by name, and are retried with exponential backoff when they
+// fail, until they run out of attempts and are moved to dead-letter storage.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDuplicate is returned by Enqueue when a job with the same unique key is
+	// already waiting or running
+	ErrDuplicate = errors.New("jobs: a job with this unique key is already queued")
+
+	// ErrNotFound is returned when there is no job with the given id
+	ErrNotFound = errors.New("jobs: job not found")
+)
+
+// Job is a unit of work in a queue. Name selects the handler that runs it, and
+// Payload is its json encoded argument.
+type Job struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FailedAt    time.Time       `json:"failed_at,omitempty"`
+}
+
+// Decode unmarshals the payload of the job into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job. ctx is cancelled when the job times out, or when the workers
+// are stopped and the job has not finished in time. A returned error fails this
+// attempt; wrap it with Permanent to give up without retrying.
+type Handler func(ctx context.Context, job *Job) error
+
+// Queue stores jobs for the workers. Implementations must be safe for concurrent use,
+// by any number of processes sharing the same storage.
+type Queue interface {
+	// Enqueue adds job to the queue. It returns ErrDuplicate if job has a unique key,
+	// and another job with that key is waiting or running.
+	Enqueue(ctx context.Context, job *Job) error
+
+	// Reserve claims the job that has been due the longest, of those named in names.
+	// The job's attempts are incremented, and it is hidden from other workers for
+	// lease; if it is neither completed, retried nor failed by then, it is run again.
+	// Reserve returns nil and no error when no job is due.
+	Reserve(ctx context.Context, names []string, lease time.Duration) (*Job, error)
+
+	// Complete removes a job that has run successfully
+	Complete(ctx context.Context, job *Job) error
+
+	// Retry returns a job to the queue, to run again at job.RunAt
+	Retry(ctx context.Context, job *Job) error
+
+	// Fail moves a job that has run out of attempts to dead-letter storage
+	Fail(ctx context.Context, job *Job) error
+
+	// Dead lists up to limit jobs in dead-letter storage, most recently failed first
+	Dead(ctx context.Context, limit int) ([]*Job, error)
+
+	// Requeue moves the job with id from dead-letter storage back to the queue, to
+	// run now with a fresh set of attempts
+	Requeue(ctx context.Context, id string) error
+}
+
+// permanentError is an error that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job fails immediately, without using its remaining
+// attempts
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// handler is a registered Handler, with its options
+type handler struct {
+	fn          Handler
+	maxAttempts int
+	concurrency int
+	timeout     time.Duration
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	running int
+}
+
+// HandlerOption configures a handler registered with Register
+type HandlerOption func(*handler)
+
+// WithMaxAttempts sets how many times a job is tried before it is moved to
+// dead-letter storage. The default is 3. Jobs enqueued with Attempts override it.
+func WithMaxAttempts(n int) HandlerOption {
+	return func(h *handler) { h.maxAttempts = n }
+}
+
+// WithConcurrency limits how many of the handler's jobs run at once in this process.
+// By default, only the total number of workers is limited.
+func WithConcurrency(n int) HandlerOption {
+	return func(h *handler) { h.concurrency = n }
+}
+
+// WithTimeout limits how long each run of a job may take. The default is the lease
+// of the workers; a timeout longer than the lease lets the job run twice at once.
+func WithTimeout(d time.Duration) HandlerOption {
+	return func(h *handler) { h.timeout = d }
+}
+
+// WithBackoff sets the wait before the first retry, which doubles after each
+// attempt up to max. The default is 1 second, up to 1 hour.
+func WithBackoff(base, max time.Duration) HandlerOption {
+	return func(h *handler) {
+		h.backoff = base
+		h.maxBackoff = max
+	}
+}
+
+// EnqueueOption configures a job added with Enqueue
+type EnqueueOption func(*Job)
+
+// Delay runs the job after d, rather than straight away
+func Delay(d time.Duration) EnqueueOption {
+	return func(j *Job) { j.RunAt = time.Now().Add(d) }
+}
+
+// At runs the job at t, rather than straight away
+func At(t time.Time) EnqueueOption {
+	return func(j *Job) { j.RunAt = t }
+}
+
+// Unique gives the job a unique key. While a job with the same key is waiting or
+// running, Enqueue returns ErrDuplicate.
+func Unique(key string) EnqueueOption {
+	return func(j *Job) { j.UniqueKey = key }
+}
+
+// Attempts overrides how many times the job is tried
+func Attempts(n int) EnqueueOption {
+	return func(j *Job) { j.MaxAttempts = n }
+}
+
+// Jobs enqueues jobs, and runs them with the handlers registered for their names.
+// Create it with New, register handlers, and call Start to run workers. A process
+// that only enqueues jobs need not start workers.
+type Jobs struct {
+	// Queue stores the jobs
+	Queue Queue
+
+	// Workers is the number of jobs run at once, across all handlers
+	Workers int
+
+	// PollInterval is how long idle workers wait before checking the queue again
+	PollInterval time.Duration
+
+	// Lease is how long a job is hidden from other workers once reserved
+	Lease time.Duration
+
+	// Logger reports failed jobs; nil means slog.Default()
+	Logger *slog.Logger
+
+	// Observe is called after every run of a job, typically to record metrics
+	Observe func(job *Job, err error, elapsed time.Duration)
+
+	mu       sync.Mutex
+	handlers map[string]*handler
+	names    []string
+	next     int
+	cancel   context.CancelFunc
+	abort    context.CancelFunc
+	loopDone chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New returns Jobs using queue, with 10 workers that poll every second, and a lease
+// of 5 minutes
+func New(queue Queue) *Jobs {
+	return &Jobs{
+		Queue:        queue,
+		Workers:      10,
+		PollInterval: time.Second,
+		Lease:        5 * time.Minute,
+		handlers:     make(map[string]*handler),
+	}
+}
+
+// Register sets fn as the handler of jobs called name. It must be called before Start.
+func (j *Jobs) Register(name string, fn Handler, opts ...HandlerOption) {
+	h := &handler{
+		fn:          fn,
+		maxAttempts: 3,
+		backoff:     time.Second,
+		maxBackoff:  time.Hour,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.handlers == nil {
+		j.handlers = make(map[string]*handler)
+	}
+	if _, ok := j.handlers[name]; !ok {
+		j.names = append(j.names, name)
+	}
+	j.handlers[name] = h
+}
+
+// Enqueue adds a job called name to the queue, with payload encoded as json. By
+// default it runs as soon as a worker is free.
+func (j *Jobs) Enqueue(ctx context.Context, name string, payload interface{}, opts ...EnqueueOption) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("jobs: encoding payload of %s: %w", name, err)
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        NewID(),
+		Name:      name,
+		Payload:   data,
+		RunAt:     now,
+		CreatedAt: now,
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	if err := j.Queue.Enqueue(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// NewID returns a random job id
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Start runs workers in the background, until Stop is called
+func (j *Jobs) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runCtx, abort := context.WithCancel(context.Background())
+	j.cancel, j.abort = cancel, abort
+	j.loopDone = make(chan struct{})
+
+	go j.loop(ctx, runCtx)
+}
+
+// Stop stops reserving jobs, and waits for running jobs to finish. If ctx is done
+// first, running jobs are cancelled, and are run again once their lease expires.
+func (j *Jobs) Stop(ctx context.Context) error {
+	j.mu.Lock()
+	cancel, abort, loopDone := j.cancel, j.abort, j.loopDone
+	j.cancel = nil
+	j.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	<-loopDone
+
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		abort()
+		return nil
+	case <-ctx.Done():
+		abort()
+		return ctx.Err()
+	}
+}
+
+// loop reserves jobs while there are free workers, and runs each one in its own
+// goroutine
+func (j *Jobs) loop(ctx, runCtx context.Context) {
+	defer close(j.loopDone)
+
+	workers := make(chan struct{}, max(j.Workers, 1))
+	for {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		job, h, err := j.reserve(ctx)
+		if job == nil {
+			<-workers
+			if err != nil && ctx.Err() == nil {
+				j.logger().Error("could not reserve job", "error", err)
+			}
+
+			select {
+			case <-time.After(j.PollInterval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		j.wg.Add(1)
+		go func() {
+			defer func() {
+				j.mu.Lock()
+				h.running--
+				j.mu.Unlock()
+				<-workers
+				j.wg.Done()
+			}()
+			j.run(runCtx, job, h)
+		}()
+	}
+}
+
+// reserve claims a job for one of the handlers that is below its concurrency limit.
+// Handlers take turns at going first, so a busy one cannot starve the others.
+func (j *Jobs) reserve(ctx context.Context) (*Job, *handler, error) {
+	j.mu.Lock()
+	var names []string
+	for i := range j.names {
+		name := j.names[(j.next+i)%len(j.names)]
+		h := j.handlers[name]
+		if h.concurrency <= 0 || h.running < h.concurrency {
+			names = append(names, name)
+		}
+	}
+	if len(j.names) > 0 {
+		j.next = (j.next + 1) % len(j.names)
+	}
+	j.mu.Unlock()
+
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+
+	job, err := j.Queue.Reserve(ctx, names, j.Lease)
+	if err != nil || job == nil {
+		return nil, nil, err
+	}
+
+	j.mu.Lock()
+	h := j.handlers[job.Name]
+	h.running++
+	j.mu.Unlock()
+
+	return job, h, nil
+}
+
+// run runs job, and then completes, retries or fails it
+func (j *Jobs) run(ctx context.Context, job *Job, h *handler) {
+	timeout := h.timeout
+	if timeout <= 0 {
+		timeout = j.Lease
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := call(runCtx, h.fn, job)
+	if j.Observe != nil {
+		j.Observe(job, err, time.Since(start))
+	}
+
+	// settle the job even if we are being stopped
+	ctx = context.WithoutCancel(ctx)
+
+	if err == nil {
+		if err := j.Queue.Complete(ctx, job); err != nil {
+			j.logger().Error("could not complete job", "job", job.Name, "id", job.ID, "error", err)
+		}
+		return
+	}
+
+	job.LastError = err.Error()
+	maxAttempts := job.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = h.maxAttempts
+	}
+
+	var permanent permanentError
+	if job.Attempts >= maxAttempts || errors.As(err, &permanent) {
+		job.FailedAt = time.Now()
+		j.logger().Error("job failed", "job", job.Name, "id", job.ID, "attempts", job.Attempts, "error", err)
+		if err := j.Queue.Fail(ctx, job); err != nil {
+			j.logger().Error("could not move job to dead-letter storage", "job", job.Name, "id", job.ID, "error", err)
+		}
+		return
+	}
+
+	job.RunAt = time.Now().Add(backoff(h.backoff, h.maxBackoff, job.Attempts))
+	j.logger().Warn("job failed, retrying", "job", job.Name, "id", job.ID,
+		"attempts", job.Attempts, "retry_at", job.RunAt, "error", err)
+	if err := j.Queue.Retry(ctx, job); err != nil {
+		j.logger().Error("could not retry job", "job", job.Name, "id", job.ID, "error", err)
+	}
+}
+
+// call runs fn, turning a panic into an error
+func call(ctx context.Context, fn Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, job)
+}
+
+// backoff returns the wait before the retry that follows attempt: base, doubled
+// for every attempt after the first, up to max
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	d := float64(base) * math.Pow(2, float64(attempt-1))
+	if d > float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
+
+func (j *Jobs) logger() *slog.Logger {
+	if j.Logger != nil {
+		return j.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,247 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestJobs(q Queue) *Jobs {
+	j := New(q)
+	j.PollInterval = 5 * time.Millisecond
+	return j
+}
+
+// waitFor polls cond until it is true, or fails the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobs_Run(t *testing.T) {
+	q := NewMemoryQueue()
+	j := newTestJobs(q)
+
+	type welcome struct {
+		UserID int `json:"user_id"`
+	}
+	got := make(chan int, 1)
+	j.Register("welcome", func(ctx context.Context, job *Job) error {
+		var payload welcome
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		got <- payload.UserID
+		return nil
+	})
+
+	j.Start()
+	defer j.Stop(context.Background())
+
+	if _, err := j.Enqueue(context.Background(), "welcome", welcome{UserID: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case id := <-got:
+		if id != 42 {
+			t.Errorf("expected user 42, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job did not run")
+	}
+
+	waitFor(t, "the job to complete", func() bool { return q.Len() == 0 })
+}
+
+func TestJobs_RetryThenDead(t *testing.T) {
+	q := NewMemoryQueue()
+	j := newTestJobs(q)
+
+	var runs atomic.Int32
+	j.Register("flaky", func(ctx context.Context, job *Job) error {
+		runs.Add(1)
+		return errors.New("boom")
+	}, WithMaxAttempts(3), WithBackoff(time.Millisecond, 10*time.Millisecond))
+
+	j.Start()
+	defer j.Stop(context.Background())
+
+	if _, err := j.Enqueue(context.Background(), "flaky", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the job to fail", func() bool {
+		dead, _ := q.Dead(context.Background(), 10)
+		return len(dead) == 1
+	})
+
+	if runs.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", runs.Load())
+	}
+
+	dead, _ := q.Dead(context.Background(), 10)
+	if dead[0].LastError != "boom" || dead[0].Attempts != 3 {
+		t.Errorf("unexpected dead job: %+v", dead[0])
+	}
+}
+
+func TestJobs_PermanentAndPanic(t *testing.T) {
+	q := NewMemoryQueue()
+	j := newTestJobs(q)
+
+	j.Register("permanent", func(ctx context.Context, job *Job) error {
+		return Permanent(errors.New("bad payload"))
+	})
+	j.Register("panics", func(ctx context.Context, job *Job) error {
+		panic("oops")
+	}, WithMaxAttempts(1))
+
+	j.Start()
+	defer j.Stop(context.Background())
+
+	for _, name := range []string{"permanent", "panics"} {
+		if _, err := j.Enqueue(context.Background(), name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "both jobs to fail", func() bool {
+		dead, _ := q.Dead(context.Background(), 10)
+		return len(dead) == 2
+	})
+
+	dead, _ := q.Dead(context.Background(), 10)
+	for _, job := range dead {
+		if job.Attempts != 1 {
+			t.Errorf("%s: expected 1 attempt, got %d", job.Name, job.Attempts)
+		}
+	}
+}
+
+func TestJobs_Concurrency(t *testing.T) {
+	q := NewMemoryQueue()
+	j := newTestJobs(q)
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	release := make(chan struct{})
+	j.Register("limited", func(ctx context.Context, job *Job) error {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}, WithConcurrency(2))
+
+	for range 5 {
+		if _, err := j.Enqueue(context.Background(), "limited", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j.Start()
+	waitFor(t, "two jobs to start", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return running == 2
+	})
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	waitFor(t, "every job to complete", func() bool { return q.Len() == 0 })
+	if err := j.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if most != 2 {
+		t.Errorf("expected at most 2 jobs at once, got %d", most)
+	}
+}
+
+func TestJobs_StopWaitsForRunningJobs(t *testing.T) {
+	q := NewMemoryQueue()
+	j := newTestJobs(q)
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	j.Register("slow", func(ctx context.Context, job *Job) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+		return nil
+	})
+
+	j.Start()
+	if _, err := j.Enqueue(context.Background(), "slow", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if err := j.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Error("Stop returned before the running job finished")
+	}
+	if q.Len() != 0 {
+		t.Error("job was not completed")
+	}
+}
+
+func TestJobs_StopTimesOut(t *testing.T) {
+	q := NewMemoryQueue()
+	j := newTestJobs(q)
+
+	started := make(chan struct{})
+	j.Register("stuck", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	j.Start()
+	if _, err := j.Enqueue(context.Background(), "stuck", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := j.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{20, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(time.Second, time.Minute, tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: expected %s, got %s", tt.attempt, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryQueue keeps jobs in memory. It is lost when the process exits, so it is
+// meant for development and tests.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	pending map[string]*Job
+	dead    []*Job
+	unique  map[string]string
+}
+
+var _ Queue = (*MemoryQueue)(nil)
+
+// NewMemoryQueue returns an empty MemoryQueue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		pending: make(map[string]*Job),
+		unique:  make(map[string]string),
+	}
+}
+
+func (q *MemoryQueue) Enqueue(_ context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if job.UniqueKey != "" {
+		if _, ok := q.unique[job.UniqueKey]; ok {
+			return ErrDuplicate
+		}
+		q.unique[job.UniqueKey] = job.ID
+	}
+
+	q.pending[job.ID] = copyJob(job)
+	return nil
+}
+
+func (q *MemoryQueue) Reserve(_ context.Context, names []string, lease time.Duration) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	now := time.Now()
+	var next *Job
+	for _, job := range q.pending {
+		if !wanted[job.Name] || job.RunAt.After(now) {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	next.Attempts++
+	next.RunAt = now.Add(lease)
+	return copyJob(next), nil
+}
+
+func (q *MemoryQueue) Complete(_ context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, job.ID)
+	q.release(job)
+	return nil
+}
+
+func (q *MemoryQueue) Retry(_ context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[job.ID] = copyJob(job)
+	return nil
+}
+
+func (q *MemoryQueue) Fail(_ context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, job.ID)
+	q.release(job)
+	q.dead = append(q.dead, copyJob(job))
+	return nil
+}
+
+func (q *MemoryQueue) Dead(_ context.Context, limit int) ([]*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dead := make([]*Job, 0, len(q.dead))
+	for _, job := range q.dead {
+		dead = append(dead, copyJob(job))
+	}
+	sort.SliceStable(dead, func(i, j int) bool { return dead[i].FailedAt.After(dead[j].FailedAt) })
+	if limit > 0 && len(dead) > limit {
+		dead = dead[:limit]
+	}
+	return dead, nil
+}
+
+func (q *MemoryQueue) Requeue(_ context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.dead {
+		if job.ID != id {
+			continue
+		}
+		if job.UniqueKey != "" {
+			if _, ok := q.unique[job.UniqueKey]; ok {
+				return ErrDuplicate
+			}
+			q.unique[job.UniqueKey] = job.ID
+		}
+
+		q.dead = append(q.dead[:i], q.dead[i+1:]...)
+		job.Attempts = 0
+		job.RunAt = time.Now()
+		job.FailedAt = time.Time{}
+		q.pending[job.ID] = job
+		return nil
+	}
+	return ErrNotFound
+}
+
+// Len returns the number of jobs waiting or running
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// release frees the unique key of job
+func (q *MemoryQueue) release(job *Job) {
+	if job.UniqueKey != "" && q.unique[job.UniqueKey] == job.ID {
+		delete(q.unique, job.UniqueKey)
+	}
+}
+
+func copyJob(job *Job) *Job {
+	c := *job
+	return &c
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestJob(name string) *Job {
+	now := time.Now()
+	return &Job{ID: NewID(), Name: name, Payload: []byte(`{}`), RunAt: now, CreatedAt: now}
+}
+
+func TestQueue_ReserveComplete(t *testing.T) {
+	for kind, q := range testQueues(t) {
+		testQueueReserveComplete(t, kind, q)
+	}
+}
+
+func testQueueReserveComplete(t *testing.T, kind string, q Queue) {
+	ctx := context.Background()
+
+	first := newTestJob("email")
+	first.RunAt = time.Now().Add(-time.Minute)
+	second := newTestJob("email")
+	other := newTestJob("report")
+	for _, job := range []*Job{second, first, other} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+	}
+
+	job, err := q.Reserve(ctx, []string{"email"}, time.Minute)
+	if err != nil {
+		t.Fatalf("%s: %s", kind, err)
+	}
+	if job == nil || job.ID != first.ID {
+		t.Fatalf("%s: expected the job due first to be reserved, got %v", kind, job)
+	}
+	if job.Attempts != 1 {
+		t.Errorf("%s: expected 1 attempt, got %d", kind, job.Attempts)
+	}
+
+	job, _ = q.Reserve(ctx, []string{"email"}, time.Minute)
+	if job == nil || job.ID != second.ID {
+		t.Fatalf("%s: expected the second job to be reserved, got %v", kind, job)
+	}
+
+	// both email jobs are leased, and report was not asked for
+	job, _ = q.Reserve(ctx, []string{"email"}, time.Minute)
+	if job != nil {
+		t.Errorf("%s: expected no job while both are leased, got %s", kind, job.ID)
+	}
+
+	if err := q.Complete(ctx, first); err != nil {
+		t.Errorf("%s: %s", kind, err)
+	}
+
+	job, _ = q.Reserve(ctx, []string{"email", "report"}, time.Minute)
+	if job == nil || job.ID != other.ID {
+		t.Errorf("%s: expected report to be reserved, got %v", kind, job)
+	}
+}
+
+func TestQueue_LeaseExpires(t *testing.T) {
+	for kind, q := range testQueues(t) {
+		ctx := context.Background()
+		if err := q.Enqueue(ctx, newTestJob("email")); err != nil {
+			t.Fatal(err)
+		}
+
+		job, _ := q.Reserve(ctx, []string{"email"}, -time.Second)
+		if job == nil {
+			t.Fatalf("%s: expected a job", kind)
+		}
+
+		// the lease has already expired, as if the worker had crashed
+		again, _ := q.Reserve(ctx, []string{"email"}, time.Minute)
+		if again == nil || again.ID != job.ID || again.Attempts != 2 {
+			t.Errorf("%s: expected the job to be reserved again on its second attempt, got %v", kind, again)
+		}
+	}
+}
+
+func TestQueue_Delayed(t *testing.T) {
+	for kind, q := range testQueues(t) {
+		ctx := context.Background()
+		job := newTestJob("email")
+		job.RunAt = time.Now().Add(time.Hour)
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := q.Reserve(ctx, []string{"email"}, time.Minute); got != nil {
+			t.Errorf("%s: delayed job was reserved before it was due", kind)
+		}
+	}
+}
+
+func TestQueue_Unique(t *testing.T) {
+	for kind, q := range testQueues(t) {
+		ctx := context.Background()
+
+		job := newTestJob("email")
+		job.UniqueKey = "welcome:1"
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+
+		dup := newTestJob("email")
+		dup.UniqueKey = "welcome:1"
+		if err := q.Enqueue(ctx, dup); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("%s: expected ErrDuplicate, got %v", kind, err)
+		}
+
+		reserved, _ := q.Reserve(ctx, []string{"email"}, time.Minute)
+		if err := q.Complete(ctx, reserved); err != nil {
+			t.Fatal(err)
+		}
+
+		// the key is free once the job has completed
+		if err := q.Enqueue(ctx, dup); err != nil {
+			t.Errorf("%s: expected the unique key to be released, got %v", kind, err)
+		}
+	}
+}
+
+func TestQueue_DeadAndRequeue(t *testing.T) {
+	for kind, q := range testQueues(t) {
+		ctx := context.Background()
+		if err := q.Enqueue(ctx, newTestJob("email")); err != nil {
+			t.Fatal(err)
+		}
+
+		job, _ := q.Reserve(ctx, []string{"email"}, time.Minute)
+		job.LastError = "smtp down"
+		job.FailedAt = time.Now()
+		if err := q.Fail(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+
+		dead, err := q.Dead(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "smtp down" {
+			t.Fatalf("%s: expected the failed job in dead-letter storage, got %v", kind, dead)
+		}
+
+		if got, _ := q.Reserve(ctx, []string{"email"}, time.Minute); got != nil {
+			t.Errorf("%s: dead job was reserved", kind)
+		}
+
+		if err := q.Requeue(ctx, job.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Requeue(ctx, job.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound requeueing a live job, got %v", kind, err)
+		}
+
+		got, _ := q.Reserve(ctx, []string{"email"}, time.Minute)
+		if got == nil || got.ID != job.ID || got.Attempts != 1 {
+			t.Errorf("%s: expected the requeued job with fresh attempts, got %v", kind, got)
+		}
+	}
+}
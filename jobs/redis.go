@@ -0,0 +1,335 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisQueue keeps jobs in redis. Each job is stored as json, and is listed in a
+// sorted set per job name, scored by the time it is next due; dead jobs are listed
+// in another sorted set, scored by when they failed. The attempts of jobs that are
+// waiting or running are counted in a hash, so reserving a job is a single step.
+//
+// Dead jobs are deleted once they are older than DeadRetention, or when there are
+// more than MaxDead of them, oldest first.
+//
+// Keys start with Prefix+"#jobs", outside the keys under Prefix+":" that a
+// cache.RedisCache with the same Prefix empties, so that flushing the cache does
+// not lose jobs.
+type RedisQueue struct {
+	Conn   *redis.Pool
+	Prefix string
+
+	// DeadRetention is how long dead jobs are kept; zero means 7 days
+	DeadRetention time.Duration
+
+	// MaxDead is how many dead jobs are kept; zero means 10000
+	MaxDead int
+}
+
+const (
+	defaultDeadRetention = 7 * 24 * time.Hour
+	defaultMaxDead       = 10000
+)
+
+var _ Queue = (*RedisQueue)(nil)
+
+var (
+	// KEYS: pending set, job, unique key; ARGV: id, due, job json, has unique key
+	enqueueScript = redis.NewScript(3, `
+if ARGV[4] == '1' and redis.call('SET', KEYS[3], ARGV[1], 'NX') == false then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1`)
+
+	// KEYS: attempts, pending sets...; ARGV: now, lease expiry, job key prefix
+	reserveScript = redis.NewScript(-1, `
+local best, bestKey, bestScore
+for i = 2, #KEYS do
+	local r = redis.call('ZRANGEBYSCORE', KEYS[i], '-inf', ARGV[1], 'WITHSCORES', 'LIMIT', 0, 1)
+	if r[1] and (best == nil or tonumber(r[2]) < bestScore) then
+		best, bestKey, bestScore = r[1], KEYS[i], tonumber(r[2])
+	end
+end
+if best == nil then
+	return false
+end
+redis.call('ZADD', bestKey, ARGV[2], best)
+local attempts = redis.call('HINCRBY', KEYS[1], best, 1)
+return {best, redis.call('GET', ARGV[3] .. best), attempts}`)
+
+	// KEYS: pending set, job, unique key, attempts; ARGV: id
+	completeScript = redis.NewScript(4, `
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+redis.call('HDEL', KEYS[4], ARGV[1])
+if redis.call('GET', KEYS[3]) == ARGV[1] then
+	redis.call('DEL', KEYS[3])
+end
+return 1`)
+
+	// KEYS: pending set, job; ARGV: id, due, job json
+	retryScript = redis.NewScript(2, `
+redis.call('SET', KEYS[2], ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1`)
+
+	// KEYS: pending set, job, unique key, dead set, attempts; ARGV: id, failed at,
+	// job json, oldest failure kept, most dead jobs kept, job key prefix
+	failScript = redis.NewScript(5, `
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('SET', KEYS[2], ARGV[3])
+redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+if redis.call('GET', KEYS[3]) == ARGV[1] then
+	redis.call('DEL', KEYS[3])
+end
+local pruned = redis.call('ZRANGEBYSCORE', KEYS[4], '-inf', '(' .. ARGV[4])
+local extra = redis.call('ZCARD', KEYS[4]) - tonumber(ARGV[5])
+if extra > 0 then
+	for _, id in ipairs(redis.call('ZRANGE', KEYS[4], 0, extra - 1)) do
+		table.insert(pruned, id)
+	end
+end
+for _, id in ipairs(pruned) do
+	redis.call('ZREM', KEYS[4], id)
+	redis.call('DEL', ARGV[6] .. id)
+end
+return 1`)
+
+	// KEYS: pending set, job, unique key, dead set, attempts; ARGV: id, due, job json,
+	// has unique key
+	requeueScript = redis.NewScript(5, `
+if redis.call('ZSCORE', KEYS[4], ARGV[1]) == false then
+	return -1
+end
+if ARGV[4] == '1' and redis.call('SET', KEYS[3], ARGV[1], 'NX') == false then
+	return 0
+end
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('SET', KEYS[2], ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1`)
+)
+
+func (q *RedisQueue) key(parts ...string) string {
+	key := q.Prefix + "#jobs"
+	for _, p := range parts {
+		key += ":" + p
+	}
+	return key
+}
+
+func (q *RedisQueue) Enqueue(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ok, err := redis.Int(enqueueScript.Do(conn,
+		q.key("pending", job.Name), q.key("job", job.ID), q.key("unique", job.UniqueKey),
+		job.ID, job.RunAt.UnixMilli(), data, flag(job.UniqueKey != "")))
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+// Reserve claims a job, and counts the attempt, in a single script, so a job is
+// never claimed without its attempt being recorded
+func (q *RedisQueue) Reserve(ctx context.Context, names []string, lease time.Duration) (*Job, error) {
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	now := time.Now()
+	args := redis.Args{}.Add(len(names)+1, q.key("attempts"))
+	for _, name := range names {
+		args = args.Add(q.key("pending", name))
+	}
+	args = args.Add(now.UnixMilli(), now.Add(lease).UnixMilli(), q.key("job")+":")
+
+	values, err := redis.Values(reserveScript.Do(conn, args...))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var id string
+	var data []byte
+	var attempts int
+	if _, err := redis.Scan(values, &id, &data, &attempts); err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("jobs: job %s is missing", id)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	job.Attempts = attempts
+	job.RunAt = now.Add(lease)
+
+	return &job, nil
+}
+
+func (q *RedisQueue) Complete(ctx context.Context, job *Job) error {
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = completeScript.Do(conn,
+		q.key("pending", job.Name), q.key("job", job.ID), q.key("unique", job.UniqueKey), q.key("attempts"), job.ID)
+	return err
+}
+
+func (q *RedisQueue) Retry(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = retryScript.Do(conn, q.key("pending", job.Name), q.key("job", job.ID),
+		job.ID, job.RunAt.UnixMilli(), data)
+	return err
+}
+
+func (q *RedisQueue) Fail(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	retention := q.DeadRetention
+	if retention <= 0 {
+		retention = defaultDeadRetention
+	}
+	maxDead := q.MaxDead
+	if maxDead <= 0 {
+		maxDead = defaultMaxDead
+	}
+
+	_, err = failScript.Do(conn,
+		q.key("pending", job.Name), q.key("job", job.ID), q.key("unique", job.UniqueKey), q.key("dead"), q.key("attempts"),
+		job.ID, job.FailedAt.UnixMilli(), data, time.Now().Add(-retention).UnixMilli(), maxDead, q.key("job")+":")
+	return err
+}
+
+func (q *RedisQueue) Dead(ctx context.Context, limit int) ([]*Job, error) {
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("ZREVRANGE", q.key("dead"), 0, limit-1))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	args := redis.Args{}
+	for _, id := range ids {
+		args = args.Add(q.key("job", id))
+	}
+	values, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	dead := make([]*Job, 0, len(values))
+	for _, data := range values {
+		if data == nil {
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return nil, err
+		}
+		dead = append(dead, &job)
+	}
+	return dead, nil
+}
+
+func (q *RedisQueue) Requeue(ctx context.Context, id string) error {
+	conn, err := q.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", q.key("job", id)))
+	if errors.Is(err, redis.ErrNil) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return err
+	}
+	job.Attempts = 0
+	job.RunAt = time.Now()
+	job.FailedAt = time.Time{}
+	if data, err = json.Marshal(job); err != nil {
+		return err
+	}
+
+	result, err := redis.Int(requeueScript.Do(conn,
+		q.key("pending", job.Name), q.key("job", id), q.key("unique", job.UniqueKey), q.key("dead"), q.key("attempts"),
+		id, job.RunAt.UnixMilli(), data, flag(job.UniqueKey != "")))
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case -1:
+		return ErrNotFound
+	case 0:
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
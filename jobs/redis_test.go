@@ -0,0 +1,174 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestRedisQueue_prunesDeadJobs(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention time.Duration
+		maxDead   int
+		failedAgo []time.Duration
+		wantDead  int
+	}{
+		{"defaults keep recent jobs", 0, 0, []time.Duration{time.Hour, time.Minute, 0}, 3},
+		{"old jobs are deleted", time.Hour, 0, []time.Duration{3 * time.Hour, 2 * time.Hour, time.Minute, 0}, 2},
+		{"cap keeps the newest", 0, 2, []time.Duration{3 * time.Minute, 2 * time.Minute, time.Minute, 0}, 2},
+		{"both limits", time.Hour, 3, []time.Duration{2 * time.Hour, 3 * time.Minute, 2 * time.Minute, time.Minute, 0}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testQueues(t)
+			q := &RedisQueue{Conn: testRedisPool, Prefix: "test-boilme", DeadRetention: tt.retention, MaxDead: tt.maxDead}
+			ctx := context.Background()
+
+			var ids []string
+			for i, ago := range tt.failedAgo {
+				job := newTestJob("email")
+				job.ID = fmt.Sprintf("job-%d", i)
+				if err := q.Enqueue(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+				job, err := q.Reserve(ctx, []string{"email"}, time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				job.FailedAt = time.Now().Add(-ago)
+				if err := q.Fail(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, job.ID)
+			}
+
+			dead, err := q.Dead(ctx, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dead) != tt.wantDead {
+				t.Fatalf("kept %d dead jobs, want %d", len(dead), tt.wantDead)
+			}
+			// the newest are kept, most recent first
+			for i, job := range dead {
+				if want := ids[len(ids)-1-i]; job.ID != want {
+					t.Errorf("dead job %d is %s, want %s", i, job.ID, want)
+				}
+			}
+
+			// the jobs themselves are deleted along with their entries
+			conn := testRedisPool.Get()
+			defer conn.Close()
+			n, err := redis.Int(conn.Do("EXISTS", q.key("job", ids[0])))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantKept := len(ids) == tt.wantDead; (n == 1) != wantKept {
+				t.Errorf("oldest job stored: %v, want %v", n == 1, wantKept)
+			}
+		})
+	}
+}
+
+func TestRedisQueue_Reserve_countsAttemptsAtomically(t *testing.T) {
+	testQueues(t)
+	q := &RedisQueue{Conn: testRedisPool, Prefix: "test-boilme"}
+	ctx := context.Background()
+
+	job := newTestJob("email")
+	if err := q.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lease expires at once, as if each worker crashed before it could run the job
+	for want := 1; want <= 3; want++ {
+		got, err := q.Reserve(ctx, []string{"email"}, -time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.Attempts != want {
+			t.Fatalf("expected attempt %d, got %+v", want, got)
+		}
+	}
+
+	reserved, err := q.Reserve(ctx, []string{"email"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Complete(ctx, reserved); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := testRedisPool.Get()
+	defer conn.Close()
+	if n, _ := redis.Int(conn.Do("HLEN", q.key("attempts"))); n != 0 {
+		t.Errorf("the attempts of a completed job are still counted: %d", n)
+	}
+}
+
+func TestRedisQueue_survivesCacheEmpty(t *testing.T) {
+	testQueues(t)
+	q := &RedisQueue{Conn: testRedisPool, Prefix: "test-boilme"}
+	ctx := context.Background()
+
+	dead := newTestJob("email")
+	if err := q.Enqueue(ctx, dead); err != nil {
+		t.Fatal(err)
+	}
+	reserved, err := q.Reserve(ctx, []string{"email"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved.FailedAt = time.Now()
+	if err := q.Fail(ctx, reserved); err != nil {
+		t.Fatal(err)
+	}
+	pending := newTestJob("email")
+	scheduled := newTestJob("email")
+	scheduled.RunAt = time.Now().Add(-time.Minute)
+	for _, job := range []*Job{pending, scheduled} {
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &cache.RedisCache{Conn: testRedisPool, Prefix: "test-boilme"}
+	if err := c.Set("page", "cached", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Empty(); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := c.Has("page"); found {
+		t.Fatal("the cache was not emptied")
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		job, err := q.Reserve(ctx, []string{"email"}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil {
+			t.Fatalf("reserved %d jobs after emptying the cache, want 2", i)
+		}
+		got[job.ID] = true
+	}
+	if !got[pending.ID] || !got[scheduled.ID] {
+		t.Errorf("reserved %v, want %s and %s", got, pending.ID, scheduled.ID)
+	}
+
+	deadJobs, err := q.Dead(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadJobs) != 1 || deadJobs[0].ID != dead.ID {
+		t.Errorf("got %d dead jobs after emptying the cache, want %s", len(deadJobs), dead.ID)
+	}
+}
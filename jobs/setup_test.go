@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+var testRedisPool *redis.Pool
+
+// newSQLQueue returns a new, empty SQLQueue, when the tests are built with a sql driver
+var newSQLQueue func(t *testing.T) Queue
+
+func TestMain(m *testing.M) {
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+
+	testRedisPool = &redis.Pool{
+		MaxIdle:     50,
+		MaxActive:   1000,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.Addr())
+		},
+	}
+	defer testRedisPool.Close()
+
+	os.Exit(m.Run())
+}
+
+// testQueues returns a new, empty queue of every kind we can test without a database
+func testQueues(t *testing.T) map[string]Queue {
+	conn := testRedisPool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("FLUSHALL"); err != nil {
+		t.Fatal(err)
+	}
+
+	queues := map[string]Queue{
+		"memory": NewMemoryQueue(),
+		"redis":  &RedisQueue{Conn: testRedisPool, Prefix: "test-boilme"},
+	}
+	if newSQLQueue != nil {
+		queues["sql"] = newSQLQueue(t)
+	}
+	return queues
+}
@@ -0,0 +1,222 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLQueue keeps jobs in a table of a postgres, mysql or sqlite database, created
+// by the migration from "boilme make jobs". Times are stored as unix milliseconds,
+// so the same schema works everywhere. Dialect is postgres, mysql or sqlite, and
+// Table defaults to jobs.
+//
+// Jobs are claimed by updating them only if they are unchanged since they were
+// read, so any number of workers can share the table without locking it.
+type SQLQueue struct {
+	DB      *sql.DB
+	Dialect string
+	Table   string
+}
+
+var _ Queue = (*SQLQueue)(nil)
+
+// jobColumns are the columns scanned by scanJob, in order
+const jobColumns = "id, name, payload, attempts, max_attempts, run_at, unique_key, last_error, created_at, failed_at"
+
+func (q *SQLQueue) table() string {
+	if q.Table == "" {
+		return "jobs"
+	}
+	return q.Table
+}
+
+// rebind replaces the ? placeholders in query with $1, $2... for postgres
+func (q *SQLQueue) rebind(query string) string {
+	if q.Dialect != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (q *SQLQueue) Enqueue(ctx context.Context, job *Job) error {
+	var unique interface{}
+	if job.UniqueKey != "" {
+		unique = job.UniqueKey
+
+		var exists int
+		err := q.DB.QueryRowContext(ctx, q.rebind(`select count(*) from `+q.table()+` where unique_key = ?`),
+			job.UniqueKey).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrDuplicate
+		}
+	}
+
+	_, err := q.DB.ExecContext(ctx, q.rebind(`insert into `+q.table()+` (`+jobColumns+`)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, null)`),
+		job.ID, job.Name, string(job.Payload), job.Attempts, job.MaxAttempts, job.RunAt.UnixMilli(),
+		unique, job.LastError, job.CreatedAt.UnixMilli())
+	if err != nil && unique != nil && isUniqueViolation(err) {
+		// another job with the key was enqueued since we looked
+		return ErrDuplicate
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation, in any of
+// the databases we support
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate entry") || strings.Contains(msg, "unique constraint failed")
+}
+
+func (q *SQLQueue) Reserve(ctx context.Context, names []string, lease time.Duration) (*Job, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	query := q.rebind(`select ` + jobColumns + ` from ` + q.table() + `
+		where failed_at is null and run_at <= ? and name in (` + placeholders + `)
+		order by run_at limit 1`)
+
+	// another worker may claim the job between our select and update; try again
+	for range 5 {
+		now := time.Now()
+		args := []interface{}{now.UnixMilli()}
+		for _, name := range names {
+			args = append(args, name)
+		}
+
+		job, err := scanJob(q.DB.QueryRowContext(ctx, query, args...))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		until := now.Add(lease)
+		res, err := q.DB.ExecContext(ctx, q.rebind(`update `+q.table()+`
+			set run_at = ?, attempts = attempts + 1
+			where id = ? and run_at = ? and attempts = ? and failed_at is null`),
+			until.UnixMilli(), job.ID, job.RunAt.UnixMilli(), job.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 {
+			job.Attempts++
+			job.RunAt = time.UnixMilli(until.UnixMilli())
+			return job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (q *SQLQueue) Complete(ctx context.Context, job *Job) error {
+	_, err := q.DB.ExecContext(ctx, q.rebind(`delete from `+q.table()+` where id = ?`), job.ID)
+	return err
+}
+
+func (q *SQLQueue) Retry(ctx context.Context, job *Job) error {
+	_, err := q.DB.ExecContext(ctx, q.rebind(`update `+q.table()+`
+		set run_at = ?, attempts = ?, last_error = ? where id = ?`),
+		job.RunAt.UnixMilli(), job.Attempts, job.LastError, job.ID)
+	return err
+}
+
+func (q *SQLQueue) Fail(ctx context.Context, job *Job) error {
+	_, err := q.DB.ExecContext(ctx, q.rebind(`update `+q.table()+`
+		set failed_at = ?, attempts = ?, last_error = ?, unique_key = null where id = ?`),
+		job.FailedAt.UnixMilli(), job.Attempts, job.LastError, job.ID)
+	return err
+}
+
+func (q *SQLQueue) Dead(ctx context.Context, limit int) ([]*Job, error) {
+	rows, err := q.DB.QueryContext(ctx, q.rebind(`select `+jobColumns+` from `+q.table()+`
+		where failed_at is not null order by failed_at desc limit ?`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dead []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		dead = append(dead, job)
+	}
+	return dead, rows.Err()
+}
+
+// Requeue runs a dead job again. Its unique key was released when it failed, so
+// it is requeued without one.
+func (q *SQLQueue) Requeue(ctx context.Context, id string) error {
+	res, err := q.DB.ExecContext(ctx, q.rebind(`update `+q.table()+`
+		set failed_at = null, attempts = 0, run_at = ? where id = ? and failed_at is not null`),
+		time.Now().UnixMilli(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scanJob reads a row of jobColumns
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
+	var (
+		job                  Job
+		payload              string
+		runAt, createdAt     int64
+		uniqueKey, lastError sql.NullString
+		failedAt             sql.NullInt64
+	)
+
+	err := row.Scan(&job.ID, &job.Name, &payload, &job.Attempts, &job.MaxAttempts, &runAt,
+		&uniqueKey, &lastError, &createdAt, &failedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = []byte(payload)
+	job.RunAt = time.UnixMilli(runAt)
+	job.CreatedAt = time.UnixMilli(createdAt)
+	job.UniqueKey = uniqueKey.String
+	job.LastError = lastError.String
+	if failedAt.Valid {
+		job.FailedAt = time.UnixMilli(failedAt.Int64)
+	}
+	return &job, nil
+}
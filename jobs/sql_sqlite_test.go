@@ -0,0 +1,32 @@
+//go:build sqlite
+
+package jobs
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	newSQLQueue = func(t *testing.T) Queue {
+		db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "jobs.db")+"?_busy_timeout=5000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		schema, err := os.ReadFile("../cmd/cli/templates/migrations/sqlite_jobs.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(schema)); err != nil {
+			t.Fatal(err)
+		}
+
+		return &SQLQueue{DB: db, Dialect: "sqlite"}
+	}
+}
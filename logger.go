@@ -0,0 +1,235 @@
+package boilme
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startLoggers creates b.Logger from the LOG_* settings, along with the InfoLog and
+// ErrorLog adapters that older applications use
+func (b *Boilme) startLoggers() error {
+	b.logLevel = new(slog.LevelVar)
+	if err := b.SetLogLevel(b.Config.Log.Level); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if b.Config.Log.File != "" {
+		path := b.Config.Log.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(b.RootPath, "logs", path)
+		}
+
+		f, err := newRotatingFile(path, b.Config.Log.MaxSize*1024*1024, b.Config.Log.MaxBackups)
+		if err != nil {
+			return err
+		}
+		b.logFile = f
+		out = io.MultiWriter(os.Stdout, f)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:     b.logLevel,
+		AddSource: b.Debug,
+	}
+
+	var handler slog.Handler
+	if strings.ToLower(b.Config.Log.Format) == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+
+	b.setLogger(slog.New(requestIDHandler{handler}))
+	return nil
+}
+
+// setLogger makes logger the application's logger, and derives InfoLog and ErrorLog from it
+func (b *Boilme) setLogger(logger *slog.Logger) {
+	b.Logger = logger
+	b.InfoLog = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+	b.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
+}
+
+// SetLogLevel changes the minimum level logged (debug, info, warn or error) while the
+// application is running. It has no effect on a logger supplied with WithLogger.
+func (b *Boilme) SetLogLevel(level string) error {
+	if b.logLevel == nil {
+		return fmt.Errorf("the log level is controlled by the logger supplied to WithLogger")
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q; use debug, info, warn or error", level)
+	}
+	b.logLevel.Set(l)
+
+	return nil
+}
+
+// LogLevel returns the minimum level currently logged
+func (b *Boilme) LogLevel() string {
+	if b.logLevel == nil {
+		return ""
+	}
+	return strings.ToLower(b.logLevel.Level().String())
+}
+
+// requestIDHandler adds the request id set by chi's middleware.RequestID, and the
+// trace id when tracing, to every record logged with a request's context, so all
+// lines for one request can be found
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := middleware.GetReqID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestLogger is middleware that logs one line for every request, with its method,
+// path, status, size and duration
+func (b *Boilme) RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				// nothing was written, so net/http sends 200
+				status = http.StatusOK
+			}
+			b.Logger.InfoContext(r.Context(), "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+			)
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// rotatingFile is an io.Writer that writes to a file, and rotates it once it grows
+// past maxSize bytes: name becomes name.1, name.1 becomes name.2 and so on, and
+// only maxBackups old files are kept
+type rotatingFile struct {
+	name       string
+	maxSize    int
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int
+}
+
+var _ io.WriteCloser = (*rotatingFile)(nil)
+
+func newRotatingFile(name string, maxSize, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+
+	r := &rotatingFile{
+		name:       name,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = int(info.Size())
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size+len(p) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += n
+	return n, err
+}
+
+// rotate must be called with r.mu held
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	if r.maxBackups <= 0 {
+		if err := os.Remove(r.name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+
+	// drop the oldest, then shift the rest up by one
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.name, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.name, i), fmt.Sprintf("%s.%d", r.name, i+1))
+	}
+	if err := os.Rename(r.name, r.name+".1"); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,183 @@
+package boilme
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int
+		maxBackups int
+		writes     []string
+		want       map[string]string
+	}{
+		{
+			name:       "no rotation under the limit",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaa", "bbb"},
+			want:       map[string]string{"app.log": "aaabbb"},
+		},
+		{
+			name:       "rotates past the limit",
+			maxSize:    5,
+			maxBackups: 2,
+			writes:     []string{"aaa", "bbb", "ccc"},
+			want:       map[string]string{"app.log": "ccc", "app.log.1": "bbb", "app.log.2": "aaa"},
+		},
+		{
+			name:       "keeps only maxBackups files",
+			maxSize:    3,
+			maxBackups: 2,
+			writes:     []string{"aaa", "bbb", "ccc", "ddd"},
+			want:       map[string]string{"app.log": "ddd", "app.log.1": "ccc", "app.log.2": "bbb", "app.log.3": ""},
+		},
+		{
+			name:       "no backups truncates",
+			maxSize:    3,
+			maxBackups: 0,
+			writes:     []string{"aaa", "bbb"},
+			want:       map[string]string{"app.log": "bbb", "app.log.1": ""},
+		},
+		{
+			name:       "a write larger than the limit still goes to an empty file",
+			maxSize:    2,
+			maxBackups: 1,
+			writes:     []string{"aaaa"},
+			want:       map[string]string{"app.log": "aaaa", "app.log.1": ""},
+		},
+		{
+			name:       "no limit",
+			maxSize:    0,
+			maxBackups: 1,
+			writes:     []string{"aaa", "bbb"},
+			want:       map[string]string{"app.log": "aaabbb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := newRotatingFile(filepath.Join(dir, "logs", "app.log"), tt.maxSize, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if _, err := r.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.want {
+				data, err := os.ReadFile(filepath.Join(dir, "logs", name))
+				if want == "" {
+					if !os.IsNotExist(err) {
+						t.Errorf("%s should not exist", name)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s holds %q, want %q", name, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFile_appendsToExistingFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(name, []byte("1234"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newRotatingFile(name, 6, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the size of the existing file counts towards the limit
+	if _, err := r.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(name + ".1"); string(data) != "1234" {
+		t.Errorf("existing file was not rotated: %q", data)
+	}
+}
+
+func TestBoilme_SetLogLevel(t *testing.T) {
+	tests := []struct {
+		level   string
+		wantErr bool
+	}{
+		{"debug", false},
+		{"INFO", false},
+		{"warn", false},
+		{"error", false},
+		{"verbose", true},
+	}
+
+	b := &Boilme{logLevel: new(slog.LevelVar)}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			err := b.SetLogLevel(tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && b.LogLevel() != strings.ToLower(tt.level) {
+				t.Errorf("level is %q", b.LogLevel())
+			}
+		})
+	}
+
+	if err := (&Boilme{}).SetLogLevel("info"); err == nil {
+		t.Error("expected an error without a level to set")
+	}
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(requestIDHandler{slog.NewJSONHandler(&buf, nil)})
+
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+	logger.InfoContext(ctx, "hello")
+
+	if !strings.Contains(buf.String(), `"request_id":"req-1"`) {
+		t.Errorf("request id not logged: %s", buf.String())
+	}
+}
+
+func TestBoilme_RequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	b := &Boilme{}
+	b.setLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	h := b.RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/pot", nil))
+
+	for _, want := range []string{`"path":"/pot"`, `"status":418`, `"bytes":15`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log line does not contain %s: %s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	sp "github.com/SparkPost/gosparkpost"
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/sendgrid/rest"
+	"github.com/sendgrid/sendgrid-go"
+	sgmail "github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// APIError is returned when a mail api rejects a message
+type APIError struct {
+	API        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mailer: %s responded with %d: %s", e.API, e.StatusCode, e.Body)
+}
+
+// Transient reports whether sending the message again may succeed: the api was
+// unavailable, or asked us to slow down
+func (e *APIError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// httpClientOr returns client, or http.DefaultClient when it is nil
+func httpClientOr(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// requireAPIKey returns an error naming api when m has no APIKey
+func requireAPIKey(m *Mail, api string) error {
+	if m.APIKey == "" {
+		return fmt.Errorf("mailer: the %s api needs APIKey", api)
+	}
+	return nil
+}
+
+// MailgunTransport sends mail with the mailgun api at APIURL, from Domain. APIURL
+// defaults to the us region of mailgun; the eu region is https://api.eu.mailgun.net/v3.
+type MailgunTransport struct {
+	Domain     string
+	APIKey     string
+	APIURL     string
+	HTTPClient *http.Client
+}
+
+var (
+	_ Transport = (*MailgunTransport)(nil)
+	_ Pinger    = (*MailgunTransport)(nil)
+)
+
+func newMailgunTransport(m *Mail) (Transport, error) {
+	if err := requireAPIKey(m, "mailgun"); err != nil {
+		return nil, err
+	}
+	return &MailgunTransport{Domain: m.Domain, APIKey: m.APIKey, APIURL: m.APIUrl, HTTPClient: m.HTTPClient}, nil
+}
+
+func (t *MailgunTransport) Send(ctx context.Context, c *Composed) error {
+	mg := mailgun.NewMailgun(t.Domain, t.APIKey)
+	mg.SetClient(httpClientOr(t.HTTPClient))
+	if t.APIURL != "" {
+		mg.SetAPIBase(t.APIURL)
+	}
+
+	message := mg.NewMessage(c.Sender(), c.Subject, c.Plain, c.To...)
+	message.SetHtml(c.HTML)
+	for _, cc := range c.CC {
+		message.AddCC(cc)
+	}
+	for _, bcc := range c.BCC {
+		message.AddBCC(bcc)
+	}
+	if c.ReplyTo != "" {
+		message.SetReplyTo(c.ReplyTo)
+	}
+	if c.MessageID != "" {
+		message.AddHeader("Message-ID", c.MessageID)
+	}
+	for k, v := range c.Headers {
+		message.AddHeader(k, v)
+	}
+	for _, f := range c.Files {
+		message.AddBufferAttachment(f.Name, f.Data)
+	}
+
+	_, _, err := mg.Send(ctx, message)
+	var unexpected *mailgun.UnexpectedResponseError
+	if errors.As(err, &unexpected) {
+		return &APIError{API: "mailgun", StatusCode: unexpected.Actual, Body: string(unexpected.Data)}
+	}
+	return connFailed(err)
+}
+
+func (t *MailgunTransport) Ping(ctx context.Context) error {
+	if t.APIURL != "" {
+		return pingURL(ctx, t.APIURL)
+	}
+	return pingURL(ctx, mailgun.APIBase)
+}
+
+// SendGridTransport sends mail with the sendgrid api
+type SendGridTransport struct {
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+var (
+	_ Transport = (*SendGridTransport)(nil)
+	_ Pinger    = (*SendGridTransport)(nil)
+)
+
+func newSendGridTransport(m *Mail) (Transport, error) {
+	if err := requireAPIKey(m, "sendgrid"); err != nil {
+		return nil, err
+	}
+	return &SendGridTransport{APIKey: m.APIKey, HTTPClient: m.HTTPClient}, nil
+}
+
+func (t *SendGridTransport) Send(ctx context.Context, c *Composed) error {
+	message := sgmail.NewV3Mail()
+	message.SetFrom(sgmail.NewEmail(c.FromName, c.From))
+	message.Subject = c.Subject
+
+	p := sgmail.NewPersonalization()
+	p.AddTos(sendGridEmails(c.To)...)
+	p.AddCCs(sendGridEmails(c.CC)...)
+	p.AddBCCs(sendGridEmails(c.BCC)...)
+	message.AddPersonalizations(p)
+
+	if c.ReplyTo != "" {
+		message.SetReplyTo(sgmail.NewEmail("", c.ReplyTo))
+	}
+	if c.MessageID != "" {
+		message.SetHeader("Message-ID", c.MessageID)
+	}
+	for k, v := range c.Headers {
+		message.SetHeader(k, v)
+	}
+
+	if c.Plain != "" {
+		message.AddContent(sgmail.NewContent("text/plain", c.Plain))
+	}
+	message.AddContent(sgmail.NewContent("text/html", c.HTML))
+
+	for _, f := range c.Files {
+		a := sgmail.NewAttachment()
+		a.SetContent(base64.StdEncoding.EncodeToString(f.Data))
+		a.SetType(f.mimeType())
+		a.SetFilename(f.Name)
+		a.SetDisposition("attachment")
+		message.AddAttachment(a)
+	}
+
+	request := sendgrid.GetRequest(t.APIKey, "/v3/mail/send", "")
+	request.Method = rest.Post
+	request.Body = sgmail.GetRequestBody(message)
+
+	client := &rest.Client{HTTPClient: httpClientOr(t.HTTPClient)}
+	res, err := client.SendWithContext(ctx, request)
+	if err != nil {
+		return connFailed(err)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return &APIError{API: "sendgrid", StatusCode: res.StatusCode, Body: res.Body}
+	}
+	return nil
+}
+
+func (t *SendGridTransport) Ping(ctx context.Context) error {
+	return pingURL(ctx, sendGridHost)
+}
+
+// sendGridHost is where the sendgrid api is
+const sendGridHost = "https://api.sendgrid.com"
+
+func sendGridEmails(addresses []string) []*sgmail.Email {
+	emails := make([]*sgmail.Email, 0, len(addresses))
+	for _, a := range addresses {
+		emails = append(emails, sgmail.NewEmail("", a))
+	}
+	return emails
+}
+
+// SparkPostTransport sends mail with the sparkpost api at APIURL, which defaults
+// to the us region of sparkpost. Every recipient is sent the same To and CC
+// headers; BCC recipients are simply left out of them.
+type SparkPostTransport struct {
+	APIURL     string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+var (
+	_ Transport = (*SparkPostTransport)(nil)
+	_ Pinger    = (*SparkPostTransport)(nil)
+)
+
+// sparkPostURL is where the us region of the sparkpost api is
+const sparkPostURL = "https://api.sparkpost.com"
+
+func newSparkPostTransport(m *Mail) (Transport, error) {
+	if err := requireAPIKey(m, "sparkpost"); err != nil {
+		return nil, err
+	}
+	return &SparkPostTransport{APIURL: m.APIUrl, APIKey: m.APIKey, HTTPClient: m.HTTPClient}, nil
+}
+
+func (t *SparkPostTransport) apiURL() string {
+	if t.APIURL == "" {
+		return sparkPostURL
+	}
+	return t.APIURL
+}
+
+func (t *SparkPostTransport) Send(ctx context.Context, c *Composed) error {
+	client := sp.Client{Client: httpClientOr(t.HTTPClient)}
+	err := client.Init(&sp.Config{BaseUrl: t.apiURL(), ApiKey: t.APIKey, ApiVersion: 1})
+	if err != nil {
+		return err
+	}
+
+	headerTo := strings.Join(c.To, ",")
+	var recipients []sp.Recipient
+	for _, group := range [][]string{c.To, c.CC, c.BCC} {
+		for _, a := range group {
+			recipients = append(recipients, sp.Recipient{Address: sp.Address{Email: a, HeaderTo: headerTo}})
+		}
+	}
+
+	headers := make(map[string]string, len(c.Headers)+2)
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	if len(c.CC) > 0 {
+		headers["CC"] = strings.Join(c.CC, ",")
+	}
+	if c.MessageID != "" {
+		headers["Message-ID"] = c.MessageID
+	}
+
+	content := sp.Content{
+		HTML:    c.HTML,
+		Text:    c.Plain,
+		Subject: c.Subject,
+		From:    sp.From{Email: c.From, Name: c.FromName},
+		ReplyTo: c.ReplyTo,
+		Headers: headers,
+	}
+	for _, f := range c.Files {
+		content.Attachments = append(content.Attachments, sp.Attachment{
+			MIMEType: f.mimeType(),
+			Filename: f.Name,
+			B64Data:  base64.StdEncoding.EncodeToString(f.Data),
+		})
+	}
+
+	_, res, err := client.SendContext(ctx, &sp.Transmission{Recipients: recipients, Content: content})
+	if res != nil && res.HTTP != nil && res.HTTP.StatusCode >= http.StatusBadRequest {
+		return &APIError{API: "sparkpost", StatusCode: res.HTTP.StatusCode, Body: string(res.Body)}
+	}
+	return connFailed(err)
+}
+
+func (t *SparkPostTransport) Ping(ctx context.Context) error {
+	return pingURL(ctx, t.apiURL())
+}
@@ -0,0 +1,172 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMail_SendSparkPost(t *testing.T) {
+	var body struct {
+		Recipients []struct {
+			Address struct {
+				Email    string `json:"email"`
+				HeaderTo string `json:"header_to"`
+			} `json:"address"`
+		} `json:"recipients"`
+		Content struct {
+			ReplyTo     string            `json:"reply_to"`
+			Headers     map[string]string `json:"headers"`
+			Attachments []struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+				Data string `json:"data"`
+			} `json:"attachments"`
+		} `json:"content"`
+	}
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":{"id":"1","total_accepted_recipients":4,"total_rejected_recipients":0}}`))
+	}))
+	defer srv.Close()
+
+	m := &Mail{
+		Templates:   "./testdata/mail",
+		FromAddress: "me@here.com",
+		FromName:    "Joe",
+		API:         "sparkpost",
+		APIKey:      "key",
+		APIUrl:      srv.URL,
+		HTTPClient:  srv.Client(),
+	}
+
+	err := m.Send(Message{
+		To:        []string{"a@there.com", "b@there.com"},
+		CC:        []string{"c@there.com"},
+		BCC:       []string{"d@there.com"},
+		ReplyTo:   "reply@here.com",
+		Subject:   "test",
+		Template:  "test",
+		Headers:   map[string]string{"X-Campaign": "welcome"},
+		MessageID: "<1@here.com>",
+		Files:     []File{{Name: "report.csv", Data: []byte("a,b\n")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Recipients) != 4 {
+		t.Fatalf("expected 4 recipients, got %d", len(body.Recipients))
+	}
+	for _, r := range body.Recipients {
+		if r.Address.HeaderTo != "a@there.com,b@there.com" {
+			t.Errorf("%s: expected the To header of every recipient to list a and b, got %q", r.Address.Email, r.Address.HeaderTo)
+		}
+	}
+	if body.Content.ReplyTo != "reply@here.com" {
+		t.Errorf("expected reply-to, got %q", body.Content.ReplyTo)
+	}
+	if h := body.Content.Headers; h["CC"] != "c@there.com" || h["X-Campaign"] != "welcome" || h["Message-ID"] != "<1@here.com>" {
+		t.Errorf("unexpected headers %v", h)
+	}
+	if a := body.Content.Attachments; len(a) != 1 || a[0].Name != "report.csv" || a[0].Type != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected attachments %+v", a)
+	}
+}
+
+func TestMail_SendSparkPostRejected(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"errors":[{"message":"try again"}]}`))
+	}))
+	defer srv.Close()
+
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", API: "sparkpost", APIKey: "key", APIUrl: srv.URL, HTTPClient: srv.Client()}
+	err := m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "test"})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if !IsTransient(err) {
+		t.Error("expected a 503 to be transient")
+	}
+}
+
+func TestMail_SendMailgun_apiURL(t *testing.T) {
+	var path string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"<1@mg.here.com>","message":"Queued. Thank you."}`))
+	}))
+	defer srv.Close()
+
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", API: "mailgun", Domain: "mg.here.com", APIKey: "key", APIUrl: srv.URL + "/v3", HTTPClient: srv.Client()}
+	if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v3/mg.here.com/messages" {
+		t.Errorf("sent to %q, want the api at APIUrl", path)
+	}
+}
+
+func TestMail_InvalidMessages(t *testing.T) {
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com"}
+
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"no recipients", Message{Subject: "test", Template: "test"}},
+		{"bad to", Message{To: []string{"nobody"}, Template: "test"}},
+		{"bad cc", Message{To: []string{"you@there.com"}, CC: []string{"@"}, Template: "test"}},
+		{"bad reply-to", Message{To: []string{"you@there.com"}, ReplyTo: "x", Template: "test"}},
+	}
+
+	for _, tt := range tests {
+		_, err := m.compose(tt.msg)
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", tt.name, err)
+		}
+		if IsTransient(err) {
+			t.Errorf("%s: expected a permanent error", tt.name)
+		}
+	}
+}
+
+func TestMail_ComposeAttachments(t *testing.T) {
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", FromName: "Joe"}
+
+	c, err := m.compose(Message{
+		To:          []string{"you@there.com"},
+		Template:    "test",
+		Attachments: []string{"./testdata/mail/test.plain.tmpl"},
+		Files:       []File{{Name: "logo.png", Data: []byte("\x89PNG\r\n\x1a\n")}, {Name: "data", Data: []byte("hello")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Sender() != `"Joe" <me@here.com>` {
+		t.Errorf("unexpected from %q", c.Sender())
+	}
+	if len(c.Files) != 3 || c.Files[0].Name != "test.plain.tmpl" || len(c.Files[0].Data) == 0 {
+		t.Fatalf("unexpected files %+v", c.Files)
+	}
+	if got := c.Files[1].mimeType(); got != "image/png" {
+		t.Errorf("expected image/png, got %s", got)
+	}
+	if got := c.Files[2].mimeType(); got != "text/plain; charset=utf-8" {
+		t.Errorf("expected text/plain, got %s", got)
+	}
+}
@@ -0,0 +1,304 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mail sent with the log or file api is captured rather than sent: each message
+// is rendered as it would be sent, and written to CaptureDir as an .eml file that
+// any mail client can open. The log api also logs a line for every message. No
+// mail server is needed, so this suits development and tests, which can read
+// the messages back with Captured.
+
+// captureExt is the extension of captured messages
+const captureExt = ".eml"
+
+// CapturedMail is a message written to CaptureDir. From, To, CC and BCC hold bare
+// addresses; Header holds every header as it was written.
+type CapturedMail struct {
+	ID          string
+	Path        string
+	Header      netmail.Header
+	From        string
+	To          []string
+	CC          []string
+	BCC         []string
+	Subject     string
+	Date        time.Time
+	HTML        string
+	Plain       string
+	Attachments []File
+}
+
+// captureDir returns the directory captured mail is written to
+func (m *Mail) captureDir() string {
+	if m.CaptureDir != "" {
+		return m.CaptureDir
+	}
+	return filepath.Join("tmp", "mail")
+}
+
+// logger returns the logger the log api reports to
+func (m *Mail) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return slog.Default()
+}
+
+// captureTransport writes mail to dir, exactly as it would be sent over smtp,
+// and logs every message when log is set. The BCC addresses, which smtp leaves out
+// of the message, are written as a Bcc header, so that they can be checked too.
+type captureTransport struct {
+	dir    string
+	log    bool
+	logger *slog.Logger
+}
+
+var (
+	_ Transport = (*captureTransport)(nil)
+	_ Pinger    = (*captureTransport)(nil)
+)
+
+func newCaptureTransport(m *Mail) (Transport, error) {
+	return &captureTransport{dir: m.captureDir(), log: m.API == "log", logger: m.logger()}, nil
+}
+
+func (t *captureTransport) Send(ctx context.Context, c *Composed) error {
+	email, err := c.email()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if len(c.BCC) > 0 {
+		fmt.Fprintf(&buf, "Bcc: %s\r\n", strings.Join(c.BCC, ", "))
+	}
+	buf.WriteString(email.GetMessage())
+
+	dir := t.dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	id, err := captureID(time.Now())
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, id+captureExt)
+
+	// the message is written under another name first, so that nothing reading
+	// the directory sees half of it
+	tmp, err := os.CreateTemp(dir, ".capture-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if t.log {
+		t.logger.Info("mail captured", "to", c.To, "subject", c.Subject, "file", path)
+	}
+	return nil
+}
+
+// Ping checks that the directory mail is written to can be created
+func (t *captureTransport) Ping(ctx context.Context) error {
+	return os.MkdirAll(t.dir, 0755)
+}
+
+// captureID returns an id for a message captured at t. Ids sort in the order the
+// messages were captured.
+func captureID(t time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return t.UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// Captured returns the messages in CaptureDir, newest first
+func (m *Mail) Captured() ([]*CapturedMail, error) {
+	entries, err := os.ReadDir(m.captureDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var captured []*CapturedMail
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != captureExt {
+			continue
+		}
+		c, err := m.ReadCaptured(strings.TrimSuffix(name, captureExt))
+		if err != nil {
+			return nil, err
+		}
+		captured = append(captured, c)
+	}
+
+	sort.Slice(captured, func(i, j int) bool { return captured[i].ID > captured[j].ID })
+	return captured, nil
+}
+
+// ReadCaptured reads the captured message with the given id. The error wraps
+// fs.ErrNotExist when there is none.
+func (m *Mail) ReadCaptured(id string) (*CapturedMail, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("mailer: no captured mail %q: %w", id, fs.ErrNotExist)
+	}
+	path := filepath.Join(m.captureDir(), id+captureExt)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := parseCaptured(data)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: reading %s: %w", path, err)
+	}
+	c.ID = id
+	c.Path = path
+	return c, nil
+}
+
+// ClearCaptured removes every captured message
+func (m *Mail) ClearCaptured() error {
+	matches, err := filepath.Glob(filepath.Join(m.captureDir(), "*"+captureExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCaptured parses a message written by capture
+func parseCaptured(data []byte) (*CapturedMail, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CapturedMail{Header: msg.Header}
+	dec := new(mime.WordDecoder)
+	if c.Subject, err = dec.DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		return nil, err
+	}
+	if from, err := netmail.ParseAddress(msg.Header.Get("From")); err == nil {
+		c.From = from.Address
+	}
+	for _, list := range []struct {
+		header string
+		dst    *[]string
+	}{{"To", &c.To}, {"Cc", &c.CC}, {"Bcc", &c.BCC}} {
+		addresses, err := msg.Header.AddressList(list.header)
+		if errors.Is(err, netmail.ErrHeaderNotPresent) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addresses {
+			*list.dst = append(*list.dst, a.Address)
+		}
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		c.Date = date
+	}
+
+	if err := c.readPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Header.Get("Content-Disposition"), msg.Body); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readPart reads a part of a captured message, and the parts nested in it, into
+// the bodies and attachments of c
+func (c *CapturedMail) readPart(contentType, encoding, disposition string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// quoted-printable parts are decoded by the reader, which drops their
+			// Content-Transfer-Encoding
+			h := p.Header
+			if err := c.readPart(h.Get("Content-Type"), h.Get("Content-Transfer-Encoding"), h.Get("Content-Disposition"), p); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	if d, dparams, err := mime.ParseMediaType(disposition); err == nil && (d == "attachment" || dparams["filename"] != "") {
+		name := dparams["filename"]
+		if name == "" {
+			name = params["name"]
+		}
+		c.Attachments = append(c.Attachments, File{Name: name, ContentType: mediaType, Data: content})
+		return nil
+	}
+
+	switch {
+	case mediaType == "text/html" && c.HTML == "":
+		c.HTML = string(content)
+	case mediaType == "text/plain" && c.Plain == "":
+		c.Plain = string(content)
+	default:
+		c.Attachments = append(c.Attachments, File{Name: params["name"], ContentType: mediaType, Data: content})
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/go-boilme/urlsigner"
+)
+
+// newCaptureMail returns a Mail capturing the messages it sends in a temporary
+// directory, rendered from the templates in testdata/layout
+func newCaptureMail(t *testing.T, api string) *Mail {
+	t.Helper()
+	return &Mail{
+		API:         api,
+		CaptureDir:  t.TempDir(),
+		Templates:   "./testdata/layout",
+		FromAddress: "me@here.com",
+		FromName:    "Joe",
+		Signer:      &urlsigner.Signer{Secret: []byte("secret")},
+	}
+}
+
+func TestMail_capture(t *testing.T) {
+	m := newCaptureMail(t, "file")
+
+	msg := Message{
+		To:        []string{"alice@example.com", "bob@example.com"},
+		CC:        []string{"team@example.com"},
+		BCC:       []string{"audit@example.com"},
+		ReplyTo:   "support@example.com",
+		MessageID: "<1@example.com>",
+		Headers:   map[string]string{"X-Campaign": "welcome"},
+		Template:  "welcome",
+		Data:      map[string]interface{}{"Name": "Jürgen", "Joined": "2024-03-05T00:00:00Z", "Link": "https://example.com"},
+		Files:     []File{{Name: "report.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")}},
+	}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	captured, err := m.Captured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("expected 1 captured message, got %d", len(captured))
+	}
+	c := captured[0]
+
+	if filepath.Dir(c.Path) != m.CaptureDir || filepath.Ext(c.Path) != ".eml" {
+		t.Errorf("message written to %s", c.Path)
+	}
+	if c.From != "me@here.com" || c.Subject != "Welcome, Jürgen & friends" {
+		t.Errorf("from %q, subject %q", c.From, c.Subject)
+	}
+	for name, tt := range map[string]struct{ got, want []string }{
+		"to":  {c.To, msg.To},
+		"cc":  {c.CC, msg.CC},
+		"bcc": {c.BCC, msg.BCC},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s is %v, want %v", name, tt.got, tt.want)
+		}
+	}
+	for header, want := range map[string]string{"X-Campaign": "welcome", "Message-Id": "<1@example.com>", "Reply-To": "<support@example.com>"} {
+		if got := c.Header.Get(header); got != want {
+			t.Errorf("%s is %q, want %q", header, got, want)
+		}
+	}
+	if c.Date.IsZero() {
+		t.Error("the message has no date")
+	}
+	if !strings.Contains(c.HTML, "Hello Jürgen, you joined on 5 Mar 2024") || !strings.Contains(c.HTML, "<body>") {
+		t.Errorf("unexpected html:\n%s", c.HTML)
+	}
+	if !strings.Contains(c.Plain, "Hello Jürgen, you joined on 5 Mar 2024") {
+		t.Errorf("unexpected plain text:\n%s", c.Plain)
+	}
+	if len(c.Attachments) != 1 || c.Attachments[0].Name != "report.csv" || string(c.Attachments[0].Data) != "a,b\n1,2\n" {
+		t.Errorf("unexpected attachments: %+v", c.Attachments)
+	}
+
+	read, err := m.ReadCaptured(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Subject != c.Subject {
+		t.Errorf("read back %q", read.Subject)
+	}
+}
+
+func TestMail_capture_log(t *testing.T) {
+	m := newCaptureMail(t, "log")
+	var buf bytes.Buffer
+	m.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	err := m.Send(Message{To: []string{"you@there.com"}, Subject: "Hi", Template: "welcome", Data: map[string]interface{}{"Joined": "2024-03-05T00:00:00Z", "Link": "https://example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captured, err := m.Captured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("expected 1 captured message, got %d", len(captured))
+	}
+	for _, want := range []string{"mail captured", "you@there.com", "subject=Hi", captured[0].Path} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("the log does not contain %q: %s", want, buf.String())
+		}
+	}
+}
+
+func TestMail_capture_invalid(t *testing.T) {
+	m := newCaptureMail(t, "file")
+
+	err := m.Send(Message{To: []string{"not_an_email_address"}, Template: "welcome"})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected an invalid message error, got %v", err)
+	}
+	if captured, _ := m.Captured(); len(captured) != 0 {
+		t.Errorf("an invalid message was captured: %+v", captured)
+	}
+}
+
+func TestMail_Captured(t *testing.T) {
+	m := newCaptureMail(t, "file")
+
+	// nothing has been captured before the directory exists
+	m.CaptureDir = filepath.Join(m.CaptureDir, "mail")
+	if captured, err := m.Captured(); err != nil || len(captured) != 0 {
+		t.Fatalf("got %v, %v", captured, err)
+	}
+
+	for _, subject := range []string{"first", "second", "third"} {
+		if err := m.Send(Message{To: []string{"you@there.com"}, Subject: subject, Template: "welcome", Data: map[string]interface{}{"Joined": "2024-03-05T00:00:00Z", "Link": "https://example.com"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// other files are left alone
+	if err := os.WriteFile(filepath.Join(m.CaptureDir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	captured, err := m.Captured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, c := range captured {
+		subjects = append(subjects, c.Subject)
+	}
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(subjects, want) {
+		t.Errorf("captured %v, want the newest first %v", subjects, want)
+	}
+
+	for _, id := range []string{"", "missing", "../mail/" + captured[0].ID, ".capture-1"} {
+		if _, err := m.ReadCaptured(id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("reading %q: expected a not found error, got %v", id, err)
+		}
+	}
+
+	if err := m.ClearCaptured(); err != nil {
+		t.Fatal(err)
+	}
+	if captured, _ := m.Captured(); len(captured) != 0 {
+		t.Errorf("%d messages left after clearing", len(captured))
+	}
+	if _, err := os.Stat(filepath.Join(m.CaptureDir, "notes.txt")); err != nil {
+		t.Errorf("clearing removed other files: %v", err)
+	}
+}
+
+func TestMail_Ping_capture(t *testing.T) {
+	m := newCaptureMail(t, "file")
+	m.CaptureDir = filepath.Join(m.CaptureDir, "tmp", "mail")
+
+	if err := m.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(m.CaptureDir); err != nil || !fi.IsDir() {
+		t.Errorf("the capture directory was not created: %v", err)
+	}
+}
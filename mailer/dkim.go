@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// DKIMSigner signs outgoing mail with DKIM for Domain. The public half of Key is
+// published in dns, as a TXT record at <Selector>._domainkey.<Domain>. Headers
+// are the header fields signed; nil means those of dkimHeaders. Those missing
+// from a message are signed as missing, so they cannot be added on the way.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+	Headers  []string
+}
+
+// dkimHeaders are the header fields signed by default: those a relay has no reason
+// to change, and that a reader sees
+var dkimHeaders = []string{
+	"From", "Reply-To", "Sender", "To", "Cc", "Subject", "Date", "Message-Id",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding", "List-Unsubscribe",
+}
+
+// LoadDKIMSigner reads the PEM private key at keyPath, an RSA or Ed25519 key in
+// PKCS #1 or PKCS #8, to sign mail for domain with selector
+func LoadDKIMSigner(domain, selector, keyPath string) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("mailer: dkim needs a domain and a selector")
+	}
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: dkim key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("mailer: dkim key: %T cannot sign", key)
+	}
+	switch signer.Public().(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("mailer: dkim key: only rsa and ed25519 keys are supported, not %T", signer.Public())
+	}
+	return &DKIMSigner{Domain: domain, Selector: selector, Key: signer}, nil
+}
+
+// Sign returns msg, a whole message with CRLF line endings, with a DKIM-Signature
+// header added in front of it. Headers and body are canonicalized as relaxed, so
+// that the signature survives relays that refold headers or trim spaces.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	headers := s.Headers
+	if headers == nil {
+		headers = dkimHeaders
+	}
+	options := &dkim.SignOptions{
+		Domain:                 s.Domain,
+		Selector:               s.Selector,
+		Signer:                 s.Key,
+		Hash:                   crypto.SHA256,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             headers,
+	}
+
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, bytes.NewReader(msg), options); err != nil {
+		return nil, fmt.Errorf("mailer: dkim: %w", err)
+	}
+	return signed.Bytes(), nil
+}
+
+// loadPrivateKey reads the first private key of the PEM file at path, in PKCS #1,
+// PKCS #8 or SEC 1
+func loadPrivateKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no private key in %s", path)
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// writeKey writes key to a PEM file in PKCS #8, and returns its path
+func writeKey(t *testing.T, key crypto.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// verifyDKIM verifies the DKIM signature of msg, looking up the key of the
+// selector in records rather than in dns
+func verifyDKIM(t *testing.T, msg []byte, records map[string]string) error {
+	t.Helper()
+	options := &dkim.VerifyOptions{LookupTXT: func(domain string) ([]string, error) {
+		return []string{records[domain]}, nil
+	}}
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(msg), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("found %d dkim signatures, want 1", len(verifications))
+	}
+	return verifications[0].Err
+}
+
+func TestSMTPTransport_dkim(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]string{
+		"mail._domainkey.here.com": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(public),
+	}
+
+	s := newFakeSMTPServer(t)
+	tr := s.transport()
+	m := &Mail{
+		Domain: "here.com", Templates: "./testdata/mail", FromAddress: "me@here.com",
+		Host: tr.Host, Port: tr.Port, Encryption: "none",
+		DKIMSelector: "mail", DKIMKeyFile: writeKey(t, key),
+	}
+	if err := m.SendSMTPMessage(Message{To: []string{"you@there.com"}, Subject: "signed", Template: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := s.message()
+	if !bytes.HasPrefix(msg, []byte("DKIM-Signature:")) {
+		t.Fatalf("message is not signed:\n%s", msg)
+	}
+	if err := verifyDKIM(t, msg, records); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+
+	tampered := bytes.Replace(msg, []byte("Subject: signed"), []byte("Subject: forged"), 1)
+	if err := verifyDKIM(t, tampered, records); err == nil {
+		t.Error("signature of a changed subject verifies")
+	}
+}
+
+func TestDKIMSigner_ed25519(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := LoadDKIMSigner("here.com", "ed", writeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Composed{Message: newTransportMessage(), HTML: "<p>hello</p>", Plain: "hello"}
+	c.From = "me@here.com"
+	raw, err := c.MIME()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := signer.Sign(crlf(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]string{
+		"ed._domainkey.here.com": "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public),
+	}
+	if err := verifyDKIM(t, msg, records); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
+
+func TestLoadDKIMSigner_errors(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeKey(t, key)
+
+	tests := []struct {
+		name     string
+		domain   string
+		selector string
+		keyPath  string
+	}{
+		{"no domain", "", "mail", path},
+		{"no selector", "here.com", "", path},
+		{"missing key", "here.com", "mail", filepath.Join(t.TempDir(), "missing.pem")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadDKIMSigner(tt.domain, tt.selector, tt.keyPath); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
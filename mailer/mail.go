@@ -1,16 +1,24 @@
 package mailer
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
-	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	netmail "net/mail"
+	"os"
 	"path/filepath"
 	"time"
 
-	apimail "github.com/ainsleyclark/go-mail"
+	"github.com/bxtal-lsn/go-boilme/urlsigner"
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Mail holds the information necessary to connect to an SMTP server
@@ -29,192 +37,452 @@ type Mail struct {
 	API         string
 	APIKey      string
 	APIUrl      string
+
+	// HTTPClient is used to call mail apis; nil means http.DefaultClient
+	HTTPClient *http.Client
+
+	// Transport, if set, sends every message, instead of a transport created from
+	// API for each message. A transport kept here can reuse its connections.
+	Transport Transport
+
+	// Observe, if set, is called after every attempt to send a message from Jobs
+	// or the outbox, typically to record metrics
+	Observe func(msg Message, err error, elapsed time.Duration)
+
+	// OnResult, if set, is called with the result of every message from Jobs, once
+	// it has been sent or has failed for good
+	OnResult func(Result)
+
+	// Outbox, if set, stores the messages received on Jobs and sends them in the
+	// background, retrying them when sending fails
+	Outbox *Outbox
+
+	// Tracer, if set, records an OpenTelemetry span for every message sent
+	Tracer trace.Tracer
+
+	// Funcs are added to the functions available to mail templates, and may
+	// replace the built in signURL, formatDate and locale
+	Funcs template.FuncMap
+
+	// Signer signs the urls passed to signURL in mail templates
+	Signer *urlsigner.Signer
+
+	// TemplateCache, if set, keeps templates once they have been parsed. Without
+	// it, they are parsed for every message, so that changes show up at once.
+	TemplateCache *TemplateCache
+
+	// CaptureDir is where mail is written when API is log or file; empty means
+	// tmp/mail in the working directory
+	CaptureDir string
+
+	// Logger is where the log api reports the mail it captures, and WebhookHandler
+	// reports the events it could not handle; nil means slog.Default()
+	Logger *slog.Logger
+
+	// Suppressions, if set, lists addresses that are never sent mail. Recipients
+	// on it are dropped from every message, and WebhookHandler adds the addresses
+	// that bounce for good or complain about spam.
+	Suppressions SuppressionList
+
+	// WebhookKey verifies the signature of webhooks: it is the webhook signing key
+	// of mailgun, or the verification key of the signed event webhook of sendgrid
+	WebhookKey string
+
+	// WebhookAuth, as user:password, is the basic auth the api must send with every
+	// webhook. Sparkpost and the inbound parse of sendgrid do not sign their
+	// webhooks, so it is required to receive them.
+	WebhookAuth string
+
+	// OnEvent is called with every event received by WebhookHandler. When it
+	// returns an error, the webhook fails, and the api sends it again later.
+	OnEvent func(ctx context.Context, e Event) error
+
+	// DKIMSelector and DKIMKeyFile, the PEM private key of the selector, have the
+	// smtp transport sign every message with DKIM for DKIMDomain, or for Domain
+	// when DKIMDomain is empty
+	DKIMDomain   string
+	DKIMSelector string
+	DKIMKeyFile  string
+
+	// SMIMECertFile and SMIMEKeyFile are the PEM certificate and private key the
+	// smtp transport signs the messages that ask for it with S/MIME
+	SMIMECertFile string
+	SMIMEKeyFile  string
 }
 
-// Message is the type for an email message
+// ErrInvalidMessage is wrapped by the errors returned for messages that can never be
+// sent as they are, such as those without recipients or with malformed addresses
+var ErrInvalidMessage = errors.New("mailer: invalid message")
+
+// Message is the type for an email message. To, CC and BCC may each hold any number
+// of addresses, but every message needs at least one recipient. From and FromName
+// default to those of the Mail sending it. MessageID, if set, is sent as the
+// Message-ID header, and Headers are added to the message as they are.
+// Attachments are the paths of files to attach, and Files are attached from memory.
+// Locale picks a variant of Template, such as welcome.de.html.tmpl, when there is
+// one. When Subject is empty, it is taken from the "subject" template. SMIME, if
+// set, signs or encrypts the message; only the smtp transport can.
 type Message struct {
 	From        string
 	FromName    string
-	To          string
+	To          []string
+	CC          []string
+	BCC         []string
+	ReplyTo     string
 	Subject     string
 	Template    string
+	Locale      string
+	Headers     map[string]string
+	MessageID   string
 	Attachments []string
+	Files       []File
 	Data        interface{}
+	SMIME       *SMIME
+
+	ctx context.Context
+
+	// flushed, if set, marks a message sent by Flush, which is closed rather than sent
+	flushed chan struct{}
+}
+
+// File is an attachment held in memory. When ContentType is empty, it is guessed
+// from the extension of Name, or else from Data.
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// mimeType returns the content type of f
+func (f File) mimeType() string {
+	if f.ContentType != "" {
+		return f.ContentType
+	}
+	if t := mime.TypeByExtension(filepath.Ext(f.Name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(f.Data)
 }
 
-// Result contains information regarding the status of the sent email message
+// WithContext returns a copy of msg carrying ctx. When the message is sent, its
+// span is a child of the span in ctx, even if it was queued on Jobs.
+func (msg Message) WithContext(ctx context.Context) Message {
+	msg.ctx = ctx
+	return msg
+}
+
+// Context returns the context of msg, or context.Background if it has none
+func (msg Message) Context() context.Context {
+	if msg.ctx != nil {
+		return msg.ctx
+	}
+	return context.Background()
+}
+
+// Result contains information regarding the status of the sent email message. ID
+// is the id of the message in the outbox, if it was sent from one.
 type Result struct {
 	Success bool
 	Error   error
+	ID      string
 }
 
 // ListenForMail listens to the mail channel and sends mail
 // when it receives a payload. It runs continually in the background,
-// and sends error/success messages back on the Results channel.
-// Note that if api and api key are set, it will prefer using
-// an api to send mail
+// and reports every result to OnResult, and on the Results channel if
+// there is room in it; results are dropped rather than waiting for a reader.
+// When Outbox is set, messages are saved to it and sent by its workers
+// instead, and their results are reported once they are sent or have failed for good.
+// Messages are sent as Send sends them. ListenForMail returns once the Jobs channel
+// has been closed and every message still in it has been sent, or saved to the outbox.
 func (m *Mail) ListenForMail() {
-	for {
-		msg := <-m.Jobs
-		err := m.Send(msg)
-		if err != nil {
-			m.Results <- Result{false, err}
-		} else {
-			m.Results <- Result{true, nil}
+	for msg := range m.Jobs {
+		if msg.flushed != nil {
+			close(msg.flushed)
+			continue
 		}
+
+		if m.Outbox != nil {
+			if _, err := m.Outbox.Enqueue(msg.Context(), msg); err != nil {
+				m.report(Result{Success: false, Error: err})
+			}
+			continue
+		}
+
+		err := m.deliver(msg)
+		m.report(Result{Success: err == nil, Error: err})
 	}
 }
 
-// Send sends an email message using correct method. If API values are set,
-// it will send using the appropriate api; otherwise, it sends via smtp
-func (m *Mail) Send(msg Message) error {
-	if len(m.API) > 0 && len(m.APIKey) > 0 && len(m.APIUrl) > 0 && m.API != "smtp" {
-		return m.ChooseAPI(msg)
+// Flush waits until ListenForMail has handled every message put on Jobs before
+// Flush was called, either sending it or saving it to the outbox. Unlike closing
+// Jobs, it leaves the channel open, so that mail can still be queued afterwards.
+func (m *Mail) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case m.Jobs <- Message{flushed: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return m.SendSMTPMessage(msg)
 }
 
-// ChooseAPI chooses api to use (specified in .env)
-func (m *Mail) ChooseAPI(msg Message) error {
-	switch m.API {
-	case "mailgun", "sparkpost", "sendgrid":
-		return m.SendUsingAPI(msg, m.API)
-	default:
-		return fmt.Errorf("unknown api %s; only mailgun, sparkpost or sendgrid accepted", m.API)
+// deliver sends msg, and reports the attempt to Observe
+func (m *Mail) deliver(msg Message) error {
+	start := time.Now()
+	err := m.Send(msg)
+	if m.Observe != nil {
+		m.Observe(msg, err, time.Since(start))
 	}
+	return err
 }
 
-// SendUsingAPI sends a message using the appropriate API. It can be called directly, if necessary.
-// transport can be one of sparkpost, sendgrid, or mailgun
-func (m *Mail) SendUsingAPI(msg Message, transport string) error {
-	if msg.From == "" {
-		msg.From = m.FromAddress
+// report passes res to OnResult, and to Results if a reader has left room for it
+func (m *Mail) report(res Result) {
+	if m.OnResult != nil {
+		m.OnResult(res)
+	}
+	if m.Results == nil {
+		return
+	}
+	select {
+	case m.Results <- res:
+	default:
 	}
+}
 
-	if msg.FromName == "" {
-		msg.FromName = m.FromName
+// Send sends an email message with Transport, or, when it is nil, with the
+// transport registered for API, or over smtp when API is empty. Recipients on
+// Suppressions are dropped first.
+func (m *Mail) Send(msg Message) error {
+	if m.Tracer == nil {
+		return m.send(msg)
 	}
 
-	cfg := apimail.Config{
-		URL:         m.APIUrl,
-		APIKey:      m.APIKey,
-		Domain:      m.Domain,
-		FromAddress: msg.From,
-		FromName:    msg.FromName,
+	_, span := m.Tracer.Start(msg.Context(), "mail.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("mail.transport", m.transportName()),
+			attribute.String("mail.template", msg.Template),
+		),
+	)
+	defer span.End()
+
+	err := m.send(msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	return err
+}
 
-	driver, err := apimail.NewClient(transport, cfg)
+func (m *Mail) send(msg Message) error {
+	msg, err := m.suppress(msg)
 	if err != nil {
 		return err
 	}
 
-	formattedMessage, err := m.buildHTMLMessage(msg)
+	t := m.Transport
+	if t == nil {
+		if t, err = m.NewTransport(); err != nil {
+			return err
+		}
+		defer closeTransport(t)
+	}
+	return m.sendWith(t, msg)
+}
+
+// sendWith composes msg and sends it with t
+func (m *Mail) sendWith(t Transport, msg Message) error {
+	c, err := m.compose(msg)
 	if err != nil {
 		return err
 	}
+	if c.SMIME != nil && !appliesSMIME(t) {
+		// sent without it, an encrypted message would go out readable by anyone
+		return fmt.Errorf("%w: s/mime is only applied by the smtp transport", ErrInvalidMessage)
+	}
+
+	ctx, cancel := context.WithTimeout(msg.Context(), sendTimeout)
+	defer cancel()
+	return t.Send(ctx, c)
+}
+
+// ChooseAPI sends msg with the transport registered for API
+func (m *Mail) ChooseAPI(msg Message) error {
+	return m.SendUsingAPI(msg, m.API)
+}
 
-	plainMessage, err := m.buildPlainTextMessage(msg)
+// SendUsingAPI sends msg with the transport registered as transport, such as
+// sparkpost, sendgrid, mailgun, ses, postmark or http, regardless of API. It can
+// be called directly, if necessary.
+func (m *Mail) SendUsingAPI(msg Message, transport string) error {
+	t, err := m.newTransport(transport)
 	if err != nil {
 		return err
 	}
+	defer closeTransport(t)
+	return m.sendWith(t, msg)
+}
 
-	tx := &apimail.Transmission{
-		Recipients: []string{msg.To},
-		Subject:    msg.Subject,
-		HTML:       formattedMessage,
-		PlainText:  plainMessage,
+// Composed is a message ready to be handed to a Transport: its defaults are filled
+// in, its addresses checked and its templates rendered. Its Attachments have been
+// read into Files, which holds every file to attach.
+type Composed struct {
+	Message
+	HTML  string
+	Plain string
+}
+
+// compose prepares msg to be sent
+func (m *Mail) compose(msg Message) (*Composed, error) {
+	if msg.From == "" {
+		msg.From = m.FromAddress
+	}
+	if msg.FromName == "" {
+		msg.FromName = m.FromName
+	}
+	if err := validate(msg); err != nil {
+		return nil, err
 	}
 
-	// add attachments
-	err = m.addAPIAttachments(msg, tx)
+	html, plain, subject, err := m.render(msg.Template, msg.Locale, msg.Data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	c := &Composed{Message: msg, HTML: html, Plain: plain}
+	if c.Subject == "" {
+		c.Subject = subject
 	}
 
-	_, err = driver.Send(tx)
-	if err != nil {
-		return err
+	c.Files = nil
+	for _, x := range msg.Attachments {
+		content, err := os.ReadFile(x)
+		if err != nil {
+			return nil, err
+		}
+		c.Files = append(c.Files, File{Name: filepath.Base(x), Data: content})
 	}
+	c.Files = append(c.Files, msg.Files...)
+	c.Attachments = nil
 
-	return nil
+	return c, nil
 }
 
-// addAPIAttachments adds attachments, if any, to mail being sent via api
-func (m *Mail) addAPIAttachments(msg Message, tx *apimail.Transmission) error {
-	if len(msg.Attachments) > 0 {
-		var attachments []apimail.Attachment
+// render renders the html and plain text templates for name in locale with data,
+// and the subject they define, if any
+func (m *Mail) render(name, locale string, data interface{}) (html, plain, subject string, err error) {
+	htmlTmpl, err := m.lookupTemplate(kindHTML, name, locale)
+	if err != nil {
+		return "", "", "", err
+	}
+	plainTmpl, err := m.lookupTemplate(kindPlain, name, locale)
+	if err != nil {
+		return "", "", "", err
+	}
 
-		for _, x := range msg.Attachments {
-			var attach apimail.Attachment
-			content, err := ioutil.ReadFile(x)
-			if err != nil {
-				return err
-			}
+	if html, err = m.renderHTML(htmlTmpl, data); err != nil {
+		return "", "", "", err
+	}
+	if plain, err = plainTmpl.render(data); err != nil {
+		return "", "", "", err
+	}
 
-			fileName := filepath.Base(x)
-			attach.Bytes = content
-			attach.Filename = fileName
-			attachments = append(attachments, attach)
+	// the subject of the plain text version is preferred, as it is not escaped
+	for _, t := range []*parsedTemplate{plainTmpl, htmlTmpl} {
+		s, ok, err := t.subject(data)
+		if err != nil {
+			return "", "", "", err
+		}
+		if ok {
+			return html, plain, s, nil
 		}
+	}
+	return html, plain, "", nil
+}
 
-		tx.Attachments = attachments
+// validate checks that msg has at least one recipient, and that all of its
+// addresses are well formed
+func validate(msg Message) error {
+	if len(msg.To)+len(msg.CC)+len(msg.BCC) == 0 {
+		return fmt.Errorf("%w: no recipients", ErrInvalidMessage)
 	}
 
+	addresses := append(append(append([]string{msg.From}, msg.To...), msg.CC...), msg.BCC...)
+	if msg.ReplyTo != "" {
+		addresses = append(addresses, msg.ReplyTo)
+	}
+	for _, a := range addresses {
+		if _, err := netmail.ParseAddress(a); err != nil {
+			return fmt.Errorf("%w: address %q: %v", ErrInvalidMessage, a, err)
+		}
+	}
 	return nil
 }
 
-// SendSMTPMessage builds and sends an email message using SMTP. This is called by ListenForMail,
-// and can also be called directly when necessary
-func (m *Mail) SendSMTPMessage(msg Message) error {
-	formattedMessage, err := m.buildHTMLMessage(msg)
-	if err != nil {
-		return err
-	}
-
-	plainMessage, err := m.buildPlainTextMessage(msg)
-	if err != nil {
-		return err
+// Sender returns the From header of c: its address, with its name if it has one
+func (c *Composed) Sender() string {
+	if c.FromName == "" {
+		return c.From
 	}
+	return (&netmail.Address{Name: c.FromName, Address: c.From}).String()
+}
 
-	server := mail.NewSMTPClient()
-	server.Host = m.Host
-	server.Port = m.Port
-	server.Username = m.Username
-	server.Password = m.Password
-	server.Encryption = m.getEncryption(m.Encryption)
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
+// SendSMTPMessage builds and sends an email message using SMTP, over a connection
+// of its own. This can be called directly when necessary.
+func (m *Mail) SendSMTPMessage(msg Message) error {
+	return m.SendUsingAPI(msg, "smtp")
+}
 
-	smtpClient, err := server.Connect()
+// MIME returns c as it is sent over smtp. Like over smtp, its BCC addresses are
+// left out of its headers.
+func (c *Composed) MIME() ([]byte, error) {
+	email, err := c.email()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return []byte(email.GetMessage()), nil
+}
 
+// email builds the mime message for c
+func (c *Composed) email() (*mail.Email, error) {
 	email := mail.NewMSG()
-	email.SetFrom(msg.From).
-		AddTo(msg.To).
-		SetSubject(msg.Subject)
+	email.SetFrom(c.Sender()).
+		AddTo(c.To...).
+		AddCc(c.CC...).
+		AddBcc(c.BCC...).
+		SetSubject(c.Subject)
+	if c.ReplyTo != "" {
+		email.SetReplyTo(c.ReplyTo)
+	}
+	if c.MessageID != "" {
+		email.AddHeader("Message-ID", c.MessageID)
+	}
+	for k, v := range c.Headers {
+		email.AddHeader(k, v)
+	}
 
-	email.SetBody(mail.TextHTML, formattedMessage)
-	email.AddAlternative(mail.TextPlain, plainMessage)
+	email.SetBody(mail.TextHTML, c.HTML)
+	email.AddAlternative(mail.TextPlain, c.Plain)
 
-	if len(msg.Attachments) > 0 {
-		for _, x := range msg.Attachments {
-			email.AddAttachment(x)
-		}
+	for _, f := range c.Files {
+		email.Attach(&mail.File{Name: f.Name, MimeType: f.mimeType(), Data: f.Data})
 	}
 
-	err = email.Send(smtpClient)
-	if err != nil {
-		return err
+	if email.Error != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, email.Error)
 	}
-
-	return nil
+	return email, nil
 }
 
 // getEncryption returns the appropriate encryption type based on a string value
-func (m *Mail) getEncryption(e string) mail.Encryption {
+func getEncryption(e string) mail.Encryption {
 	switch e {
 	case "tls":
 		return mail.EncryptionSTARTTLS
@@ -229,19 +497,20 @@ func (m *Mail) getEncryption(e string) mail.Encryption {
 
 // buildHTMLMessage creates the html version of the message
 func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	templateToRender := fmt.Sprintf("%s/%s.html.tmpl", m.Templates, msg.Template)
-
-	t, err := template.New("email-html").ParseFiles(templateToRender)
+	t, err := m.lookupTemplate(kindHTML, msg.Template, msg.Locale)
 	if err != nil {
 		return "", err
 	}
+	return m.renderHTML(t, msg.Data)
+}
 
-	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.Data); err != nil {
+// renderHTML renders the html template t with data, and inlines its css
+func (m *Mail) renderHTML(t *parsedTemplate, data interface{}) (string, error) {
+	formattedMessage, err := t.render(data)
+	if err != nil {
 		return "", err
 	}
 
-	formattedMessage := tpl.String()
 	formattedMessage, err = m.inlineCSS(formattedMessage)
 	if err != nil {
 		return "", err
@@ -252,21 +521,12 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 
 // buildPlainTextMessage creates the plaintext version of the message
 func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	templateToRender := fmt.Sprintf("%s/%s.plain.tmpl", m.Templates, msg.Template)
-
-	t, err := template.New("email-html").ParseFiles(templateToRender)
+	t, err := m.lookupTemplate(kindPlain, msg.Template, msg.Locale)
 	if err != nil {
 		return "", err
 	}
 
-	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.Data); err != nil {
-		return "", err
-	}
-
-	plainMessage := tpl.String()
-
-	return plainMessage, nil
+	return t.render(msg.Data)
 }
 
 // inlineCSS takes html input as a string, and inlines css where possible
@@ -289,3 +549,23 @@ func (m *Mail) inlineCSS(s string) (string, error) {
 
 	return html, nil
 }
+
+// Ping checks that mail can be handed off, with Transport or the transport for
+// API, if it can be checked: the apis are connected to, and the smtp server is
+// waited on for its greeting. No mail is sent. When mail is captured, it checks
+// that CaptureDir can be created.
+func (m *Mail) Ping(ctx context.Context) error {
+	t := m.Transport
+	if t == nil {
+		var err error
+		if t, err = m.NewTransport(); err != nil {
+			return err
+		}
+		defer closeTransport(t)
+	}
+
+	if p, ok := t.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
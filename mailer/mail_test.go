@@ -7,10 +7,12 @@ import (
 
 
 func TestMail_SendSMTPMessage(t *testing.T) {
+	requireMailhog(t)
+
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -23,10 +25,12 @@ func TestMail_SendSMTPMessage(t *testing.T) {
 }
 
 func TestMail_SendUsingChan(t *testing.T) {
+	requireMailhog(t)
+
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -38,7 +42,7 @@ func TestMail_SendUsingChan(t *testing.T) {
 		t.Error(errors.New("failed to send over channel"))
 	}
 
-	msg.To = "not_an_email_address"
+	msg.To = []string{"not_an_email_address"}
 	mailer.Jobs <- msg
 	res = <-mailer.Results
 	if res.Error == nil {
@@ -48,7 +52,7 @@ func TestMail_SendUsingChan(t *testing.T) {
 
 func TestMail_SendUsingAPI(t *testing.T) {
 	msg := Message{
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -71,7 +75,7 @@ func TestMail_buildHTMLMessage(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -87,7 +91,7 @@ func TestMail_buildPlainMessage(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -100,10 +104,12 @@ func TestMail_buildPlainMessage(t *testing.T) {
 }
 
 func TestMail_send(t *testing.T) {
+	requireMailhog(t)
+
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -132,7 +138,7 @@ func TestMail_ChooseAPI(t *testing.T) {
 	msg := Message{
 		From: "me@here.com",
 		FromName: "Joe",
-		To: "you@there.com",
+		To: []string{"you@there.com"},
 		Subject: "test",
 		Template: "test",
 		Attachments: []string{"./testdata/mail/test.html.tmpl"},
@@ -142,4 +148,5 @@ func TestMail_ChooseAPI(t *testing.T) {
 	if err == nil {
 		t.Error(err)
 	}
+	mailer.API = ""
 }
\ No newline at end of file
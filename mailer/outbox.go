@@ -0,0 +1,467 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/jobs"
+)
+
+// SendJob is the name of the background job that sends mail from the outbox
+const SendJob = "mail.send"
+
+var (
+	// ErrNotFound is returned when there is no message with the given id in the outbox
+	ErrNotFound = errors.New("mailer: message not found")
+
+	// ErrNotFailed is returned by Retry for a message that has not failed
+	ErrNotFailed = errors.New("mailer: message has not failed")
+)
+
+// Status is the state of a message in the outbox
+type Status string
+
+const (
+	StatusQueued   Status = "queued"
+	StatusRetrying Status = "retrying"
+	StatusSent     Status = "sent"
+	StatusFailed   Status = "failed"
+)
+
+// Attempt records one attempt to send a message from the outbox
+type Attempt struct {
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+// OutboxMessage is a message in the outbox, with its status and the history of
+// attempts to send it
+type OutboxMessage struct {
+	ID        string    `json:"id"`
+	Message   Message   `json:"message"`
+	Status    Status    `json:"status"`
+	Attempts  []Attempt `json:"attempts,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	SentAt    time.Time `json:"sent_at,omitempty"`
+}
+
+// LastError returns the error of the most recent attempt, if it failed
+func (m *OutboxMessage) LastError() string {
+	if len(m.Attempts) == 0 {
+		return ""
+	}
+	return m.Attempts[len(m.Attempts)-1].Error
+}
+
+// OutboxStore keeps the messages of an outbox. Implementations must be safe for
+// concurrent use, by any number of processes sharing the same storage.
+type OutboxStore interface {
+	// Save adds msg to the store, or replaces the message with the same id
+	Save(ctx context.Context, msg *OutboxMessage) error
+
+	// Get returns the message with id, or ErrNotFound
+	Get(ctx context.Context, id string) (*OutboxMessage, error)
+
+	// List returns up to limit messages with status, or with any status if it is
+	// empty, most recently updated first
+	List(ctx context.Context, status Status, limit int) ([]*OutboxMessage, error)
+
+	// Count returns how many messages have status
+	Count(ctx context.Context, status Status) (int, error)
+
+	// Prune deletes sent and failed messages last updated before t, and returns how
+	// many were deleted
+	Prune(ctx context.Context, before time.Time) (int, error)
+}
+
+// Outbox sends mail in the background. Messages are saved to Store before they are
+// sent, and are sent by a job registered with Jobs, so they survive restarts when
+// both are persistent, and are retried with backoff when sending fails with a
+// transient error.
+//
+// Messages are stored as json, so Data must survive a round trip through json:
+// templates see structs as maps, keyed by field name, and lose their methods.
+type Outbox struct {
+	Mail  *Mail
+	Jobs  *jobs.Jobs
+	Store OutboxStore
+
+	// Workers is how many messages are sent at once by this process
+	Workers int
+
+	// MaxAttempts is how many times a message is tried before it is marked failed
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry, which doubles after each attempt,
+	// up to an hour
+	Backoff time.Duration
+
+	// Logger reports messages whose status could not be saved; nil means slog.Default()
+	Logger *slog.Logger
+}
+
+// NewOutbox returns an Outbox sending mail with m, from store, using j. It sends
+// up to 5 messages at once, and tries each up to 5 times, waiting 30 seconds
+// before the first retry. Call Register before the workers of j are started.
+func NewOutbox(m *Mail, j *jobs.Jobs, store OutboxStore) *Outbox {
+	return &Outbox{
+		Mail:        m,
+		Jobs:        j,
+		Store:       store,
+		Workers:     5,
+		MaxAttempts: 5,
+		Backoff:     30 * time.Second,
+	}
+}
+
+// Register registers the job that sends mail from the outbox with o.Jobs
+func (o *Outbox) Register() {
+	o.Jobs.Register(SendJob, o.handle,
+		jobs.WithConcurrency(o.Workers),
+		jobs.WithMaxAttempts(o.MaxAttempts),
+		jobs.WithBackoff(o.Backoff, time.Hour),
+	)
+}
+
+// sendPayload is the payload of SendJob
+type sendPayload struct {
+	ID string `json:"id"`
+}
+
+// Enqueue saves msg to the outbox, and queues it to be sent
+func (o *Outbox) Enqueue(ctx context.Context, msg Message) (*OutboxMessage, error) {
+	now := time.Now()
+	id := jobs.NewID()
+	if msg.MessageID == "" {
+		// the same id is sent with every attempt, so receivers can spot duplicates
+		msg.MessageID = "<" + id + "@" + o.Mail.messageIDDomain(msg) + ">"
+	}
+	out := &OutboxMessage{
+		ID:        id,
+		Message:   msg,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := o.Store.Save(ctx, out); err != nil {
+		return nil, fmt.Errorf("mailer: saving message: %w", err)
+	}
+
+	if err := o.enqueue(ctx, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// enqueue queues the job that sends msg. If that fails, msg is marked failed, so it
+// can be retried later.
+func (o *Outbox) enqueue(ctx context.Context, msg *OutboxMessage) error {
+	_, err := o.Jobs.Enqueue(ctx, SendJob, sendPayload{ID: msg.ID}, jobs.Attempts(o.MaxAttempts))
+	if err == nil {
+		return nil
+	}
+
+	msg.Status = StatusFailed
+	msg.UpdatedAt = time.Now()
+	msg.Attempts = append(msg.Attempts, Attempt{At: msg.UpdatedAt, Error: "could not queue message: " + err.Error()})
+	if saveErr := o.Store.Save(context.WithoutCancel(ctx), msg); saveErr != nil {
+		o.logger().Error("could not save mail status", "id", msg.ID, "error", saveErr)
+	}
+	return fmt.Errorf("mailer: queueing message: %w", err)
+}
+
+// Get returns the message with id
+func (o *Outbox) Get(ctx context.Context, id string) (*OutboxMessage, error) {
+	return o.Store.Get(ctx, id)
+}
+
+// List returns up to limit messages with status, or with any status if it is
+// empty, most recently updated first
+func (o *Outbox) List(ctx context.Context, status Status, limit int) ([]*OutboxMessage, error) {
+	return o.Store.List(ctx, status, limit)
+}
+
+// Pending returns how many messages are waiting to be sent, either for their first
+// attempt or for a retry
+func (o *Outbox) Pending(ctx context.Context) (int, error) {
+	total := 0
+	for _, status := range []Status{StatusQueued, StatusRetrying} {
+		n, err := o.Store.Count(ctx, status)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Retry queues a failed message to be sent again, with a fresh set of attempts
+func (o *Outbox) Retry(ctx context.Context, id string) error {
+	msg, err := o.Store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if msg.Status != StatusFailed {
+		return ErrNotFailed
+	}
+
+	msg.Status = StatusQueued
+	msg.UpdatedAt = time.Now()
+	if err := o.Store.Save(ctx, msg); err != nil {
+		return err
+	}
+	return o.enqueue(ctx, msg)
+}
+
+// Prune deletes sent and failed messages last updated before t
+func (o *Outbox) Prune(ctx context.Context, before time.Time) (int, error) {
+	return o.Store.Prune(ctx, before)
+}
+
+// messageIDDomain returns the domain used in the message ids generated for msg
+func (m *Mail) messageIDDomain(msg Message) string {
+	if m.Domain != "" {
+		return m.Domain
+	}
+	from := msg.From
+	if from == "" {
+		from = m.FromAddress
+	}
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		return from[i+1:]
+	}
+	return "localhost"
+}
+
+// handle runs SendJob: it sends the message, records the attempt, and reports the
+// result once the message is sent or has failed for good
+func (o *Outbox) handle(ctx context.Context, job *jobs.Job) error {
+	var payload sendPayload
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	msg, err := o.Store.Get(ctx, payload.ID)
+	if errors.Is(err, ErrNotFound) {
+		return jobs.Permanent(err)
+	} else if err != nil {
+		return err
+	}
+	if msg.Status == StatusSent || msg.Status == StatusFailed {
+		// already settled; the job was run again after its lease expired
+		return nil
+	}
+
+	sendErr := o.Mail.deliver(msg.Message.WithContext(ctx))
+
+	now := time.Now()
+	attempt := Attempt{At: now}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	msg.Attempts = append(msg.Attempts, attempt)
+	msg.UpdatedAt = now
+
+	final := sendErr == nil || job.Attempts >= job.MaxAttempts || !IsTransient(sendErr)
+	switch {
+	case sendErr == nil:
+		msg.Status = StatusSent
+		msg.SentAt = now
+	case final:
+		msg.Status = StatusFailed
+	default:
+		msg.Status = StatusRetrying
+	}
+
+	// the message has been sent, or given up on, even if we are being stopped
+	if err := o.Store.Save(context.WithoutCancel(ctx), msg); err != nil {
+		o.logger().Error("could not save mail status", "id", msg.ID, "status", msg.Status, "error", err)
+	}
+	if final {
+		o.Mail.report(Result{ID: msg.ID, Success: sendErr == nil, Error: sendErr})
+	}
+
+	if sendErr != nil && !IsTransient(sendErr) {
+		return jobs.Permanent(sendErr)
+	}
+	return sendErr
+}
+
+func (o *Outbox) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
+
+// IsTransient reports whether err, returned when sending a message, may go away if
+// it is sent again: failures to reach or talk to the mail server or api, timeouts,
+// 4xx replies from an SMTP server, and 429 or 5xx responses from a mail API. Every
+// other error is permanent, including invalid messages, missing templates or
+// attachments, template errors, 5xx SMTP replies and other API responses, so a
+// message is never sent again because of an error we do not understand.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrInvalidMessage) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Transient()
+	}
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code < 500
+	}
+
+	// a missing file wraps a syscall.Errno, which is also a net.Error
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+
+	var connErr *connError
+	var netErr net.Error
+	return errors.As(err, &connErr) || errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// connError is a failure to reach, or talk to, a mail server or api, without a
+// reply from it. The libraries we send with flatten some network errors into plain
+// strings, so these are marked where they happen, and are transient.
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string { return e.err.Error() }
+
+func (e *connError) Unwrap() error { return e.err }
+
+// connFailed marks err, returned while talking to a mail server or api, as a
+// connection failure, unless it is a reply from the server
+func connFailed(err error) error {
+	var reply *textproto.Error
+	var apiErr *APIError
+	if err == nil || errors.As(err, &reply) || errors.As(err, &apiErr) {
+		return err
+	}
+	return &connError{err: err}
+}
+
+// MemoryOutboxStore keeps the outbox in memory. It is lost when the process exits,
+// so it is meant for development and tests. Like the other stores, it keeps each
+// message as json, so Data is seen by templates as it would be in production.
+type MemoryOutboxStore struct {
+	mu       sync.Mutex
+	messages map[string]*OutboxMessage
+}
+
+var _ OutboxStore = (*MemoryOutboxStore)(nil)
+
+// NewMemoryOutboxStore returns an empty MemoryOutboxStore
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{messages: make(map[string]*OutboxMessage)}
+}
+
+func (s *MemoryOutboxStore) Save(_ context.Context, msg *OutboxMessage) error {
+	c, err := copyOutboxMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[msg.ID] = c
+	return nil
+}
+
+func (s *MemoryOutboxStore) Get(_ context.Context, id string) (*OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyOutboxMessage(msg)
+}
+
+func (s *MemoryOutboxStore) List(_ context.Context, status Status, limit int) ([]*OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*OutboxMessage, 0, len(s.messages))
+	for _, msg := range s.messages {
+		if status == "" || msg.Status == status {
+			c, err := copyOutboxMessage(msg)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *MemoryOutboxStore) Count(_ context.Context, status Status) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, msg := range s.messages {
+		if msg.Status == status {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryOutboxStore) Prune(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, msg := range s.messages {
+		if settled(msg.Status) && msg.UpdatedAt.Before(before) {
+			delete(s.messages, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// settled reports whether a message with status will not be sent again, unless it
+// is retried by hand
+func settled(status Status) bool {
+	return status == StatusSent || status == StatusFailed
+}
+
+// copyOutboxMessage copies msg through json, as the redis and sql stores do
+func copyOutboxMessage(msg *OutboxMessage) (*OutboxMessage, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var c OutboxMessage
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,201 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisOutboxStore keeps the outbox in redis. Each message is stored as json, and
+// is listed in a sorted set per status, scored by when it was last updated. Keys
+// start with Prefix+"#mail:outbox", outside the keys that a cache.RedisCache with
+// the same Prefix empties, so that flushing the cache does not lose mail.
+type RedisOutboxStore struct {
+	Conn   *redis.Pool
+	Prefix string
+}
+
+var _ OutboxStore = (*RedisOutboxStore)(nil)
+
+var statuses = []Status{StatusQueued, StatusRetrying, StatusSent, StatusFailed}
+
+// KEYS: message, then the set of each status; ARGV: id, updated at, message json,
+// index of the set of its status
+var saveOutboxScript = redis.NewScript(len(statuses)+1, `
+for i = 2, #KEYS do
+	redis.call('ZREM', KEYS[i], ARGV[1])
+end
+redis.call('SET', KEYS[1], ARGV[3])
+redis.call('ZADD', KEYS[tonumber(ARGV[4])], ARGV[2], ARGV[1])
+return 1`)
+
+// KEYS: status set, message; ARGV: id, prune before
+var pruneOutboxScript = redis.NewScript(2, `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score == false or tonumber(score) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+return 1`)
+
+func (s *RedisOutboxStore) key(parts ...string) string {
+	key := s.Prefix + "#mail:outbox"
+	for _, p := range parts {
+		key += ":" + p
+	}
+	return key
+}
+
+func (s *RedisOutboxStore) Save(ctx context.Context, msg *OutboxMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	args := redis.Args{}.Add(s.key("message", msg.ID))
+	index := 0
+	for i, status := range statuses {
+		args = args.Add(s.key("status", string(status)))
+		if status == msg.Status {
+			index = i + 2
+		}
+	}
+	if index == 0 {
+		return errors.New("mailer: unknown status " + string(msg.Status))
+	}
+	args = args.Add(msg.ID, msg.UpdatedAt.UnixMilli(), data, index)
+
+	_, err = saveOutboxScript.Do(conn, args...)
+	return err
+}
+
+func (s *RedisOutboxStore) Get(ctx context.Context, id string) (*OutboxMessage, error) {
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.key("message", id)))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var msg OutboxMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *RedisOutboxStore) List(ctx context.Context, status Status, limit int) ([]*OutboxMessage, error) {
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	wanted := statuses
+	if status != "" {
+		wanted = []Status{status}
+	}
+
+	// collect the newest ids of each status, then merge them
+	type entry struct {
+		id      string
+		updated int64
+	}
+	var entries []entry
+	for _, st := range wanted {
+		values, err := redis.Values(conn.Do("ZREVRANGE", s.key("status", string(st)), 0, limit-1, "WITHSCORES"))
+		if err != nil {
+			return nil, err
+		}
+		for len(values) >= 2 {
+			var e entry
+			if values, err = redis.Scan(values, &e.id, &e.updated); err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].updated > entries[j].updated })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	args := redis.Args{}
+	for _, e := range entries {
+		args = args.Add(s.key("message", e.id))
+	}
+	values, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*OutboxMessage, 0, len(values))
+	for _, data := range values {
+		if data == nil {
+			continue
+		}
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		list = append(list, &msg)
+	}
+	return list, nil
+}
+
+func (s *RedisOutboxStore) Count(ctx context.Context, status Status) (int, error) {
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int(conn.Do("ZCARD", s.key("status", string(status))))
+}
+
+func (s *RedisOutboxStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	n := 0
+	for _, status := range []Status{StatusSent, StatusFailed} {
+		set := s.key("status", string(status))
+		ids, err := redis.Strings(conn.Do("ZRANGEBYSCORE", set, "-inf", "("+strconv.FormatInt(before.UnixMilli(), 10)))
+		if err != nil {
+			return n, err
+		}
+		for _, id := range ids {
+			// the message may have been retried since we listed it
+			removed, err := redis.Int(pruneOutboxScript.Do(conn, set, s.key("message", id), id, before.UnixMilli()))
+			if err != nil {
+				return n, err
+			}
+			n += removed
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,197 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLOutboxStore keeps the outbox in a table of a postgres, mysql or sqlite
+// database, created by the migration from "boilme make jobs". Times are stored as
+// unix milliseconds, and the message and its attempts as json. Dialect is postgres,
+// mysql or sqlite, and Table defaults to mail_outbox.
+type SQLOutboxStore struct {
+	DB      *sql.DB
+	Dialect string
+	Table   string
+}
+
+var _ OutboxStore = (*SQLOutboxStore)(nil)
+
+// outboxColumns are the columns scanned by scanOutboxMessage, in order
+const outboxColumns = "id, status, message, attempts, created_at, updated_at, sent_at"
+
+func (s *SQLOutboxStore) table() string {
+	if s.Table == "" {
+		return "mail_outbox"
+	}
+	return s.Table
+}
+
+func (s *SQLOutboxStore) rebind(query string) string {
+	return rebind(s.Dialect, query)
+}
+
+// rebind replaces the ? placeholders in query with $1, $2... for postgres
+func rebind(dialect, query string) string {
+	if dialect != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLOutboxStore) Save(ctx context.Context, msg *OutboxMessage) error {
+	message, err := json.Marshal(msg.Message)
+	if err != nil {
+		return err
+	}
+	attempts, err := json.Marshal(msg.Attempts)
+	if err != nil {
+		return err
+	}
+
+	var sentAt interface{}
+	if !msg.SentAt.IsZero() {
+		sentAt = msg.SentAt.UnixMilli()
+	}
+
+	// update first, since most saves are of messages already in the outbox
+	res, err := s.DB.ExecContext(ctx, s.rebind(`update `+s.table()+`
+		set status = ?, message = ?, attempts = ?, updated_at = ?, sent_at = ? where id = ?`),
+		string(msg.Status), string(message), string(attempts), msg.UpdatedAt.UnixMilli(), sentAt, msg.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = s.DB.ExecContext(ctx, s.rebind(`insert into `+s.table()+` (`+outboxColumns+`)
+		values (?, ?, ?, ?, ?, ?, ?)`),
+		msg.ID, string(msg.Status), string(message), string(attempts),
+		msg.CreatedAt.UnixMilli(), msg.UpdatedAt.UnixMilli(), sentAt)
+	if err != nil && isUniqueViolation(err) {
+		// mysql reports no rows affected when an update changes nothing, so the
+		// message was there after all
+		return nil
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation, in any of
+// the databases we support
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return state.SQLState() == "23505"
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate entry") || strings.Contains(msg, "unique constraint failed")
+}
+
+func (s *SQLOutboxStore) Get(ctx context.Context, id string) (*OutboxMessage, error) {
+	msg, err := scanOutboxMessage(s.DB.QueryRowContext(ctx,
+		s.rebind(`select `+outboxColumns+` from `+s.table()+` where id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return msg, err
+}
+
+func (s *SQLOutboxStore) List(ctx context.Context, status Status, limit int) ([]*OutboxMessage, error) {
+	query := `select ` + outboxColumns + ` from ` + s.table()
+	var args []interface{}
+	if status != "" {
+		query += ` where status = ?`
+		args = append(args, string(status))
+	}
+	query += ` order by updated_at desc`
+	if limit > 0 {
+		query += ` limit ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, msg)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLOutboxStore) Count(ctx context.Context, status Status) (int, error) {
+	var n int
+	err := s.DB.QueryRowContext(ctx, s.rebind(`select count(*) from `+s.table()+` where status = ?`),
+		string(status)).Scan(&n)
+	return n, err
+}
+
+func (s *SQLOutboxStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, s.rebind(`delete from `+s.table()+`
+		where status in (?, ?) and updated_at < ?`),
+		string(StatusSent), string(StatusFailed), before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// scanOutboxMessage reads a row of outboxColumns
+func scanOutboxMessage(row interface{ Scan(...interface{}) error }) (*OutboxMessage, error) {
+	var (
+		msg                  OutboxMessage
+		status               string
+		message, attempts    string
+		createdAt, updatedAt int64
+		sentAt               sql.NullInt64
+	)
+
+	err := row.Scan(&msg.ID, &status, &message, &attempts, &createdAt, &updatedAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(message), &msg.Message); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(attempts), &msg.Attempts); err != nil {
+		return nil, err
+	}
+	msg.Status = Status(status)
+	msg.CreatedAt = time.UnixMilli(createdAt)
+	msg.UpdatedAt = time.UnixMilli(updatedAt)
+	if sentAt.Valid {
+		msg.SentAt = time.UnixMilli(sentAt.Int64)
+	}
+	return &msg, nil
+}
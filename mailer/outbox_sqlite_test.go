@@ -0,0 +1,41 @@
+//go:build sqlite
+
+package mailer
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteDB returns a new sqlite database with the tables of "boilme make jobs"
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "outbox.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("../cmd/cli/templates/migrations/sqlite_jobs.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func init() {
+	newSQLOutboxStore = func(t *testing.T) OutboxStore {
+		return &SQLOutboxStore{DB: newSQLiteDB(t), Dialect: "sqlite"}
+	}
+	newSQLSuppressionList = func(t *testing.T) SuppressionList {
+		return &SQLSuppressionList{DB: newSQLiteDB(t), Dialect: "sqlite"}
+	}
+}
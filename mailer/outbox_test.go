@@ -0,0 +1,499 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/jobs"
+	"github.com/bxtal-lsn/go-boilme/urlsigner"
+	"github.com/gomodule/redigo/redis"
+)
+
+// newSQLOutboxStore returns a new, empty SQLOutboxStore, when the tests are built
+// with a sql driver
+var newSQLOutboxStore func(t *testing.T) OutboxStore
+
+// testOutboxStores returns an empty store of every kind that runs without a server
+func testOutboxStores(t *testing.T) map[string]OutboxStore {
+	stores := map[string]OutboxStore{
+		"memory": NewMemoryOutboxStore(),
+		"redis":  &RedisOutboxStore{Conn: newTestRedisPool(t), Prefix: "test"},
+	}
+	if newSQLOutboxStore != nil {
+		stores["sql"] = newSQLOutboxStore(t)
+	}
+	return stores
+}
+
+func TestOutboxStore(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range testOutboxStores(t) {
+		t.Run(name, func(t *testing.T) {
+			base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+			for i, id := range []string{"a", "b", "c"} {
+				msg := &OutboxMessage{
+					ID:        id,
+					Message:   Message{To: []string{id + "@example.com"}, Data: map[string]interface{}{"n": i}},
+					Status:    StatusQueued,
+					CreatedAt: base,
+					UpdatedAt: base.Add(time.Duration(i) * time.Minute),
+				}
+				if err := store.Save(ctx, msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+
+			msg, err := store.Get(ctx, "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg.Status = StatusFailed
+			msg.Attempts = append(msg.Attempts, Attempt{At: base, Error: "boom"})
+			if err := store.Save(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err = store.Get(ctx, "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Status != StatusFailed || msg.LastError() != "boom" || msg.Message.To[0] != "b@example.com" {
+				t.Errorf("message not saved: %+v", msg)
+			}
+			// every store keeps data as json, so numbers come back as float64
+			if data, ok := msg.Message.Data.(map[string]interface{}); !ok || data["n"] != float64(1) {
+				t.Errorf("data did not round trip through json: %#v", msg.Message.Data)
+			}
+
+			all, err := store.List(ctx, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 3 || all[0].ID != "c" || all[2].ID != "a" {
+				t.Errorf("expected c, b, a; got %v", outboxIDs(all))
+			}
+
+			queued, err := store.List(ctx, StatusQueued, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(queued) != 1 || queued[0].ID != "c" {
+				t.Errorf("expected c; got %v", outboxIDs(queued))
+			}
+
+			failed, err := store.List(ctx, StatusFailed, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(failed) != 1 || failed[0].ID != "b" {
+				t.Errorf("expected b; got %v", outboxIDs(failed))
+			}
+
+			for status, want := range map[Status]int{StatusQueued: 2, StatusFailed: 1, StatusSent: 0} {
+				n, err := store.Count(ctx, status)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != want {
+					t.Errorf("expected %d %s messages, got %d", want, status, n)
+				}
+			}
+
+			// only settled messages are pruned
+			n, err := store.Prune(ctx, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Errorf("expected 1 message pruned, got %d", n)
+			}
+			if _, err := store.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected b to be pruned, got %v", err)
+			}
+			if _, err := store.Get(ctx, "a"); err != nil {
+				t.Errorf("expected a to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+func outboxIDs(list []*OutboxMessage) []string {
+	ids := make([]string, 0, len(list))
+	for _, msg := range list {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+// newTestOutbox returns an outbox sending with m, whose workers are running
+func newTestOutbox(t *testing.T, m *Mail) *Outbox {
+	t.Helper()
+
+	j := jobs.New(jobs.NewMemoryQueue())
+	j.PollInterval = 10 * time.Millisecond
+
+	outbox := NewOutbox(m, j, NewMemoryOutboxStore())
+	outbox.MaxAttempts = 2
+	outbox.Backoff = 10 * time.Millisecond
+	outbox.Register()
+	m.Outbox = outbox
+
+	j.Start()
+	t.Cleanup(func() { _ = j.Stop(context.Background()) })
+	return outbox
+}
+
+// waitForResult returns the next result on results, or fails the test
+func waitForResult(t *testing.T, results chan Result) Result {
+	t.Helper()
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a result")
+		return Result{}
+	}
+}
+
+// newTestRedisPool returns a pool of connections to a new miniredis server
+func newTestRedisPool(t *testing.T) *redis.Pool {
+	t.Helper()
+
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.Addr())
+		},
+	}
+	t.Cleanup(func() { _ = pool.Close() })
+	return pool
+}
+
+func TestRedisOutboxStore_survivesCacheEmpty(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestRedisPool(t)
+	store := &RedisOutboxStore{Conn: pool, Prefix: "test"}
+
+	now := time.Now()
+	msg := &OutboxMessage{ID: "a", Message: Message{To: []string{"a@example.com"}}, Status: StatusQueued, CreatedAt: now, UpdatedAt: now}
+	if err := store.Save(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cache.RedisCache{Conn: pool, Prefix: "test"}
+	if err := c.Set("page", "cached", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Empty(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(ctx, "a"); err != nil {
+		t.Fatalf("the queued message is gone after emptying the cache: %v", err)
+	}
+	if n, err := store.Count(ctx, StatusQueued); err != nil || n != 1 {
+		t.Errorf("got %d queued messages after emptying the cache, want 1 (%v)", n, err)
+	}
+}
+
+func TestOutbox_RetriesTransientErrors(t *testing.T) {
+	results := make(chan Result, 1)
+	m := &Mail{
+		Templates:   "./testdata/mail",
+		Host:        "localhost",
+		Port:        1, // nothing listens here
+		Encryption:  "none",
+		FromAddress: "me@here.com",
+		OnResult:    func(res Result) { results <- res },
+	}
+	outbox := newTestOutbox(t, m)
+
+	out, err := outbox.Enqueue(context.Background(), Message{To: []string{"you@there.com"}, Subject: "test", Template: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := waitForResult(t, results)
+	if res.Success || res.ID != out.ID {
+		t.Fatalf("expected a failure for %s, got %+v", out.ID, res)
+	}
+
+	msg, err := outbox.Get(context.Background(), out.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || len(msg.Attempts) != 2 {
+		t.Errorf("expected failed after 2 attempts, got %s after %d", msg.Status, len(msg.Attempts))
+	}
+
+	// a retried message gets a fresh set of attempts
+	if err := outbox.Retry(context.Background(), out.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitForResult(t, results)
+	msg, err = outbox.Get(context.Background(), out.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || len(msg.Attempts) != 4 {
+		t.Errorf("expected failed after 4 attempts, got %s after %d", msg.Status, len(msg.Attempts))
+	}
+}
+
+func TestOutbox_PermanentErrors(t *testing.T) {
+	results := make(chan Result, 1)
+	m := &Mail{
+		Templates:   "./testdata/mail",
+		Host:        "localhost",
+		Port:        1,
+		Encryption:  "none",
+		FromAddress: "me@here.com",
+		OnResult:    func(res Result) { results <- res },
+	}
+	outbox := newTestOutbox(t, m)
+
+	out, err := outbox.Enqueue(context.Background(), Message{To: []string{"you@there.com"}, Subject: "test", Template: "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := waitForResult(t, results); res.Success {
+		t.Fatal("expected a failure")
+	}
+	msg, err := outbox.Get(context.Background(), out.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || len(msg.Attempts) != 1 {
+		t.Errorf("expected failed after 1 attempt, got %s after %d", msg.Status, len(msg.Attempts))
+	}
+
+	if err := outbox.Retry(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOutbox_ListenForMail(t *testing.T) {
+	requireMailhog(t)
+
+	m := &Mail{
+		Templates:   "./testdata/mail",
+		Host:        "localhost",
+		Port:        1026,
+		Encryption:  "none",
+		FromAddress: "me@here.com",
+		Jobs:        make(chan Message, 1),
+		Results:     make(chan Result, 1),
+	}
+	outbox := newTestOutbox(t, m)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ListenForMail()
+	}()
+
+	m.Jobs <- Message{To: []string{"you@there.com"}, Subject: "test", Template: "test", Data: struct{ Name string }{"Joe"}}
+	res := waitForResult(t, m.Results)
+	if !res.Success {
+		t.Fatalf("expected the message to be sent, got %v", res.Error)
+	}
+
+	msg, err := outbox.Get(context.Background(), res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusSent || msg.SentAt.IsZero() {
+		t.Errorf("expected sent, got %s", msg.Status)
+	}
+
+	close(m.Jobs)
+	<-done
+}
+
+func TestListenForMail_DoesNotBlockOnResults(t *testing.T) {
+	m := &Mail{
+		Templates: "./testdata/mail",
+		Jobs:      make(chan Message),
+		Results:   make(chan Result, 1),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ListenForMail()
+	}()
+
+	// nobody reads Results, so all but the first result are dropped
+	for range 3 {
+		select {
+		case m.Jobs <- Message{Template: "missing"}:
+		case <-time.After(5 * time.Second):
+			t.Fatal("ListenForMail is blocked")
+		}
+	}
+
+	close(m.Jobs)
+	<-done
+	if len(m.Results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(m.Results))
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	_, pathErr := os.Open("./testdata/missing")
+	_, dialErr := net.Dial("tcp", "127.0.0.1:1")
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"4xx reply", &textproto.Error{Code: 421, Msg: "try again later"}, true},
+		{"5xx reply", &textproto.Error{Code: 550, Msg: "no such user"}, false},
+		{"wrapped 4xx reply", fmt.Errorf("Mail Error on Hello: %w", &textproto.Error{Code: 421}), true},
+		{"reply marked as a connection failure", connFailed(&textproto.Error{Code: 535, Msg: "bad credentials"}), false},
+		{"api unavailable", &APIError{API: "sparkpost", StatusCode: http.StatusServiceUnavailable}, true},
+		{"api rate limit", &APIError{API: "sparkpost", StatusCode: http.StatusTooManyRequests}, true},
+		{"api rejected", &APIError{API: "sparkpost", StatusCode: http.StatusBadRequest}, false},
+		{"invalid message", fmt.Errorf("%w: no recipients", ErrInvalidMessage), false},
+		{"missing file", pathErr, false},
+		{"network error", dialErr, true},
+		{"connection failure", connFailed(errors.New("Mail Error: SMTP Connection timed out")), true},
+		{"timeout", fmt.Errorf("sending: %w", context.DeadlineExceeded), true},
+		{"unclassified", errors.New("something odd"), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// fakeSender is a mail api that answers each message with the next of statuses,
+// and 200 OK once they run out
+type fakeSender struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (f *fakeSender) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.bodies = append(f.bodies, string(data))
+	status := http.StatusOK
+	if len(f.statuses) > 0 {
+		status, f.statuses = f.statuses[0], f.statuses[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		_, _ = w.Write([]byte(`{"results":{"id":"1","total_accepted_recipients":1,"total_rejected_recipients":0}}`))
+		return
+	}
+	_, _ = w.Write([]byte(`{"errors":[{"message":"not now"}]}`))
+}
+
+func (f *fakeSender) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.bodies...)
+}
+
+func TestOutbox_fakeSender(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantSuccess  bool
+		wantStatus   Status
+		wantAttempts int
+	}{
+		{"sent at once", nil, true, StatusSent, 1},
+		{"sent after a transient error", []int{http.StatusServiceUnavailable}, true, StatusSent, 2},
+		{"rate limited until out of attempts", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, false, StatusFailed, 2},
+		{"rejected", []int{http.StatusBadRequest}, false, StatusFailed, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{statuses: tt.statuses}
+			srv := httptest.NewTLSServer(sender)
+			defer srv.Close()
+
+			results := make(chan Result, 1)
+			m := &Mail{
+				Templates:   "./testdata/layout",
+				FromAddress: "me@here.com",
+				API:         "sparkpost",
+				APIKey:      "key",
+				APIUrl:      srv.URL,
+				HTTPClient:  srv.Client(),
+				Signer:      &urlsigner.Signer{Secret: []byte("secret")},
+				OnResult:    func(res Result) { results <- res },
+			}
+			outbox := newTestOutbox(t, m)
+
+			// the data is stored as json, so the template sees the struct as a map,
+			// and the time as a string
+			data := struct {
+				Name   string
+				Link   string
+				Joined time.Time
+			}{"Joe", "https://example.com/start", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)}
+			out, err := outbox.Enqueue(context.Background(), Message{To: []string{"you@there.com"}, Template: "welcome", Data: data})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := waitForResult(t, results)
+			if res.Success != tt.wantSuccess || res.ID != out.ID {
+				t.Fatalf("unexpected result %+v", res)
+			}
+
+			msg, err := outbox.Get(context.Background(), out.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Status != tt.wantStatus || len(msg.Attempts) != tt.wantAttempts {
+				t.Errorf("expected %s after %d attempts, got %s after %d", tt.wantStatus, tt.wantAttempts, msg.Status, len(msg.Attempts))
+			}
+
+			sent := sender.sent()
+			if len(sent) != tt.wantAttempts {
+				t.Fatalf("the api was called %d times", len(sent))
+			}
+			if !strings.Contains(sent[0], "Hello Joe, you joined on 5 Mar 2024") {
+				t.Errorf("the data was not rendered: %s", sent[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Preview is a template rendered as it would be sent
+type Preview struct {
+	Subject string
+	HTML    string
+	Plain   string
+}
+
+// TemplateInfo describes a message template in Templates: its name, and the
+// locales it has variants for
+type TemplateInfo struct {
+	Name    string
+	Locales []string
+}
+
+// Preview renders the templates for name in locale with data, exactly as a message
+// using them would be rendered, without sending anything
+func (m *Mail) Preview(name, locale string, data interface{}) (*Preview, error) {
+	html, plain, subject, err := m.render(name, locale, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Preview{Subject: subject, HTML: html, Plain: plain}, nil
+}
+
+// ListTemplates returns the message templates in Templates, sorted by name. A
+// template is listed when it has an html or a plain text version; layouts and
+// partials are not listed.
+func (m *Mail) ListTemplates() ([]TemplateInfo, error) {
+	locales := make(map[string]map[string]bool)
+	for _, kind := range []string{kindHTML, kindPlain} {
+		matches, err := filepath.Glob(filepath.Join(m.Templates, "*."+kind+".tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			base := strings.TrimSuffix(filepath.Base(path), "."+kind+".tmpl")
+			name, locale, _ := strings.Cut(base, ".")
+			if locales[name] == nil {
+				locales[name] = make(map[string]bool)
+			}
+			if locale != "" {
+				locales[name][locale] = true
+			}
+		}
+	}
+
+	templates := make([]TemplateInfo, 0, len(locales))
+	for name, set := range locales {
+		t := TemplateInfo{Name: name}
+		for locale := range set {
+			t.Locales = append(t.Locales, locale)
+		}
+		sort.Strings(t.Locales)
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// SampleData returns the data to preview the template name with, which is read
+// from samples/name.json in Templates. Without that file, it is an empty map.
+func (m *Mail) SampleData(name string) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	if name != filepath.Base(name) {
+		return data, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(m.Templates, "samples", name+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("mailer: sample data for %s: %w", name, err)
+	}
+	return data, nil
+}
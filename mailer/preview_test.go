@@ -0,0 +1,82 @@
+package mailer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/go-boilme/urlsigner"
+)
+
+func TestMail_ListTemplates(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want []TemplateInfo
+	}{
+		{"./testdata/layout", []TemplateInfo{{Name: "welcome", Locales: []string{"de"}}}},
+		{"./testdata/mail", []TemplateInfo{{Name: "test"}}},
+		{"./testdata/missing", []TemplateInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			m := &Mail{Templates: tt.dir}
+			got, err := m.ListTemplates()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMail_Preview(t *testing.T) {
+	m := &Mail{Templates: "./testdata/layout", Signer: &urlsigner.Signer{Secret: []byte("secret")}}
+
+	data, err := m.SampleData("welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["Name"] != "Sample User" {
+		t.Fatalf("unexpected sample data: %v", data)
+	}
+
+	p, err := m.Preview("welcome", "", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "Welcome, Sample User & friends" {
+		t.Errorf("wrong subject: %q", p.Subject)
+	}
+	if !strings.Contains(p.HTML, "Hello Sample User, you joined on 5 Mar 2024") || !strings.Contains(p.Plain, "-- Sent by Boilme") {
+		t.Errorf("unexpected preview: %+v", p)
+	}
+
+	p, err = m.Preview("welcome", "de", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "Willkommen, Sample User" {
+		t.Errorf("wrong subject in german: %q", p.Subject)
+	}
+
+	if _, err := m.Preview("missing", "", data); err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestMail_SampleData(t *testing.T) {
+	m := &Mail{Templates: "./testdata/layout"}
+
+	for _, name := range []string{"other", "../layout/samples/welcome"} {
+		data, err := m.SampleData(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 0 {
+			t.Errorf("%s has sample data %v", name, data)
+		}
+	}
+}
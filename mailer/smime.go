@@ -0,0 +1,250 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+
+	"go.mozilla.org/pkcs7"
+)
+
+func init() {
+	// pkcs7 encrypts with DES unless told otherwise, which no mail client should
+	// accept any more
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+}
+
+// SMIME asks for a message to be signed, encrypted or both with S/MIME when it is
+// sent over smtp. It is kept with the message in the outbox, so it holds the
+// certificates of the recipients, but never a private key: messages are signed
+// with the SMIMESigner of the transport.
+type SMIME struct {
+	// Sign signs the message, so that its recipients can tell it comes from the
+	// owner of the certificate of the transport, and has not been changed
+	Sign bool `json:"sign,omitempty"`
+
+	// EncryptTo are the PEM certificates, with RSA keys, of those who can read the
+	// message; usually one for each recipient
+	EncryptTo [][]byte `json:"encrypt_to,omitempty"`
+}
+
+// SMIMESigner is the certificate messages are signed with, and its private key.
+// Chain holds the intermediate certificates between Certificate and the root,
+// which are sent with every signature.
+type SMIMESigner struct {
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	Key         crypto.PrivateKey
+}
+
+// LoadSMIMESigner reads the PEM certificate at certPath, followed by its chain if
+// any, and the PEM private key at keyPath
+func LoadSMIMESigner(certPath, keyPath string) (*SMIMESigner, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: s/mime certificate: %w", err)
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: s/mime certificate %s: %w", certPath, err)
+	}
+	key, err := loadPrivateKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: s/mime key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !publicKeyEqual(certs[0].PublicKey, signer.Public()) {
+		return nil, fmt.Errorf("mailer: s/mime key %s does not belong to the certificate %s", keyPath, certPath)
+	}
+	return &SMIMESigner{Certificate: certs[0], Chain: certs[1:], Key: key}, nil
+}
+
+// publicKeyEqual reports whether a and b are the same key
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// parseCertificates returns the certificates of the PEM data, in order
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// appliesSMIME reports whether t honours the SMIME of messages. Captured mail is
+// never delivered, so it is kept as it was composed.
+func appliesSMIME(t Transport) bool {
+	switch t.(type) {
+	case *SMTPTransport, *captureTransport:
+		return true
+	}
+	return false
+}
+
+// apply signs and then encrypts msg, a whole message with CRLF line endings, as s
+// asks. The content headers and the body of msg are what is protected; the other
+// headers, such as the subject, stay readable, as they must to deliver it.
+func (s *SMIME) apply(msg []byte, signer *SMIMESigner) ([]byte, error) {
+	if !s.Sign && len(s.EncryptTo) == 0 {
+		return msg, nil
+	}
+
+	header, entity, err := splitEntity(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Sign {
+		if signer == nil {
+			return nil, fmt.Errorf("%w: it asks to be signed with s/mime, but no certificate is configured", ErrInvalidMessage)
+		}
+		if entity, err = smimeSign(entity, signer); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.EncryptTo) > 0 {
+		var recipients []*x509.Certificate
+		for _, data := range s.EncryptTo {
+			certs, err := parseCertificates(data)
+			if err != nil {
+				return nil, fmt.Errorf("%w: s/mime certificate: %v", ErrInvalidMessage, err)
+			}
+			recipients = append(recipients, certs...)
+		}
+		if entity, err = smimeEncrypt(entity, recipients); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(header, entity...), nil
+}
+
+// splitEntity splits msg into the header fields that stay on the message, and the
+// entity S/MIME protects: the content header fields, followed by the body
+func splitEntity(msg []byte) (header, entity []byte, err error) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, nil, errors.New("mailer: s/mime: the message has no body")
+	}
+
+	var content []byte
+	fields := msg[:end+2]
+	for len(fields) > 0 {
+		// a field runs on over the lines that start with a space or a tab
+		n := 0
+		for {
+			i := bytes.Index(fields[n:], []byte("\r\n"))
+			n += i + 2
+			if n == len(fields) || (fields[n] != ' ' && fields[n] != '\t') {
+				break
+			}
+		}
+		field := fields[:n]
+		fields = fields[n:]
+
+		name, _, _ := strings.Cut(string(field), ":")
+		if strings.HasPrefix(strings.ToLower(name), "content-") {
+			content = append(content, field...)
+		} else {
+			header = append(header, field...)
+		}
+	}
+
+	entity = append(content, msg[end+2:]...)
+	return header, entity, nil
+}
+
+// smimeSign returns entity as the first part of a multipart/signed entity, with
+// its detached signature as the second
+func smimeSign(entity []byte, signer *SMIMESigner) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: s/mime: %w", err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(signer.Certificate, signer.Key, signer.Chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("mailer: s/mime: %w", err)
+	}
+	sd.Detach()
+	signature, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("mailer: s/mime: %w", err)
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\";\r\n micalg=sha-256; boundary=\"%s\"\r\n\r\n", boundary)
+	b.WriteString("This is an S/MIME signed message\r\n")
+	// what is signed runs from after the first delimiter line up to the CRLF in
+	// front of the next one
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.Write(entity)
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	writeBase64Lines(&b, signature)
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+// smimeEncrypt returns entity enveloped for recipients, as an application/pkcs7-mime
+// entity
+func smimeEncrypt(entity []byte, recipients []*x509.Certificate) ([]byte, error) {
+	enveloped, err := pkcs7.Encrypt(entity, recipients)
+	if err != nil {
+		return nil, fmt.Errorf("%w: s/mime: %v", ErrInvalidMessage, err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data;\r\n name=\"smime.p7m\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	writeBase64Lines(&b, enveloped)
+	return b.Bytes(), nil
+}
+
+// writeBase64Lines writes data to b in base64, in lines of 76 characters
+func writeBase64Lines(b *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	b.WriteString("\r\n")
+}
+
+// crlf returns msg with every line ending in CRLF, as mail is sent and signed
+func crlf(msg []byte) []byte {
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+}
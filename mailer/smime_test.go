@@ -0,0 +1,237 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// newTestCertificate returns a self signed certificate for email protection, its
+// key, and the certificate in PEM
+func newTestCertificate(t *testing.T, address string) (*x509.Certificate, *rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: address},
+		EmailAddresses: []string{address},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// newSMIMEMail returns a Mail that signs with a new certificate over s, and that
+// certificate
+func newSMIMEMail(t *testing.T, s *fakeSMTPServer) (*Mail, *x509.Certificate) {
+	t.Helper()
+	cert, key, certPEM := newTestCertificate(t, "me@here.com")
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := s.transport()
+	return &Mail{
+		Templates: "./testdata/mail", FromAddress: "me@here.com",
+		Host: tr.Host, Port: tr.Port, Encryption: "none",
+		SMIMECertFile: certFile, SMIMEKeyFile: writeKey(t, key),
+	}, cert
+}
+
+// readEntity returns the header and the raw body of the entity data
+func readEntity(t *testing.T, data []byte) (netmail.Header, []byte) {
+	t.Helper()
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Header, body
+}
+
+// verifySigned checks the signature of the multipart/signed entity data, made by
+// cert, and returns the entity that was signed
+func verifySigned(t *testing.T, data []byte, cert *x509.Certificate) []byte {
+	t.Helper()
+	header, body := readEntity(t, data)
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" {
+		t.Fatalf("entity is not signed: %s", header.Get("Content-Type"))
+	}
+
+	// the signed part is taken as it is, before any decoding
+	delimiter := []byte("--" + params["boundary"] + "\r\n")
+	start := bytes.Index(body, delimiter) + len(delimiter)
+	end := start + bytes.Index(body[start:], []byte("\r\n--"+params["boundary"]+"\r\n"))
+	signed := body[start:end]
+
+	parts := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	if _, err := parts.NextRawPart(); err != nil {
+		t.Fatal(err)
+	}
+	part, err := parts.NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.ReplaceAll(encoded, []byte("\r\n"), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7, err := pkcs7.Parse(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7.Content = signed
+	if err := p7.Verify(); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if signer := p7.GetOnlySigner(); signer == nil || !signer.Equal(cert) {
+		t.Error("not signed with the certificate of the transport")
+	}
+
+	p7.Content = append([]byte("X-Forged: yes\r\n"), signed...)
+	if err := p7.Verify(); err == nil {
+		t.Error("signature of changed content verifies")
+	}
+	return signed
+}
+
+func TestSMTPTransport_smimeSign(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	m, cert := newSMIMEMail(t, s)
+
+	msg := Message{To: []string{"you@there.com"}, Subject: "signed", Template: "test", SMIME: &SMIME{Sign: true}}
+	if err := m.SendSMTPMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := s.message()
+	header, _ := readEntity(t, sent)
+	if header.Get("Subject") != "signed" || header.Get("To") != "<you@there.com>" {
+		t.Errorf("headers of the message were not kept: %v", header)
+	}
+
+	signed := verifySigned(t, sent, cert)
+	inner, _ := readEntity(t, signed)
+	if mediaType, _, _ := mime.ParseMediaType(inner.Get("Content-Type")); mediaType != "multipart/alternative" {
+		t.Errorf("signed the wrong entity: %s", inner.Get("Content-Type"))
+	}
+	if inner.Get("Subject") != "" {
+		t.Error("the signed entity has the headers of the message")
+	}
+}
+
+func TestSMTPTransport_smimeEncrypt(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	m, cert := newSMIMEMail(t, s)
+	recipient, recipientKey, recipientPEM := newTestCertificate(t, "you@there.com")
+
+	msg := Message{
+		To: []string{"you@there.com"}, Subject: "secret", Template: "test",
+		SMIME: &SMIME{Sign: true, EncryptTo: [][]byte{recipientPEM}},
+	}
+	if err := m.SendSMTPMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	header, body := readEntity(t, s.message())
+	if mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType != "application/pkcs7-mime" || params["smime-type"] != "enveloped-data" {
+		t.Fatalf("message is not encrypted: %s", header.Get("Content-Type"))
+	}
+	if header.Get("Subject") != "secret" {
+		t.Errorf("subject of the message was not kept: %q", header.Get("Subject"))
+	}
+
+	enveloped, err := base64.StdEncoding.DecodeString(string(bytes.ReplaceAll(body, []byte("\r\n"), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.Parse(enveloped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := p7.Decrypt(recipient, recipientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed first, then encrypted
+	verifySigned(t, decrypted, cert)
+}
+
+func TestSMIME_errors(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	tr := s.transport()
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", Host: tr.Host, Port: tr.Port, Encryption: "none", APIUrl: "http://127.0.0.1:1"}
+
+	tests := []struct {
+		name  string
+		smime *SMIME
+		api   string
+	}{
+		{"sign without a certificate", &SMIME{Sign: true}, "smtp"},
+		{"encrypt to a malformed certificate", &SMIME{EncryptTo: [][]byte{[]byte("not a certificate")}}, "smtp"},
+		{"over an api", &SMIME{Sign: true}, "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{To: []string{"you@there.com"}, Subject: "test", Template: "test", SMIME: tt.smime}
+			if err := m.SendUsingAPI(msg, tt.api); !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("got %v, want ErrInvalidMessage", err)
+			}
+		})
+	}
+	if _, _, messages := s.counts(); messages != 0 {
+		t.Errorf("%d messages were sent", messages)
+	}
+}
+
+func TestLoadSMIMESigner_otherKey(t *testing.T) {
+	_, _, certPEM := newTestCertificate(t, "me@here.com")
+	_, otherKey, _ := newTestCertificate(t, "you@there.com")
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadSMIMESigner(certFile, writeKey(t, otherKey)); err == nil {
+		t.Error("loaded a key that does not belong to the certificate")
+	}
+}
@@ -0,0 +1,141 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSuppressed is returned for a message whose recipients are all on the
+// suppression list. It wraps ErrInvalidMessage, so the message is not retried.
+var ErrSuppressed = fmt.Errorf("%w: every recipient is suppressed", ErrInvalidMessage)
+
+// Suppression is an address on a suppression list, and why it was put there
+type Suppression struct {
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SuppressionList holds the addresses that must not be sent mail, such as those
+// that bounced for good or complained about spam. Addresses are compared without
+// their names and case, so "Ann <Ann@Example.com>" and "ann@example.com" match.
+type SuppressionList interface {
+	// Suppress adds address to the list, or updates its reason if it is there
+	Suppress(ctx context.Context, address, reason string) error
+	// Unsuppress removes address from the list
+	Unsuppress(ctx context.Context, address string) error
+	// Suppressed returns those of addresses that are on the list, normalized
+	Suppressed(ctx context.Context, addresses []string) (map[string]bool, error)
+	// List returns up to limit suppressed addresses, most recent first; a limit
+	// of 0 means no limit
+	List(ctx context.Context, limit int) ([]Suppression, error)
+}
+
+// normalizeAddress returns the bare, lower case address in a
+func normalizeAddress(a string) string {
+	if addr, err := netmail.ParseAddress(a); err == nil {
+		a = addr.Address
+	}
+	return strings.ToLower(strings.TrimSpace(a))
+}
+
+// suppress drops the recipients of msg that are on the suppression list. When
+// none are left, it returns ErrSuppressed.
+func (m *Mail) suppress(msg Message) (Message, error) {
+	if m.Suppressions == nil {
+		return msg, nil
+	}
+
+	recipients := append(append(append([]string{}, msg.To...), msg.CC...), msg.BCC...)
+	suppressed, err := m.Suppressions.Suppressed(msg.Context(), recipients)
+	if err != nil {
+		// the list is kept in a store like the outbox, so this is worth retrying
+		return msg, connFailed(fmt.Errorf("mailer: checking the suppression list: %w", err))
+	}
+	if len(suppressed) == 0 {
+		return msg, nil
+	}
+
+	keep := func(addresses []string) []string {
+		var kept []string
+		for _, a := range addresses {
+			if !suppressed[normalizeAddress(a)] {
+				kept = append(kept, a)
+			}
+		}
+		return kept
+	}
+	msg.To, msg.CC, msg.BCC = keep(msg.To), keep(msg.CC), keep(msg.BCC)
+	if len(msg.To)+len(msg.CC)+len(msg.BCC) == 0 {
+		return msg, ErrSuppressed
+	}
+	return msg, nil
+}
+
+// MemorySuppressionList keeps the suppression list in memory. It is lost when the
+// process exits, so it is meant for development and tests.
+type MemorySuppressionList struct {
+	mu        sync.Mutex
+	addresses map[string]Suppression
+}
+
+var _ SuppressionList = (*MemorySuppressionList)(nil)
+
+// NewMemorySuppressionList returns an empty MemorySuppressionList
+func NewMemorySuppressionList() *MemorySuppressionList {
+	return &MemorySuppressionList{addresses: make(map[string]Suppression)}
+}
+
+func (l *MemorySuppressionList) Suppress(ctx context.Context, address, reason string) error {
+	address = normalizeAddress(address)
+	if address == "" {
+		return errors.New("mailer: cannot suppress an empty address")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addresses[address] = Suppression{Address: address, Reason: reason, CreatedAt: time.Now()}
+	return nil
+}
+
+func (l *MemorySuppressionList) Unsuppress(ctx context.Context, address string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.addresses, normalizeAddress(address))
+	return nil
+}
+
+func (l *MemorySuppressionList) Suppressed(ctx context.Context, addresses []string) (map[string]bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	suppressed := make(map[string]bool)
+	for _, a := range addresses {
+		a = normalizeAddress(a)
+		if _, ok := l.addresses[a]; ok {
+			suppressed[a] = true
+		}
+	}
+	return suppressed, nil
+}
+
+func (l *MemorySuppressionList) List(ctx context.Context, limit int) ([]Suppression, error) {
+	l.mu.Lock()
+	list := make([]Suppression, 0, len(l.addresses))
+	for _, s := range l.addresses {
+		list = append(list, s)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
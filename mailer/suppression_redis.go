@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisSuppressionList keeps the suppression list in redis: a hash of each address
+// and its suppression as json, and a sorted set of the addresses, scored by when
+// they were suppressed. Keys start with Prefix+"#mail:suppressions", outside the
+// keys that a cache.RedisCache with the same Prefix empties, so that flushing the
+// cache does not mail suppressed addresses again.
+type RedisSuppressionList struct {
+	Conn   *redis.Pool
+	Prefix string
+}
+
+var _ SuppressionList = (*RedisSuppressionList)(nil)
+
+func (l *RedisSuppressionList) key(part string) string {
+	return l.Prefix + "#mail:suppressions:" + part
+}
+
+func (l *RedisSuppressionList) Suppress(ctx context.Context, address, reason string) error {
+	address = normalizeAddress(address)
+	if address == "" {
+		return errors.New("mailer: cannot suppress an empty address")
+	}
+	s := Suppression{Address: address, Reason: reason, CreatedAt: time.Now()}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	conn, err := l.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HSET", l.key("addresses"), address, data)
+	_ = conn.Send("ZADD", l.key("by_time"), s.CreatedAt.UnixMilli(), address)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (l *RedisSuppressionList) Unsuppress(ctx context.Context, address string) error {
+	address = normalizeAddress(address)
+
+	conn, err := l.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("HDEL", l.key("addresses"), address)
+	_ = conn.Send("ZREM", l.key("by_time"), address)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (l *RedisSuppressionList) Suppressed(ctx context.Context, addresses []string) (map[string]bool, error) {
+	suppressed := make(map[string]bool)
+	if len(addresses) == 0 {
+		return suppressed, nil
+	}
+
+	conn, err := l.Conn.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	args := redis.Args{}.Add(l.key("addresses"))
+	normalized := make([]string, len(addresses))
+	for i, a := range addresses {
+		normalized[i] = normalizeAddress(a)
+		args = args.Add(normalized[i])
+	}
+	values, err := redis.Values(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if v != nil {
+			suppressed[normalized[i]] = true
+		}
+	}
+	return suppressed, nil
+}
+
+func (l *RedisSuppressionList) List(ctx context.Context, limit int) ([]Suppression, error) {
+	conn, err := l.Conn.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	addresses, err := redis.Strings(conn.Do("ZREVRANGE", l.key("by_time"), 0, limit-1))
+	if err != nil || len(addresses) == 0 {
+		return nil, err
+	}
+
+	values, err := redis.ByteSlices(conn.Do("HMGET", redis.Args{}.Add(l.key("addresses")).AddFlat(addresses)...))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Suppression, 0, len(values))
+	for _, data := range values {
+		if data == nil {
+			continue
+		}
+		var s Suppression
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SQLSuppressionList keeps the suppression list in a table of a postgres, mysql or
+// sqlite database, created by the migration from "boilme make jobs". Dialect is
+// postgres, mysql or sqlite, and Table defaults to mail_suppressions.
+type SQLSuppressionList struct {
+	DB      *sql.DB
+	Dialect string
+	Table   string
+}
+
+var _ SuppressionList = (*SQLSuppressionList)(nil)
+
+func (l *SQLSuppressionList) table() string {
+	if l.Table == "" {
+		return "mail_suppressions"
+	}
+	return l.Table
+}
+
+func (l *SQLSuppressionList) Suppress(ctx context.Context, address, reason string) error {
+	address = normalizeAddress(address)
+	if address == "" {
+		return errors.New("mailer: cannot suppress an empty address")
+	}
+	now := time.Now().UnixMilli()
+
+	res, err := l.DB.ExecContext(ctx, rebind(l.Dialect, `update `+l.table()+`
+		set reason = ?, created_at = ? where address = ?`), reason, now, address)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	_, err = l.DB.ExecContext(ctx, rebind(l.Dialect, `insert into `+l.table()+`
+		(address, reason, created_at) values (?, ?, ?)`), address, reason, now)
+	if err != nil && isUniqueViolation(err) {
+		// suppressed at the same time by another call, or mysql reported no rows
+		// affected by an update that changed nothing
+		return nil
+	}
+	return err
+}
+
+func (l *SQLSuppressionList) Unsuppress(ctx context.Context, address string) error {
+	_, err := l.DB.ExecContext(ctx, rebind(l.Dialect, `delete from `+l.table()+` where address = ?`),
+		normalizeAddress(address))
+	return err
+}
+
+func (l *SQLSuppressionList) Suppressed(ctx context.Context, addresses []string) (map[string]bool, error) {
+	suppressed := make(map[string]bool)
+	if len(addresses) == 0 {
+		return suppressed, nil
+	}
+
+	args := make([]interface{}, len(addresses))
+	for i, a := range addresses {
+		args[i] = normalizeAddress(a)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := l.DB.QueryContext(ctx, rebind(l.Dialect, `select address from `+l.table()+`
+		where address in (`+placeholders+`)`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		suppressed[address] = true
+	}
+	return suppressed, rows.Err()
+}
+
+func (l *SQLSuppressionList) List(ctx context.Context, limit int) ([]Suppression, error) {
+	query := `select address, reason, created_at from ` + l.table() + ` order by created_at desc`
+	var args []interface{}
+	if limit > 0 {
+		query += ` limit ?`
+		args = append(args, limit)
+	}
+
+	rows, err := l.DB.QueryContext(ctx, rebind(l.Dialect, query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Suppression
+	for rows.Next() {
+		var s Suppression
+		var createdAt int64
+		if err := rows.Scan(&s.Address, &s.Reason, &createdAt); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = time.UnixMilli(createdAt)
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,154 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+)
+
+// newSQLSuppressionList returns a new, empty SQLSuppressionList, when the tests
+// are built with a sql driver
+var newSQLSuppressionList func(t *testing.T) SuppressionList
+
+// testSuppressionLists returns an empty list of every kind that runs without a server
+func testSuppressionLists(t *testing.T) map[string]SuppressionList {
+	lists := map[string]SuppressionList{
+		"memory": NewMemorySuppressionList(),
+		"redis":  &RedisSuppressionList{Conn: newTestRedisPool(t), Prefix: "test"},
+	}
+	if newSQLSuppressionList != nil {
+		lists["sql"] = newSQLSuppressionList(t)
+	}
+	return lists
+}
+
+func TestSuppressionList(t *testing.T) {
+	ctx := context.Background()
+
+	for name, list := range testSuppressionLists(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := list.Suppress(ctx, "Ann <Ann@Example.com>", "bounced"); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * time.Millisecond)
+			if err := list.Suppress(ctx, "bob@example.com", "complained"); err != nil {
+				t.Fatal(err)
+			}
+			if err := list.Suppress(ctx, "", "bounced"); err == nil {
+				t.Error("suppressed an empty address")
+			}
+
+			got, err := list.Suppressed(ctx, []string{"ann@example.com", "Bob <BOB@example.com>", "carol@example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string]bool{"ann@example.com": true, "bob@example.com": true}; !reflect.DeepEqual(got, want) {
+				t.Errorf("suppressed %v, want %v", got, want)
+			}
+
+			all, err := list.List(ctx, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 2 || all[0].Address != "bob@example.com" || all[1].Address != "ann@example.com" {
+				t.Fatalf("listed %+v, want bob then ann", all)
+			}
+			if all[1].Reason != "bounced" || all[1].CreatedAt.IsZero() {
+				t.Errorf("ann was listed as %+v", all[1])
+			}
+			if first, err := list.List(ctx, 1); err != nil || len(first) != 1 {
+				t.Errorf("a limit of 1 listed %+v, %v", first, err)
+			}
+
+			// suppressing again updates the reason
+			time.Sleep(2 * time.Millisecond)
+			if err := list.Suppress(ctx, "ann@example.com", "complained"); err != nil {
+				t.Fatal(err)
+			}
+			if all, _ = list.List(ctx, 0); len(all) != 2 || all[0].Address != "ann@example.com" || all[0].Reason != "complained" {
+				t.Errorf("after suppressing ann again, listed %+v", all)
+			}
+
+			if err := list.Unsuppress(ctx, "ANN@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ = list.Suppressed(ctx, []string{"ann@example.com"}); len(got) != 0 {
+				t.Errorf("ann is still suppressed: %v", got)
+			}
+			if all, _ = list.List(ctx, 0); len(all) != 1 {
+				t.Errorf("after unsuppressing ann, listed %+v", all)
+			}
+		})
+	}
+}
+
+func TestMail_sendSkipsSuppressed(t *testing.T) {
+	ctx := context.Background()
+	m := newCaptureMail(t, "file")
+	m.Suppressions = NewMemorySuppressionList()
+	if err := m.Suppressions.Suppress(ctx, "bob@example.com", "bounced"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Suppressions.Suppress(ctx, "audit@example.com", "complained"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := Message{
+		To:       []string{"alice@example.com", "Bob <bob@example.com>"},
+		BCC:      []string{"audit@example.com"},
+		Subject:  "hello",
+		Template: "welcome",
+		Data:     map[string]interface{}{"Name": "Alice", "Joined": "2024-03-05T00:00:00Z", "Link": "https://example.com"},
+	}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	captured, err := m.Captured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("expected 1 captured message, got %d", len(captured))
+	}
+	if got := captured[0]; !reflect.DeepEqual(got.To, []string{"alice@example.com"}) || len(got.BCC) != 0 {
+		t.Errorf("sent to %v and bcc %v, want only alice", got.To, got.BCC)
+	}
+
+	msg.To = []string{"bob@example.com"}
+	err = m.Send(msg)
+	if !errors.Is(err, ErrSuppressed) || IsTransient(err) {
+		t.Errorf("sending only to suppressed addresses returned %v, want a permanent ErrSuppressed", err)
+	}
+	if captured, _ = m.Captured(); len(captured) != 1 {
+		t.Errorf("a message to suppressed addresses was sent")
+	}
+}
+
+func TestRedisSuppressionList_survivesCacheEmpty(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestRedisPool(t)
+	list := &RedisSuppressionList{Conn: pool, Prefix: "test"}
+	if err := list.Suppress(ctx, "bounced@example.com", "bounce"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cache.RedisCache{Conn: pool, Prefix: "test"}
+	if err := c.Set("page", "cached", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Empty(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := list.Suppressed(ctx, []string{"bounced@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got["bounced@example.com"] {
+		t.Error("the address is no longer suppressed after emptying the cache")
+	}
+}
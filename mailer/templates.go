@@ -0,0 +1,280 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Mail templates live in the Templates directory. A message with Template "welcome"
+// is rendered from welcome.html.tmpl and welcome.plain.tmpl, or from a variant for
+// its Locale, such as welcome.de.html.tmpl, when there is one. Every file in the
+// layouts and partials subdirectories with the same suffix is parsed along with it.
+// When a layout defines "layout", it is executed, and is expected to call
+// {{template "body" .}}; otherwise "body" is executed on its own. A template that
+// defines "subject" provides the subject of messages that don't set their own.
+
+// template kinds, which are also the suffixes of their files
+const (
+	kindHTML  = "html"
+	kindPlain = "plain"
+)
+
+// parsedTemplate is a message template parsed with its layouts and partials
+type parsedTemplate struct {
+	html  *htmltemplate.Template
+	plain *texttemplate.Template
+}
+
+// validLocale matches the locales that pick variants of a template, such as de,
+// de-AT or zh_Hant
+var validLocale = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]+)?$`)
+
+// checkTemplate returns an error wrapping ErrInvalidMessage unless name is the name
+// of a file in Templates, and locale a valid locale or empty, so that neither can
+// lead to a file outside Templates
+func checkTemplate(name, locale string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("%w: template name %q", ErrInvalidMessage, name)
+	}
+	if locale != "" && !validLocale.MatchString(locale) {
+		return fmt.Errorf("%w: locale %q", ErrInvalidMessage, locale)
+	}
+	return nil
+}
+
+// TemplateCache holds parsed mail templates, keyed by their file, so that it holds
+// no more than the files in Templates whatever the locales asked for. It is safe
+// for concurrent use, and may be shared by copies of a Mail.
+type TemplateCache struct {
+	mu        sync.RWMutex
+	templates map[string]*parsedTemplate
+}
+
+// NewTemplateCache returns an empty template cache
+func NewTemplateCache() *TemplateCache {
+	return &TemplateCache{templates: make(map[string]*parsedTemplate)}
+}
+
+// Clear drops the parsed templates, so that they are read again from disk the
+// next time they are used
+func (tc *TemplateCache) Clear() {
+	tc.mu.Lock()
+	tc.templates = make(map[string]*parsedTemplate)
+	tc.mu.Unlock()
+}
+
+// lookupTemplate returns the template of the given kind for name and locale. It is
+// parsed, unless TemplateCache already holds it.
+func (m *Mail) lookupTemplate(kind, name, locale string) (*parsedTemplate, error) {
+	if err := checkTemplate(name, locale); err != nil {
+		return nil, err
+	}
+	file, err := m.templateFile(kind, name, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := m.TemplateCache
+	if tc == nil {
+		t, err := m.parseTemplate(kind, name, file)
+		if err != nil {
+			return nil, err
+		}
+		return m.localized(t, locale)
+	}
+
+	key := kind + "|" + file
+	tc.mu.RLock()
+	t, ok := tc.templates[key]
+	tc.mu.RUnlock()
+	if !ok {
+		if t, err = m.parseTemplate(kind, name, file); err != nil {
+			return nil, err
+		}
+		tc.mu.Lock()
+		tc.templates[key] = t
+		tc.mu.Unlock()
+	}
+	return m.localized(t, locale)
+}
+
+// parseTemplate parses file, the template for name, along with the layouts and
+// partials of the same kind
+func (m *Mail) parseTemplate(kind, name, file string) (*parsedTemplate, error) {
+	var files []string
+	for _, dir := range []string{"layouts", "partials"} {
+		matches, err := filepath.Glob(filepath.Join(m.Templates, dir, "*."+kind+".tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	// the message template is parsed last, so that it can override a layout
+	files = append(files, file)
+
+	funcs := m.templateFuncs()
+	t := &parsedTemplate{}
+	var err error
+	if kind == kindHTML {
+		t.html, err = htmltemplate.New(name).Funcs(htmltemplate.FuncMap(funcs)).ParseFiles(files...)
+	} else {
+		t.plain, err = texttemplate.New(name).Funcs(texttemplate.FuncMap(funcs)).ParseFiles(files...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// localized returns a copy of t whose locale function returns locale, unless Funcs
+// replaces it. Templates in the cache are never executed, so they can be copied.
+func (m *Mail) localized(t *parsedTemplate, locale string) (*parsedTemplate, error) {
+	funcs := map[string]interface{}{"locale": func() string { return locale }}
+	if _, ok := m.Funcs["locale"]; ok {
+		funcs = nil
+	}
+
+	c := &parsedTemplate{}
+	var err error
+	if t.html != nil {
+		if c.html, err = t.html.Clone(); err == nil {
+			c.html.Funcs(htmltemplate.FuncMap(funcs))
+		}
+	} else {
+		if c.plain, err = t.plain.Clone(); err == nil {
+			c.plain.Funcs(texttemplate.FuncMap(funcs))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// templateFile returns the path of the file for name in locale: name.de-AT.html.tmpl,
+// then name.de.html.tmpl, then name.html.tmpl, whichever exists first
+func (m *Mail) templateFile(kind, name, locale string) (string, error) {
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			candidates = append(candidates, locale[:i])
+		}
+	}
+	candidates = append(candidates, "")
+
+	for _, loc := range candidates {
+		base := name
+		if loc != "" {
+			base += "." + loc
+		}
+		path := filepath.Join(m.Templates, base+"."+kind+".tmpl")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("mailer: no %s template for %s in %s", kind, name, m.Templates)
+}
+
+// templateFuncs returns the functions available to mail templates: those of Funcs,
+// and the built in ones, which Funcs may replace. locale is set by localized.
+func (m *Mail) templateFuncs() map[string]interface{} {
+	funcs := map[string]interface{}{
+		"signURL":    m.signURL,
+		"formatDate": formatDate,
+		"locale":     func() string { return "" },
+	}
+	for k, v := range m.Funcs {
+		funcs[k] = v
+	}
+	return funcs
+}
+
+// signURL signs u with Signer, so that the link can be verified when it is followed
+func (m *Mail) signURL(u string) (string, error) {
+	if m.Signer == nil {
+		return "", errors.New("mailer: signURL needs a Signer")
+	}
+	return m.Signer.GenerateTokenFromString(u), nil
+}
+
+// formatDate formats t with layout. Besides times, it accepts RFC 3339 strings,
+// which is what times become once a message has been through the outbox.
+func formatDate(layout string, t interface{}) (string, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(layout), nil
+	default:
+		return "", fmt.Errorf("mailer: formatDate cannot format %T", t)
+	}
+}
+
+// render executes the layout of t, or its body, with data
+func (t *parsedTemplate) render(data interface{}) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	if t.html != nil {
+		entry := "body"
+		if t.html.Lookup("layout") != nil {
+			entry = "layout"
+		}
+		err = t.html.ExecuteTemplate(&buf, entry, data)
+	} else {
+		entry := "body"
+		if t.plain.Lookup("layout") != nil {
+			entry = "layout"
+		}
+		err = t.plain.ExecuteTemplate(&buf, entry, data)
+	}
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// subject renders the "subject" template of t, if it defines one
+func (t *parsedTemplate) subject(data interface{}) (string, bool, error) {
+	var buf bytes.Buffer
+	var err error
+	switch {
+	case t.plain != nil && t.plain.Lookup("subject") != nil:
+		err = t.plain.ExecuteTemplate(&buf, "subject", data)
+	case t.html != nil && t.html.Lookup("subject") != nil:
+		err = t.html.ExecuteTemplate(&buf, "subject", data)
+		if err == nil {
+			// the subject is a header, not html, so undo the escaping
+			unescaped := html.UnescapeString(buf.String())
+			buf.Reset()
+			buf.WriteString(unescaped)
+		}
+	default:
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.Join(strings.Fields(buf.String()), " "), true, nil
+}
@@ -0,0 +1,181 @@
+package mailer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/urlsigner"
+)
+
+func TestMail_compose_layoutsAndPartials(t *testing.T) {
+	m := &Mail{
+		Templates:   "./testdata/layout",
+		FromAddress: "me@here.com",
+		Signer:      &urlsigner.Signer{Secret: []byte("secret")},
+	}
+
+	data := map[string]interface{}{
+		"Name":   "Ann & Bob",
+		"Joined": time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		"Link":   "https://example.com/confirm?id=1",
+	}
+	c, err := m.compose(Message{To: []string{"you@there.com"}, Template: "welcome", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Subject != "Welcome, Ann & Bob & friends" {
+		t.Errorf("wrong subject: %q", c.Subject)
+	}
+	for _, want := range []string{"you joined on 5 Mar 2024", "Sent by Boilme", "<body>", "hash="} {
+		if !strings.Contains(c.HTML, want) {
+			t.Errorf("html does not contain %q:\n%s", want, c.HTML)
+		}
+	}
+	if !strings.Contains(c.Plain, "Ann & Bob") || !strings.Contains(c.Plain, "-- Sent by Boilme") {
+		t.Errorf("unexpected plain text:\n%s", c.Plain)
+	}
+
+	c, err = m.compose(Message{To: []string{"you@there.com"}, Template: "welcome", Subject: "Hi", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "Hi" {
+		t.Errorf("subject of the message was replaced: %q", c.Subject)
+	}
+}
+
+func TestMail_compose_locale(t *testing.T) {
+	m := &Mail{Templates: "./testdata/layout", FromAddress: "me@here.com", Signer: &urlsigner.Signer{Secret: []byte("secret")}}
+
+	data := map[string]interface{}{"Name": "Ann", "Joined": "2024-03-05T00:00:00Z", "Link": "https://example.com"}
+	c, err := m.compose(Message{To: []string{"you@there.com"}, Template: "welcome", Locale: "de-AT", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "Willkommen, Ann" {
+		t.Errorf("wrong subject: %q", c.Subject)
+	}
+	if !strings.Contains(c.Plain, "Hallo Ann (de-AT)") {
+		t.Errorf("unexpected plain text:\n%s", c.Plain)
+	}
+
+	// a locale without templates of its own falls back to the default ones
+	c, err = m.compose(Message{To: []string{"you@there.com"}, Template: "welcome", Locale: "fr", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(c.Plain, "you joined on 5 Mar 2024") {
+		t.Errorf("unexpected plain text:\n%s", c.Plain)
+	}
+}
+
+func TestMail_templateCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(body string) {
+		for _, kind := range []string{"html", "plain"} {
+			err := os.WriteFile(filepath.Join(dir, "note."+kind+".tmpl"), []byte(`{{define "body"}}`+body+`{{end}}`), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	m := &Mail{Templates: dir, TemplateCache: NewTemplateCache()}
+	msg := Message{Template: "note"}
+
+	write("first")
+	if s, err := m.buildPlainTextMessage(msg); err != nil || s != "first" {
+		t.Fatalf("got %q, %v", s, err)
+	}
+
+	write("second")
+	if s, _ := m.buildPlainTextMessage(msg); s != "first" {
+		t.Errorf("template was not cached: %q", s)
+	}
+
+	m.TemplateCache.Clear()
+	if s, _ := m.buildPlainTextMessage(msg); s != "second" {
+		t.Errorf("template was not parsed again: %q", s)
+	}
+
+	// without a cache, templates are parsed for every message
+	m.TemplateCache = nil
+	write("third")
+	if s, _ := m.buildPlainTextMessage(msg); s != "third" {
+		t.Errorf("template was not parsed again: %q", s)
+	}
+}
+
+func TestMail_signURL_withoutSigner(t *testing.T) {
+	m := &Mail{Templates: "./testdata/layout"}
+	_, err := m.buildHTMLMessage(Message{Template: "welcome", Data: map[string]interface{}{"Joined": time.Now()}})
+	if err == nil {
+		t.Error("expected an error signing a url without a signer")
+	}
+}
+
+func TestMail_lookupTemplate_rejectsPaths(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "x.plain.tmpl"), []byte(`{{define "body"}}outside{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(dir, outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Mail{Templates: dir, TemplateCache: NewTemplateCache()}
+
+	tests := []struct {
+		name, template, locale string
+	}{
+		{"locale with a path", "welcome", "x/" + rel + "/x"},
+		{"locale with dots", "welcome", "de..AT"},
+		{"long language", "welcome", "deutsch"},
+		{"name with a path", rel + "/x", ""},
+		{"name with a backslash", `..\x`, ""},
+		{"name with dots", "..", ""},
+		{"empty name", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.lookupTemplate(kindPlain, tt.template, tt.locale)
+			if !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("got %v, want ErrInvalidMessage", err)
+			}
+		})
+	}
+}
+
+func TestMail_templateCache_boundedByFiles(t *testing.T) {
+	m := &Mail{Templates: "./testdata/layout", Signer: &urlsigner.Signer{Secret: []byte("secret")}, TemplateCache: NewTemplateCache()}
+	data := map[string]interface{}{"Name": "Ann", "Joined": "2024-03-05T00:00:00Z", "Link": "https://example.com"}
+
+	var wg sync.WaitGroup
+	for _, locale := range []string{"de-AT", "de-CH", "de", "fr", "fr-CA", "xx-junk1", "xx-junk2", "xx-junk3"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := m.buildPlainTextMessage(Message{Template: "welcome", Locale: locale, Data: data})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// locales that share a file still see their own locale
+			if strings.HasPrefix(locale, "de") && !strings.Contains(s, "("+locale+")") {
+				t.Errorf("%s: unexpected plain text:\n%s", locale, s)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// welcome.de.plain.tmpl and welcome.plain.tmpl
+	if n := len(m.TemplateCache.templates); n != 2 {
+		t.Errorf("the cache holds %d templates, want 2", n)
+	}
+}
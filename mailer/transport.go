@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendTimeout bounds the time a transport may take to send a message
+const sendTimeout = 10 * time.Second
+
+// Transport hands composed messages to a mail server or api. A Transport may be
+// used by several goroutines at once. When it is also an io.Closer, it is closed
+// once it is no longer needed.
+type Transport interface {
+	Send(ctx context.Context, c *Composed) error
+}
+
+// Pinger is implemented by transports that can check that mail can be handed off,
+// without sending any
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// TransportFactory creates a transport from the settings of m, and returns an
+// error when they are missing something the transport needs
+type TransportFactory func(m *Mail) (Transport, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]TransportFactory{
+		"smtp":      newSMTPTransport,
+		"log":       newCaptureTransport,
+		"file":      newCaptureTransport,
+		"mailgun":   newMailgunTransport,
+		"sendgrid":  newSendGridTransport,
+		"sparkpost": newSparkPostTransport,
+		"ses":       newSESTransport,
+		"postmark":  newPostmarkTransport,
+		"http":      newHTTPTransport,
+	}
+)
+
+// RegisterTransport makes a transport available as API under name, replacing any
+// registered before it. It is usually called from an init function, so that the
+// name is known when the configuration is checked.
+func RegisterTransport(name string, factory TransportFactory) {
+	if name == "" || factory == nil {
+		panic("mailer: RegisterTransport needs a name and a factory")
+	}
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = factory
+}
+
+// Transports returns the names of the registered transports, sorted
+func Transports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTransport creates the transport registered for API, or an smtp transport
+// when API is empty. Keep it in Transport, so that it is used for every message.
+func (m *Mail) NewTransport() (Transport, error) {
+	return m.newTransport(m.transportName())
+}
+
+func (m *Mail) newTransport(name string) (Transport, error) {
+	transportsMu.RLock()
+	factory, ok := transports[name]
+	transportsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("mailer: unknown api %s; only %s accepted", name, strings.Join(Transports(), ", "))
+	}
+	return factory(m)
+}
+
+// transportName returns the name of the transport mail is sent with
+func (m *Mail) transportName() string {
+	if m.API == "" {
+		return "smtp"
+	}
+	return m.API
+}
+
+// closeTransport closes t, if it can be closed
+func closeTransport(t Transport) {
+	if c, ok := t.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// pingURL connects to the host of rawURL, on port 443 unless it names another
+func pingURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
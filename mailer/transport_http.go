@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HTTPTransport posts every message as an HTTPMessage in json to URL, for mail
+// services without a transport of their own, or a service of the application
+// that sends mail. Token, if set, is sent as a bearer token, and Headers are added
+// to every request. A response of 2xx means the message was accepted; 429 and 5xx
+// are retried.
+type HTTPTransport struct {
+	URL        string
+	Token      string
+	Headers    map[string]string
+	HTTPClient *http.Client
+}
+
+var (
+	_ Transport = (*HTTPTransport)(nil)
+	_ Pinger    = (*HTTPTransport)(nil)
+)
+
+// HTTPMessage is the body HTTPTransport posts. Attachments are base64 encoded,
+// as json encodes bytes.
+type HTTPMessage struct {
+	From        string            `json:"from"`
+	FromName    string            `json:"from_name,omitempty"`
+	To          []string          `json:"to,omitempty"`
+	CC          []string          `json:"cc,omitempty"`
+	BCC         []string          `json:"bcc,omitempty"`
+	ReplyTo     string            `json:"reply_to,omitempty"`
+	Subject     string            `json:"subject"`
+	HTML        string            `json:"html,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	MessageID   string            `json:"message_id,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Attachments []HTTPAttachment  `json:"attachments,omitempty"`
+}
+
+// HTTPAttachment is a file attached to an HTTPMessage
+type HTTPAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// newHTTPTransport posts to APIUrl, with APIKey as the bearer token
+func newHTTPTransport(m *Mail) (Transport, error) {
+	if m.APIUrl == "" {
+		return nil, errors.New("mailer: the http api needs APIUrl")
+	}
+	return &HTTPTransport{URL: m.APIUrl, Token: m.APIKey, HTTPClient: m.HTTPClient}, nil
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, c *Composed) error {
+	message := HTTPMessage{
+		From:      c.From,
+		FromName:  c.FromName,
+		To:        c.To,
+		CC:        c.CC,
+		BCC:       c.BCC,
+		ReplyTo:   c.ReplyTo,
+		Subject:   c.Subject,
+		HTML:      c.HTML,
+		Text:      c.Plain,
+		MessageID: c.MessageID,
+		Headers:   c.Headers,
+	}
+	for _, f := range c.Files {
+		message.Attachments = append(message.Attachments, HTTPAttachment{Name: f.Name, ContentType: f.mimeType(), Data: f.Data})
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return postJSON(httpClientOr(t.HTTPClient), req, "http")
+}
+
+func (t *HTTPTransport) Ping(ctx context.Context) error {
+	return pingURL(ctx, t.URL)
+}
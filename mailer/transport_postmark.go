@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PostmarkTransport sends mail with the api of Postmark, authenticated by the
+// token of a server. APIURL defaults to the api of Postmark, and MessageStream,
+// if set, names the stream messages are sent in, rather than the default
+// transactional one.
+type PostmarkTransport struct {
+	APIURL        string
+	ServerToken   string
+	MessageStream string
+	HTTPClient    *http.Client
+}
+
+var (
+	_ Transport = (*PostmarkTransport)(nil)
+	_ Pinger    = (*PostmarkTransport)(nil)
+)
+
+// postmarkURL is where the api of Postmark is
+const postmarkURL = "https://api.postmarkapp.com"
+
+// newPostmarkTransport takes the server token from APIKey
+func newPostmarkTransport(m *Mail) (Transport, error) {
+	if err := requireAPIKey(m, "postmark"); err != nil {
+		return nil, err
+	}
+	return &PostmarkTransport{APIURL: m.APIUrl, ServerToken: m.APIKey, HTTPClient: m.HTTPClient}, nil
+}
+
+func (t *PostmarkTransport) apiURL() string {
+	if t.APIURL == "" {
+		return postmarkURL
+	}
+	return strings.TrimSuffix(t.APIURL, "/")
+}
+
+// postmarkMessage is the body of a message sent to the email endpoint of Postmark
+type postmarkMessage struct {
+	From          string               `json:"From"`
+	To            string               `json:"To,omitempty"`
+	Cc            string               `json:"Cc,omitempty"`
+	Bcc           string               `json:"Bcc,omitempty"`
+	ReplyTo       string               `json:"ReplyTo,omitempty"`
+	Subject       string               `json:"Subject"`
+	HTMLBody      string               `json:"HtmlBody,omitempty"`
+	TextBody      string               `json:"TextBody,omitempty"`
+	MessageStream string               `json:"MessageStream,omitempty"`
+	Headers       []postmarkHeader     `json:"Headers,omitempty"`
+	Attachments   []postmarkAttachment `json:"Attachments,omitempty"`
+}
+
+type postmarkHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type postmarkAttachment struct {
+	Name        string `json:"Name"`
+	Content     string `json:"Content"`
+	ContentType string `json:"ContentType"`
+}
+
+func (t *PostmarkTransport) Send(ctx context.Context, c *Composed) error {
+	message := postmarkMessage{
+		From:          c.Sender(),
+		To:            strings.Join(c.To, ","),
+		Cc:            strings.Join(c.CC, ","),
+		Bcc:           strings.Join(c.BCC, ","),
+		ReplyTo:       c.ReplyTo,
+		Subject:       c.Subject,
+		HTMLBody:      c.HTML,
+		TextBody:      c.Plain,
+		MessageStream: t.MessageStream,
+	}
+	if c.MessageID != "" {
+		message.Headers = append(message.Headers, postmarkHeader{Name: "Message-ID", Value: c.MessageID})
+	}
+	names := make([]string, 0, len(c.Headers))
+	for k := range c.Headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		message.Headers = append(message.Headers, postmarkHeader{Name: k, Value: c.Headers[k]})
+	}
+	for _, f := range c.Files {
+		message.Attachments = append(message.Attachments, postmarkAttachment{
+			Name:        f.Name,
+			Content:     base64.StdEncoding.EncodeToString(f.Data),
+			ContentType: f.mimeType(),
+		})
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.apiURL()+"/email", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", t.ServerToken)
+
+	return postJSON(httpClientOr(t.HTTPClient), req, "postmark")
+}
+
+// postJSON sends req, and returns an APIError naming api when it is rejected
+func postJSON(client *http.Client, req *http.Request, api string) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return connFailed(err)
+	}
+	defer res.Body.Close()
+
+	// the body of an error explains it; that of a success is not needed
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode >= http.StatusBadRequest {
+		return &APIError{API: api, StatusCode: res.StatusCode, Body: string(data)}
+	}
+	return nil
+}
+
+func (t *PostmarkTransport) Ping(ctx context.Context) error {
+	return pingURL(ctx, t.apiURL())
+}
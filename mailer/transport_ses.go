@@ -0,0 +1,144 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sesv2"
+)
+
+// SESTransport sends mail with the v2 api of Amazon SES, as raw messages, so that
+// attachments and custom headers are kept. Region defaults to that of the
+// environment (AWS_REGION), and Endpoint to the endpoint of Region. Without
+// AccessKeyID, credentials come from the environment, the shared credentials
+// file or the role of the instance. ConfigurationSet, if set, names the
+// configuration set the message is sent with.
+type SESTransport struct {
+	Region           string
+	Endpoint         string
+	AccessKeyID      string
+	SecretAccessKey  string
+	ConfigurationSet string
+	HTTPClient       *http.Client
+
+	once   sync.Once
+	client *sesv2.SESV2
+	err    error
+}
+
+var (
+	_ Transport = (*SESTransport)(nil)
+	_ Pinger    = (*SESTransport)(nil)
+)
+
+// sesEndpoint matches the endpoints of SES, and captures their region
+var sesEndpoint = regexp.MustCompile(`^email(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// newSESTransport takes the endpoint, and so the region, from APIUrl, such as
+// https://email.eu-west-1.amazonaws.com, and the credentials from APIKey, as
+// access key id:secret access key
+func newSESTransport(m *Mail) (Transport, error) {
+	t := &SESTransport{Endpoint: m.APIUrl, HTTPClient: m.HTTPClient}
+	if m.APIUrl != "" {
+		u, err := url.Parse(m.APIUrl)
+		if err != nil {
+			return nil, fmt.Errorf("mailer: ses endpoint: %w", err)
+		}
+		if match := sesEndpoint.FindStringSubmatch(u.Hostname()); match != nil {
+			t.Region = match[1]
+		}
+	}
+	if m.APIKey != "" {
+		id, secret, ok := strings.Cut(m.APIKey, ":")
+		if !ok {
+			return nil, errors.New("mailer: the ses api needs APIKey as access key id:secret access key")
+		}
+		t.AccessKeyID, t.SecretAccessKey = id, secret
+	}
+
+	if _, err := t.sesClient(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// sesClient returns the client of the SES api, created on first use
+func (t *SESTransport) sesClient() (*sesv2.SESV2, error) {
+	t.once.Do(func() {
+		config := aws.NewConfig()
+		if t.Region != "" {
+			config = config.WithRegion(t.Region)
+		}
+		if t.Endpoint != "" {
+			config = config.WithEndpoint(t.Endpoint)
+		}
+		if t.AccessKeyID != "" {
+			config = config.WithCredentials(credentials.NewStaticCredentials(t.AccessKeyID, t.SecretAccessKey, ""))
+		}
+		if t.HTTPClient != nil {
+			config = config.WithHTTPClient(t.HTTPClient)
+		}
+
+		sess, err := session.NewSessionWithOptions(session.Options{Config: *config, SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			t.err = fmt.Errorf("mailer: ses: %w", err)
+			return
+		}
+		if aws.StringValue(sess.Config.Region) == "" {
+			t.err = errors.New("mailer: the ses api needs a region: set AWS_REGION, or APIUrl to https://email.<region>.amazonaws.com")
+			return
+		}
+		t.client = sesv2.New(sess)
+	})
+	return t.client, t.err
+}
+
+func (t *SESTransport) Send(ctx context.Context, c *Composed) error {
+	client, err := t.sesClient()
+	if err != nil {
+		return err
+	}
+	raw, err := c.MIME()
+	if err != nil {
+		return err
+	}
+
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(c.Sender()),
+		Destination: &sesv2.Destination{
+			ToAddresses:  aws.StringSlice(c.To),
+			CcAddresses:  aws.StringSlice(c.CC),
+			BccAddresses: aws.StringSlice(c.BCC),
+		},
+		Content: &sesv2.EmailContent{Raw: &sesv2.RawMessage{Data: raw}},
+	}
+	if t.ConfigurationSet != "" {
+		input.ConfigurationSetName = aws.String(t.ConfigurationSet)
+	}
+
+	_, err = client.SendEmailWithContext(ctx, input)
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) {
+		return &APIError{API: "ses", StatusCode: failure.StatusCode(), Body: failure.Code() + ": " + failure.Message()}
+	}
+	return connFailed(err)
+}
+
+// Ping connects to the endpoint of SES
+func (t *SESTransport) Ping(ctx context.Context) error {
+	client, err := t.sesClient()
+	if err != nil {
+		return err
+	}
+	return pingURL(ctx, client.Endpoint)
+}
@@ -0,0 +1,234 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// The defaults of SMTPTransport
+const (
+	defaultSMTPMaxIdle     = 2
+	defaultSMTPIdleTimeout = 30 * time.Second
+)
+
+// SMTPTransport sends mail over smtp. Encryption is tls (STARTTLS, the default),
+// ssl or none. Connections are kept open between messages, up to MaxIdle of them
+// (2 when 0, none when negative), and closed once they have been idle for
+// IdleTimeout (30s when 0), as servers drop idle clients. DKIM, if set, signs
+// every message, and SMIME signs the messages that ask for it with S/MIME.
+type SMTPTransport struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+
+	MaxIdle     int
+	IdleTimeout time.Duration
+
+	DKIM  *DKIMSigner
+	SMIME *SMIMESigner
+
+	mu     sync.Mutex
+	idle   []idleSMTPClient
+	closed bool
+}
+
+// idleSMTPClient is a connection waiting for the next message
+type idleSMTPClient struct {
+	client *mail.SMTPClient
+	since  time.Time
+}
+
+var (
+	_ Transport = (*SMTPTransport)(nil)
+	_ Pinger    = (*SMTPTransport)(nil)
+)
+
+func newSMTPTransport(m *Mail) (Transport, error) {
+	t := &SMTPTransport{
+		Host:       m.Host,
+		Port:       m.Port,
+		Username:   m.Username,
+		Password:   m.Password,
+		Encryption: m.Encryption,
+	}
+
+	var err error
+	if m.DKIMSelector != "" || m.DKIMKeyFile != "" {
+		domain := m.DKIMDomain
+		if domain == "" {
+			domain = m.Domain
+		}
+		if t.DKIM, err = LoadDKIMSigner(domain, m.DKIMSelector, m.DKIMKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if m.SMIMECertFile != "" || m.SMIMEKeyFile != "" {
+		if t.SMIME, err = LoadSMIMESigner(m.SMIMECertFile, m.SMIMEKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *SMTPTransport) maxIdle() int {
+	if t.MaxIdle == 0 {
+		return defaultSMTPMaxIdle
+	}
+	return t.MaxIdle
+}
+
+func (t *SMTPTransport) idleTimeout() time.Duration {
+	if t.IdleTimeout <= 0 {
+		return defaultSMTPIdleTimeout
+	}
+	return t.IdleTimeout
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, c *Composed) error {
+	email, err := c.email()
+	if err != nil {
+		return err
+	}
+	raw, err := t.message(c, email)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return connFailed(err)
+	}
+
+	client, err := t.get()
+	if err != nil {
+		return connFailed(err)
+	}
+	if err := mail.SendMessage(email.GetFrom(), email.GetRecipients(), string(raw), client); err != nil {
+		// the connection may be in any state, so it is not used again
+		_ = client.Close()
+		return connFailed(err)
+	}
+	t.put(client)
+	return nil
+}
+
+// message returns email as it is sent: as go-simple-mail builds it, protected with
+// S/MIME when c asks for it, and then signed with DKIM, which has to come last as
+// it signs the headers and body as they are sent
+func (t *SMTPTransport) message(c *Composed, email *mail.Email) ([]byte, error) {
+	raw := crlf([]byte(email.GetMessage()))
+
+	var err error
+	if c.SMIME != nil {
+		if raw, err = c.SMIME.apply(raw, t.SMIME); err != nil {
+			return nil, err
+		}
+	}
+	if t.DKIM != nil {
+		if raw, err = t.DKIM.Sign(raw); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// get returns an idle connection that still answers, or a new one
+func (t *SMTPTransport) get() (*mail.SMTPClient, error) {
+	for {
+		t.mu.Lock()
+		n := len(t.idle)
+		if n == 0 {
+			t.mu.Unlock()
+			break
+		}
+		// the most recently used connection is the most likely to be alive
+		idle := t.idle[n-1]
+		t.idle = t.idle[:n-1]
+		t.mu.Unlock()
+
+		if time.Since(idle.since) < t.idleTimeout() && idle.client.Noop() == nil {
+			return idle.client, nil
+		}
+		_ = idle.client.Close()
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = t.Host
+	server.Port = t.Port
+	server.Username = t.Username
+	server.Password = t.Password
+	server.Encryption = getEncryption(t.Encryption)
+	server.KeepAlive = true
+	server.ConnectTimeout = sendTimeout
+	server.SendTimeout = sendTimeout
+	return server.Connect()
+}
+
+// put keeps client for the next message, or closes it when enough are kept
+func (t *SMTPTransport) put(client *mail.SMTPClient) {
+	t.mu.Lock()
+	if !t.closed && len(t.idle) < t.maxIdle() {
+		t.idle = append(t.idle, idleSMTPClient{client: client, since: time.Now()})
+		client = nil
+	}
+	t.mu.Unlock()
+
+	if client != nil {
+		_ = client.Quit()
+		_ = client.Close()
+	}
+}
+
+// Close closes the idle connections. Messages can still be sent afterwards, but
+// their connections are closed once they are sent.
+func (t *SMTPTransport) Close() error {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle, t.closed = nil, true
+	t.mu.Unlock()
+
+	for _, c := range idle {
+		_ = c.client.Quit()
+		_ = c.client.Close()
+	}
+	return nil
+}
+
+// Ping connects to the smtp server, and waits for its greeting
+func (t *SMTPTransport) Ping(ctx context.Context) error {
+	if t.Host == "" {
+		return errors.New("no smtp host configured")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.Host, strconv.Itoa(t.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// with implicit tls (port 465) the greeting only arrives after a handshake,
+	// so reaching the port has to do
+	if getEncryption(t.Encryption) == mail.EncryptionSSL {
+		return nil
+	}
+
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	_ = tp.PrintfLine("QUIT")
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts mail over smtp without checking it, and counts the
+// connections it was sent mail over
+type fakeSMTPServer struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	conns    int
+	quits    int
+	messages []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{ln: ln}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-fake")
+			reply("250 8BITMIME")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			s.mu.Lock()
+			s.quits++
+			s.mu.Unlock()
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) counts() (conns, quits, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.quits, len(s.messages)
+}
+
+// message returns the last message the server received
+func (s *fakeSMTPServer) message() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		return nil
+	}
+	return []byte(s.messages[len(s.messages)-1])
+}
+
+func (s *fakeSMTPServer) transport() *SMTPTransport {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &SMTPTransport{Host: host, Port: p, Encryption: "none"}
+}
+
+func TestSMTPTransport_reusesConnections(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	tr := s.transport()
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", Transport: tr}
+
+	for i := 0; i < 3; i++ {
+		if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "test " + strconv.Itoa(i), Template: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns, _, messages := s.counts(); conns != 1 || messages != 3 {
+		t.Errorf("sent %d messages over %d connections, want 3 over 1", messages, conns)
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// mail can still be sent, but its connection is not kept
+	if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "late", Template: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		conns, quits, messages := s.counts()
+		return conns == 2 && quits == 2 && messages == 4
+	})
+}
+
+func TestSMTPTransport_replacesIdleConnections(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	tr := s.transport()
+	tr.IdleTimeout = 10 * time.Millisecond
+	defer tr.Close()
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", Transport: tr}
+
+	if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "first", Template: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "second", Template: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, messages := s.counts(); conns != 2 || messages != 2 {
+		t.Errorf("sent %d messages over %d connections, want 2 over 2", messages, conns)
+	}
+}
+
+func TestSMTPTransport_withoutTransport(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	tr := s.transport()
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", Host: tr.Host, Port: tr.Port, Encryption: "none"}
+
+	// without a Transport kept on m, each message has a connection of its own
+	for i := 0; i < 2; i++ {
+		if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		conns, quits, messages := s.counts()
+		return conns == 2 && quits == 2 && messages == 2
+	})
+
+	if err := m.Ping(context.Background()); err != nil {
+		t.Errorf("ping failed: %v", err)
+	}
+}
+
+// waitFor fails t unless cond is true within a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,275 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingTransport keeps the messages it is sent
+type recordingTransport struct {
+	sent []*Composed
+}
+
+func (t *recordingTransport) Send(ctx context.Context, c *Composed) error {
+	t.sent = append(t.sent, c)
+	return nil
+}
+
+func TestRegisterTransport(t *testing.T) {
+	rec := &recordingTransport{}
+	RegisterTransport("test-recording", func(m *Mail) (Transport, error) { return rec, nil })
+	defer func() {
+		transportsMu.Lock()
+		delete(transports, "test-recording")
+		transportsMu.Unlock()
+	}()
+
+	found := false
+	for _, name := range Transports() {
+		found = found || name == "test-recording"
+	}
+	if !found {
+		t.Errorf("the transport is not listed in %v", Transports())
+	}
+
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", API: "test-recording"}
+	if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "test", Attachments: []string{"./testdata/mail/test.plain.tmpl"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.sent) != 1 {
+		t.Fatalf("the transport was sent %d messages, want 1", len(rec.sent))
+	}
+	if c := rec.sent[0]; c.From != "me@here.com" || c.HTML == "" || len(c.Files) != 1 || len(c.Attachments) != 0 {
+		t.Errorf("the transport was sent %+v", c)
+	}
+
+	// a Transport kept on the Mail is used instead of API
+	kept := &recordingTransport{}
+	m.Transport = kept
+	if err := m.Send(Message{To: []string{"you@there.com"}, Subject: "test", Template: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(kept.sent) != 1 || len(rec.sent) != 1 {
+		t.Errorf("the kept transport was sent %d messages and the registered one %d", len(kept.sent), len(rec.sent))
+	}
+}
+
+func TestMail_NewTransport(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+
+	tests := []struct {
+		name    string
+		mail    Mail
+		wantErr string
+	}{
+		{"smtp by default", Mail{}, ""},
+		{"file", Mail{API: "file"}, ""},
+		{"unknown", Mail{API: "pigeon"}, "unknown api pigeon"},
+		{"mailgun", Mail{API: "mailgun", APIKey: "key"}, ""},
+		{"mailgun without a key", Mail{API: "mailgun"}, "needs APIKey"},
+		{"sendgrid without a key", Mail{API: "sendgrid"}, "needs APIKey"},
+		{"sparkpost without a key", Mail{API: "sparkpost"}, "needs APIKey"},
+		{"postmark", Mail{API: "postmark", APIKey: "token"}, ""},
+		{"postmark without a token", Mail{API: "postmark"}, "needs APIKey"},
+		{"http", Mail{API: "http", APIUrl: "https://mail.example.com/send"}, ""},
+		{"http without a url", Mail{API: "http", APIKey: "token"}, "needs APIUrl"},
+		{"ses in the region of its endpoint", Mail{API: "ses", APIUrl: "https://email.eu-west-1.amazonaws.com", APIKey: "id:secret"}, ""},
+		{"ses without a region", Mail{API: "ses"}, "needs a region"},
+		{"ses with a malformed key", Mail{API: "ses", APIUrl: "https://email.eu-west-1.amazonaws.com", APIKey: "id"}, "access key id:secret access key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := tt.mail.NewTransport()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				closeTransport(tr)
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	tr, err := (&Mail{API: "ses", APIUrl: "https://email.eu-west-1.amazonaws.com"}).NewTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ses := tr.(*SESTransport); ses.Region != "eu-west-1" {
+		t.Errorf("the region is %q, want eu-west-1", ses.Region)
+	}
+}
+
+// newTransportMessage returns a message using every field a transport has to pass on
+func newTransportMessage() Message {
+	return Message{
+		To:        []string{"a@there.com", "b@there.com"},
+		CC:        []string{"c@there.com"},
+		BCC:       []string{"d@there.com"},
+		ReplyTo:   "reply@here.com",
+		Subject:   "test",
+		Template:  "test",
+		Headers:   map[string]string{"X-Campaign": "welcome"},
+		MessageID: "<1@here.com>",
+		Files:     []File{{Name: "report.csv", Data: []byte("a,b\n")}},
+	}
+}
+
+func TestPostmarkTransport(t *testing.T) {
+	var body postmarkMessage
+	var token string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/email" {
+			t.Errorf("posted to %s", r.URL.Path)
+		}
+		token = r.Header.Get("X-Postmark-Server-Token")
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"ErrorCode": 406, "Message": "You tried to send to a recipient that has been marked as inactive."}`))
+	}))
+	defer srv.Close()
+
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", FromName: "Joe", API: "postmark", APIKey: "token", APIUrl: srv.URL}
+	if err := m.Send(newTransportMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "token" {
+		t.Errorf("sent token %q", token)
+	}
+	if body.From != `"Joe" <me@here.com>` || body.To != "a@there.com,b@there.com" || body.Cc != "c@there.com" || body.Bcc != "d@there.com" ||
+		body.ReplyTo != "reply@here.com" || body.Subject != "test" || body.HTMLBody == "" || body.TextBody == "" {
+		t.Errorf("unexpected message %+v", body)
+	}
+	if h := body.Headers; len(h) != 2 || h[0] != (postmarkHeader{"Message-ID", "<1@here.com>"}) || h[1] != (postmarkHeader{"X-Campaign", "welcome"}) {
+		t.Errorf("unexpected headers %+v", h)
+	}
+	if a := body.Attachments; len(a) != 1 || a[0].Name != "report.csv" || a[0].Content != base64.StdEncoding.EncodeToString([]byte("a,b\n")) {
+		t.Errorf("unexpected attachments %+v", a)
+	}
+
+	status = http.StatusUnprocessableEntity
+	err := m.Send(newTransportMessage())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.API != "postmark" || apiErr.StatusCode != status || !strings.Contains(apiErr.Body, "inactive") {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if IsTransient(err) {
+		t.Error("a rejected message is transient")
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	var body HTTPMessage
+	var auth string
+	status := http.StatusAccepted
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", FromName: "Joe", API: "http", APIKey: "token", APIUrl: srv.URL + "/send"}
+	if err := m.Send(newTransportMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Bearer token" {
+		t.Errorf("sent authorization %q", auth)
+	}
+	if body.From != "me@here.com" || body.FromName != "Joe" || strings.Join(body.To, ",") != "a@there.com,b@there.com" ||
+		strings.Join(body.BCC, ",") != "d@there.com" || body.MessageID != "<1@here.com>" || body.Headers["X-Campaign"] != "welcome" ||
+		body.HTML == "" || body.Text == "" {
+		t.Errorf("unexpected message %+v", body)
+	}
+	if a := body.Attachments; len(a) != 1 || a[0].Name != "report.csv" || string(a[0].Data) != "a,b\n" || a[0].ContentType != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected attachments %+v", a)
+	}
+
+	status = http.StatusTooManyRequests
+	if err := m.Send(newTransportMessage()); !IsTransient(err) {
+		t.Errorf("a 429 returned %v, want a transient error", err)
+	}
+}
+
+func TestSESTransport(t *testing.T) {
+	var body struct {
+		FromEmailAddress string
+		Destination      struct {
+			ToAddresses, CcAddresses, BccAddresses []string
+		}
+		Content struct {
+			Raw struct{ Data []byte }
+		}
+		ConfigurationSetName string
+	}
+	reject := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/email/outbound-emails" {
+			t.Errorf("posted to %s", r.URL.Path)
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=id/") {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if reject {
+			w.Header().Set("X-Amzn-Errortype", "MessageRejected")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message": "Email address is not verified."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"MessageId": "0100"}`))
+	}))
+	defer srv.Close()
+
+	tr := &SESTransport{Region: "eu-west-1", Endpoint: srv.URL, AccessKeyID: "id", SecretAccessKey: "secret", ConfigurationSet: "transactional"}
+	m := &Mail{Templates: "./testdata/mail", FromAddress: "me@here.com", FromName: "Joe", Transport: tr}
+	if err := m.Send(newTransportMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.FromEmailAddress != `"Joe" <me@here.com>` || strings.Join(body.Destination.ToAddresses, ",") != "a@there.com,b@there.com" ||
+		strings.Join(body.Destination.BccAddresses, ",") != "d@there.com" || body.ConfigurationSetName != "transactional" {
+		t.Errorf("unexpected request %+v", body)
+	}
+	raw := string(body.Content.Raw.Data)
+	for _, want := range []string{"Subject: test", "Message-Id: <1@here.com>", "X-Campaign: welcome", "report.csv"} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("the raw message does not contain %q:\n%s", want, raw)
+		}
+	}
+	if strings.Contains(raw, "d@there.com") {
+		t.Error("the raw message shows its bcc address")
+	}
+
+	reject = true
+	err := m.Send(newTransportMessage())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.API != "ses" || apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Body, "MessageRejected") {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if IsTransient(err) {
+		t.Error("a rejected message is transient")
+	}
+}
@@ -0,0 +1,570 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/mailgun/mailgun-go/v4/events"
+	"github.com/sendgrid/sendgrid-go/helpers/eventwebhook"
+)
+
+// EventType is the kind of an Event
+type EventType string
+
+// The events delivered by WebhookHandler. Other events sent by the apis, such as
+// clicks, are acknowledged and dropped.
+const (
+	EventInbound    EventType = "inbound"
+	EventDelivered  EventType = "delivered"
+	EventBounced    EventType = "bounced"
+	EventComplained EventType = "complained"
+	EventOpened     EventType = "opened"
+)
+
+// Event is a webhook call from a mail api, in the same shape for every api.
+// Recipient is the address the event is about; for inbound mail, the address it
+// was sent to. MessageID is the id of the message as the api reports it.
+// Permanent is set for bounces of addresses that will never accept mail. Inbound
+// holds the message of inbound events.
+type Event struct {
+	Type      EventType
+	API       string
+	Recipient string
+	MessageID string
+	Time      time.Time
+	Reason    string
+	Permanent bool
+	Inbound   *InboundMessage
+}
+
+// InboundMessage is a message received by a mail api and posted to WebhookHandler
+type InboundMessage struct {
+	From        string
+	To          []string
+	CC          []string
+	Subject     string
+	MessageID   string
+	HTML        string
+	Plain       string
+	Attachments []File
+}
+
+// maxWebhookBody is the largest webhook accepted; inbound mail carries its attachments
+const maxWebhookBody = 32 << 20
+
+// errUnauthorized is returned by the parsers when a webhook fails verification
+var errUnauthorized = errors.New("mailer: webhook failed verification")
+
+// WebhookHandler returns a handler for the event and inbound webhooks of api, which
+// is one of mailgun, sendgrid or sparkpost, or API when empty. Every webhook is
+// verified: mailgun's by its signature, which needs WebhookKey; sendgrid's events
+// by their signature when WebhookKey is set; and every call by basic auth when
+// WebhookAuth is set. A handler that would accept calls that cannot be verified
+// is an error. Events are passed to OnEvent, and permanent bounces and complaints
+// are added to Suppressions, if set. Mount the handler where the api posts to;
+// paths under /api are exempt from csrf checks in Boilme applications.
+func (m *Mail) WebhookHandler(api string) (http.Handler, error) {
+	if api == "" {
+		api = m.API
+	}
+
+	var parse func(r *http.Request, body []byte, verified bool) ([]Event, error)
+	switch api {
+	case "mailgun":
+		if m.WebhookKey == "" {
+			return nil, errors.New("mailer: mailgun webhooks need WebhookKey, the webhook signing key")
+		}
+		parse = m.parseMailgun
+	case "sendgrid":
+		if m.WebhookKey == "" && m.WebhookAuth == "" {
+			return nil, errors.New("mailer: sendgrid webhooks need WebhookKey or WebhookAuth")
+		}
+		var key *ecdsa.PublicKey
+		if m.WebhookKey != "" {
+			var err error
+			if key, err = parseSendGridKey(m.WebhookKey); err != nil {
+				return nil, err
+			}
+		}
+		parse = func(r *http.Request, body []byte, verified bool) ([]Event, error) {
+			return parseSendGrid(r, body, key, verified)
+		}
+	case "sparkpost":
+		if m.WebhookAuth == "" {
+			return nil, errors.New("mailer: sparkpost webhooks need WebhookAuth")
+		}
+		parse = func(r *http.Request, body []byte, verified bool) ([]Event, error) {
+			return parseSparkPost(body)
+		}
+	default:
+		return nil, fmt.Errorf("mailer: unknown api %s; only mailgun, sparkpost or sendgrid accepted", api)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		verified := false
+		if m.WebhookAuth != "" {
+			if !m.webhookAuthorized(r) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			verified = true
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		evs, err := parse(r, body, verified)
+		if errors.Is(err, errUnauthorized) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, e := range evs {
+			e.API = api
+			if err := m.handleEvent(r.Context(), e); err != nil {
+				m.logger().Error("could not handle mail webhook", "api", api, "event", e.Type, "recipient", e.Recipient, "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}), nil
+}
+
+// webhookAuthorized reports whether r carries the basic auth in WebhookAuth
+func (m *Mail) webhookAuthorized(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(m.WebhookAuth)) == 1
+}
+
+// handleEvent suppresses the recipient of e if it bounced for good or complained,
+// and passes e to OnEvent
+func (m *Mail) handleEvent(ctx context.Context, e Event) error {
+	if m.Suppressions != nil && e.Recipient != "" &&
+		(e.Type == EventComplained || (e.Type == EventBounced && e.Permanent)) {
+		reason := string(e.Type)
+		if e.Reason != "" {
+			reason += ": " + e.Reason
+		}
+		if err := m.Suppressions.Suppress(ctx, e.Recipient, reason); err != nil {
+			return err
+		}
+	}
+	if m.OnEvent != nil {
+		return m.OnEvent(ctx, e)
+	}
+	return nil
+}
+
+// isJSON reports whether r has a json body
+func isJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// parseForm parses the form in body, which is multipart or url encoded
+func parseForm(r *http.Request, body []byte) (*multipart.Form, error) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseMultipartForm(maxWebhookBody); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, err
+	}
+	if r.MultipartForm != nil {
+		return r.MultipartForm, nil
+	}
+	return &multipart.Form{Value: r.PostForm}, nil
+}
+
+// formValue returns the first value of key in form
+func formValue(form *multipart.Form, key string) string {
+	if v := form.Value[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// formFiles reads the files of form whose field names start with prefix
+func formFiles(form *multipart.Form, prefix string) ([]File, error) {
+	var files []File
+	for field, headers := range form.File {
+		if !strings.HasPrefix(field, prefix) {
+			continue
+		}
+		for _, h := range headers {
+			f, err := h.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(f)
+			_ = f.Close()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, File{Name: h.Filename, ContentType: h.Header.Get("Content-Type"), Data: data})
+		}
+	}
+	return files, nil
+}
+
+// addressList returns the bare addresses in a header value, or the value as it
+// is when it cannot be parsed
+func addressList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	list, err := netmail.ParseAddressList(value)
+	if err != nil {
+		return []string{strings.TrimSpace(value)}
+	}
+	addresses := make([]string, len(list))
+	for i, a := range list {
+		addresses[i] = a.Address
+	}
+	return addresses
+}
+
+// firstAddress returns the first address in a header value
+func firstAddress(value string) string {
+	if list := addressList(value); len(list) > 0 {
+		return list[0]
+	}
+	return ""
+}
+
+// parseMailgun parses an event webhook, posted as json, or a message forwarded by
+// a route, posted as a form. Both are signed with WebhookKey.
+func (m *Mail) parseMailgun(r *http.Request, body []byte, verified bool) ([]Event, error) {
+	mg := mailgun.NewMailgun(m.Domain, m.WebhookKey)
+
+	if isJSON(r) {
+		var payload mailgun.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		if ok, _ := mg.VerifyWebhookSignature(payload.Signature); !ok {
+			return nil, errUnauthorized
+		}
+		return mailgunEvent(payload.EventData)
+	}
+
+	form, err := parseForm(r, body)
+	if err != nil {
+		return nil, err
+	}
+	ok, _ := mg.VerifyWebhookSignature(mailgun.Signature{
+		TimeStamp: formValue(form, "timestamp"),
+		Token:     formValue(form, "token"),
+		Signature: formValue(form, "signature"),
+	})
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	files, err := formFiles(form, "attachment-")
+	if err != nil {
+		return nil, err
+	}
+	msg := &InboundMessage{
+		From:        firstAddress(formValue(form, "from")),
+		To:          addressList(formValue(form, "To")),
+		CC:          addressList(formValue(form, "Cc")),
+		Subject:     formValue(form, "subject"),
+		MessageID:   formValue(form, "Message-Id"),
+		HTML:        formValue(form, "body-html"),
+		Plain:       formValue(form, "body-plain"),
+		Attachments: files,
+	}
+	e := Event{Type: EventInbound, Recipient: firstAddress(formValue(form, "recipient")), MessageID: msg.MessageID, Time: time.Now(), Inbound: msg}
+	if ts, err := strconv.ParseInt(formValue(form, "timestamp"), 10, 64); err == nil {
+		e.Time = time.Unix(ts, 0)
+	}
+	return []Event{e}, nil
+}
+
+// mailgunEvent converts the event data of a mailgun webhook
+func mailgunEvent(raw []byte) ([]Event, error) {
+	parsed, err := mailgun.ParseEvent(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	e := Event{Time: parsed.GetTimestamp()}
+	switch ev := parsed.(type) {
+	case *events.Delivered:
+		e.Type, e.Recipient, e.MessageID = EventDelivered, ev.Recipient, ev.Message.Headers.MessageID
+	case *events.Failed:
+		e.Type, e.Recipient, e.MessageID = EventBounced, ev.Recipient, ev.Message.Headers.MessageID
+		e.Permanent = ev.Severity == "permanent"
+		e.Reason = ev.DeliveryStatus.Description
+		if e.Reason == "" {
+			e.Reason = ev.DeliveryStatus.Message
+		}
+		if e.Reason == "" {
+			e.Reason = ev.Reason
+		}
+	case *events.Complained:
+		e.Type, e.Recipient, e.MessageID = EventComplained, ev.Recipient, ev.Message.Headers.MessageID
+	case *events.Opened:
+		e.Type, e.Recipient, e.MessageID = EventOpened, ev.Recipient, ev.Message.Headers.MessageID
+	default:
+		return nil, nil
+	}
+	return []Event{e}, nil
+}
+
+// parseSendGridKey parses the base64 verification key of sendgrid's signed event
+// webhook
+func parseSendGridKey(key string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: sendgrid webhook key: %w", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: sendgrid webhook key: %w", err)
+	}
+	ecKey, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("mailer: sendgrid webhook key is not an ecdsa key")
+	}
+	return ecKey, nil
+}
+
+// sendGridEvent is an event of sendgrid's event webhook
+type sendGridEvent struct {
+	Email       string `json:"email"`
+	Timestamp   int64  `json:"timestamp"`
+	Event       string `json:"event"`
+	Type        string `json:"type"`
+	Reason      string `json:"reason"`
+	SMTPID      string `json:"smtp-id"`
+	SGMessageID string `json:"sg_message_id"`
+}
+
+// parseSendGrid parses a batch of events, posted as json and signed when key is
+// set, or a message from the inbound parse, posted as a form, which is only
+// verified by basic auth
+func parseSendGrid(r *http.Request, body []byte, key *ecdsa.PublicKey, verified bool) ([]Event, error) {
+	if isJSON(r) {
+		if key != nil {
+			ok, _ := eventwebhook.VerifySignature(key, body,
+				r.Header.Get(eventwebhook.VerificationHTTPHeader), r.Header.Get(eventwebhook.TimestampHTTPHeader))
+			if !ok {
+				return nil, errUnauthorized
+			}
+		} else if !verified {
+			return nil, errUnauthorized
+		}
+
+		var batch []sendGridEvent
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, err
+		}
+		var evs []Event
+		for _, sg := range batch {
+			e := Event{Recipient: sg.Email, MessageID: sg.SMTPID, Time: time.Unix(sg.Timestamp, 0), Reason: sg.Reason}
+			if e.MessageID == "" {
+				e.MessageID = sg.SGMessageID
+			}
+			switch sg.Event {
+			case "delivered":
+				e.Type = EventDelivered
+			case "bounce":
+				// a blocked message may be accepted later; a bounce is for good
+				e.Type, e.Permanent = EventBounced, sg.Type != "blocked"
+			case "spamreport":
+				e.Type = EventComplained
+			case "open":
+				e.Type = EventOpened
+			default:
+				continue
+			}
+			evs = append(evs, e)
+		}
+		return evs, nil
+	}
+
+	if !verified {
+		return nil, errUnauthorized
+	}
+	form, err := parseForm(r, body)
+	if err != nil {
+		return nil, err
+	}
+	files, err := formFiles(form, "attachment")
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &InboundMessage{
+		From:        firstAddress(formValue(form, "from")),
+		To:          addressList(formValue(form, "to")),
+		CC:          addressList(formValue(form, "cc")),
+		Subject:     formValue(form, "subject"),
+		HTML:        formValue(form, "html"),
+		Plain:       formValue(form, "text"),
+		Attachments: files,
+	}
+	if headers, err := netmail.ReadMessage(strings.NewReader(formValue(form, "headers") + "\r\n\r\n")); err == nil {
+		msg.MessageID = headers.Header.Get("Message-Id")
+	}
+
+	e := Event{Type: EventInbound, MessageID: msg.MessageID, Time: time.Now(), Inbound: msg}
+	var envelope struct {
+		To []string `json:"to"`
+	}
+	if err := json.Unmarshal([]byte(formValue(form, "envelope")), &envelope); err == nil && len(envelope.To) > 0 {
+		e.Recipient = envelope.To[0]
+	} else if len(msg.To) > 0 {
+		e.Recipient = msg.To[0]
+	}
+	return []Event{e}, nil
+}
+
+// sparkPostTime is a unix time, which sparkpost sends as a string
+type sparkPostTime time.Time
+
+func (t *sparkPostTime) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*t = sparkPostTime(time.Unix(secs, 0))
+	return nil
+}
+
+// sparkPostEvent is a message or engagement event of sparkpost's webhooks
+type sparkPostEvent struct {
+	Type        string        `json:"type"`
+	RcptTo      string        `json:"rcpt_to"`
+	MessageID   string        `json:"message_id"`
+	Timestamp   sparkPostTime `json:"timestamp"`
+	BounceClass string        `json:"bounce_class"`
+	Reason      string        `json:"reason"`
+}
+
+// sparkPostRelay is a message received by a sparkpost relay webhook
+type sparkPostRelay struct {
+	RcptTo  string `json:"rcpt_to"`
+	MsgFrom string `json:"msg_from"`
+	Content struct {
+		HTML         string              `json:"html"`
+		Text         string              `json:"text"`
+		Subject      string              `json:"subject"`
+		To           []string            `json:"to"`
+		CC           []string            `json:"cc"`
+		Headers      []map[string]string `json:"headers"`
+		EmailRFC822  string              `json:"email_rfc822"`
+		RFC822Base64 bool                `json:"email_rfc822_is_base64"`
+	} `json:"content"`
+}
+
+// sparkPostHardBounces are the bounce classes of addresses that will never accept
+// mail: invalid recipients, generic bounces without a recipient, and unsubscribes
+var sparkPostHardBounces = map[string]bool{"10": true, "30": true, "90": true}
+
+// parseSparkPost parses a batch of sparkpost events, which may mix message and
+// engagement events with relayed inbound messages
+func parseSparkPost(body []byte) ([]Event, error) {
+	var batch []struct {
+		Msys struct {
+			MessageEvent *sparkPostEvent `json:"message_event"`
+			TrackEvent   *sparkPostEvent `json:"track_event"`
+			RelayMessage *sparkPostRelay `json:"relay_message"`
+		} `json:"msys"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+
+	var evs []Event
+	for _, item := range batch {
+		switch {
+		case item.Msys.MessageEvent != nil || item.Msys.TrackEvent != nil:
+			sp := item.Msys.MessageEvent
+			if sp == nil {
+				sp = item.Msys.TrackEvent
+			}
+			e := Event{Recipient: sp.RcptTo, MessageID: sp.MessageID, Time: time.Time(sp.Timestamp), Reason: sp.Reason}
+			switch sp.Type {
+			case "delivery":
+				e.Type = EventDelivered
+			case "bounce", "out_of_band":
+				e.Type, e.Permanent = EventBounced, sparkPostHardBounces[sp.BounceClass]
+			case "spam_complaint":
+				e.Type = EventComplained
+			case "open", "initial_open":
+				e.Type = EventOpened
+			default:
+				continue
+			}
+			evs = append(evs, e)
+
+		case item.Msys.RelayMessage != nil:
+			relay := item.Msys.RelayMessage
+			msg := &InboundMessage{
+				From:    relay.MsgFrom,
+				To:      relay.Content.To,
+				CC:      relay.Content.CC,
+				Subject: relay.Content.Subject,
+				HTML:    relay.Content.HTML,
+				Plain:   relay.Content.Text,
+			}
+			for _, h := range relay.Content.Headers {
+				for k, v := range h {
+					if strings.EqualFold(k, "Message-ID") {
+						msg.MessageID = v
+					}
+				}
+			}
+			// attachments are only in the raw message
+			if raw := relay.Content.EmailRFC822; raw != "" {
+				data := []byte(raw)
+				if relay.Content.RFC822Base64 {
+					var err error
+					if data, err = base64.StdEncoding.DecodeString(raw); err != nil {
+						return nil, err
+					}
+				}
+				if parsed, err := parseCaptured(data); err == nil {
+					msg.Attachments = parsed.Attachments
+				}
+			}
+			evs = append(evs, Event{Type: EventInbound, Recipient: relay.RcptTo, MessageID: msg.MessageID, Time: time.Now(), Inbound: msg})
+		}
+	}
+	return evs, nil
+}
@@ -0,0 +1,438 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// webhookRecorder collects the events passed to OnEvent
+type webhookRecorder struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (rec *webhookRecorder) onEvent(ctx context.Context, e Event) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.events = append(rec.events, e)
+	return rec.err
+}
+
+// newWebhookMail returns a Mail recording its events in rec, with a memory
+// suppression list
+func newWebhookMail(rec *webhookRecorder) *Mail {
+	return &Mail{Domain: "example.com", Suppressions: NewMemorySuppressionList(), OnEvent: rec.onEvent}
+}
+
+// serveWebhook posts body to the webhook handler of m for api
+func serveWebhook(t *testing.T, m *Mail, api string, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	h, err := m.WebhookHandler(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr
+}
+
+// mailgunSignature signs timestamp and token with key, like mailgun
+func mailgunSignature(key, timestamp, token string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(timestamp + token))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func suppressed(t *testing.T, m *Mail, address string) bool {
+	t.Helper()
+	got, err := m.Suppressions.Suppressed(context.Background(), []string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got[normalizeAddress(address)]
+}
+
+func TestMail_WebhookHandler_config(t *testing.T) {
+	tests := []struct {
+		name    string
+		mail    Mail
+		api     string
+		wantErr bool
+	}{
+		{"mailgun", Mail{WebhookKey: "key"}, "mailgun", false},
+		{"mailgun without a key", Mail{WebhookAuth: "user:pass"}, "mailgun", true},
+		{"sendgrid with auth", Mail{WebhookAuth: "user:pass"}, "sendgrid", false},
+		{"sendgrid with a bad key", Mail{WebhookKey: "not a key"}, "sendgrid", true},
+		{"sendgrid without secrets", Mail{}, "sendgrid", true},
+		{"sparkpost", Mail{WebhookAuth: "user:pass"}, "sparkpost", false},
+		{"sparkpost without auth", Mail{WebhookKey: "key"}, "sparkpost", true},
+		{"the mail api", Mail{API: "mailgun", WebhookKey: "key"}, "", false},
+		{"unknown api", Mail{WebhookAuth: "user:pass"}, "smtp", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.mail.WebhookHandler(tt.api)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WebhookHandler(%q) error = %v, wantErr %v", tt.api, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMail_WebhookHandler_mailgun(t *testing.T) {
+	const key = "signing-key"
+	event := func(signature string) string {
+		return `{"signature": {"timestamp": "1700000000", "token": "tok", "signature": "` + signature + `"},
+			"event-data": {"event": "failed", "timestamp": 1700000000.5, "severity": "permanent", "recipient": "Ann@Example.com",
+				"message": {"headers": {"message-id": "abc@example.com"}},
+				"delivery-status": {"code": 550, "description": "mailbox does not exist"}}}`
+	}
+
+	t.Run("event", func(t *testing.T) {
+		rec := &webhookRecorder{}
+		m := newWebhookMail(rec)
+		m.WebhookKey = key
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(event(mailgunSignature(key, "1700000000", "tok"))))
+		r.Header.Set("Content-Type", "application/json")
+		if rr := serveWebhook(t, m, "mailgun", r); rr.Code != http.StatusOK {
+			t.Fatalf("status is %d: %s", rr.Code, rr.Body.String())
+		}
+
+		if len(rec.events) != 1 {
+			t.Fatalf("got %d events, want 1", len(rec.events))
+		}
+		e := rec.events[0]
+		if e.Type != EventBounced || !e.Permanent || e.API != "mailgun" || e.MessageID != "abc@example.com" ||
+			e.Reason != "mailbox does not exist" || e.Time.Unix() != 1700000000 {
+			t.Errorf("got event %+v", e)
+		}
+		if !suppressed(t, m, "ann@example.com") {
+			t.Error("a permanent bounce was not suppressed")
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		rec := &webhookRecorder{}
+		m := newWebhookMail(rec)
+		m.WebhookKey = key
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(event(mailgunSignature("other", "1700000000", "tok"))))
+		r.Header.Set("Content-Type", "application/json")
+		if rr := serveWebhook(t, m, "mailgun", r); rr.Code != http.StatusUnauthorized {
+			t.Errorf("status is %d, want %d", rr.Code, http.StatusUnauthorized)
+		}
+		if len(rec.events) != 0 || suppressed(t, m, "ann@example.com") {
+			t.Error("an unsigned event was handled")
+		}
+	})
+
+	t.Run("inbound", func(t *testing.T) {
+		rec := &webhookRecorder{}
+		m := newWebhookMail(rec)
+		m.WebhookKey = key
+
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		fields := map[string]string{
+			"timestamp":  "1700000000",
+			"token":      "tok",
+			"signature":  mailgunSignature(key, "1700000000", "tok"),
+			"recipient":  "support@example.com",
+			"from":       "Bob <bob@example.org>",
+			"To":         "Support <support@example.com>, sales@example.com",
+			"subject":    "Help",
+			"body-plain": "Please help",
+			"body-html":  "<p>Please help</p>",
+			"Message-Id": "<q@example.org>",
+		}
+		for k, v := range fields {
+			_ = w.WriteField(k, v)
+		}
+		fw, _ := w.CreateFormFile("attachment-1", "log.txt")
+		_, _ = fw.Write([]byte("log"))
+		_ = w.Close()
+
+		r := httptest.NewRequest(http.MethodPost, "/", &body)
+		r.Header.Set("Content-Type", w.FormDataContentType())
+		if rr := serveWebhook(t, m, "mailgun", r); rr.Code != http.StatusOK {
+			t.Fatalf("status is %d: %s", rr.Code, rr.Body.String())
+		}
+
+		if len(rec.events) != 1 || rec.events[0].Inbound == nil {
+			t.Fatalf("got events %+v, want 1 inbound", rec.events)
+		}
+		e, in := rec.events[0], rec.events[0].Inbound
+		if e.Type != EventInbound || e.Recipient != "support@example.com" || in.From != "bob@example.org" ||
+			strings.Join(in.To, ",") != "support@example.com,sales@example.com" || in.Subject != "Help" ||
+			in.Plain != "Please help" || in.HTML != "<p>Please help</p>" || in.MessageID != "<q@example.org>" {
+			t.Errorf("got event %+v with message %+v", e, in)
+		}
+		if len(in.Attachments) != 1 || in.Attachments[0].Name != "log.txt" || string(in.Attachments[0].Data) != "log" {
+			t.Errorf("got attachments %+v", in.Attachments)
+		}
+	})
+}
+
+func TestMail_WebhookHandler_sendgrid(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(der)
+
+	body := `[
+		{"email": "ann@example.com", "timestamp": 1700000000, "event": "bounce", "type": "bounce", "reason": "550 no such user", "smtp-id": "<a@example.com>"},
+		{"email": "bob@example.com", "timestamp": 1700000001, "event": "bounce", "type": "blocked", "sg_message_id": "sg1"},
+		{"email": "carol@example.com", "timestamp": 1700000002, "event": "spamreport"},
+		{"email": "dave@example.com", "timestamp": 1700000003, "event": "open"},
+		{"email": "erin@example.com", "timestamp": 1700000004, "event": "click"},
+		{"email": "frank@example.com", "timestamp": 1700000005, "event": "delivered"}
+	]`
+	sign := func(timestamp, payload string) string {
+		digest := sha256.Sum256([]byte(timestamp + payload))
+		sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	t.Run("events", func(t *testing.T) {
+		rec := &webhookRecorder{}
+		m := newWebhookMail(rec)
+		m.WebhookKey = key
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", sign("1700000010", body))
+		r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", "1700000010")
+		if rr := serveWebhook(t, m, "sendgrid", r); rr.Code != http.StatusOK {
+			t.Fatalf("status is %d: %s", rr.Code, rr.Body.String())
+		}
+
+		want := []struct {
+			typ       EventType
+			recipient string
+			permanent bool
+			messageID string
+		}{
+			{EventBounced, "ann@example.com", true, "<a@example.com>"},
+			{EventBounced, "bob@example.com", false, "sg1"},
+			{EventComplained, "carol@example.com", false, ""},
+			{EventOpened, "dave@example.com", false, ""},
+			{EventDelivered, "frank@example.com", false, ""},
+		}
+		if len(rec.events) != len(want) {
+			t.Fatalf("got %d events, want %d: %+v", len(rec.events), len(want), rec.events)
+		}
+		for i, w := range want {
+			e := rec.events[i]
+			if e.Type != w.typ || e.Recipient != w.recipient || e.Permanent != w.permanent || e.MessageID != w.messageID {
+				t.Errorf("event %d is %+v, want %+v", i, e, w)
+			}
+		}
+
+		for address, want := range map[string]bool{"ann@example.com": true, "bob@example.com": false, "carol@example.com": true, "dave@example.com": false} {
+			if got := suppressed(t, m, address); got != want {
+				t.Errorf("%s suppressed: %v, want %v", address, got, want)
+			}
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		rec := &webhookRecorder{}
+		m := newWebhookMail(rec)
+		m.WebhookKey = key
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", sign("1700000010", "[]"))
+		r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", "1700000010")
+		if rr := serveWebhook(t, m, "sendgrid", r); rr.Code != http.StatusUnauthorized {
+			t.Errorf("status is %d, want %d", rr.Code, http.StatusUnauthorized)
+		}
+		if len(rec.events) != 0 {
+			t.Error("an unsigned event was handled")
+		}
+	})
+
+	t.Run("inbound", func(t *testing.T) {
+		inbound := func(auth bool) *http.Request {
+			var buf bytes.Buffer
+			w := multipart.NewWriter(&buf)
+			_ = w.WriteField("from", "Bob <bob@example.org>")
+			_ = w.WriteField("to", "support@example.com")
+			_ = w.WriteField("cc", "sales@example.com")
+			_ = w.WriteField("subject", "Help")
+			_ = w.WriteField("text", "Please help")
+			_ = w.WriteField("headers", "Message-Id: <q@example.org>\nSubject: Help")
+			_ = w.WriteField("envelope", `{"to": ["support@inbound.example.com"], "from": "bob@example.org"}`)
+			fw, _ := w.CreateFormFile("attachment1", "log.txt")
+			_, _ = fw.Write([]byte("log"))
+			_ = w.Close()
+
+			r := httptest.NewRequest(http.MethodPost, "/", &buf)
+			r.Header.Set("Content-Type", w.FormDataContentType())
+			if auth {
+				r.SetBasicAuth("user", "pass")
+			}
+			return r
+		}
+
+		rec := &webhookRecorder{}
+		m := newWebhookMail(rec)
+		m.WebhookKey = key
+		if rr := serveWebhook(t, m, "sendgrid", inbound(false)); rr.Code != http.StatusUnauthorized {
+			t.Errorf("without basic auth, status is %d, want %d", rr.Code, http.StatusUnauthorized)
+		}
+
+		m.WebhookAuth = "user:pass"
+		if rr := serveWebhook(t, m, "sendgrid", inbound(true)); rr.Code != http.StatusOK {
+			t.Fatalf("status is %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(rec.events) != 1 || rec.events[0].Inbound == nil {
+			t.Fatalf("got events %+v, want 1 inbound", rec.events)
+		}
+		e, in := rec.events[0], rec.events[0].Inbound
+		if e.Recipient != "support@inbound.example.com" || in.From != "bob@example.org" || strings.Join(in.CC, ",") != "sales@example.com" ||
+			in.MessageID != "<q@example.org>" || in.Plain != "Please help" || len(in.Attachments) != 1 {
+			t.Errorf("got event %+v with message %+v", e, in)
+		}
+	})
+}
+
+func TestMail_WebhookHandler_sparkpost(t *testing.T) {
+	raw := "From: bob@example.org\r\nTo: support@example.com\r\nSubject: Help\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nPlease help\r\n" +
+		"--b\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"log.txt\"\r\n\r\nlog\r\n--b--\r\n"
+	body := `[
+		{"msys": {"message_event": {"type": "bounce", "bounce_class": "10", "rcpt_to": "ann@example.com", "message_id": "m1", "timestamp": "1700000000", "reason": "550 no such user"}}},
+		{"msys": {"message_event": {"type": "bounce", "bounce_class": "21", "rcpt_to": "bob@example.com", "timestamp": "1700000000"}}},
+		{"msys": {"message_event": {"type": "spam_complaint", "rcpt_to": "carol@example.com", "timestamp": "1700000000"}}},
+		{"msys": {"track_event": {"type": "open", "rcpt_to": "dave@example.com", "timestamp": "1700000000"}}},
+		{"msys": {"track_event": {"type": "click", "rcpt_to": "erin@example.com", "timestamp": "1700000000"}}},
+		{"msys": {"relay_message": {"rcpt_to": "support@example.com", "msg_from": "bob@example.org",
+			"content": {"subject": "Help", "text": "Please help", "to": ["support@example.com"],
+				"headers": [{"Message-ID": "<q@example.org>"}],
+				"email_rfc822": "` + base64.StdEncoding.EncodeToString([]byte(raw)) + `", "email_rfc822_is_base64": true}}}}
+	]`
+	post := func(auth bool) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if auth {
+			r.SetBasicAuth("user", "pass")
+		}
+		return r
+	}
+
+	rec := &webhookRecorder{}
+	m := newWebhookMail(rec)
+	m.WebhookAuth = "user:pass"
+
+	if rr := serveWebhook(t, m, "sparkpost", post(false)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("without basic auth, status is %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serveWebhook(t, m, "sparkpost", post(true)); rr.Code != http.StatusOK {
+		t.Fatalf("status is %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var types []string
+	for _, e := range rec.events {
+		types = append(types, string(e.Type))
+	}
+	if got := strings.Join(types, ","); got != "bounced,bounced,complained,opened,inbound" {
+		t.Fatalf("got events %s", got)
+	}
+	if e := rec.events[0]; !e.Permanent || e.MessageID != "m1" || e.Time.Unix() != 1700000000 || e.Reason != "550 no such user" {
+		t.Errorf("got hard bounce %+v", e)
+	}
+	if rec.events[1].Permanent {
+		t.Error("a soft bounce is permanent")
+	}
+	in := rec.events[4].Inbound
+	if in == nil || in.MessageID != "<q@example.org>" || in.Plain != "Please help" ||
+		len(in.Attachments) != 1 || in.Attachments[0].Name != "log.txt" || string(in.Attachments[0].Data) != "log" {
+		t.Errorf("got inbound message %+v", in)
+	}
+
+	for address, want := range map[string]bool{"ann@example.com": true, "bob@example.com": false, "carol@example.com": true} {
+		if got := suppressed(t, m, address); got != want {
+			t.Errorf("%s suppressed: %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestMail_WebhookHandler_errors(t *testing.T) {
+	rec := &webhookRecorder{}
+	m := newWebhookMail(rec)
+	m.WebhookAuth = "user:pass"
+	var logged bytes.Buffer
+	m.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+
+	post := func(method, body string) *http.Request {
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.SetBasicAuth("user", "pass")
+		return r
+	}
+	event := `[{"msys": {"track_event": {"type": "open", "rcpt_to": "dave@example.com", "timestamp": "1700000000"}}}]`
+
+	if rr := serveWebhook(t, m, "sparkpost", post(http.MethodGet, "")); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("a get returned %d, want %d", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if rr := serveWebhook(t, m, "sparkpost", post(http.MethodPost, "{")); rr.Code != http.StatusBadRequest {
+		t.Errorf("malformed json returned %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	// a failed handler returns an error, so the api tries again later
+	rec.err = errors.New("database is down")
+	if rr := serveWebhook(t, m, "sparkpost", post(http.MethodPost, event)); rr.Code != http.StatusInternalServerError {
+		t.Errorf("a failing OnEvent returned %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(logged.String(), "database is down") {
+		t.Errorf("the error was not logged: %s", logged.String())
+	}
+}
+
+func TestAddressList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"ann@example.com", []string{"ann@example.com"}},
+		{"Ann <ann@example.com>, bob@example.com", []string{"ann@example.com", "bob@example.com"}},
+		{"undisclosed-recipients:;", nil},
+		{"not an address", []string{"not an address"}},
+	}
+	for _, tt := range tests {
+		got := addressList(tt.value)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("addressList(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
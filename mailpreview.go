@@ -0,0 +1,212 @@
+package boilme
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/go-chi/chi/v5"
+)
+
+// MailPreviewPath is where captured mail and previews of the mail templates are
+// served, in debug mode only
+const MailPreviewPath = "/_boilme/mail"
+
+// MailPreviewRoutes returns the handler mounted on MailPreviewPath in debug mode.
+// It lists the mail captured with MAILER_API=log or file, shows each message, and
+// renders any template in the mail folder with the sample data in
+// mail/samples/<name>.json. It is exposed so it can be mounted elsewhere, or
+// tested; it must never be reachable in production, as it shows every message.
+func (b *Boilme) MailPreviewRoutes() http.Handler {
+	mux := chi.NewRouter()
+	mux.Get("/", b.mailPreviewIndex)
+	mux.Get("/captured/{id}", b.mailPreviewCaptured)
+	mux.Get("/captured/{id}/html", b.mailPreviewCapturedHTML)
+	mux.Get("/captured/{id}/raw", b.mailPreviewRaw)
+	mux.Get("/templates/{name}", b.mailPreviewTemplate)
+	mux.Get("/templates/{name}/html", b.mailPreviewTemplateHTML)
+	return mux
+}
+
+func (b *Boilme) mailPreviewIndex(w http.ResponseWriter, r *http.Request) {
+	captured, err := b.Mail.Captured()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	templates, err := b.Mail.ListTemplates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b.writeMailPreview(w, mailPreviewIndexPage, map[string]interface{}{
+		"Base":      MailPreviewPath,
+		"Capturing": b.Mail.API == "log" || b.Mail.API == "file",
+		"Dir":       b.Mail.CaptureDir,
+		"Captured":  captured,
+		"Templates": templates,
+	})
+}
+
+func (b *Boilme) mailPreviewCaptured(w http.ResponseWriter, r *http.Request) {
+	c, ok := b.readCaptured(w, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	b.writeMailPreview(w, mailPreviewMessagePage, map[string]interface{}{
+		"Base":    MailPreviewPath,
+		"Title":   c.Subject,
+		"Message": c,
+		"HTMLURL": MailPreviewPath + "/captured/" + c.ID + "/html",
+		"Plain":   c.Plain,
+	})
+}
+
+func (b *Boilme) mailPreviewCapturedHTML(w http.ResponseWriter, r *http.Request) {
+	c, ok := b.readCaptured(w, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	writeMailHTML(w, c.HTML)
+}
+
+func (b *Boilme) mailPreviewRaw(w http.ResponseWriter, r *http.Request) {
+	c, ok := b.readCaptured(w, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+c.ID+`.eml"`)
+	http.ServeFile(w, r, c.Path)
+}
+
+// readCaptured reads the captured message id, or writes an error and reports false
+func (b *Boilme) readCaptured(w http.ResponseWriter, id string) (*mailer.CapturedMail, bool) {
+	c, err := b.Mail.ReadCaptured(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		b.ErrorStatus(w, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return c, true
+}
+
+func (b *Boilme) mailPreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	name, locale := chi.URLParam(r, "name"), r.URL.Query().Get("locale")
+	p, ok := b.previewTemplate(w, name, locale)
+	if !ok {
+		return
+	}
+
+	htmlURL := MailPreviewPath + "/templates/" + url.PathEscape(name) + "/html"
+	if locale != "" {
+		htmlURL += "?locale=" + url.QueryEscape(locale)
+	}
+	b.writeMailPreview(w, mailPreviewMessagePage, map[string]interface{}{
+		"Base":    MailPreviewPath,
+		"Title":   p.Subject,
+		"Name":    name,
+		"Locale":  locale,
+		"HTMLURL": htmlURL,
+		"Plain":   p.Plain,
+	})
+}
+
+func (b *Boilme) mailPreviewTemplateHTML(w http.ResponseWriter, r *http.Request) {
+	p, ok := b.previewTemplate(w, chi.URLParam(r, "name"), r.URL.Query().Get("locale"))
+	if !ok {
+		return
+	}
+	writeMailHTML(w, p.HTML)
+}
+
+// previewTemplate renders the template name in locale with its sample data, or
+// writes an error and reports false
+func (b *Boilme) previewTemplate(w http.ResponseWriter, name, locale string) (*mailer.Preview, bool) {
+	data, err := b.Mail.SampleData(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	p, err := b.Mail.Preview(name, locale, data)
+	if err != nil {
+		// in debug mode, the template error is the most useful thing to show
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return p, true
+}
+
+// writeMailHTML writes the html body of a message. It is shown in a sandboxed
+// frame, and sandboxed again by its own headers, so its scripts never run.
+func writeMailHTML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(body))
+}
+
+// writeMailPreview writes page, executed with data
+func (b *Boilme) writeMailPreview(w http.ResponseWriter, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := page.Execute(w, data); err != nil {
+		b.Logger.Error("could not render the mail preview", "error", err)
+	}
+}
+
+var mailPreviewIndexPage = template.Must(template.New("mail").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Mail</title></head>
+<body>
+<h1>Mail</h1>
+{{if not .Capturing}}<p>Mail is not being captured. Set <code>MAILER_API=log</code> or <code>MAILER_API=file</code> to write it to {{.Dir}}.</p>{{end}}
+<h2>Captured</h2>
+{{if .Captured}}<table>
+<tr><th>Date</th><th>From</th><th>To</th><th>Subject</th></tr>
+{{range .Captured}}<tr>
+<td>{{.Date.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.From}}</td>
+<td>{{range $i, $to := .To}}{{if $i}}, {{end}}{{$to}}{{end}}</td>
+<td><a href="{{$.Base}}/captured/{{.ID}}">{{if .Subject}}{{.Subject}}{{else}}(no subject){{end}}</a></td>
+</tr>
+{{end}}</table>{{else}}<p>No mail has been captured.</p>{{end}}
+<h2>Templates</h2>
+{{if .Templates}}<ul>
+{{range .Templates}}<li><a href="{{$.Base}}/templates/{{.Name}}">{{.Name}}</a>{{$name := .Name}}{{range .Locales}} <a href="{{$.Base}}/templates/{{$name}}?locale={{.}}">{{.}}</a>{{end}}</li>
+{{end}}</ul>{{else}}<p>There are no templates in the mail folder.</p>{{end}}
+</body>
+</html>
+`))
+
+var mailPreviewMessagePage = template.Must(template.New("message").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<p><a href="{{.Base}}/">All mail</a></p>
+<h1>{{if .Title}}{{.Title}}{{else}}(no subject){{end}}</h1>
+{{with .Message}}<table>
+<tr><th>From</th><td>{{.Header.Get "From"}}</td></tr>
+<tr><th>To</th><td>{{.Header.Get "To"}}</td></tr>
+{{with .Header.Get "Cc"}}<tr><th>CC</th><td>{{.}}</td></tr>{{end}}
+{{with .Header.Get "Bcc"}}<tr><th>BCC</th><td>{{.}}</td></tr>{{end}}
+{{with .Header.Get "Reply-To"}}<tr><th>Reply-To</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Date</th><td>{{.Header.Get "Date"}}</td></tr>
+{{if .Attachments}}<tr><th>Attachments</th><td>{{range .Attachments}}{{.Name}} ({{.ContentType}}, {{len .Data}} bytes) {{end}}</td></tr>{{end}}
+</table>
+<p><a href="{{$.Base}}/captured/{{.ID}}/raw">Download .eml</a></p>{{end}}
+{{with .Name}}<p>Template {{.}}{{with $.Locale}}, locale {{.}}{{end}}, rendered with the data in mail/samples/{{.}}.json</p>{{end}}
+<h2>HTML</h2>
+<iframe sandbox src="{{.HTMLURL}}" style="width:100%;height:32em;border:1px solid #ccc"></iframe>
+<h2>Plain text</h2>
+<pre>{{.Plain}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,118 @@
+package boilme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newMailPreviewApp returns an application capturing its mail, with a welcome
+// template and sample data for it in its mail folder
+func newMailPreviewApp(t *testing.T, debug bool) *Boilme {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.Debug = debug
+	config.Mail.API = "file"
+	config.Mail.FromAddress = "app@example.com"
+	root := t.TempDir()
+
+	files := map[string]string{
+		"welcome.html.tmpl":     `{{define "subject"}}Welcome, {{.Name}}{{end}}{{define "body"}}<p>Hello <b>{{.Name}}</b></p>{{end}}`,
+		"welcome.plain.tmpl":    `{{define "body"}}Hello {{.Name}}{{end}}`,
+		"welcome.de.html.tmpl":  `{{define "subject"}}Willkommen, {{.Name}}{{end}}{{define "body"}}<p>Hallo {{.Name}}</p>{{end}}`,
+		"welcome.de.plain.tmpl": `{{define "body"}}Hallo {{.Name}}{{end}}`,
+		"samples/welcome.json":  `{"Name": "Sample User"}`,
+		"broken.html.tmpl":      `{{define "body"}}{{template "missing" .}}{{end}}`,
+		"broken.plain.tmpl":     `{{define "body"}}{{end}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, "mail", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return newTestApp(t, prometheus.NewRegistry(), WithRootPath(root), WithConfig(config))
+}
+
+func TestBoilme_MailPreviewRoutes(t *testing.T) {
+	b := newMailPreviewApp(t, true)
+
+	err := b.Mail.Send(mailer.Message{
+		To:       []string{"ann@example.com"},
+		BCC:      []string{"audit@example.com"},
+		Template: "welcome",
+		Data:     map[string]interface{}{"Name": "Ann"},
+		Files:    []mailer.File{{Name: "notes.txt", Data: []byte("notes")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured, err := b.Mail.Captured()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("expected 1 captured message, got %d", len(captured))
+	}
+	if dir := filepath.Join(b.RootPath, "tmp", "mail"); filepath.Dir(captured[0].Path) != dir {
+		t.Errorf("mail captured in %s, want %s", captured[0].Path, dir)
+	}
+	id := captured[0].ID
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       []string
+	}{
+		{"index", "/", http.StatusOK, []string{"Welcome, Ann", "ann@example.com", "/captured/" + id, "/templates/welcome", "?locale=de", "/templates/broken"}},
+		{"captured message", "/captured/" + id, http.StatusOK, []string{"Welcome, Ann", "audit@example.com", "notes.txt", "<iframe sandbox src=\"/_boilme/mail/captured/" + id + "/html\"", "<pre>Hello Ann</pre>"}},
+		{"captured html", "/captured/" + id + "/html", http.StatusOK, []string{"<p>Hello <b>Ann</b></p>"}},
+		{"raw message", "/captured/" + id + "/raw", http.StatusOK, []string{"Subject: Welcome, Ann", "Bcc: audit@example.com"}},
+		{"missing message", "/captured/nothing", http.StatusNotFound, nil},
+		{"template", "/templates/welcome", http.StatusOK, []string{"Welcome, Sample User", "<pre>Hello Sample User</pre>", "mail/samples/welcome.json", "/templates/welcome/html\""}},
+		{"template html", "/templates/welcome/html", http.StatusOK, []string{"<p>Hello <b>Sample User</b></p>"}},
+		{"template in a locale", "/templates/welcome?locale=de", http.StatusOK, []string{"Willkommen, Sample User", "locale de", "/templates/welcome/html?locale=de"}},
+		{"template html in a locale", "/templates/welcome/html?locale=de", http.StatusOK, []string{"<p>Hallo Sample User</p>"}},
+		{"missing template", "/templates/nothing", http.StatusUnprocessableEntity, []string{"no html template for nothing"}},
+		{"broken template", "/templates/broken", http.StatusUnprocessableEntity, []string{"missing"}},
+	}
+
+	h := b.MailPreviewRoutes()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status is %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("the page does not contain %q:\n%s", want, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBoilme_MailPreviewRoutes_onlyInDebugMode(t *testing.T) {
+	for _, debug := range []bool{true, false} {
+		b := newMailPreviewApp(t, debug)
+		if mounted := b.Routes.Match(chi.NewRouteContext(), http.MethodGet, MailPreviewPath+"/"); mounted != debug {
+			t.Errorf("with debug %v, the preview is mounted: %v", debug, mounted)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package boilme
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// maintenanceKey is where the cache store keeps the maintenance state
+	maintenanceKey = "boilme:maintenance"
+
+	// redisMaintenanceKey is where the redis store keeps the maintenance state,
+	// after the prefix. It is not under Prefix+":", so emptying the cache leaves it.
+	redisMaintenanceKey = "#maintenance"
+
+	// maintenanceCookie holds the bypass token of a browser that visited the secret url
+	maintenanceCookie = "boilme_maintenance_bypass"
+)
+
+// MaintenanceState describes a maintenance window. The site is down while Enabled
+// is set and the time is between Start and Until; a zero Start means now, and a
+// zero Until means until the site is brought back up.
+//
+// During the window, requests from AllowedIPs (addresses or CIDR ranges) are let
+// through, and so are browsers that have visited /<Secret>, which sets a bypass
+// cookie. RetryAfter is sent in the Retry-After header when there is no Until;
+// zero means MAINTENANCE_RETRY_AFTER.
+type MaintenanceState struct {
+	Enabled    bool          `json:"enabled"`
+	Message    string        `json:"message,omitempty"`
+	Start      time.Time     `json:"start,omitempty"`
+	Until      time.Time     `json:"until,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+	AllowedIPs []string      `json:"allowed_ips,omitempty"`
+	Secret     string        `json:"secret,omitempty"`
+}
+
+// Active reports whether the site is down at now
+func (s MaintenanceState) Active(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	if !s.Start.IsZero() && now.Before(s.Start) {
+		return false
+	}
+	if !s.Until.IsZero() && !now.Before(s.Until) {
+		return false
+	}
+	return true
+}
+
+// MaintenanceStore persists the maintenance state, so it survives restarts and is
+// shared by every instance of the application that uses the same store
+type MaintenanceStore interface {
+	Load(ctx context.Context) (MaintenanceState, error)
+	Save(ctx context.Context, state MaintenanceState) error
+}
+
+// CacheMaintenanceStore keeps the maintenance state in a cache. Emptying the cache
+// ends maintenance, so for redis, RedisMaintenanceStore is used instead.
+type CacheMaintenanceStore struct {
+	Cache cache.Cache
+}
+
+func (s CacheMaintenanceStore) Load(_ context.Context) (MaintenanceState, error) {
+	var state MaintenanceState
+
+	str, err := cache.Get[string](s.Cache, maintenanceKey)
+	if errors.Is(err, cache.ErrMiss) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal([]byte(str), &state)
+	return state, err
+}
+
+func (s CacheMaintenanceStore) Save(_ context.Context, state MaintenanceState) error {
+	out, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.Cache.Set(maintenanceKey, string(out), 0)
+}
+
+// RedisMaintenanceStore keeps the maintenance state in redis, under Prefix+"#maintenance",
+// so every instance sharing the redis server shares the state, and emptying a
+// cache.RedisCache with the same Prefix does not end maintenance
+type RedisMaintenanceStore struct {
+	Conn   *redis.Pool
+	Prefix string
+}
+
+func (s RedisMaintenanceStore) Load(ctx context.Context) (MaintenanceState, error) {
+	var state MaintenanceState
+
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return state, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.Prefix+redisMaintenanceKey))
+	if errors.Is(err, redis.ErrNil) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func (s RedisMaintenanceStore) Save(ctx context.Context, state MaintenanceState) error {
+	out, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	conn, err := s.Conn.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", s.Prefix+redisMaintenanceKey, out)
+	return err
+}
+
+// SQLMaintenanceStore keeps the maintenance state in the boilme_maintenance table,
+// which it creates if it does not exist. Dialect is one of the Dialect constants.
+type SQLMaintenanceStore struct {
+	DB      *sql.DB
+	Dialect string
+
+	mu      sync.Mutex
+	created bool
+}
+
+// init creates the table the first time the store is used
+func (s *SQLMaintenanceStore) init(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.created {
+		return nil
+	}
+	_, err := s.DB.ExecContext(ctx,
+		"create table if not exists boilme_maintenance (id integer primary key, state text not null)")
+	s.created = err == nil
+	return err
+}
+
+func (s *SQLMaintenanceStore) Load(ctx context.Context) (MaintenanceState, error) {
+	var state MaintenanceState
+	if err := s.init(ctx); err != nil {
+		return state, err
+	}
+
+	var str string
+	err := s.DB.QueryRowContext(ctx, "select state from boilme_maintenance where id = 1").Scan(&str)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal([]byte(str), &state)
+	return state, err
+}
+
+func (s *SQLMaintenanceStore) Save(ctx context.Context, state MaintenanceState) error {
+	if err := s.init(ctx); err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	var query string
+	switch s.Dialect {
+	case DialectPostgres:
+		query = "insert into boilme_maintenance (id, state) values (1, $1) on conflict (id) do update set state = excluded.state"
+	case DialectMySQL:
+		query = "insert into boilme_maintenance (id, state) values (1, ?) on duplicate key update state = values(state)"
+	default:
+		query = "insert into boilme_maintenance (id, state) values (1, ?) on conflict (id) do update set state = excluded.state"
+	}
+
+	_, err = s.DB.ExecContext(ctx, query, string(out))
+	return err
+}
+
+// FileMaintenanceStore keeps the maintenance state in a json file. It survives
+// restarts, but is only shared by instances on the same machine.
+type FileMaintenanceStore struct {
+	Path string
+}
+
+func (s FileMaintenanceStore) Load(_ context.Context) (MaintenanceState, error) {
+	var state MaintenanceState
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func (s FileMaintenanceStore) Save(_ context.Context, state MaintenanceState) error {
+	out, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+
+	// write a temporary file and rename it, so readers never see half a state
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// maintenance caches the state held in a MaintenanceStore, so the store is read at
+// most once every refresh, rather than on every request
+type maintenance struct {
+	store   MaintenanceStore
+	refresh time.Duration
+	logger  *slog.Logger
+
+	// loads makes concurrent requests share a single read of the store
+	loads singleflight.Group
+
+	mu     sync.Mutex
+	state  MaintenanceState
+	loaded time.Time
+	// version is bumped by set, so a read of the store that started before a state
+	// was set does not replace it
+	version uint64
+}
+
+// current returns the state, reading it from the store if our copy is stale. If the
+// store cannot be read, the last state read is used. The store is read without
+// holding the lock, so requests are never queued behind a slow store; while one
+// request reads it, the others wait for its result.
+func (m *maintenance) current(ctx context.Context) MaintenanceState {
+	m.mu.Lock()
+	state := m.state
+	stale := m.loaded.IsZero() || time.Since(m.loaded) >= m.refresh
+	m.mu.Unlock()
+
+	if !stale {
+		return state
+	}
+
+	v, _, _ := m.loads.Do("state", func() (interface{}, error) {
+		m.mu.Lock()
+		version := m.version
+		m.mu.Unlock()
+
+		// the read is shared, so it must not fail because the request that started
+		// it went away
+		loaded, err := m.store.Load(context.WithoutCancel(ctx))
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if version != m.version {
+			return m.state, nil
+		}
+		if err != nil {
+			m.logger.Error("could not read maintenance state", "error", err)
+		} else {
+			m.state = loaded
+		}
+		m.loaded = time.Now()
+		return m.state, nil
+	})
+
+	return v.(MaintenanceState)
+}
+
+func (m *maintenance) set(ctx context.Context, state MaintenanceState) error {
+	if err := m.store.Save(ctx, state); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+	m.loaded = time.Now()
+	m.version++
+	return nil
+}
+
+// createMaintenanceStore returns the store selected by MAINTENANCE_STORE. By default,
+// the redis server of the cache is used if there is one, and a file in tmp
+// otherwise.
+func (b *Boilme) createMaintenanceStore() (MaintenanceStore, error) {
+	switch b.Config.Maintenance.Store {
+	case "cache":
+		if b.Cache == nil {
+			return nil, errors.New("MAINTENANCE_STORE is cache, but no cache is configured")
+		}
+		if rc := b.redisCache(); rc != nil {
+			return RedisMaintenanceStore{Conn: rc.Conn, Prefix: rc.Prefix}, nil
+		}
+		return CacheMaintenanceStore{Cache: b.Cache}, nil
+	case "database":
+		if b.DB.Pool == nil {
+			return nil, errors.New("MAINTENANCE_STORE is database, but no database connection is available")
+		}
+		return &SQLMaintenanceStore{DB: b.DB.Pool, Dialect: b.DB.Dialect}, nil
+	case "file":
+	default:
+		if rc := b.redisCache(); rc != nil {
+			return RedisMaintenanceStore{Conn: rc.Conn, Prefix: rc.Prefix}, nil
+		}
+	}
+	return FileMaintenanceStore{Path: filepath.Join(b.RootPath, "tmp", "maintenance.json")}, nil
+}
+
+// Maintenance reads the current maintenance state from the store
+func (b *Boilme) Maintenance(ctx context.Context) (MaintenanceState, error) {
+	return b.maintenance.store.Load(ctx)
+}
+
+// SetMaintenance saves state to the store. It takes effect on this instance at
+// once, and on others sharing the store within MAINTENANCE_REFRESH.
+func (b *Boilme) SetMaintenance(ctx context.Context, state MaintenanceState) error {
+	return b.maintenance.set(ctx, state)
+}
+
+// InMaintenance reports whether the site is currently down for maintenance
+func (b *Boilme) InMaintenance(ctx context.Context) bool {
+	return b.maintenance.current(ctx).Active(time.Now())
+}
+
+// CheckForMaintenanceMode is middleware that answers requests with 503 Service
+// Unavailable during a maintenance window. Requests under /api/ get a json body;
+// others get public/maintenance.html, or a plain page if there is none.
+//
+// Requests from an allowed address, or carrying the bypass cookie, are passed on.
+// Addresses are taken from r.RemoteAddr, which is only trustworthy behind a proxy
+// that sets the X-Forwarded-For or X-Real-IP headers used by the RealIP middleware.
+func (b *Boilme) CheckForMaintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		state := b.maintenance.current(r.Context())
+		if !state.Active(now) || r.URL.Path == "/public/maintenance.html" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if state.Secret != "" {
+			token := b.bypassToken(state.Secret)
+			if r.URL.Path == "/"+state.Secret {
+				http.SetCookie(w, &http.Cookie{
+					Name:     maintenanceCookie,
+					Value:    token,
+					Path:     "/",
+					Domain:   b.Config.Session.CookieDomain,
+					Secure:   b.Config.Session.CookieSecure,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+
+			if c, err := r.Cookie(maintenanceCookie); err == nil && hmac.Equal([]byte(c.Value), []byte(token)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if ipAllowed(r.RemoteAddr, b.Config.Maintenance.AllowedIPs) || ipAllowed(r.RemoteAddr, state.AllowedIPs) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := b.retryAfter(state, now)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, post-check=0, pre-check=0")
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			payload := struct {
+				Error      string     `json:"error"`
+				Message    string     `json:"message,omitempty"`
+				Until      *time.Time `json:"until,omitempty"`
+				RetryAfter int        `json:"retry_after"`
+			}{
+				Error:      "maintenance",
+				Message:    state.Message,
+				RetryAfter: retryAfter,
+			}
+			if !state.Until.IsZero() {
+				payload.Until = &state.Until
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(payload)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page, err := os.ReadFile(filepath.Join(b.RootPath, "public", "maintenance.html"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err == nil {
+			_, _ = w.Write(page)
+			return
+		}
+		_ = maintenancePage.Execute(w, state)
+	})
+}
+
+// maintenancePage is shown when the application has no public/maintenance.html
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Down for maintenance</title></head>
+<body>
+<h1>Down for maintenance</h1>
+<p>{{if .Message}}{{.Message}}{{else}}We'll be back shortly.{{end}}</p>
+{{if not .Until.IsZero}}<p>Expected back at {{.Until.Format "2006-01-02 15:04 MST"}}.</p>{{end}}
+</body>
+</html>
+`))
+
+// retryAfter returns the number of seconds clients should wait before retrying
+func (b *Boilme) retryAfter(state MaintenanceState, now time.Time) int {
+	wait := state.RetryAfter
+	if !state.Until.IsZero() {
+		wait = state.Until.Sub(now)
+	} else if wait <= 0 {
+		wait = b.Config.Maintenance.RetryAfter
+	}
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
+
+// bypassToken is the value of the bypass cookie for secret. It is signed with the
+// application key, so the cookie does not reveal the secret.
+func (b *Boilme) bypassToken(secret string) string {
+	mac := hmac.New(sha256.New, []byte(b.Config.Key))
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ipAllowed reports whether the address in remoteAddr matches one of allowed,
+// which may hold addresses or CIDR ranges
+func ipAllowed(remoteAddr string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, a := range allowed {
+		a = strings.TrimSpace(a)
+		if strings.Contains(a, "/") {
+			if _, network, err := net.ParseCIDR(a); err == nil && network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(a); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+package boilme
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestIPAllowed(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		allowed    []string
+		want       bool
+	}{
+		{"nothing allowed", "10.0.0.1:1234", nil, false},
+		{"exact address", "10.0.0.1:1234", []string{"10.0.0.1"}, true},
+		{"other address", "10.0.0.2:1234", []string{"10.0.0.1"}, false},
+		{"cidr range", "192.168.1.77:80", []string{"10.0.0.1", "192.168.1.0/24"}, true},
+		{"outside cidr range", "192.168.2.1:80", []string{"192.168.1.0/24"}, false},
+		{"without a port", "10.0.0.1", []string{"10.0.0.1"}, true},
+		{"ipv6", "[::1]:4000", []string{"::1"}, true},
+		{"ipv6 range", "[2001:db8::5]:4000", []string{"2001:db8::/32"}, true},
+		{"spaces around entries", "10.0.0.1:1234", []string{" 10.0.0.1 "}, true},
+		{"invalid entries are skipped", "10.0.0.1:1234", []string{"nonsense", "10.0.0.0/33", "10.0.0.1"}, true},
+		{"unparseable remote address", "somewhere:80", []string{"10.0.0.1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ipAllowed(tt.remoteAddr, tt.allowed); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoilme_retryAfter(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		state MaintenanceState
+		want  int
+	}{
+		{"configured default", MaintenanceState{}, 300},
+		{"state's own wait", MaintenanceState{RetryAfter: time.Minute}, 60},
+		{"until the end of the window", MaintenanceState{RetryAfter: time.Minute, Until: now.Add(90 * time.Second)}, 90},
+		{"partial seconds round up", MaintenanceState{Until: now.Add(1500 * time.Millisecond)}, 2},
+		{"at least one second", MaintenanceState{Until: now.Add(-time.Second)}, 1},
+	}
+
+	b := &Boilme{}
+	b.Config.Maintenance.RetryAfter = 5 * time.Minute
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.retryAfter(tt.state, now); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// newMaintenanceApp returns an application in maintenance with state, and a handler
+// passing requests through CheckForMaintenanceMode to one answering 200 OK
+func newMaintenanceApp(t *testing.T, state MaintenanceState) (*Boilme, http.Handler) {
+	t.Helper()
+
+	store := FileMaintenanceStore{Path: filepath.Join(t.TempDir(), "maintenance.json")}
+	if err := store.Save(context.Background(), state); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Boilme{RootPath: t.TempDir(), maintenance: &maintenance{store: store, refresh: time.Minute}}
+	b.Config.Key = "abcdefghijklmnopqrstuvwxyz123456"
+	b.Config.Maintenance.RetryAfter = 5 * time.Minute
+
+	return b, b.CheckForMaintenanceMode(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("the app"))
+	}))
+}
+
+func TestBoilme_CheckForMaintenanceMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      MaintenanceState
+		allowed    []string
+		remoteAddr string
+		path       string
+		wantStatus int
+	}{
+		{"not enabled", MaintenanceState{}, nil, "10.0.0.1:1", "/", http.StatusOK},
+		{"enabled", MaintenanceState{Enabled: true}, nil, "10.0.0.1:1", "/", http.StatusServiceUnavailable},
+		{"window not started", MaintenanceState{Enabled: true, Start: time.Now().Add(time.Hour)}, nil, "10.0.0.1:1", "/", http.StatusOK},
+		{"window over", MaintenanceState{Enabled: true, Until: time.Now().Add(-time.Hour)}, nil, "10.0.0.1:1", "/", http.StatusOK},
+		{"allowed by the state", MaintenanceState{Enabled: true, AllowedIPs: []string{"10.0.0.0/8"}}, nil, "10.0.0.1:1", "/", http.StatusOK},
+		{"allowed by the config", MaintenanceState{Enabled: true}, []string{"10.0.0.1"}, "10.0.0.1:1", "/", http.StatusOK},
+		{"not allowed", MaintenanceState{Enabled: true, AllowedIPs: []string{"10.0.0.2"}}, nil, "10.0.0.1:1", "/", http.StatusServiceUnavailable},
+		{"maintenance page itself", MaintenanceState{Enabled: true}, nil, "10.0.0.1:1", "/public/maintenance.html", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, h := newMaintenanceApp(t, tt.state)
+			b.Config.Maintenance.AllowedIPs = tt.allowed
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status is %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if rr.Code == http.StatusServiceUnavailable {
+				if rr.Header().Get("Retry-After") != "300" {
+					t.Errorf("Retry-After is %q", rr.Header().Get("Retry-After"))
+				}
+				if !strings.Contains(rr.Body.String(), "Down for maintenance") {
+					t.Errorf("the maintenance page was not shown: %s", rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBoilme_CheckForMaintenanceMode_json(t *testing.T) {
+	until := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	_, h := newMaintenanceApp(t, MaintenanceState{Enabled: true, Message: "upgrading", Until: until})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status is %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type is %q", ct)
+	}
+
+	var payload struct {
+		Error      string    `json:"error"`
+		Message    string    `json:"message"`
+		Until      time.Time `json:"until"`
+		RetryAfter int       `json:"retry_after"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Error != "maintenance" || payload.Message != "upgrading" || !payload.Until.Equal(until) {
+		t.Errorf("unexpected body: %+v", payload)
+	}
+	if payload.RetryAfter < 590 || payload.RetryAfter > 600 {
+		t.Errorf("retry_after is %d", payload.RetryAfter)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
+
+func TestBoilme_CheckForMaintenanceMode_bypassCookie(t *testing.T) {
+	b, h := newMaintenanceApp(t, MaintenanceState{Enabled: true, Secret: "let-me-in"})
+
+	// visiting the secret url sets the cookie
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/let-me-in", nil))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("expected a redirect home, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != maintenanceCookie || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	if strings.Contains(cookies[0].Value, "let-me-in") {
+		t.Error("the cookie reveals the secret")
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"with the cookie", cookies[0], http.StatusOK},
+		{"without a cookie", nil, http.StatusServiceUnavailable},
+		{"with a forged cookie", &http.Cookie{Name: maintenanceCookie, Value: "let-me-in"}, http.StatusServiceUnavailable},
+		{"with the token of another secret", &http.Cookie{Name: maintenanceCookie, Value: b.bypassToken("other")}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status is %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+// blockingMaintenanceStore counts reads, and holds each one until release is
+// closed. Saves are discarded.
+type blockingMaintenanceStore struct {
+	loads   atomic.Int32
+	release chan struct{}
+	state   MaintenanceState
+}
+
+func (s *blockingMaintenanceStore) Load(ctx context.Context) (MaintenanceState, error) {
+	s.loads.Add(1)
+	<-s.release
+	return s.state, nil
+}
+
+func (s *blockingMaintenanceStore) Save(ctx context.Context, state MaintenanceState) error {
+	return nil
+}
+
+func TestMaintenance_current_sharesOneRead(t *testing.T) {
+	store := &blockingMaintenanceStore{release: make(chan struct{}), state: MaintenanceState{Enabled: true}}
+	m := &maintenance{store: store, refresh: time.Minute}
+
+	var wg sync.WaitGroup
+	results := make(chan MaintenanceState, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- m.current(context.Background())
+		}()
+	}
+
+	// the lock is free while the store is read
+	time.Sleep(20 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		m.mu.Lock()
+		m.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the lock is held while the store is read")
+	}
+
+	close(store.release)
+	wg.Wait()
+	close(results)
+
+	for state := range results {
+		if !state.Enabled {
+			t.Error("a request did not get the state read")
+		}
+	}
+	if n := store.loads.Load(); n != 1 {
+		t.Errorf("the store was read %d times", n)
+	}
+
+	// until the state is stale, it is not read again
+	m.current(context.Background())
+	if n := store.loads.Load(); n != 1 {
+		t.Errorf("the store was read again: %d", n)
+	}
+}
+
+func TestMaintenance_set_winsOverSlowerRead(t *testing.T) {
+	store := &blockingMaintenanceStore{release: make(chan struct{}), state: MaintenanceState{Enabled: true}}
+	m := &maintenance{store: store, refresh: time.Minute}
+
+	// start a read of the old state, then set a new one while it is in progress
+	done := make(chan MaintenanceState)
+	go func() { done <- m.current(context.Background()) }()
+	for store.loads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := m.set(context.Background(), MaintenanceState{Enabled: false, Message: "back"}); err != nil {
+		t.Fatal(err)
+	}
+	close(store.release)
+
+	if state := <-done; state.Message != "back" {
+		t.Errorf("the read returned the old state: %+v", state)
+	}
+	if state := m.current(context.Background()); state.Message != "back" {
+		t.Errorf("the old state replaced the one set: %+v", state)
+	}
+}
+
+func TestBoilme_createMaintenanceStore(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) }}
+	defer pool.Close()
+	rc := &cache.RedisCache{Conn: pool, Prefix: "test"}
+
+	tests := []struct {
+		name  string
+		store string
+		cache cache.Cache
+		want  MaintenanceStore
+	}{
+		{"default with redis", "", rc, RedisMaintenanceStore{Conn: pool, Prefix: "test"}},
+		{"default with a tiered cache", "", &cache.TieredCache{Remote: rc}, RedisMaintenanceStore{Conn: pool, Prefix: "test"}},
+		{"default with a memory cache", "", &cache.MemoryCache{}, FileMaintenanceStore{}},
+		{"default without a cache", "", nil, FileMaintenanceStore{}},
+		{"cache with redis", "cache", rc, RedisMaintenanceStore{Conn: pool, Prefix: "test"}},
+		{"cache with a memory cache", "cache", &cache.MemoryCache{}, CacheMaintenanceStore{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Boilme{Cache: tt.cache, RootPath: t.TempDir()}
+			b.Config.Maintenance.Store = tt.store
+			got, err := b.createMaintenanceStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+				t.Fatalf("got a %T, want a %T", got, tt.want)
+			}
+			if want, ok := tt.want.(RedisMaintenanceStore); ok && got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestRedisMaintenanceStore_survivesCacheEmpty(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) }}
+	defer pool.Close()
+
+	ctx := context.Background()
+	store := RedisMaintenanceStore{Conn: pool, Prefix: "test"}
+	if err := store.Save(ctx, MaintenanceState{Enabled: true, Message: "upgrading"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cache.RedisCache{Conn: pool, Prefix: "test"}
+	if err := c.Set("page", "cached", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Empty(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Enabled || state.Message != "upgrading" {
+		t.Errorf("got %+v after emptying the cache, want the site still down", state)
+	}
+}
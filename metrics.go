@@ -0,0 +1,283 @@
+package boilme
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/jobs"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
+)
+
+// metricsNamespace prefixes the name of every metric we export
+const metricsNamespace = "boilme"
+
+// metrics holds the collectors for an application with METRICS_ENABLED. They are
+// registered with registry, which is served on METRICS_PATH.
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	cacheOps     *prometheus.CounterVec
+	mailSent     *prometheus.CounterVec
+	mailDuration prometheus.Histogram
+	jobRuns      *prometheus.CounterVec
+	jobDuration  *prometheus.HistogramVec
+	queueRuns    *prometheus.CounterVec
+	queueTime    *prometheus.HistogramVec
+}
+
+// newMetrics creates the application's collectors and registers them with registry.
+// When registry is nil, a new one is created, which also exports the Go runtime and
+// process metrics.
+func newMetrics(registry *prometheus.Registry) (*metrics, error) {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+		if err := registry.Register(collectors.NewGoCollector()); err != nil {
+			return nil, err
+		}
+		if err := registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &metrics{
+		registry: registry,
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by method, chi route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by method and chi route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		cacheOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_operations_total",
+			Help:      "Cache operations, by backend, operation and result (hit, miss, ok or error).",
+		}, []string{"backend", "op", "result"}),
+		mailSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mail_sent_total",
+			Help:      "Messages taken from the mail queue, by result (ok or error).",
+		}, []string{"result"}),
+		mailDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "mail_send_duration_seconds",
+			Help:      "Time taken to send a message from the mail queue.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "scheduler_job_runs_total",
+			Help:      "Scheduled job runs, by job and result (ok or panic).",
+		}, []string{"job", "result"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "scheduler_job_duration_seconds",
+			Help:      "Time taken by scheduled job runs, by job.",
+			Buckets:   []float64{.01, .1, .5, 1, 5, 10, 30, 60, 300, 900},
+		}, []string{"job"}),
+		queueRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_job_runs_total",
+			Help:      "Background job runs, by job name and result (ok or error).",
+		}, []string{"job", "result"}),
+		queueTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_job_duration_seconds",
+			Help:      "Time taken by background job runs, by job name.",
+			Buckets:   []float64{.01, .1, .5, 1, 5, 10, 30, 60, 300, 900},
+		}, []string{"job"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.httpRequests, m.httpDuration, m.cacheOps, m.mailSent, m.mailDuration, m.jobRuns, m.jobDuration,
+		m.queueRuns, m.queueTime,
+	} {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// MetricsRegistry returns the registry served on METRICS_PATH, so applications can
+// register their own collectors with it. It is nil unless metrics are enabled.
+func (b *Boilme) MetricsRegistry() *prometheus.Registry {
+	if b.metrics == nil {
+		return nil
+	}
+	return b.metrics.registry
+}
+
+// instrumentCache counts the operations of the redis, badger or memory cache, or of
+// both tiers of a tiered cache
+func (m *metrics) instrumentCache(c cache.Cache) {
+	observer := func(backend string) cache.Observer {
+		return func(op, result string) {
+			m.cacheOps.WithLabelValues(backend, op, result).Inc()
+		}
+	}
+
+	switch c := cache.Unwrap(c).(type) {
+	case *cache.RedisCache:
+		c.Observe = observer("redis")
+	case *cache.BadgerCache:
+		c.Observe = observer("badger")
+	case *cache.MemoryCache:
+		c.Observe = observer("memory")
+	case *cache.TieredCache:
+		c.Local.Observe = observer("memory")
+		c.Remote.Observe = observer("redis")
+	}
+}
+
+// instrumentMail counts and times messages sent by ListenForMail, and reports how
+// many are waiting to be sent. Messages on Jobs are handed to the outbox at once, so
+// the depth is read from the outbox, which is set up after this is called.
+func (m *metrics) instrumentMail(mail *mailer.Mail) error {
+	mail.Observe = func(_ mailer.Message, err error, elapsed time.Duration) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		m.mailSent.WithLabelValues(result).Inc()
+		m.mailDuration.Observe(elapsed.Seconds())
+	}
+
+	return m.registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "mail_queue_depth",
+		Help:      "Messages queued or waiting for a retry in the mail outbox.",
+	}, func() float64 {
+		return mailQueueDepth(mail)
+	}))
+}
+
+// mailQueueDepth returns the number of messages in mail.Jobs, and those the outbox
+// has yet to send, or NaN if the outbox cannot be read
+func mailQueueDepth(mail *mailer.Mail) float64 {
+	depth := len(mail.Jobs)
+	if mail.Outbox == nil {
+		return float64(depth)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	n, err := mail.Outbox.Pending(ctx)
+	if err != nil {
+		return math.NaN()
+	}
+	return float64(depth + n)
+}
+
+// instrumentJobs counts and times every run of a background job
+func (m *metrics) instrumentJobs(j *jobs.Jobs) {
+	j.Observe = func(job *jobs.Job, err error, elapsed time.Duration) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		m.queueRuns.WithLabelValues(job.Name, result).Inc()
+		m.queueTime.WithLabelValues(job.Name).Observe(elapsed.Seconds())
+	}
+}
+
+// instrumentDB exports the connection pool statistics of db
+func (m *metrics) instrumentDB(db Database) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db.Pool, db.Dialect))
+}
+
+// jobWrapper is a cron.JobWrapper that counts and times every run of a scheduled job
+func (m *metrics) jobWrapper() cron.JobWrapper {
+	return func(job cron.Job) cron.Job {
+		name := jobName(job)
+
+		return cron.FuncJob(func() {
+			start := time.Now()
+			result := "panic"
+			defer func() {
+				m.jobRuns.WithLabelValues(name, result).Inc()
+				m.jobDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			}()
+
+			job.Run()
+			result = "ok"
+		})
+	}
+}
+
+// jobName names a scheduled job for metrics: the function name for jobs added with
+// AddFunc, and the type name otherwise. Jobs wrapped by OnLockHolder are named after
+// the job they wrap.
+func jobName(job cron.Job) string {
+	if locked, ok := job.(*lockedJob); ok {
+		return jobName(locked.job)
+	}
+	if fn, ok := job.(cron.FuncJob); ok {
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return fmt.Sprintf("%T", job)
+}
+
+// endpoint is middleware that serves the registry on path, ahead of sessions and
+// maintenance mode, and passes every other request on
+func (m *metrics) endpoint(path string) func(http.Handler) http.Handler {
+	handler := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == path && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// instrument is middleware that counts and times requests by their chi route
+// pattern. Requests that match no route are grouped together, so probing random
+// urls cannot create unbounded series.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,214 @@
+package boilme
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/robfig/cron/v3"
+)
+
+// newTestApp returns an application set up in a temporary directory, with its
+// metrics registered with reg
+func newTestApp(t *testing.T, reg *prometheus.Registry, opts ...Option) *Boilme {
+	t.Helper()
+
+	opts = append([]Option{WithRootPath(t.TempDir()), WithConfig(DefaultConfig()), WithMetricsRegistry(reg)}, opts...)
+	b, err := NewWithOptions(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = b.Shutdown(ctx)
+	})
+	return b
+}
+
+// gaugeValue returns the value of the gauge called name in reg
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("%s is not registered", name)
+	return 0
+}
+
+func TestMetrics_instrument(t *testing.T) {
+	m, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(m.instrument)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	for _, target := range []string{"/users/1", "/users/2", "/random/1", "/random/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", nil))
+
+	tests := []struct {
+		method, route, status string
+		want                  float64
+	}{
+		{"GET", "/users/{id}", "200", 2},
+		{"POST", "/users", "201", 1},
+		{"GET", "unmatched", "404", 2},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.httpRequests.WithLabelValues(tt.method, tt.route, tt.status)); got != tt.want {
+			t.Errorf("%s %s %s counted %v times, want %v", tt.method, tt.route, tt.status, got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(m.httpDuration); n != 3 {
+		t.Errorf("expected 3 duration series, got %d", n)
+	}
+}
+
+func TestMetrics_endpoint(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := newMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mailSent.WithLabelValues("ok").Inc()
+
+	h := m.endpoint("/metrics")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rr.Body.String(), `boilme_mail_sent_total{result="ok"} 1`) {
+		t.Errorf("metrics not served: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("a POST was not passed on: %d", rr.Code)
+	}
+}
+
+func TestMetrics_instrumentCache(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) }}
+	defer pool.Close()
+
+	m, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cache.RedisCache{Conn: pool, Prefix: "test"}
+	m.instrumentCache(c)
+
+	_, _ = c.Get("missing")
+	_ = c.Set("key", "value", 0)
+	_, _ = c.Get("key")
+
+	for _, result := range []string{"hit", "miss"} {
+		if got := testutil.ToFloat64(m.cacheOps.WithLabelValues("redis", "get", result)); got != 1 {
+			t.Errorf("counted %v %s results", got, result)
+		}
+	}
+}
+
+func TestMetrics_jobWrapper(t *testing.T) {
+	m, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := cron.FuncJob(quietJob)
+	name := jobName(job)
+	if !strings.HasSuffix(name, ".quietJob") {
+		t.Errorf("job is named %q", name)
+	}
+
+	wrapped := m.jobWrapper()(job)
+	wrapped.Run()
+	wrapped.Run()
+
+	// the panic is left for cron's own Recover wrapper
+	func() {
+		defer func() { _ = recover() }()
+		m.jobWrapper()(cron.FuncJob(panickingJob)).Run()
+	}()
+
+	tests := []struct {
+		job, result string
+		want        float64
+	}{
+		{name, "ok", 2},
+		{jobName(cron.FuncJob(panickingJob)), "panic", 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.jobRuns.WithLabelValues(tt.job, tt.result)); got != tt.want {
+			t.Errorf("%s counted %v %s runs, want %v", tt.job, got, tt.result, tt.want)
+		}
+	}
+}
+
+func quietJob() {}
+
+func panickingJob() { panic("oops") }
+
+func TestMetrics_mailQueueDepth(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	store := mailer.NewMemoryOutboxStore()
+	b := newTestApp(t, reg, WithMailOutboxStore(store))
+
+	if got := gaugeValue(t, reg, "boilme_mail_queue_depth"); got != 0 {
+		t.Errorf("depth of an empty outbox is %v", got)
+	}
+
+	// messages saved without a job to send them stay where they are
+	ctx := context.Background()
+	for id, status := range map[string]mailer.Status{
+		"a": mailer.StatusQueued,
+		"b": mailer.StatusQueued,
+		"c": mailer.StatusRetrying,
+		"d": mailer.StatusSent,
+		"e": mailer.StatusFailed,
+	} {
+		msg := &mailer.OutboxMessage{ID: id, Status: status, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+		if err := store.Save(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := gaugeValue(t, reg, "boilme_mail_queue_depth"); got != 3 {
+		t.Errorf("depth is %v, want the 3 queued and retrying messages", got)
+	}
+	if b.MetricsRegistry() != reg {
+		t.Error("the registry passed in is not the one served")
+	}
+}
@@ -6,7 +6,6 @@ import (
 
 	"github.com/golang-migrate/migrate/v4"
 
-	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/golang-migrate/migrate/v4/database/mysql"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -0,0 +1,231 @@
+package boilme
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/filesystems"
+	"github.com/bxtal-lsn/go-boilme/jobs"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures an application created with NewWithOptions
+type Option func(*options) error
+
+// options collects everything passed to NewWithOptions. Anything left unset is
+// created from the configuration, just as New does.
+type options struct {
+	rootPath        string
+	config          *Config
+	db              *sql.DB
+	cache           cache.Cache
+	mailer          *mailer.Mail
+	sessionStore    scs.Store
+	logger          *slog.Logger
+	metricsRegistry *prometheus.Registry
+	tracerProvider  trace.TracerProvider
+	maintenance     MaintenanceStore
+	jobQueue        jobs.Queue
+	outboxStore     mailer.OutboxStore
+	suppressions    mailer.SuppressionList
+	mailTransport   mailer.Transport
+	fileSystems     map[string]filesystems.FS
+}
+
+// WithRootPath sets the root path of the application, where views, mail templates,
+// migrations and tmp are found. It defaults to the current directory.
+func WithRootPath(path string) Option {
+	return func(o *options) error {
+		o.rootPath = path
+		return nil
+	}
+}
+
+// WithConfig sets the configuration of the application. Without it, DefaultConfig is
+// used; no .env file or environment variables are read. Use LoadConfig to build a
+// Config from those sources.
+func WithConfig(cfg Config) Option {
+	return func(o *options) error {
+		o.config = &cfg
+		return nil
+	}
+}
+
+// WithDB uses db as the application's database, instead of opening one from the
+// configuration. The application takes ownership of db, and closes it on Shutdown.
+// db is used as it is, so its queries are not traced even when tracing is on; open
+// it with otelsql to trace them.
+func WithDB(db *sql.DB) Option {
+	return func(o *options) error {
+		if db == nil {
+			return errors.New("WithDB: db is nil")
+		}
+		o.db = db
+		return nil
+	}
+}
+
+// WithCache uses c as the application's cache, instead of creating one from the
+// configuration. If c is a *cache.RedisCache or *cache.BadgerCache, its connection
+// is closed on Shutdown.
+func WithCache(c cache.Cache) Option {
+	return func(o *options) error {
+		if c == nil {
+			return errors.New("WithCache: cache is nil")
+		}
+		o.cache = c
+		return nil
+	}
+}
+
+// WithMailer uses m as the application's mailer, instead of creating one from the
+// configuration. If m has no Jobs or Results channels, they are created.
+func WithMailer(m mailer.Mail) Option {
+	return func(o *options) error {
+		o.mailer = &m
+		return nil
+	}
+}
+
+// WithSessionStore stores sessions in store, regardless of SESSION_TYPE
+func WithSessionStore(store scs.Store) Option {
+	return func(o *options) error {
+		if store == nil {
+			return errors.New("WithSessionStore: store is nil")
+		}
+		o.sessionStore = store
+		return nil
+	}
+}
+
+// WithLogger uses logger as the application's Logger, instead of creating one from
+// the LOG_* settings. InfoLog and ErrorLog write through it, and SetLogLevel has no
+// effect; control the level through logger's handler instead.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			return errors.New("WithLogger: logger is nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithMetricsRegistry enables metrics, regardless of METRICS_ENABLED, and registers
+// them with registry rather than a registry of their own. Tests can use it to read
+// the values recorded.
+func WithMetricsRegistry(registry *prometheus.Registry) Option {
+	return func(o *options) error {
+		if registry == nil {
+			return errors.New("WithMetricsRegistry: registry is nil")
+		}
+		o.metricsRegistry = registry
+		return nil
+	}
+}
+
+// WithTracerProvider enables tracing, regardless of TRACING_ENABLED, and records
+// spans with tp. The application does not shut tp down. Tests can pass a provider
+// with an in-memory exporter to inspect the spans recorded.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) error {
+		if tp == nil {
+			return errors.New("WithTracerProvider: tracer provider is nil")
+		}
+		o.tracerProvider = tp
+		return nil
+	}
+}
+
+// WithFileSystem adds fs to the application's FileSystems under name. It may be
+// given more than once.
+func WithFileSystem(name string, fs filesystems.FS) Option {
+	return func(o *options) error {
+		if name == "" || fs == nil {
+			return errors.New("WithFileSystem: name and file system are required")
+		}
+		if o.fileSystems == nil {
+			o.fileSystems = make(map[string]filesystems.FS)
+		}
+		o.fileSystems[name] = fs
+		return nil
+	}
+}
+
+// NewWithOptions creates an application programmatically. Unlike New, it does not
+// need a .env file and does not create any folders, which makes it suitable for
+// tests and for embedding Boilme in a larger program. It never exits the process;
+// every problem is returned as an error.
+func NewWithOptions(opts ...Option) (*Boilme, error) {
+	b := &Boilme{}
+	if err := b.setup(opts...); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WithMaintenanceStore keeps the maintenance state in store, instead of the store
+// selected by MAINTENANCE_STORE
+func WithMaintenanceStore(store MaintenanceStore) Option {
+	return func(o *options) error {
+		if store == nil {
+			return errors.New("WithMaintenanceStore: store is nil")
+		}
+		o.maintenance = store
+		return nil
+	}
+}
+
+// WithJobQueue stores background jobs in queue, instead of the queue selected by
+// JOBS_BACKEND
+func WithJobQueue(queue jobs.Queue) Option {
+	return func(o *options) error {
+		if queue == nil {
+			return errors.New("WithJobQueue: queue is nil")
+		}
+		o.jobQueue = queue
+		return nil
+	}
+}
+
+// WithMailOutboxStore keeps the mail outbox in store, instead of the store selected
+// by JOBS_BACKEND
+func WithMailOutboxStore(store mailer.OutboxStore) Option {
+	return func(o *options) error {
+		if store == nil {
+			return errors.New("WithMailOutboxStore: store is nil")
+		}
+		o.outboxStore = store
+		return nil
+	}
+}
+
+// WithMailTransport sends mail with t, instead of the transport selected by
+// MAILER_API. It is closed on shutdown, if it is an io.Closer.
+func WithMailTransport(t mailer.Transport) Option {
+	return func(o *options) error {
+		if t == nil {
+			return errors.New("WithMailTransport: transport is nil")
+		}
+		o.mailTransport = t
+		return nil
+	}
+}
+
+// WithMailSuppressionList checks mail against list before it is sent, and adds
+// the addresses that bounce for good or complain to it, regardless of
+// MAIL_SUPPRESSIONS and JOBS_BACKEND
+func WithMailSuppressionList(list mailer.SuppressionList) Option {
+	return func(o *options) error {
+		if list == nil {
+			return errors.New("WithMailSuppressionList: list is nil")
+		}
+		o.suppressions = list
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package boilme
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewWithOptions_closesWhatItOpenedOnError(t *testing.T) {
+	root := t.TempDir()
+
+	cfg := DefaultConfig()
+	cfg.Cache = "badger"
+	// fails after the cache has been opened, as there is no database
+	cfg.Maintenance.Store = "database"
+
+	if _, err := NewWithOptions(WithRootPath(root), WithConfig(cfg)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// badger locks its directory, so it can only be opened again once it is closed
+	db, err := badger.Open(badger.DefaultOptions(filepath.Join(root, "tmp", "badger")).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("badger was left open: %v", err)
+	}
+	_ = db.Close()
+}
+
+func TestNewWithOptions_leavesGivenConnectionsOpenOnError(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := &cache.BadgerCache{Conn: db}
+
+	cfg := DefaultConfig()
+	cfg.Maintenance.Store = "database"
+
+	if _, err := NewWithOptions(WithRootPath(t.TempDir()), WithConfig(cfg), WithCache(c)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if err := c.Set("key", "value", 0); err != nil {
+		t.Errorf("the cache passed in was closed: %v", err)
+	}
+}
+
+func TestNewWithOptions_mailSuppressions(t *testing.T) {
+	given := mailer.NewMemorySuppressionList()
+	enabled := DefaultConfig()
+	enabled.Mail.Suppressions = true
+
+	tests := []struct {
+		name string
+		opts []Option
+		want func(mailer.SuppressionList) bool
+	}{
+		{"off by default", nil, func(l mailer.SuppressionList) bool { return l == nil }},
+		{"in the jobs store", []Option{WithConfig(enabled)}, func(l mailer.SuppressionList) bool {
+			_, ok := l.(*mailer.MemorySuppressionList)
+			return ok && l != given
+		}},
+		{"given", []Option{WithMailSuppressionList(given)}, func(l mailer.SuppressionList) bool { return l == given }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestApp(t, prometheus.NewRegistry(), tt.opts...)
+			if !tt.want(b.Mail.Suppressions) {
+				t.Errorf("the suppression list is %#v", b.Mail.Suppressions)
+			}
+		})
+	}
+
+	if _, err := NewWithOptions(WithMailSuppressionList(nil)); err == nil {
+		t.Error("a nil suppression list was accepted")
+	}
+}
+
+// closingTransport records whether it was closed
+type closingTransport struct {
+	closed bool
+}
+
+func (t *closingTransport) Send(ctx context.Context, c *mailer.Composed) error { return nil }
+
+func (t *closingTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestNewWithOptions_mailTransport(t *testing.T) {
+	b := newTestApp(t, prometheus.NewRegistry())
+	if _, ok := b.Mail.Transport.(*mailer.SMTPTransport); !ok {
+		t.Errorf("the default transport is %T, want smtp", b.Mail.Transport)
+	}
+
+	given := &closingTransport{}
+	b = newTestApp(t, prometheus.NewRegistry(), WithMailTransport(given))
+	if b.Mail.Transport != given {
+		t.Fatalf("the transport is %T, want the one given", b.Mail.Transport)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !given.closed {
+		t.Error("the transport was not closed on shutdown")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Mail.API = "postmark"
+	if _, err := NewWithOptions(WithRootPath(t.TempDir()), WithConfig(cfg)); err == nil || !strings.Contains(err.Error(), "needs APIKey") {
+		t.Errorf("a postmark transport without a token returned %v", err)
+	}
+}
+
+func TestNewWithOptions_sessionStore(t *testing.T) {
+	tests := []struct {
+		dbType, sessionType string
+		want                any
+	}{
+		{"mariadb", "mariadb", &mysqlstore.MySQLStore{}},
+		{"mysql", "mariadb", &mysqlstore.MySQLStore{}},
+		{"pgx", "postgresql", &postgresstore.PostgresStore{}},
+		{"postgresql", "postgres", &postgresstore.PostgresStore{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbType+"/"+tt.sessionType, func(t *testing.T) {
+			driver, err := driverName(NormalizeDialect(tt.dbType))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// nothing is sent over the connection, so it needs no server
+			db, err := sql.Open(driver, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			cfg := DefaultConfig()
+			cfg.Database.Type, cfg.Database.Host, cfg.Database.User, cfg.Database.Name = tt.dbType, "localhost", "boilme", "boilme"
+			cfg.Session.Type = tt.sessionType
+			b := newTestApp(t, prometheus.NewRegistry(), WithConfig(cfg), WithDB(db))
+			if got, want := fmt.Sprintf("%T", b.Session.Store), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("the session store is %s, want %s", got, want)
+			}
+		})
+	}
+}
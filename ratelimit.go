@@ -0,0 +1,330 @@
+package boilme
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+)
+
+const (
+	// defaultRateLimit is how many requests a RateLimit allows when its Limit is 0
+	defaultRateLimit = 60
+
+	// defaultRateLimitWindow is the Window of a RateLimit when it is 0
+	defaultRateLimitWindow = time.Minute
+)
+
+// RateLimitAlgorithm is how a RateLimit counts requests
+type RateLimitAlgorithm int
+
+const (
+	// FixedWindow allows Limit requests in each Window, starting at multiples of
+	// Window. It is the cheapest, but lets up to twice Limit through around the
+	// start of a window.
+	FixedWindow RateLimitAlgorithm = iota
+
+	// SlidingWindow weighs the count of the previous window by how much of it
+	// overlaps the last Window, which smooths out the bursts of FixedWindow.
+	SlidingWindow
+
+	// TokenBucket allows bursts of up to Limit requests, and refills Limit
+	// requests every Window, evenly. It locks the bucket for every request, so it
+	// costs the most round trips to the cache, and refuses requests that wait for
+	// the lock for more than 200ms.
+	TokenBucket
+)
+
+// RateLimit configures the RateLimit middleware: Limit requests are allowed every
+// Window, for each key that Key returns. Name keeps the counts of different limits
+// apart. Limit defaults to 60, Window to a minute and Key to RateLimitByIP;
+// requests for which Key returns an empty key are not limited.
+type RateLimit struct {
+	Name      string
+	Limit     int
+	Window    time.Duration
+	Algorithm RateLimitAlgorithm
+	Key       func(r *http.Request) string
+
+	now func() time.Time
+}
+
+// rateLimitResult is the outcome of a request under a limit. reset is the time
+// until the limit is fully restored, and retryAfter, for a refused request, the
+// time until a request would be allowed.
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// rateLimitStore is what a cache has to do for rate limits
+type rateLimitStore interface {
+	cache.Cache
+	cache.Counter
+	cache.Locker
+}
+
+// rateLimiter applies a RateLimit, with counts kept in store
+type rateLimiter struct {
+	RateLimit
+	store rateLimitStore
+}
+
+// RateLimit returns middleware that refuses requests over rl with 429 Too Many
+// Requests. Counts are kept in the application's cache, so that a limit holds
+// across the replicas that share it; with no cache that keeps counters, they are
+// kept in memory. Every response carries the RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers, and refusals a Retry-After header.
+// Requests under /api/ are refused with a json body.
+//
+//	r.With(app.RateLimit(boilme.RateLimit{Name: "login", Limit: 5, Window: time.Minute})).Post("/login", h.PostLogin)
+//
+// When the cache fails, requests are let through, and the error is logged.
+func (b *Boilme) RateLimit(rl RateLimit) func(http.Handler) http.Handler {
+	if rl.Limit <= 0 {
+		rl.Limit = defaultRateLimit
+	}
+	if rl.Window <= 0 {
+		rl.Window = defaultRateLimitWindow
+	}
+	if rl.Key == nil {
+		rl.Key = RateLimitByIP
+	}
+	if rl.now == nil {
+		rl.now = time.Now
+	}
+
+	var store rateLimitStore
+	if b.Cache != nil {
+		if _, ok := cache.Unwrap(b.Cache).(rateLimitStore); ok {
+			store, _ = b.Cache.(rateLimitStore)
+		}
+	}
+	if store == nil {
+		b.Logger.Warn("the cache keeps no counters, so rate limits are kept in memory", "limit", rl.Name)
+		store = &cache.MemoryCache{}
+	}
+	l := &rateLimiter{RateLimit: rl, store: store}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rl.Key(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := l.take(key)
+			if err != nil {
+				b.Logger.Error("could not check a rate limit, so the request was let through", "limit", rl.Name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(rl.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.reset)))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.Limit, seconds(rl.Window)))
+			if res.allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter := seconds(res.retryAfter)
+			h.Set("Retry-After", strconv.Itoa(retryAfter))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				payload := struct {
+					Error      string `json:"error"`
+					RetryAfter int    `json:"retry_after"`
+				}{
+					Error:      "rate_limited",
+					RetryAfter: retryAfter,
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_ = json.NewEncoder(w).Encode(payload)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		})
+	}
+}
+
+// RateLimitByIP keys rate limits by the address of the client. Behind a proxy, it
+// is only the client's once the RealIP middleware has read it from the headers.
+func RateLimitByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitByToken keys rate limits by the bearer token of the Authorization
+// header, hashed so that tokens are not kept in the cache. Requests without one
+// are not limited.
+func RateLimitByToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RateLimitByUser keys rate limits by the userID in the session, which must have
+// been loaded. Guests are not limited.
+func (b *Boilme) RateLimitByUser(r *http.Request) string {
+	if !b.Session.Exists(r.Context(), "userID") {
+		return ""
+	}
+	return fmt.Sprint(b.Session.Get(r.Context(), "userID"))
+}
+
+// seconds rounds d up to whole seconds, as rate limit headers count them
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// take counts a request for key, and returns whether it is allowed
+func (l *rateLimiter) take(key string) (rateLimitResult, error) {
+	key = fmt.Sprintf("ratelimit:%s:%s", l.Name, key)
+	switch l.Algorithm {
+	case SlidingWindow:
+		return l.slidingWindow(key)
+	case TokenBucket:
+		return l.tokenBucket(key)
+	default:
+		return l.fixedWindow(key)
+	}
+}
+
+func (l *rateLimiter) fixedWindow(key string) (rateLimitResult, error) {
+	now := l.now()
+	start := now.Truncate(l.Window)
+	reset := start.Add(l.Window).Sub(now)
+
+	// the counter outlives its window a little, for clocks that are behind
+	n, err := l.store.Increment(fmt.Sprintf("%s:%d", key, start.UnixNano()), 1, reset+time.Second)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	return rateLimitResult{
+		allowed:    n <= int64(l.Limit),
+		remaining:  max(l.Limit-int(n), 0),
+		reset:      reset,
+		retryAfter: reset,
+	}, nil
+}
+
+func (l *rateLimiter) slidingWindow(key string) (rateLimitResult, error) {
+	now := l.now()
+	start := now.Truncate(l.Window)
+	elapsed := now.Sub(start)
+
+	current, err := l.store.Increment(fmt.Sprintf("%s:%d", key, start.UnixNano()), 1, 2*l.Window)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	previous, err := l.store.Increment(fmt.Sprintf("%s:%d", key, start.Add(-l.Window).UnixNano()), 0, 2*l.Window)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+
+	// the share of the previous window that is still within the last Window
+	overlap := 1 - float64(elapsed)/float64(l.Window)
+	count := float64(previous)*overlap + float64(current)
+	limit := float64(l.Limit)
+
+	res := rateLimitResult{
+		allowed:   count <= limit,
+		remaining: max(int(limit-count), 0),
+		reset:     l.Window - elapsed,
+	}
+	if current > 0 {
+		res.reset += l.Window
+	}
+
+	// the share of a window to wait for the next request to fit
+	switch {
+	case res.allowed:
+	case float64(current)+1 <= limit:
+		// previous weighs more than the room left, so it is not 0
+		wait := 1 - (limit-float64(current)-1)/float64(previous)
+		res.retryAfter = max(time.Duration(wait*float64(l.Window))-elapsed, 0)
+	default:
+		// the current window is full: wait for it to become the previous one
+		wait := max(1-(limit-1)/float64(current), 0)
+		res.retryAfter = l.Window - elapsed + time.Duration(wait*float64(l.Window))
+	}
+	return res, nil
+}
+
+// bucketAttempts is how many times a request tries to lock a token bucket, 5ms
+// apart, before it gives up
+const bucketAttempts = 40
+
+func (l *rateLimiter) tokenBucket(key string) (rateLimitResult, error) {
+	limit := float64(l.Limit)
+	perToken := float64(l.Window) / limit
+
+	var token string
+	var err error
+	for i := 0; i < bucketAttempts; i++ {
+		if token, err = l.store.Lock(key, time.Second); !errors.Is(err, cache.ErrLocked) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if errors.Is(err, cache.ErrLocked) {
+		// the requests holding the bucket are taking its tokens, so this one is
+		// refused rather than let through uncounted
+		return rateLimitResult{reset: l.Window, retryAfter: time.Duration(perToken)}, nil
+	}
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	defer func() { _ = l.store.Unlock(key, token) }()
+
+	now := l.now()
+
+	// the bucket is kept as its tokens and the time they were counted, and is full
+	// when it is not in the cache
+	tokens := limit
+	bucket, err := cache.Get[string](l.store, key)
+	if err != nil && !errors.Is(err, cache.ErrMiss) {
+		return rateLimitResult{}, err
+	}
+	var counted int64
+	if _, scanErr := fmt.Sscanf(bucket, "%g %d", &tokens, &counted); err == nil && scanErr == nil {
+		tokens = min(limit, tokens+float64(now.Sub(time.Unix(0, counted)))/perToken)
+	}
+
+	res := rateLimitResult{allowed: tokens >= 1}
+	if res.allowed {
+		tokens--
+	} else {
+		res.retryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	res.remaining = int(tokens)
+	res.reset = time.Duration((limit - tokens) * perToken)
+
+	// a bucket left alone for a Window is full again
+	if err := l.store.Set(key, fmt.Sprintf("%g %d", tokens, now.UnixNano()), l.Window); err != nil {
+		return rateLimitResult{}, err
+	}
+	return res, nil
+}
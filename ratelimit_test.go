@@ -0,0 +1,346 @@
+package boilme
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/gomodule/redigo/redis"
+)
+
+// testClock is a clock that only moves when told to
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newRateLimited returns a handler limited by rl, with counts in a memory cache,
+// and the clock of the limit, which starts at the beginning of a window
+func newRateLimited(rl RateLimit) (http.Handler, *testClock) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	rl.now = clock.Now
+	b := &Boilme{Cache: &cache.MemoryCache{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	return b.RateLimit(rl)(ok), clock
+}
+
+// hit sends a request from addr to h, and returns the response
+func hit(h http.Handler, path, addr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// allowed sends a request from addr to h, and reports whether it got through
+func allowed(h http.Handler, addr string) bool {
+	return hit(h, "/login", addr).Code == http.StatusOK
+}
+
+func TestRateLimit_fixedWindow(t *testing.T) {
+	h, clock := newRateLimited(RateLimit{Name: "login", Limit: 3, Window: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if !allowed(h, "10.0.0.1:1234") {
+			t.Fatalf("request %d was refused", i+1)
+		}
+	}
+	clock.Add(20 * time.Second)
+	w := hit(h, "/login", "10.0.0.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d over the limit, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "40" {
+		t.Errorf("Retry-After is %q, want 40", got)
+	}
+
+	// other clients have their own count
+	if !allowed(h, "10.0.0.2:1234") {
+		t.Error("another address was refused")
+	}
+
+	// a new window starts from 0
+	clock.Add(40 * time.Second)
+	if !allowed(h, "10.0.0.1:1234") {
+		t.Error("refused in a new window")
+	}
+}
+
+func TestRateLimit_slidingWindow(t *testing.T) {
+	h, clock := newRateLimited(RateLimit{Name: "api", Limit: 4, Window: time.Minute, Algorithm: SlidingWindow})
+
+	// 4 requests at the end of a window
+	clock.Add(50 * time.Second)
+	for i := 0; i < 4; i++ {
+		if !allowed(h, "10.0.0.1:1") {
+			t.Fatalf("request %d was refused", i+1)
+		}
+	}
+
+	// a quarter into the next window, 3 of them still count, so one more fits
+	clock.Add(25 * time.Second)
+	if !allowed(h, "10.0.0.1:1") {
+		t.Fatal("refused with room left")
+	}
+	w := hit(h, "/login", "10.0.0.1:1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d over the limit, want 429", w.Code)
+	}
+	// with 2 requests in this window, the previous one must weigh no more than 1
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After is %q, want 30", got)
+	}
+
+	clock.Add(30 * time.Second)
+	if !allowed(h, "10.0.0.1:1") {
+		t.Error("refused after Retry-After")
+	}
+}
+
+func TestRateLimit_tokenBucket(t *testing.T) {
+	h, clock := newRateLimited(RateLimit{Name: "burst", Limit: 5, Window: 10 * time.Second, Algorithm: TokenBucket})
+
+	// a full bucket lets a burst through
+	for i := 0; i < 5; i++ {
+		if !allowed(h, "10.0.0.1:1") {
+			t.Fatalf("request %d was refused", i+1)
+		}
+	}
+	w := hit(h, "/login", "10.0.0.1:1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d with an empty bucket, want 429", w.Code)
+	}
+	// a token every 2 seconds
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After is %q, want 2", got)
+	}
+
+	clock.Add(4 * time.Second)
+	for i := 0; i < 2; i++ {
+		if !allowed(h, "10.0.0.1:1") {
+			t.Fatalf("refilled request %d was refused", i+1)
+		}
+	}
+	if allowed(h, "10.0.0.1:1") {
+		t.Error("more than the refilled tokens were let through")
+	}
+}
+
+func TestRateLimit_tokenBucketConcurrent(t *testing.T) {
+	h, _ := newRateLimited(RateLimit{Name: "burst", Limit: 10, Window: time.Minute, Algorithm: TokenBucket})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	through := 0
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowed(h, "10.0.0.1:1") {
+				mu.Lock()
+				through++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if through != 10 {
+		t.Errorf("%d requests got through, want 10", through)
+	}
+}
+
+func TestRateLimit_tokenBucketContended(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pool := &redis.Pool{MaxActive: 50, Wait: true, Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) }}
+	defer pool.Close()
+
+	stores := map[string]cache.Cache{
+		"memory": &cache.MemoryCache{},
+		"redis":  &cache.RedisCache{Conn: pool, Prefix: "test"},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			b := &Boilme{Cache: store, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			h := b.RateLimit(RateLimit{Name: "burst", Limit: 5, Window: time.Minute, Algorithm: TokenBucket})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			var wg sync.WaitGroup
+			var through atomic.Int32
+			for i := 0; i < 300; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if allowed(h, "10.0.0.1:1") {
+						through.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := through.Load(); n > 5 {
+				t.Errorf("%d requests got through, want at most 5", n)
+			}
+		})
+	}
+}
+
+func TestRateLimit_tokenBucketBusy(t *testing.T) {
+	b := &Boilme{Cache: lockedBucket{&cache.MemoryCache{}}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	h := b.RateLimit(RateLimit{Name: "burst", Limit: 5, Window: 10 * time.Second, Algorithm: TokenBucket})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := hit(h, "/login", "10.0.0.1:1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d while the bucket stays locked, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After is %q, want 2", got)
+	}
+}
+
+func TestRateLimit_defaults(t *testing.T) {
+	h, _ := newRateLimited(RateLimit{Name: "defaults", Limit: -1})
+
+	w := hit(h, "/login", "10.0.0.1:1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "60;w=60" {
+		t.Errorf("RateLimit-Policy is %q, want 60;w=60", got)
+	}
+}
+
+func TestRateLimit_headers(t *testing.T) {
+	h, clock := newRateLimited(RateLimit{Name: "api", Limit: 2, Window: time.Minute})
+	clock.Add(15 * time.Second)
+
+	w := hit(h, "/api/things", "10.0.0.1:1")
+	want := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "45",
+		"RateLimit-Policy":    "2;w=60",
+		"Retry-After":         "",
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s is %q, want %q", k, got, v)
+		}
+	}
+
+	hit(h, "/api/things", "10.0.0.1:1")
+	w = hit(h, "/api/things", "10.0.0.1:1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %s, want a json 429", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "rate_limited" || body.RetryAfter != 45 {
+		t.Errorf("got %+v", body)
+	}
+	if w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("RateLimit-Remaining is %q, want 0", w.Header().Get("RateLimit-Remaining"))
+	}
+}
+
+func TestRateLimit_keys(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     func(*http.Request) string
+		request func(*http.Request)
+		want    string
+	}{
+		{"ip", RateLimitByIP, func(r *http.Request) { r.RemoteAddr = "10.0.0.1:1234" }, "10.0.0.1"},
+		{"ipv6", RateLimitByIP, func(r *http.Request) { r.RemoteAddr = "[::1]:1234" }, "::1"},
+		{"token", RateLimitByToken, func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+			"2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{"no token", RateLimitByToken, func(r *http.Request) {}, ""},
+		{"basic auth", RateLimitByToken, func(r *http.Request) { r.SetBasicAuth("user", "pass") }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.request(r)
+			if got := tt.key(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	b := &Boilme{Session: scs.New()}
+	var keys []string
+	h := b.Session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			b.Session.Put(r.Context(), "userID", 42)
+		}
+		keys = append(keys, b.RateLimitByUser(r))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if keys[1] != "42" || keys[2] != "" {
+		t.Errorf("got keys %q, want 42 for the user and none for a guest", keys)
+	}
+}
+
+func TestRateLimit_failsOpen(t *testing.T) {
+	b := &Boilme{Cache: brokenCounter{&cache.MemoryCache{}}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	h := b.RateLimit(RateLimit{Name: "login", Limit: 1, Window: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 3; i++ {
+		if !allowed(h, "10.0.0.1:1") {
+			t.Fatal("refused while the cache fails")
+		}
+	}
+}
+
+// brokenCounter is a cache whose counters fail
+type brokenCounter struct{ *cache.MemoryCache }
+
+func (brokenCounter) Increment(string, int64, time.Duration) (int64, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+// lockedBucket is a cache whose locks are always held by someone else
+type lockedBucket struct{ *cache.MemoryCache }
+
+func (lockedBucket) Lock(string, time.Duration) (string, error) {
+	return "", cache.ErrLocked
+}
@@ -1,16 +1,20 @@
 package render
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/CloudyKit/jet/v6"
 	"github.com/alexedwards/scs/v2"
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Render struct {
@@ -21,6 +25,8 @@ type Render struct {
 	ServerName string
 	JetViews   *jet.Set
 	Session    *scs.SessionManager
+	Logger     *slog.Logger
+	Tracer     trace.Tracer
 }
 
 type TemplateData struct {
@@ -50,7 +56,10 @@ func (b *Render) defaultData(td *TemplateData, r *http.Request) *TemplateData {
 	return td
 }
 
-func (b *Render) Page(w http.ResponseWriter, r *http.Request, view string, variables, data interface{}) error {
+func (b *Render) Page(w http.ResponseWriter, r *http.Request, view string, variables, data interface{}) (err error) {
+	r, span := b.startSpan(r, "render.page", view)
+	defer func() { endSpan(span, err) }()
+
 	switch strings.ToLower(b.Renderer) {
 	case "go":
 		return b.GoPage(w, r, view, data)
@@ -63,7 +72,10 @@ func (b *Render) Page(w http.ResponseWriter, r *http.Request, view string, varia
 }
 
 // GoPage renders a standard Go template
-func (b *Render) GoPage(w http.ResponseWriter, r *http.Request, view string, data interface{}) error {
+func (b *Render) GoPage(w http.ResponseWriter, r *http.Request, view string, data interface{}) (err error) {
+	_, span := b.startSpan(r, "render.go", view)
+	defer func() { endSpan(span, err) }()
+
 	tmpl, err := template.ParseFiles(fmt.Sprintf("%s/views/%s.page.tmpl", b.RootPath, view))
 	if err != nil {
 		return err
@@ -83,7 +95,10 @@ func (b *Render) GoPage(w http.ResponseWriter, r *http.Request, view string, dat
 }
 
 // JetPage renders a template using the Jet templating engine
-func (b *Render) JetPage(w http.ResponseWriter, r *http.Request, templateName string, variables, data interface{}) error {
+func (b *Render) JetPage(w http.ResponseWriter, r *http.Request, templateName string, variables, data interface{}) (err error) {
+	r, span := b.startSpan(r, "render.jet", templateName)
+	defer func() { endSpan(span, err) }()
+
 	var vars jet.VarMap
 
 	if variables == nil {
@@ -101,13 +116,45 @@ func (b *Render) JetPage(w http.ResponseWriter, r *http.Request, templateName st
 
 	t, err := b.JetViews.GetTemplate(fmt.Sprintf("%s.jet", templateName))
 	if err != nil {
-		log.Println(err)
+		b.logger().ErrorContext(r.Context(), "could not load template", "template", templateName, "error", err)
 		return err
 	}
 
 	if err = t.Execute(w, vars, td); err != nil {
-		log.Println(err)
+		b.logger().ErrorContext(r.Context(), "could not render template", "template", templateName, "error", err)
 		return err
 	}
 	return nil
 }
+
+// startSpan starts a span for rendering view, as a child of the span in r, and
+// returns r with the new span in its context. Without a Tracer, it does nothing.
+func (b *Render) startSpan(r *http.Request, name, view string) (*http.Request, trace.Span) {
+	if b.Tracer == nil {
+		// a span that records nothing; never the request's own span
+		return r, trace.SpanFromContext(context.Background())
+	}
+
+	ctx, span := b.Tracer.Start(r.Context(), name, trace.WithAttributes(attribute.String("render.view", view)))
+	return r.WithContext(ctx), span
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span trace.Span, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// logger returns the Logger, or the default logger if none is set
+func (b *Render) logger() *slog.Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	return slog.Default()
+}
@@ -1,9 +1,13 @@
 package render
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var pageData = []struct {
@@ -77,3 +81,48 @@ func TestRender_JetPage(t *testing.T) {
 	}
 	
 }
+
+func TestRender_Page_traced(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "request")
+
+	renderer := Render{Renderer: "go", RootPath: "./testdata", Tracer: tracer}
+	r := httptest.NewRequest("GET", "/url", nil).WithContext(ctx)
+	if err := renderer.Page(httptest.NewRecorder(), r, "home", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := renderer.Page(httptest.NewRecorder(), r, "no-file", nil, nil); err == nil {
+		t.Fatal("rendered a template that does not exist")
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	want := []struct {
+		name   string
+		parent int
+		error  bool
+	}{
+		// spans end in reverse, the innermost first
+		{"render.go", 1, false},
+		{"render.page", 4, false},
+		{"render.go", 3, true},
+		{"render.page", 4, true},
+		{"request", -1, false},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(spans), len(want))
+	}
+	for i, w := range want {
+		span := spans[i]
+		if span.Name != w.name {
+			t.Errorf("span %d is %s, want %s", i, span.Name, w.name)
+		}
+		if w.parent >= 0 && span.Parent.SpanID() != spans[w.parent].SpanContext.SpanID() {
+			t.Errorf("span %d, %s, is not a child of %s", i, span.Name, spans[w.parent].Name)
+		}
+		if got := span.Status.Code.String() == "Error"; got != w.error {
+			t.Errorf("span %d, %s, recorded an error: %v, want %v", i, span.Name, got, w.error)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package boilme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+)
+
+const (
+	// defaultResponseTTL is how long a ResponseCache keeps responses when its TTL
+	// is 0
+	defaultResponseTTL = 5 * time.Minute
+
+	// defaultResponseMaxSize is the largest body a ResponseCache keeps when its
+	// MaxSize is 0
+	defaultResponseMaxSize = 1 << 20
+)
+
+// ResponseCache configures the CacheResponses middleware. Responses are kept for
+// TTL (5 minutes when 0), a variant for each value of the request headers in Vary,
+// up to MaxSize bytes of body (1MB when 0). Tags, when set, returns the tags of the response to r, which
+// PurgeResponses or cache.FlushTags forget it by.
+type ResponseCache struct {
+	TTL     time.Duration
+	Vary    []string
+	Tags    func(r *http.Request) []string
+	MaxSize int
+}
+
+// cachedResponse is a response kept in the cache
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	Stored time.Time
+}
+
+// CacheResponses returns middleware that keeps the responses to anonymous GET
+// requests in the application's cache, keyed by the method, host, path, query and
+// Vary headers of the request, and answers the same requests from it for rc.TTL.
+// Cached responses get an ETag and a Last-Modified header, unless they have one,
+// and conditional requests are answered with 304 Not Modified. Responses say
+// whether they came from the cache in an X-Cache header, HIT or MISS.
+//
+//	r.With(app.CacheResponses(boilme.ResponseCache{TTL: 10 * time.Minute})).Get("/about", h.About)
+//
+// Requests from a logged in user, with a flash or error message waiting in their
+// session, or with an Authorization header, are not cached; the session must have
+// been loaded. Only 200 responses that set no cookie, change no session and are
+// not Cache-Control private or no-store are kept. Pages with forms carry the CSRF
+// token of a visitor, so they should not be cached.
+//
+// Other requests are passed on, and, when they succeed, the responses tagged with
+// rc.Tags are purged, so that a route that changes what a page shows can share its
+// ResponseCache. When the cache fails, responses are rendered, and the error is
+// logged.
+func (b *Boilme) CacheResponses(rc ResponseCache) func(http.Handler) http.Handler {
+	if rc.TTL <= 0 {
+		rc.TTL = defaultResponseTTL
+	}
+	if rc.MaxSize <= 0 {
+		rc.MaxSize = defaultResponseMaxSize
+	}
+	if b.Cache == nil {
+		b.Logger.Warn("there is no cache, so responses are not cached")
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				b.purgeAfter(w, r, rc, next)
+				return
+			}
+			if r.Header.Get("Authorization") != "" || !b.anonymous(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(rc.Vary) > 0 {
+				w.Header().Add("Vary", strings.Join(rc.Vary, ", "))
+			}
+			key := responseKey(r, rc.Vary)
+			cached, err := cache.Get[cachedResponse](b.Cache, key)
+			switch {
+			case err == nil:
+				w.Header().Set("X-Cache", "HIT")
+				w.Header().Set("Age", strconv.Itoa(int(time.Since(cached.Stored).Seconds())))
+				cached.write(w, r)
+				return
+			case !errors.Is(err, cache.ErrMiss):
+				b.Logger.Error("could not read a cached response", "path", r.URL.Path, "error", err)
+			}
+
+			before := w.Header().Clone()
+			rec := &responseRecorder{ResponseWriter: w, limit: rc.MaxSize}
+			next.ServeHTTP(rec, r)
+			if rec.passed {
+				return
+			}
+
+			res := rec.response(before)
+			w.Header().Set("X-Cache", "MISS")
+			if r.Method == http.MethodGet && b.storable(r, w.Header()) {
+				var tags []string
+				if rc.Tags != nil {
+					tags = rc.Tags(r)
+				}
+				if err := b.storeResponse(key, res, rc.TTL, tags); err != nil {
+					b.Logger.Error("could not cache a response", "path", r.URL.Path, "error", err)
+				}
+			}
+			res.write(w, r)
+		})
+	}
+}
+
+// PurgeResponses forgets the cached responses tagged with any of tags, along with
+// any other value of the cache tagged with them
+func (b *Boilme) PurgeResponses(tags ...string) error {
+	if b.Cache == nil {
+		return nil
+	}
+	return cache.FlushTags(b.Cache, tags...)
+}
+
+// purgeAfter serves r, a request that may change what cached responses show, and
+// purges the responses tagged with rc.Tags when it succeeds
+func (b *Boilme) purgeAfter(w http.ResponseWriter, r *http.Request, rc ResponseCache, next http.Handler) {
+	if rc.Tags == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w}
+	next.ServeHTTP(sw, r)
+	if sw.status >= http.StatusBadRequest {
+		return
+	}
+	if err := b.PurgeResponses(rc.Tags(r)...); err != nil {
+		b.Logger.Error("could not purge cached responses", "path", r.URL.Path, "error", err)
+	}
+}
+
+// anonymous reports whether the session of r has no user, nor messages waiting to
+// be shown
+func (b *Boilme) anonymous(r *http.Request) bool {
+	if b.Session == nil {
+		return true
+	}
+	ctx := r.Context()
+	return !b.Session.Exists(ctx, "userID") && !b.Session.Exists(ctx, "flash") && !b.Session.Exists(ctx, "error")
+}
+
+// storable reports whether the response to r, with header, can be served to
+// others. Middleware in front, such as NoSurf, may have set its cookies already.
+func (b *Boilme) storable(r *http.Request, header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	if cc := strings.ToLower(header.Get("Cache-Control")); strings.Contains(cc, "private") || strings.Contains(cc, "no-store") {
+		return false
+	}
+	return b.Session == nil || b.Session.Status(r.Context()) == scs.Unmodified
+}
+
+// storeResponse keeps res under key for ttl, tagged with tags when the cache
+// supports it
+func (b *Boilme) storeResponse(key string, res *cachedResponse, ttl time.Duration, tags []string) error {
+	if len(tags) > 0 {
+		err := cache.SetTagged(b.Cache, key, *res, ttl, tags...)
+		if !errors.Is(err, cache.ErrTagsUnsupported) {
+			return err
+		}
+	}
+	return b.Cache.Set(key, *res, ttl)
+}
+
+// responseKey returns the cache key of the response to r: HEAD requests share the
+// responses of GET. Hosts are told apart, for applications that serve several.
+func responseKey(r *http.Request, vary []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", strings.ToLower(r.Host), r.URL.Path, r.URL.Query().Encode())
+	for _, name := range vary {
+		fmt.Fprintf(h, "%s: %s\n", http.CanonicalHeaderKey(name), strings.Join(r.Header.Values(name), ", "))
+	}
+	return "response:GET:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// write sends res in answer to r, or 304 Not Modified when r already has it
+func (res *cachedResponse) write(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range res.Header {
+		h[k] = slices.Clone(v)
+	}
+
+	if notModified(r, res.Header) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(res.Body)))
+	w.WriteHeader(res.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(res.Body)
+	}
+}
+
+// notModified reports whether the conditional request r matches a response with
+// header. If-None-Match wins over If-Modified-Since.
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// responseRecorder holds a response back, so that it can be cached and given an
+// ETag, unless it is not a 200 or its body outgrows limit: then it is passed on as
+// it is written.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	limit  int
+	passed bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = status
+	if status != http.StatusOK {
+		rec.pass()
+	}
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.passed && rec.body.Len()+len(p) > rec.limit {
+		rec.pass()
+	}
+	if rec.passed {
+		return rec.ResponseWriter.Write(p)
+	}
+	return rec.body.Write(p)
+}
+
+// Flush passes the response on, as a streamed response cannot be held back
+func (rec *responseRecorder) Flush() {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.passed {
+		rec.pass()
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// pass writes what was held back, and lets the rest of the response through
+func (rec *responseRecorder) pass() {
+	rec.passed = true
+	rec.ResponseWriter.WriteHeader(rec.status)
+	if rec.body.Len() > 0 {
+		_, _ = rec.ResponseWriter.Write(rec.body.Bytes())
+		rec.body.Reset()
+	}
+}
+
+// response returns the response held back, with the headers the handler set since
+// before, and an ETag and a Last-Modified header unless it set them
+func (rec *responseRecorder) response(before http.Header) *cachedResponse {
+	res := &cachedResponse{
+		Status: rec.status,
+		Header: http.Header{},
+		Body:   rec.body.Bytes(),
+		Stored: time.Now(),
+	}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	for k, v := range rec.Header() {
+		if !slices.Equal(before[k], v) {
+			res.Header[k] = slices.Clone(v)
+		}
+	}
+
+	if res.Header.Get("ETag") == "" {
+		sum := sha256.Sum256(res.Body)
+		res.Header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	if res.Header.Get("Last-Modified") == "" {
+		res.Header.Set("Last-Modified", res.Stored.UTC().Format(http.TimeFormat))
+	}
+	return res
+}
+
+// statusWriter records the status of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
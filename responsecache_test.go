@@ -0,0 +1,267 @@
+package boilme
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+)
+
+// newCachedPage returns a handler counting how many times it renders, behind the
+// response cache rc of b, with a memory cache unless b has one
+func newCachedPage(b *Boilme, rc ResponseCache) (http.Handler, *int) {
+	if b.Cache == nil {
+		b.Cache = &cache.MemoryCache{}
+	}
+	b.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	renders := 0
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			renders++
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<p>%s %s</p>", r.URL.Path, r.Header.Get("Accept-Language"))
+	})
+	return b.CacheResponses(rc)(page), &renders
+}
+
+// get sends a GET request for target to h, with header, and returns the response
+func get(h http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCacheResponses(t *testing.T) {
+	h, renders := newCachedPage(&Boilme{}, ResponseCache{TTL: time.Minute})
+
+	first := get(h, "/about?b=2&a=1", nil)
+	second := get(h, "/about?a=1&b=2", nil)
+	if *renders != 1 {
+		t.Fatalf("rendered %d times, want 1", *renders)
+	}
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("got X-Cache %q then %q, want MISS then HIT", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if second.Code != http.StatusOK || second.Body.String() != first.Body.String() {
+		t.Errorf("got %d %q from the cache, want 200 %q", second.Code, second.Body, first.Body)
+	}
+	if got := second.Header().Get("Content-Type"); got != "text/html" {
+		t.Errorf("got Content-Type %q from the cache, want text/html", got)
+	}
+	if etag := first.Header().Get("ETag"); etag == "" || second.Header().Get("ETag") != etag {
+		t.Errorf("got ETags %q and %q, want the same", etag, second.Header().Get("ETag"))
+	}
+
+	get(h, "/about?a=2", nil)
+	if *renders != 2 {
+		t.Errorf("rendered %d times after another query, want 2", *renders)
+	}
+
+	head := httptest.NewRecorder()
+	h.ServeHTTP(head, httptest.NewRequest(http.MethodHead, "/about?a=1&b=2", nil))
+	if head.Header().Get("X-Cache") != "HIT" || head.Body.Len() != 0 {
+		t.Errorf("got X-Cache %q and %d bytes for HEAD, want a HIT without a body", head.Header().Get("X-Cache"), head.Body.Len())
+	}
+}
+
+func TestCacheResponses_conditional(t *testing.T) {
+	h, _ := newCachedPage(&Boilme{}, ResponseCache{TTL: time.Minute})
+	first := get(h, "/about", nil)
+	etag := first.Header().Get("ETag")
+	modified := first.Header().Get("Last-Modified")
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"weak etag in a list", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		{"any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": modified}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}, http.StatusOK},
+		{"etag wins", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(h, "/about", tt.header)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("got a body with 304: %q", w.Body)
+			}
+		})
+	}
+
+	// the response that is cached answers conditional requests too
+	h, _ = newCachedPage(&Boilme{}, ResponseCache{TTL: time.Minute})
+	if w := get(h, "/about", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("got %d on a miss, want 304", w.Code)
+	}
+}
+
+func TestCacheResponses_vary(t *testing.T) {
+	h, renders := newCachedPage(&Boilme{}, ResponseCache{TTL: time.Minute, Vary: []string{"Accept-Language"}})
+
+	en := get(h, "/", map[string]string{"Accept-Language": "en"})
+	da := get(h, "/", map[string]string{"Accept-Language": "da"})
+	get(h, "/", map[string]string{"Accept-Language": "en"})
+	if *renders != 2 {
+		t.Fatalf("rendered %d times for two languages, want 2", *renders)
+	}
+	if en.Body.String() == da.Body.String() {
+		t.Error("languages got the same page")
+	}
+	if got := da.Header().Get("Vary"); got != "Accept-Language" {
+		t.Errorf("got Vary %q, want Accept-Language", got)
+	}
+}
+
+func TestCacheResponses_hosts(t *testing.T) {
+	h, renders := newCachedPage(&Boilme{}, ResponseCache{TTL: time.Minute})
+
+	for _, host := range []string{"a.example.com", "b.example.com", "A.example.com"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if *renders != 2 {
+		t.Errorf("rendered %d times for two hosts, want 2", *renders)
+	}
+}
+
+func TestCacheResponses_ttl(t *testing.T) {
+	h, renders := newCachedPage(&Boilme{}, ResponseCache{TTL: 50 * time.Millisecond})
+
+	get(h, "/", nil)
+	time.Sleep(100 * time.Millisecond)
+	get(h, "/", nil)
+	if *renders != 2 {
+		t.Errorf("rendered %d times, want the expired page rendered again", *renders)
+	}
+}
+
+func TestCacheResponses_defaultTTL(t *testing.T) {
+	b := &Boilme{}
+	h, _ := newCachedPage(b, ResponseCache{})
+
+	get(h, "/", nil)
+	key := responseKey(httptest.NewRequest(http.MethodGet, "/", nil), nil)
+	if found, _ := b.Cache.Has(key); !found {
+		t.Error("a response cache without a TTL kept nothing")
+	}
+}
+
+func TestCacheResponses_skipped(t *testing.T) {
+	b := &Boilme{Session: scs.New()}
+	cached, renders := newCachedPage(b, ResponseCache{TTL: time.Minute})
+	h := b.Session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			b.Session.Put(r.Context(), "userID", 42)
+		case "/cookie":
+			http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1"})
+			cached.ServeHTTP(w, r)
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+			cached.ServeHTTP(w, r)
+		case "/visit":
+			b.Session.Put(r.Context(), "visited", true)
+			cached.ServeHTTP(w, r)
+		default:
+			cached.ServeHTTP(w, r)
+		}
+	}))
+
+	login := get(h, "/login", nil)
+	var cookie string
+	for _, c := range login.Result().Cookies() {
+		cookie = c.String()
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+	}{
+		{"logged in", "/", map[string]string{"Cookie": cookie}},
+		{"authorization", "/", map[string]string{"Authorization": "Bearer token"}},
+		{"set cookie", "/cookie", nil},
+		{"private", "/private", nil},
+		{"session changed", "/visit", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := *renders
+			get(h, tt.target, tt.header)
+			if w := get(h, tt.target, tt.header); w.Header().Get("X-Cache") == "HIT" {
+				t.Error("got a cached response")
+			}
+			if *renders != before+2 {
+				t.Errorf("rendered %d times, want 2", *renders-before)
+			}
+		})
+	}
+
+	// a page is cached for the guests, but not served to the user
+	get(h, "/", nil)
+	if w := get(h, "/", map[string]string{"Cookie": cookie}); w.Header().Get("X-Cache") == "HIT" || !strings.Contains(w.Body.String(), "/") {
+		t.Error("a user got the page cached for guests")
+	}
+}
+
+func TestCacheResponses_large(t *testing.T) {
+	b := &Boilme{Cache: &cache.MemoryCache{}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	body := strings.Repeat("x", 100)
+	h := b.CacheResponses(ResponseCache{TTL: time.Minute, MaxSize: 64})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body[:50])
+		_, _ = io.WriteString(w, body[50:])
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := get(h, "/", nil)
+		if w.Body.String() != body || w.Header().Get("X-Cache") != "" {
+			t.Fatalf("got %d bytes and X-Cache %q, want the whole body passed through", w.Body.Len(), w.Header().Get("X-Cache"))
+		}
+	}
+}
+
+func TestCacheResponses_purge(t *testing.T) {
+	b := &Boilme{}
+	rc := ResponseCache{
+		TTL:  time.Minute,
+		Tags: func(r *http.Request) []string { return []string{"posts"} },
+	}
+	h, renders := newCachedPage(b, rc)
+
+	get(h, "/posts", nil)
+	get(h, "/posts", nil)
+	if err := b.PurgeResponses("posts"); err != nil {
+		t.Fatal(err)
+	}
+	get(h, "/posts", nil)
+	if *renders != 2 {
+		t.Fatalf("rendered %d times, want the page rendered again after a purge", *renders)
+	}
+
+	// a successful POST through the same cache purges it
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/posts", nil))
+	get(h, "/posts", nil)
+	if *renders != 3 {
+		t.Errorf("rendered %d times, want the page rendered again after a POST", *renders)
+	}
+}
@@ -11,14 +11,26 @@ func (b *Boilme) routes() http.Handler {
 	mux := chi.NewRouter()
 	mux.Use(middleware.RequestID)
 	mux.Use(middleware.RealIP)
+	if b.tracing() {
+		mux.Use(b.TraceRequests)
+	}
 	if b.Debug {
-		mux.Use(middleware.Logger)
+		mux.Use(b.RequestLogger)
 	}
 	mux.Use(middleware.Recoverer)
+	mux.Use(b.HealthEndpoints)
+	if b.metrics != nil {
+		mux.Use(b.metrics.endpoint(b.Config.Metrics.Path))
+		mux.Use(b.metrics.instrument)
+	}
 	mux.Use(b.SessionLoad)
 	mux.Use(b.NoSurf)
 	mux.Use(b.CheckForMaintenanceMode)
 
+	if b.Debug {
+		mux.Mount(MailPreviewPath, b.MailPreviewRoutes())
+	}
+
 	return mux
 }
 
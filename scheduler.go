@@ -0,0 +1,84 @@
+package boilme
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/robfig/cron/v3"
+)
+
+// lockedJob is a scheduled job that runs only while it holds a lock
+type lockedJob struct {
+	job    cron.Job
+	name   string
+	ttl    time.Duration
+	locker cache.Locker
+	logger *slog.Logger
+}
+
+// OnLockHolder returns a cron.JobWrapper that runs a job only on the replica that
+// acquires the lock name in the cache, so that a job every replica schedules runs
+// once:
+//
+//	app.Scheduler.AddJob("@hourly", app.OnLockHolder("reports", 5*time.Minute)(cron.FuncJob(sendReports)))
+//
+// The lock is renewed while the job runs, and then left to expire, so that the
+// replicas whose clocks are behind do not run the job again: ttl must be longer
+// than the difference between the clocks of the replicas, and shorter than the
+// time between two runs. A replica that cannot reach the cache skips the job. When
+// the cache holds no locks, as with no cache at all, every replica runs it.
+func (b *Boilme) OnLockHolder(name string, ttl time.Duration) cron.JobWrapper {
+	return func(job cron.Job) cron.Job {
+		var locker cache.Locker
+		if b.Cache != nil {
+			if _, ok := cache.Unwrap(b.Cache).(cache.Locker); ok {
+				locker, _ = b.Cache.(cache.Locker)
+			}
+		}
+		if locker == nil {
+			b.Logger.Warn("the cache holds no locks, so the job runs on every replica", "lock", name)
+			return job
+		}
+
+		return &lockedJob{
+			job:    job,
+			name:   name,
+			ttl:    ttl,
+			locker: locker,
+			logger: b.Logger,
+		}
+	}
+}
+
+func (j *lockedJob) Run() {
+	token, err := j.locker.Lock("scheduler:"+j.name, j.ttl)
+	if errors.Is(err, cache.ErrLocked) {
+		return
+	}
+	if err != nil {
+		j.logger.Error("could not lock a scheduled job, so it was skipped", "lock", j.name, "error", err)
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(j.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := j.locker.Renew("scheduler:"+j.name, token, j.ttl); err != nil {
+					j.logger.Error("could not renew the lock of a running job", "lock", j.name, "error", err)
+					return
+				}
+			}
+		}
+	}()
+
+	j.job.Run()
+}
@@ -0,0 +1,82 @@
+package boilme
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/robfig/cron/v3"
+)
+
+func TestOnLockHolder(t *testing.T) {
+	shared := &cache.MemoryCache{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var runs atomic.Int32
+	job := cron.FuncJob(func() {
+		runs.Add(1)
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	// replicas sharing a cache run the job once
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		b := &Boilme{Cache: shared, Logger: logger}
+		locked := b.OnLockHolder("report", time.Minute)(job)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locked.Run()
+		}()
+	}
+	wg.Wait()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("the job ran %d times, want once", n)
+	}
+
+	// the lock is left to expire, so a late replica does not run it again
+	(&Boilme{Cache: shared, Logger: logger}).OnLockHolder("report", time.Minute)(job).Run()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("a late replica ran the job")
+	}
+
+	// without locks, the job runs
+	(&Boilme{Logger: logger}).OnLockHolder("report", time.Minute)(job).Run()
+	if n := runs.Load(); n != 2 {
+		t.Errorf("the job did not run without a cache")
+	}
+}
+
+func TestOnLockHolder_renew(t *testing.T) {
+	shared := &cache.MemoryCache{}
+	b := &Boilme{Cache: shared, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	go b.OnLockHolder("long", 60*time.Millisecond)(cron.FuncJob(func() {
+		close(running)
+		<-release
+	})).Run()
+	<-running
+	defer close(release)
+
+	// the job outlives the ttl, but keeps the lock
+	time.Sleep(150 * time.Millisecond)
+	if _, err := shared.Lock("scheduler:long", time.Second); err == nil {
+		t.Error("the lock of a running job expired")
+	}
+}
+
+func sendReports() {}
+
+func TestJobName_locked(t *testing.T) {
+	b := &Boilme{Cache: &cache.MemoryCache{}}
+	job := b.OnLockHolder("report", time.Minute)(cron.FuncJob(sendReports))
+	if name := jobName(job); name != "github.com/bxtal-lsn/go-boilme.sendReports" {
+		t.Errorf("named %s", name)
+	}
+}
@@ -1,35 +1,241 @@
 package boilme
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
 )
 
+// ListenAndServe starts the web server, and blocks until the server fails or the
+// process receives SIGINT/SIGTERM. On a signal, the application is shut down
+// gracefully (see Shutdown) before ListenAndServe returns.
 func (b *Boilme) ListenAndServe() error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%s", os.Getenv("PORT")),
+		Addr:         fmt.Sprintf(":%s", b.Config.Port),
 		ErrorLog:     b.ErrorLog,
 		Handler:      b.Routes,
 		IdleTimeout:  30 * time.Second,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 600 * time.Second,
 	}
+	var redirectSrv *http.Server
+	if b.Config.TLS.enabled() {
+		httpHandler, err := b.configureTLS(srv)
+		if err != nil {
+			return err
+		}
+
+		if port := b.Config.TLS.redirectPort(); port != "" {
+			redirectSrv = &http.Server{
+				Addr:         fmt.Sprintf(":%s", port),
+				ErrorLog:     b.ErrorLog,
+				Handler:      httpHandler,
+				IdleTimeout:  30 * time.Second,
+				ReadTimeout:  30 * time.Second,
+				WriteTimeout: 30 * time.Second,
+			}
+		}
+	}
+
+	b.shutdownMu.Lock()
+	b.server = srv
+	b.redirectServer = redirectSrv
+	b.shutdownMu.Unlock()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := b.listenAdmin(); err != nil {
+		return err
+	}
+
+	b.Jobs.Start()
+
+	serverErr := make(chan error, 2)
+	go func() {
+		if srv.TLSConfig != nil {
+			b.Logger.Info("listening for https", "port", b.Config.Port)
+			serverErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		b.Logger.Info("listening for http", "port", b.Config.Port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	if redirectSrv != nil {
+		go func() {
+			b.Logger.Info("redirecting http to https", "port", b.Config.TLS.redirectPort())
+			serverErr <- redirectSrv.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serverErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			// Shutdown was called directly; wait for it to finish below
+			err = nil
+		}
+	case <-ctx.Done():
+		b.Logger.Info("shutdown signal received, draining connections")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), b.Config.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := b.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+
+	return err
+}
+
+// OnShutdown registers fn to be run when the application shuts down. Hooks run
+// after in-flight requests, scheduled jobs and queued mail have been drained, but
+// before the database, Redis and Badger connections are closed, so they may still
+// use them, and may still queue mail on Mail.Jobs. Hooks run in reverse order of
+// registration.
+func (b *Boilme) OnShutdown(fn func(ctx context.Context) error) {
+	b.shutdownMu.Lock()
+	defer b.shutdownMu.Unlock()
+	b.shutdownHooks = append(b.shutdownHooks, fn)
+}
+
+// ShuttingDown reports whether Shutdown has been started
+func (b *Boilme) ShuttingDown() bool {
+	return b.shuttingDown.Load()
+}
+
+// Shutdown gracefully stops the application: it stops accepting connections and
+// waits for in-flight requests, stops the admin server, stops the scheduler, moves
+// the mail queue to the outbox, stops the job workers and waits for running jobs and
+// mail being sent, runs the OnShutdown hooks and finally closes the database, Redis
+// and Badger connections. ctx bounds how long we wait for each of these steps. It
+// is safe to call Shutdown more than once; only the first call does any work.
+func (b *Boilme) Shutdown(ctx context.Context) error {
+	b.shutdownOnce.Do(func() {
+		b.shutdownErr = b.shutdown(ctx)
+	})
+	return b.shutdownErr
+}
+
+func (b *Boilme) shutdown(ctx context.Context) error {
+	b.shuttingDown.Store(true)
+	var errs []error
+
+	b.shutdownMu.Lock()
+	srv, redirectSrv, adminServers := b.server, b.redirectServer, b.adminServers
+	b.shutdownMu.Unlock()
+
+	// stop accepting new connections, and drain in-flight requests
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("http server: %w", err))
+		}
+	}
+
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("http redirect server: %w", err))
+		}
+	}
+
+	// stop the admin server
+	for _, adminSrv := range adminServers {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("admin server: %w", err))
+		}
+	}
+
+	// stop the scheduler, and wait for running jobs to complete
+	if b.Scheduler != nil {
+		select {
+		case <-b.Scheduler.Stop().Done():
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("scheduler: %w", ctx.Err()))
+		}
+	}
+
+	// move whatever is left in the mail queue to the outbox. Jobs is left open, so
+	// hooks and late handlers can still queue mail, which the outbox keeps for the
+	// next start when it is stored in redis or the database.
+	if b.Mail.Jobs != nil {
+		if err := b.Mail.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("mail queue: %w", err))
+		}
+	}
+
+	// stop the job workers, and wait for running jobs, and mail being sent, to complete
+	if b.Jobs != nil {
+		if err := b.Jobs.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("jobs: %w", err))
+		}
+	}
+
+	// run application hooks, most recently registered first
+	b.shutdownMu.Lock()
+	hooks := b.shutdownHooks
+	b.shutdownMu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// close the connections kept by the mail transport
+	if c, ok := b.Mail.Transport.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("mail transport: %w", err))
+		}
+	}
+
+	// close stores last, since everything above may still need them
+	if c, ok := cache.Unwrap(b.Cache).(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("cache: %w", err))
+		}
+	}
 
 	if b.DB.Pool != nil {
-		defer b.DB.Pool.Close()
+		if err := b.DB.Pool.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("database: %w", err))
+		}
+	}
+
+	if b.redisPool != nil {
+		if err := b.redisPool.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("redis: %w", err))
+		}
+	}
+
+	if b.badgerConn != nil {
+		if err := b.badgerConn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("badger: %w", err))
+		}
 	}
 
-	if redisPool != nil {
-		defer redisPool.Close()
+	// flush spans still waiting to be exported
+	if b.ownedTracerProvider != nil {
+		if err := b.ownedTracerProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tracing: %w", err))
+		}
 	}
 
-	if badgerConn != nil {
-		defer badgerConn.Close()
+	// the log file goes last, so everything above can still be logged
+	if b.logFile != nil {
+		if err := b.logFile.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("log file: %w", err))
+		}
 	}
 
-	go b.listenRPC()
-	b.InfoLog.Printf("Listening on port %s", os.Getenv("PORT"))
-	return srv.ListenAndServe()
+	return errors.Join(errs...)
 }
@@ -0,0 +1,98 @@
+package boilme
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/mailer"
+)
+
+func TestBoilme_Shutdown_hooks(t *testing.T) {
+	b := &Boilme{}
+
+	var order []string
+	b.OnShutdown(func(ctx context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	b.OnShutdown(func(ctx context.Context) error {
+		order = append(order, "second")
+		return errors.New("boom")
+	})
+
+	if b.ShuttingDown() {
+		t.Error("shutting down before Shutdown was called")
+	}
+
+	err := b.Shutdown(context.Background())
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("expected the error of the hook, got %v", err)
+	}
+	if len(order) != 2 || order[0] != "second" || order[1] != "first" {
+		t.Errorf("hooks ran in the wrong order: %v", order)
+	}
+	if !b.ShuttingDown() {
+		t.Error("not shutting down after Shutdown was called")
+	}
+
+	// only the first call does any work, and later ones return the same error
+	err2 := b.Shutdown(context.Background())
+	if err2 != err {
+		t.Errorf("second call returned %v, want %v", err2, err)
+	}
+	if len(order) != 2 {
+		t.Errorf("hooks ran again: %v", order)
+	}
+}
+
+func TestBoilme_Shutdown_hooksCanQueueMail(t *testing.T) {
+	results := make(chan mailer.Result, 1)
+	b := &Boilme{Mail: mailer.Mail{
+		Jobs:     make(chan mailer.Message, 1),
+		OnResult: func(res mailer.Result) { results <- res },
+	}}
+	go b.Mail.ListenForMail()
+
+	b.OnShutdown(func(ctx context.Context) error {
+		// sending on Jobs must not panic once the mail queue has been drained
+		b.Mail.Jobs <- mailer.Message{}
+		return nil
+	})
+
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-results:
+		if !errors.Is(res.Error, mailer.ErrInvalidMessage) {
+			t.Errorf("expected the message to be handled, got %v", res.Error)
+		}
+	case <-time.After(time.Second):
+		t.Error("mail queued by a hook was not handled")
+	}
+}
+
+func TestBoilme_Shutdown_expiredContext(t *testing.T) {
+	// nothing reads Jobs, so draining the mail queue cannot finish
+	b := &Boilme{Mail: mailer.Mail{Jobs: make(chan mailer.Message)}}
+
+	hookRan := false
+	b.OnShutdown(func(ctx context.Context) error {
+		hookRan = true
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := b.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if !hookRan {
+		t.Error("hooks did not run after a step timed out")
+	}
+}
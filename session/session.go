@@ -14,6 +14,8 @@ import (
 	"github.com/gomodule/redigo/redis"
 )
 
+// Session configures the session manager. SessionType is cookie, redis, or the
+// normalized dialect of DBPool, mysql or postgres.
 type Session struct {
 	CookieLifetime string
 	CookiePersist  string
@@ -57,9 +59,9 @@ func (b *Session) InitSession() *scs.SessionManager {
 	switch strings.ToLower(b.SessionType) {
 	case "redis":
 		session.Store = redisstore.New(b.RedisPool)
-	case "mysql", "mariadb":
+	case "mysql":
 		session.Store = mysqlstore.New(b.DBPool)
-	case "postgres", "postgresql":
+	case "postgres":
 		session.Store = postgresstore.New(b.DBPool)
 	default:
 		// cookie
@@ -0,0 +1,245 @@
+package boilme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS sets up srv to serve https, either from the certificate files in
+// TLS_CERT/TLS_KEY or with certificates obtained automatically over ACME. It
+// returns the handler that should answer on the plain http port, if one is wanted.
+func (b *Boilme) configureTLS(srv *http.Server) (http.Handler, error) {
+	redirect := http.HandlerFunc(b.redirectToHTTPS)
+
+	if b.Config.TLS.ACME {
+		manager, err := b.autocertManager()
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+		// http-01 challenges must be answered on the plain http port
+		return manager.HTTPHandler(redirect), nil
+	}
+
+	reloader, err := newCertReloader(b.Config.TLS.CertFile, b.Config.TLS.KeyFile, b.ErrorLog.Printf)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	return redirect, nil
+}
+
+// autocertManager builds an ACME certificate manager from the TLS_ACME_* settings
+func (b *Boilme) autocertManager() (*autocert.Manager, error) {
+	if len(b.Config.TLS.ACMEDomains) == 0 {
+		return nil, errors.New("TLS_ACME requires at least one domain in TLS_ACME_DOMAINS")
+	}
+
+	var certCache autocert.Cache
+	switch b.Config.TLS.ACMECache {
+	case "cache":
+		if b.Cache == nil {
+			return nil, errors.New("TLS_ACME_CACHE=cache, but no CACHE is configured")
+		}
+		certCache = NewAutocertCache(b.Cache)
+	case "":
+		certCache = autocert.DirCache(b.RootPath + "/tmp/certs")
+	default:
+		certCache = autocert.DirCache(b.Config.TLS.ACMECache)
+	}
+
+	client := &acme.Client{
+		DirectoryURL: autocert.DefaultACMEDirectory,
+	}
+	if b.Config.TLS.ACMEDirectory != "" {
+		client.DirectoryURL = b.Config.TLS.ACMEDirectory
+	}
+
+	// a private ACME server (for example pebble, in testing) is usually served with
+	// a certificate from its own CA, which we must trust explicitly
+	if b.Config.TLS.ACMECARoot != "" {
+		pem, err := os.ReadFile(b.Config.TLS.ACMECARoot)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", b.Config.TLS.ACMECARoot)
+		}
+		client.HTTPClient = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(b.Config.TLS.ACMEDomains...),
+		Cache:      certCache,
+		Email:      b.Config.TLS.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// redirectToHTTPS sends a permanent redirect to the https version of the requested url
+func (b *Boilme) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if b.Config.Port != "" && b.Config.Port != "443" {
+		host = net.JoinHostPort(host, b.Config.Port)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// certReloader serves a certificate loaded from disk, and reloads it when either the
+// certificate or the key file changes, so renewed certificates are picked up without
+// a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logf     func(format string, v ...interface{})
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// certCheckInterval is how often, at most, we look at the certificate files for changes
+const certCheckInterval = 5 * time.Second
+
+func newCertReloader(certFile, keyFile string, logf func(format string, v ...interface{})) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logf:     logf,
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate satisfies tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	cert, checkedAt := c.cert, c.checkedAt
+	c.mu.RUnlock()
+
+	if time.Since(checkedAt) < certCheckInterval {
+		return cert, nil
+	}
+
+	c.mu.Lock()
+	c.checkedAt = time.Now()
+	c.mu.Unlock()
+
+	if modTime, err := c.latestModTime(); err == nil && modTime.After(c.loadedModTime()) {
+		// on failure keep serving the old certificate; the files may be mid-write
+		if err := c.reload(); err != nil {
+			c.logf("could not reload tls certificate: %v", err)
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.checkedAt = time.Now()
+
+	return nil
+}
+
+func (c *certReloader) loadedModTime() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modTime
+}
+
+// latestModTime returns the most recent modification time of the cert and key files
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// autocertCache stores ACME account keys and certificates in a cache.Cache, so every
+// instance of an application can share them
+type autocertCache struct {
+	cache cache.Cache
+}
+
+// NewAutocertCache returns an autocert.Cache backed by c
+func NewAutocertCache(c cache.Cache) autocert.Cache {
+	return &autocertCache{cache: c}
+}
+
+func (a *autocertCache) key(name string) string {
+	return "autocert:" + name
+}
+
+func (a *autocertCache) Get(_ context.Context, name string) ([]byte, error) {
+	data, err := cache.Get[[]byte](a.cache, a.key(name))
+	if errors.Is(err, cache.ErrMiss) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (a *autocertCache) Put(_ context.Context, name string, data []byte) error {
+	return a.cache.Set(a.key(name), data, 0)
+}
+
+func (a *autocertCache) Delete(_ context.Context, name string) error {
+	return a.cache.Forget(a.key(name))
+}
@@ -0,0 +1,258 @@
+package boilme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// writeTestCert writes a self-signed certificate for commonName, and its key, to
+// certFile and keyFile
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first.test")
+
+	var logged []string
+	c, err := newCertReloader(certFile, keyFile, func(format string, v ...interface{}) {
+		logged = append(logged, format)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		want   string
+	}{
+		{"initial certificate", func() {}, "first.test"},
+		{"renewed certificate", func() {
+			writeTestCert(t, certFile, keyFile, "second.test")
+			later := time.Now().Add(time.Minute)
+			_ = os.Chtimes(certFile, later, later)
+		}, "second.test"},
+		{"broken files keep the old certificate", func() {
+			_ = os.WriteFile(certFile, []byte("not a certificate"), 0600)
+			later := time.Now().Add(2 * time.Minute)
+			_ = os.Chtimes(certFile, later, later)
+		}, "second.test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+
+			// pretend the files were last checked long ago
+			c.mu.Lock()
+			c.checkedAt = time.Time{}
+			c.mu.Unlock()
+
+			cert, err := c.GetCertificate(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := commonName(t, cert); got != tt.want {
+				t.Errorf("got certificate for %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if len(logged) != 1 {
+		t.Errorf("expected the failed reload to be logged once, got %v", logged)
+	}
+}
+
+func TestCertReloader_missingFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), t.Logf)
+	if err == nil {
+		t.Error("expected an error for missing certificate files")
+	}
+}
+
+func TestAutocertCache(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) }}
+	defer pool.Close()
+
+	c := NewAutocertCache(&cache.RedisCache{Conn: pool, Prefix: "test"})
+	ctx := context.Background()
+
+	if _, err := c.Get(ctx, "example.test"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+
+	if err := c.Put(ctx, "example.test", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Get(ctx, "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "certificate" {
+		t.Errorf("got %q", data)
+	}
+
+	if err := c.Delete(ctx, "example.test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "example.test"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Errorf("expected a cache miss after delete, got %v", err)
+	}
+}
+
+func TestBoilme_redirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   string
+		target string
+		want   string
+	}{
+		{"default port", "443", "http://example.com/users?id=1", "https://example.com/users?id=1"},
+		{"no port", "", "http://example.com/", "https://example.com/"},
+		{"custom port", "8443", "http://example.com:8080/login", "https://example.com:8443/login"},
+		{"ipv6 host", "8443", "http://[::1]:8080/", "https://[::1]:8443/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Boilme{}
+			b.Config.Port = tt.port
+
+			rr := httptest.NewRecorder()
+			b.redirectToHTTPS(rr, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rr.Code != http.StatusMovedPermanently {
+				t.Errorf("got status %d", rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tt.want {
+				t.Errorf("redirected to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_redirectPort(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+		want string
+	}{
+		{"certificate files", TLSConfig{CertFile: "c", KeyFile: "k"}, ""},
+		{"explicit port", TLSConfig{CertFile: "c", KeyFile: "k", RedirectPort: "8080"}, "8080"},
+		{"acme defaults to 80", TLSConfig{ACME: true}, "80"},
+		{"acme with explicit port", TLSConfig{ACME: true, RedirectPort: "8080"}, "8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.redirectPort(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBoilme_autocertManager_pebble obtains a certificate from a running pebble
+// server. Start pebble with PEBBLE_VA_ALWAYS_VALID=1, and set PEBBLE_DIRECTORY and
+// PEBBLE_CA_ROOT to run it, for example:
+//
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CA_ROOT=test/certs/pebble.minica.pem go test -run pebble
+func TestBoilme_autocertManager_pebble(t *testing.T) {
+	directory, caRoot := os.Getenv("PEBBLE_DIRECTORY"), os.Getenv("PEBBLE_CA_ROOT")
+	if directory == "" || caRoot == "" {
+		t.Skip("PEBBLE_DIRECTORY and PEBBLE_CA_ROOT are not set")
+	}
+
+	b := &Boilme{RootPath: t.TempDir()}
+	b.Config.TLS = TLSConfig{
+		ACME:          true,
+		ACMEDomains:   []string{"example.test"},
+		ACMEDirectory: directory,
+		ACMECARoot:    caRoot,
+		ACMECache:     t.TempDir(),
+	}
+
+	manager, err := b.autocertManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil || cert.Leaf.DNSNames[0] != "example.test" {
+		t.Errorf("unexpected certificate: %+v", cert.Leaf)
+	}
+
+	if _, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.test"}); err == nil {
+		t.Error("expected a certificate for a domain not in TLS_ACME_DOMAINS to be refused")
+	}
+}
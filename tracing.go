@@ -0,0 +1,230 @@
+package boilme
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bxtal-lsn/go-boilme/cache"
+	"github.com/bxtal-lsn/go-boilme/filesystems"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans Boilme records
+const tracerName = "github.com/bxtal-lsn/go-boilme"
+
+// setupTracing sets b.Tracer from the tracer provider given to WithTracerProvider,
+// or from one built from the TRACING_* settings. Without either, b.Tracer records
+// nothing.
+func (b *Boilme) setupTracing(tp trace.TracerProvider) error {
+	b.propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	if tp == nil && b.Config.Tracing.Enabled {
+		provider, err := b.newTracerProvider()
+		if err != nil {
+			return err
+		}
+		b.ownedTracerProvider = provider
+		tp = provider
+
+		// let other instrumented libraries in the application join our traces
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(b.propagator)
+	}
+
+	if tp == nil {
+		b.Tracer = noop.NewTracerProvider().Tracer(tracerName)
+		return nil
+	}
+
+	b.tracerProvider = tp
+	b.Tracer = tp.Tracer(tracerName, trace.WithInstrumentationVersion(version))
+	return nil
+}
+
+// newTracerProvider builds a tracer provider that exports spans as TRACING_EXPORTER
+// says. Sampling follows the standard OTEL_TRACES_SAMPLER variables, and the otlp
+// exporter is configured with the standard OTEL_EXPORTER_OTLP_* variables.
+func (b *Boilme) newTracerProvider() (*sdktrace.TracerProvider, error) {
+	serviceName := b.Config.Tracing.ServiceName
+	if serviceName == "" {
+		serviceName = b.Config.AppName
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.DeploymentEnvironment(b.Config.Environment),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var processor sdktrace.SpanProcessor
+	switch b.Config.Tracing.Exporter {
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	case "otlp", "":
+		exporter, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q", b.Config.Tracing.Exporter)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSpanProcessor(processor),
+	), nil
+}
+
+// tracing reports whether spans are being recorded
+func (b *Boilme) tracing() bool {
+	return b.tracerProvider != nil
+}
+
+// TraceRequests is middleware that continues the trace in the W3C traceparent
+// header of each request, or starts a new one, and records a span for the request
+// named by its chi route pattern
+func (b *Boilme) TraceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := b.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := b.Tracer.Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(r.RemoteAddr),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// TracingTransport returns an http.RoundTripper that records a span for every
+// outgoing request, and passes the trace on to the server in the traceparent
+// header. base is used to make the requests; nil means http.DefaultTransport.
+func (b *Boilme) TracingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{base: base, b: b}
+}
+
+type tracingTransport struct {
+	base http.RoundTripper
+	b    *Boilme
+}
+
+func (t *tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := t.b.Tracer.Start(r.Context(), "HTTP "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLFull(r.URL.String()),
+			semconv.ServerAddress(r.URL.Hostname()),
+		),
+	)
+	defer span.End()
+
+	// RoundTrippers must not modify the request they are given
+	r = r.Clone(ctx)
+	t.b.propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// CacheFor returns the application's cache, with its spans recorded as children of
+// the span in ctx. Use it in handlers, as app.CacheFor(r.Context()).Get(key). When
+// tracing is off, it returns app.Cache.
+func (b *Boilme) CacheFor(ctx context.Context) cache.Cache {
+	if t, ok := b.Cache.(*cache.TracedCache); ok {
+		return t.WithContext(ctx)
+	}
+	return b.Cache
+}
+
+// TraceFS returns fs with a span recorded, as a child of the span in ctx, for every
+// operation. When tracing is off, it returns fs unchanged.
+func (b *Boilme) TraceFS(ctx context.Context, fs filesystems.FS) filesystems.FS {
+	if !b.tracing() || fs == nil {
+		return fs
+	}
+	return filesystems.NewTracedFS(fs, b.Tracer, fmt.Sprintf("%T", fs)).WithContext(ctx)
+}
+
+// traceCache wraps b.Cache so every call records a span
+func (b *Boilme) traceCache() {
+	backend := "custom"
+	switch cache.Unwrap(b.Cache).(type) {
+	case *cache.RedisCache:
+		backend = "redis"
+	case *cache.BadgerCache:
+		backend = "badger"
+	case *cache.MemoryCache:
+		backend = "memory"
+	case *cache.TieredCache:
+		backend = "tiered"
+	}
+	b.Cache = cache.NewTracedCache(b.Cache, b.Tracer, backend)
+}
+
+// dbAttributes describes the database in sql spans
+func dbAttributes(dialect, name string) []attribute.KeyValue {
+	system := dialect
+	if system == DialectPostgres {
+		system = "postgresql"
+	}
+	return []attribute.KeyValue{
+		semconv.DBSystemKey.String(system),
+		semconv.DBNamespace(name),
+	}
+}
@@ -0,0 +1,221 @@
+package boilme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bxtal-lsn/go-boilme/filesystems"
+	"github.com/bxtal-lsn/go-boilme/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTracedApp returns an application recording its spans in the exporter returned
+// with it
+func newTracedApp(t *testing.T, opts ...Option) (*Boilme, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	b := newTestApp(t, prometheus.NewRegistry(), append([]Option{WithTracerProvider(tp)}, opts...)...)
+	return b, exporter
+}
+
+// findSpan returns the span called name, failing t if there is none
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	t.Fatalf("no span called %q in %q", name, names)
+	return tracetest.SpanStub{}
+}
+
+// traceparent is a W3C traceparent header, of a sampled remote span
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTraceRequests(t *testing.T) {
+	b, exporter := newTracedApp(t)
+
+	mux := chi.NewRouter()
+	mux.Use(b.TraceRequests)
+	mux.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Get("/fail", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) })
+
+	r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	r.Header.Set("traceparent", traceparent)
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	spans := exporter.GetSpans()
+	span := findSpan(t, spans, "GET /users/{id}")
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("got a %s span, want server", span.SpanKind)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace %s, want the one in traceparent", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" || !span.Parent.IsRemote() {
+		t.Errorf("got parent %s, want the remote span in traceparent", got)
+	}
+
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["http.route"] != "/users/{id}" || attrs["url.path"] != "/users/42" || attrs["http.response.status_code"] != "200" {
+		t.Errorf("got attributes %v", attrs)
+	}
+
+	failed := findSpan(t, spans, "GET /fail")
+	if failed.Status.Code.String() != "Error" {
+		t.Errorf("got status %s for a 500, want Error", failed.Status.Code)
+	}
+	if failed.Parent.IsValid() {
+		t.Error("a request without traceparent continued a trace")
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	b, exporter := newTracedApp(t)
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: b.TracingTransport(nil)}
+	h := b.TraceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", traceparent)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var incoming, outgoing tracetest.SpanStub
+	for _, s := range exporter.GetSpans() {
+		switch s.SpanKind {
+		case trace.SpanKindServer:
+			incoming = s
+		case trace.SpanKindClient:
+			outgoing = s
+		}
+	}
+	if outgoing.Parent.SpanID() != incoming.SpanContext.SpanID() {
+		t.Errorf("the outgoing request's span is not a child of the request's")
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + outgoing.SpanContext.SpanID().String() + "-01"
+	if got != want {
+		t.Errorf("the server got traceparent %q, want %q", got, want)
+	}
+}
+
+// listFS is a file system that lists nothing
+type listFS struct{}
+
+func (listFS) Put(fileName, folder string) error                 { return nil }
+func (listFS) Get(destination string, items ...string) error     { return nil }
+func (listFS) List(prefix string) ([]filesystems.Listing, error) { return nil, nil }
+func (listFS) Delete(itemsToDelete []string) bool                { return true }
+
+func TestTracing_childSpans(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"views/home.page.tmpl":    `<p>home</p>`,
+		"mail/welcome.html.tmpl":  `{{define "subject"}}Welcome{{end}}{{define "body"}}<p>Hello</p>{{end}}`,
+		"mail/welcome.plain.tmpl": `{{define "body"}}Hello{{end}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Renderer = "go"
+	config.Cache = "memory"
+	config.Mail.API = "file"
+	config.Mail.FromAddress = "app@example.com"
+	b, exporter := newTracedApp(t, WithRootPath(root), WithConfig(config))
+
+	h := b.TraceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if err := b.CacheFor(ctx).Set("key", "value", 0); err != nil {
+			t.Error(err)
+		}
+		if _, err := b.TraceFS(ctx, listFS{}).List(""); err != nil {
+			t.Error(err)
+		}
+		if err := b.Render.Page(w, r, "home", nil, nil); err != nil {
+			t.Error(err)
+		}
+		msg := mailer.Message{To: []string{"ann@example.com"}, Template: "welcome"}
+		if err := b.Mail.Send(msg.WithContext(ctx)); err != nil {
+			t.Error(err)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := exporter.GetSpans()
+	request := findSpan(t, spans, "HTTP GET")
+	for _, name := range []string{"cache.set", "fs.list", "render.page", "mail.send"} {
+		span := findSpan(t, spans, name)
+		if span.Parent.SpanID() != request.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of the request's span", name)
+		}
+	}
+	if findSpan(t, spans, "render.go").Parent.SpanID() != findSpan(t, spans, "render.page").SpanContext.SpanID() {
+		t.Error("render.go is not a child of render.page")
+	}
+}
+
+func TestTracing_logsTraceID(t *testing.T) {
+	var buf bytes.Buffer
+	b, exporter := newTracedApp(t, WithLogger(slog.New(requestIDHandler{slog.NewJSONHandler(&buf, nil)})))
+
+	h := b.TraceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.Logger.InfoContext(r.Context(), "hello")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	want := findSpan(t, exporter.GetSpans(), "HTTP GET").SpanContext.TraceID().String()
+	if record["trace_id"] != want {
+		t.Errorf("got trace_id %v, want %s", record["trace_id"], want)
+	}
+}
@@ -10,27 +10,10 @@ type initPaths struct {
 	folderNames []string
 }
 
-// cookieConfig holds cookie config values
-type cookieConfig struct {
-	name     string
-	lifetime string
-	persist  string
-	secure   string
-	domain   string
-}
-
-type databaseConfig struct {
-	dsn      string
-	database string
-}
-
+// Database holds the application's connection pool. DataType is DATABASE_TYPE as
+// configured; Dialect is its normalized form, one of the Dialect constants.
 type Database struct {
 	DataType string
+	Dialect  string
 	Pool     *sql.DB
 }
-
-type redisConfig struct {
-	host     string
-	password string
-	prefix   string
-}
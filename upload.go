@@ -14,20 +14,20 @@ import (
 func (b *Boilme) UploadFile(r *http.Request, destination, field string, fs filesystems.FS) error {
 	fileName, err := b.getFileToUpload(r, field)
 	if err != nil {
-		b.ErrorLog.Println(err)
+		b.Logger.ErrorContext(r.Context(), "upload failed", "field", field, "error", err)
 		return err
 	}
 
 	if fs != nil {
-		err = fs.Put(fileName, destination)
+		err = b.TraceFS(r.Context(), fs).Put(fileName, destination)
 		if err != nil {
-			b.ErrorLog.Println(err)
+			b.Logger.ErrorContext(r.Context(), "upload failed", "field", field, "error", err)
 			return err
 		}
 	} else {
 		err = os.Rename(fileName, fmt.Sprintf("%s/%s", destination, path.Base(fileName)))
 		if err != nil {
-			b.ErrorLog.Println(err)
+			b.Logger.ErrorContext(r.Context(), "upload failed", "field", field, "error", err)
 			return err
 		}
 	}
@@ -40,7 +40,7 @@ func (b *Boilme) UploadFile(r *http.Request, destination, field string, fs files
 }
 
 func (b *Boilme) getFileToUpload(r *http.Request, fieldName string) (string, error) {
-	_ = r.ParseMultipartForm(b.config.uploads.maxUploadSize)
+	_ = r.ParseMultipartForm(b.Config.Uploads.MaxUploadSize)
 
 	file, header, err := r.FormFile(fieldName)
 	if err != nil {
@@ -59,7 +59,7 @@ func (b *Boilme) getFileToUpload(r *http.Request, fieldName string) (string, err
 		return "", err
 	}
 
-	if !inSlice(b.config.uploads.allowedMimeTypes, mimeType.String()) {
+	if !inSlice(b.Config.Uploads.AllowedMimeTypes, mimeType.String()) {
 		return "", errors.New("invalid file type uploaded")
 	}
 
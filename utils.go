@@ -1,7 +1,6 @@
 package boilme
 
 import (
-	"fmt"
 	"regexp"
 	"runtime"
 	"time"
@@ -16,5 +15,5 @@ func (b *Boilme) LoadTime(start time.Time) {
 	runtimeFunc := regexp.MustCompile(`^.*\.(.*)$`)
 	name := runtimeFunc.ReplaceAllString(funcObj.Name(), "$1")
 
-	b.InfoLog.Println(fmt.Sprintf("Load Time: %s took %s", name, elapsed))
+	b.Logger.Info("load time", "func", name, "elapsed", elapsed)
 }